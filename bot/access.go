@@ -0,0 +1,453 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// Access policies
+const (
+	AccessOpen   = "open"   // everyone is allowed
+	AccessUsers  = "users"  // listed and approved users are allowed
+	AccessChats  = "chats"  // listed chats and approved users are allowed
+	AccessInvite = "invite" // users who sent an invite code are allowed
+)
+
+// Admin commands managing access to a bot
+const (
+	CmdPending = "pending"
+	CmdApprove = "approve"
+)
+
+// Access statuses of users
+const (
+	AccessPending  = "pending"
+	AccessApproved = "approved"
+)
+
+const (
+	// rejectInterval limits how often a user who isn't allowed gets the
+	// rejection message
+	rejectInterval = time.Hour
+	// accessTimeout limits the time of loading and saving access of a user
+	accessTimeout = 5 * time.Second
+)
+
+// AccessConfig is the access policy of a bot
+type AccessConfig struct {
+	Policy      string   // one of AccessOpen, AccessUsers, AccessChats, AccessInvite
+	Users       []int64  // users allowed with any policy
+	Chats       []int64  // chats allowed with AccessChats
+	InviteCodes []string // codes accepted with AccessInvite
+}
+
+// Validate checks that the policy has its parameters
+func (c AccessConfig) Validate() error {
+	switch {
+	case c.Policy == AccessChats && len(c.Chats) == 0:
+		return errors.New("access policy chats requires allowed chats")
+	case c.Policy == AccessInvite && len(c.InviteCodes) == 0:
+		return errors.New("access policy invite requires invite codes")
+	}
+
+	return nil
+}
+
+// UserAccess is the access status of a bot user
+type UserAccess struct {
+	Status  string // AccessPending or AccessApproved
+	Name    string // user name shown to admins
+	Updated time.Time
+}
+
+// AccessStore keeps access statuses of bot users
+type AccessStore interface {
+	// LoadAccess returns the user's access or nil if there's none
+	LoadAccess(ctx context.Context, bot string, usr int64) (*UserAccess, error)
+	// SaveAccess creates or replaces the user's access
+	SaveAccess(ctx context.Context, bot string, usr int64, ua UserAccess) error
+	// PendingUsers returns users waiting for approval by user id
+	PendingUsers(ctx context.Context, bot string) (map[int64]UserAccess, error)
+}
+
+// Access enforces the access policy of a bot. Users who aren't allowed by the
+// policy become pending until an admin approves them with CmdApprove, they get
+// a polite rejection message meanwhile. With AccessInvite, users are approved
+// as soon as they send /start with an invite code, e.g. following the link
+// https://t.me/<bot>?start=<code>. Farm admins are always allowed.
+type Access struct {
+	bot    string
+	cfg    AccessConfig
+	users  map[int64]bool
+	chats  map[int64]bool
+	codes  map[string]bool
+	store  AccessStore
+	i18n   *I18n
+	t      Transport
+	logger *zap.SugaredLogger
+
+	mu         sync.Mutex
+	approved   map[int64]bool
+	unapproved *throttle[int64] // users found unapproved in the store
+	rejected   *throttle[int64] // rejection messages by user
+}
+
+// NewAccess creates Access of the named bot. Rejection messages are sent with
+// t in the user's language selected by i18n. store may be nil, then approvals
+// are kept in memory.
+func NewAccess(bot string, cfg AccessConfig, store AccessStore, i18n *I18n, t Transport, l *zap.SugaredLogger) (*Access, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	a := &Access{
+		bot:        bot,
+		cfg:        cfg,
+		users:      make(map[int64]bool),
+		chats:      make(map[int64]bool),
+		codes:      make(map[string]bool),
+		store:      store,
+		i18n:       i18n,
+		t:          t,
+		logger:     l,
+		approved:   make(map[int64]bool),
+		unapproved: newThrottle[int64](rejectInterval),
+		rejected:   newThrottle[int64](rejectInterval),
+	}
+	for _, usr := range cfg.Users {
+		a.users[usr] = true
+	}
+	for _, cht := range cfg.Chats {
+		a.chats[cht] = true
+	}
+	for _, code := range cfg.InviteCodes {
+		a.codes[code] = true
+	}
+
+	return a, nil
+}
+
+// Middleware passes to handlers only requests allowed by the policy. It should
+// be added to the bot router before any other middleware of the bot, except
+// I18n's.
+func (a *Access) Middleware() Middleware {
+	return Auth(a.allow, a.reject)
+}
+
+// Routes registers admin commands managing access in the router:
+//
+//	/pending       - lists users waiting for approval
+//	/approve <id>  - approves the user
+func (a *Access) Routes(r *Router) {
+	r.Command(CmdPending, adminOnly(r, a.handlePending))
+	r.Command(CmdApprove, adminOnly(r, a.handleApprove))
+}
+
+// Approve allows the user to use the bot
+func (a *Access) Approve(usr int64, name string) error {
+	a.mu.Lock()
+	a.approved[usr] = true
+	a.unapproved.forget(usr)
+	a.rejected.forget(usr)
+	a.mu.Unlock()
+
+	if a.store == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), accessTimeout)
+	defer cancel()
+
+	return a.store.SaveAccess(ctx, a.bot, usr, UserAccess{Status: AccessApproved, Name: name, Updated: time.Now()})
+}
+
+func (a *Access) allow(req *Request) bool {
+	switch {
+	case a.cfg.Policy == AccessOpen, IsAdmin(req.User), a.users[req.User]:
+		return true
+	case a.cfg.Policy == AccessChats && a.chats[req.Chat]:
+		return true
+	}
+
+	if a.isApproved(req) {
+		return true
+	}
+
+	if a.cfg.Policy == AccessInvite && req.Command == "start" && a.codes[req.Args] {
+		if err := a.Approve(req.User, userName(req)); err != nil {
+			req.Logger.Errorw("failed saving access", "err", err)
+		}
+		req.Logger.Infow("user joined with invite code")
+		return true
+	}
+
+	return false
+}
+
+// isApproved reports whether an admin has approved the user. Approvals are
+// final, so they're cached. Users who aren't approved are cached for
+// rejectInterval, so strangers don't make a query on every update; approvals
+// made by another instance of the bot are seen after that.
+func (a *Access) isApproved(req *Request) bool {
+	a.mu.Lock()
+	ok := a.approved[req.User]
+	unapproved := a.unapproved.recent(req.User, time.Now())
+	a.mu.Unlock()
+	if ok || unapproved || a.store == nil {
+		return ok
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), accessTimeout)
+	defer cancel()
+
+	ua, err := a.store.LoadAccess(ctx, a.bot, req.User)
+	if err != nil {
+		req.Logger.Errorw("failed loading access", "err", err)
+		return false
+	}
+	if ua == nil || ua.Status != AccessApproved {
+		a.mu.Lock()
+		a.unapproved.allow(req.User, time.Now())
+		a.mu.Unlock()
+		return false
+	}
+
+	a.mu.Lock()
+	a.approved[req.User] = true
+	a.mu.Unlock()
+
+	return true
+}
+
+// reject makes the user pending and sends the rejection message at most once
+// per rejectInterval. Callback queries are always answered, so the button
+// doesn't keep loading.
+func (a *Access) reject(req *Request) {
+	if cbq := req.Update.CallbackQuery; cbq != nil {
+		if _, err := a.t.Request(tg.NewCallback(cbq.ID, "")); err != nil {
+			req.Logger.Errorw("failed answering callback query", "err", err)
+		}
+	}
+
+	a.mu.Lock()
+	allowed := a.rejected.allow(req.User, time.Now())
+	a.mu.Unlock()
+	if !allowed {
+		return
+	}
+
+	key := "access.pending"
+	if a.cfg.Policy == AccessInvite {
+		key = "access.invite"
+	} else if a.request(req) {
+		a.notifyAdmins(req)
+	}
+
+	l := a.i18n.For(req.User)
+	if _, err := a.t.SendMessage(tg.NewMessage(req.Chat, l.T(key))); err != nil {
+		req.Logger.Errorw("failed sending rejection", "err", err)
+	}
+}
+
+// request makes the user pending. It returns true if the user wasn't pending
+// before.
+func (a *Access) request(req *Request) bool {
+	if a.store == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), accessTimeout)
+	defer cancel()
+
+	ua, err := a.store.LoadAccess(ctx, a.bot, req.User)
+	if err != nil {
+		req.Logger.Errorw("failed loading access", "err", err)
+		return false
+	}
+	if ua != nil {
+		return false
+	}
+
+	err = a.store.SaveAccess(ctx, a.bot, req.User, UserAccess{Status: AccessPending, Name: userName(req), Updated: time.Now()})
+	if err != nil {
+		req.Logger.Errorw("failed saving access", "err", err)
+		return false
+	}
+
+	return true
+}
+
+// notifyAdmins tells admins that the user is waiting for approval
+func (a *Access) notifyAdmins(req *Request) {
+	text := fmt.Sprintf("%s (%d) requests access to %s. Approve with /%s %d",
+		userName(req), req.User, a.bot, CmdApprove, req.User)
+	for _, admin := range adminIDs() {
+		if _, err := a.t.SendMessage(tg.NewMessage(admin, text)); err != nil && !IsPermanent(err) {
+			a.logger.Warnw("failed notifying admin", "admin", admin, "err", err)
+		}
+	}
+}
+
+func (a *Access) handlePending(req *Request) {
+	if a.store == nil {
+		a.replyAdmin(req, "Pending users aren't kept without a store")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), accessTimeout)
+	defer cancel()
+
+	pending, err := a.store.PendingUsers(ctx, a.bot)
+	if err != nil {
+		req.Logger.Errorw("failed listing pending users", "err", err)
+		a.replyAdmin(req, "Failed listing pending users: "+err.Error())
+		return
+	}
+
+	if len(pending) == 0 {
+		a.replyAdmin(req, "No users are waiting for approval")
+		return
+	}
+
+	users := make([]int64, 0, len(pending))
+	for usr := range pending {
+		users = append(users, usr)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return pending[users[i]].Updated.Before(pending[users[j]].Updated)
+	})
+
+	fields := make([]Field, len(users))
+	for i, usr := range users {
+		ua := pending[usr]
+		fields[i] = Field{
+			Name:  fmt.Sprintf("/%s %d", CmdApprove, usr),
+			Value: fmt.Sprintf("%s, since %s", ua.Name, ua.Updated.UTC().Format(time.RFC3339)),
+		}
+	}
+	a.replyAdmin(req, formatFields("Pending users", fields))
+}
+
+func (a *Access) handleApprove(req *Request) {
+	if len(req.ArgList) != 1 {
+		a.replyAdmin(req, fmt.Sprintf("Usage: /%s <user id>", CmdApprove))
+		return
+	}
+	usr, err := strconv.ParseInt(req.ArgList[0], 10, 64)
+	if err != nil {
+		a.replyAdmin(req, fmt.Sprintf("Invalid user id %q", req.ArgList[0]))
+		return
+	}
+
+	name := ""
+	if a.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), accessTimeout)
+		ua, err := a.store.LoadAccess(ctx, a.bot, usr)
+		cancel()
+		if err != nil {
+			req.Logger.Errorw("failed loading access", "user", usr, "err", err)
+		} else if ua != nil {
+			name = ua.Name
+		}
+	}
+
+	if err = a.Approve(usr, name); err != nil {
+		req.Logger.Errorw("failed approving user", "user", usr, "err", err)
+		a.replyAdmin(req, "Failed approving the user: "+err.Error())
+		return
+	}
+	req.Logger.Infow("user approved", "user", usr)
+
+	a.replyAdmin(req, fmt.Sprintf("User %d is approved", usr))
+
+	l := a.i18n.For(usr)
+	if _, err := a.t.SendMessage(tg.NewMessage(usr, l.T("access.approved"))); err != nil {
+		req.Logger.Warnw("failed notifying approved user", "user", usr, "err", err)
+	}
+}
+
+func (a *Access) replyAdmin(req *Request, text string) {
+	if _, err := a.t.SendMessage(tg.NewMessage(req.Chat, text)); err != nil {
+		req.Logger.Errorw("failed replying to admin", "err", err)
+	}
+}
+
+// userName returns the name of the user who sent the request
+func userName(req *Request) string {
+	from := req.Update.SentFrom()
+	if from == nil {
+		return strconv.FormatInt(req.User, 10)
+	}
+
+	name := strings.TrimSpace(from.FirstName + " " + from.LastName)
+	if from.UserName != "" {
+		name += " @" + from.UserName
+	}
+
+	return strings.TrimSpace(name)
+}
+
+// PGAccessStore is an AccessStore that keeps access statuses in the
+// user_access table of a Postgres database.
+type PGAccessStore struct {
+	db *sql.DB
+}
+
+func NewPGAccessStore(db *sql.DB) *PGAccessStore {
+	return &PGAccessStore{db: db}
+}
+
+func (s *PGAccessStore) LoadAccess(ctx context.Context, bot string, usr int64) (*UserAccess, error) {
+	var ua UserAccess
+	row := s.db.QueryRowContext(ctx,
+		"SELECT status, name, updated FROM user_access WHERE bot = $1 AND user_id = $2", bot, usr)
+	err := row.Scan(&ua.Status, &ua.Name, &ua.Updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ua, nil
+}
+
+func (s *PGAccessStore) SaveAccess(ctx context.Context, bot string, usr int64, ua UserAccess) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_access(bot, user_id, status, name, updated) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (bot, user_id) DO UPDATE
+		SET status = EXCLUDED.status, name = EXCLUDED.name, updated = EXCLUDED.updated`,
+		bot, usr, ua.Status, ua.Name, ua.Updated)
+	return err
+}
+
+func (s *PGAccessStore) PendingUsers(ctx context.Context, bot string) (map[int64]UserAccess, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT user_id, status, name, updated FROM user_access WHERE bot = $1 AND status = $2", bot, AccessPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := make(map[int64]UserAccess)
+	for rows.Next() {
+		var usr int64
+		var ua UserAccess
+		if err = rows.Scan(&usr, &ua.Status, &ua.Name, &ua.Updated); err != nil {
+			return nil, err
+		}
+		pending[usr] = ua
+	}
+
+	return pending, rows.Err()
+}
@@ -0,0 +1,162 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"context"
+	"testing"
+	"testing/fstest"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// accessStore keeps access in memory and counts loads
+type accessStore struct {
+	users map[int64]bot.UserAccess
+	loads int
+}
+
+func (s *accessStore) LoadAccess(_ context.Context, _ string, usr int64) (*bot.UserAccess, error) {
+	s.loads++
+	ua, ok := s.users[usr]
+	if !ok {
+		return nil, nil
+	}
+	return &ua, nil
+}
+
+func (s *accessStore) SaveAccess(_ context.Context, _ string, usr int64, ua bot.UserAccess) error {
+	s.users[usr] = ua
+	return nil
+}
+
+func (s *accessStore) PendingUsers(context.Context, string) (map[int64]bot.UserAccess, error) {
+	pending := make(map[int64]bot.UserAccess)
+	for usr, ua := range s.users {
+		if ua.Status == bot.AccessPending {
+			pending[usr] = ua
+		}
+	}
+	return pending, nil
+}
+
+func TestAccess(t *testing.T) {
+	bot.SetAdmins(1)
+	defer bot.SetAdmins()
+
+	l := zap.NewNop().Sugar()
+	c, err := bot.LoadCatalog(fstest.MapFS{}, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i18n := bot.NewI18n("test", c, nil, l)
+	en := c.Localizer("en")
+
+	newRouter := func(cfg bot.AccessConfig) (*bot.Router, *fakeTransport, *[]int64) {
+		ft := &fakeTransport{}
+		a, err := bot.NewAccess("test", cfg, nil, i18n, ft, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var handled []int64
+		r := bot.NewRouter("test", l)
+		r.Use(a.Middleware())
+		r.UnknownCommand(func(req *bot.Request) { handled = append(handled, req.User) })
+		a.Routes(r)
+
+		return r, ft, &handled
+	}
+
+	r, ft, handled := newRouter(bot.AccessConfig{Policy: bot.AccessUsers, Users: []int64{2}})
+	r.Handle(command(2, "/start", 6))
+	r.Handle(command(3, "/start", 6))
+	r.Handle(command(3, "/start", 6))
+	if len(*handled) != 1 || (*handled)[0] != 2 {
+		t.Errorf("expected only user 2 to be allowed, got %v", *handled)
+	}
+	// the rejection and the notification of the admin are sent once
+	if len(ft.sent) != 2 || ft.sent[0] == ft.sent[1] {
+		t.Fatalf("unexpected messages %q", ft.sent)
+	}
+
+	ft.sent = nil
+	r.Handle(command(1, "/approve 3", 8))
+	r.Handle(command(3, "/start", 6))
+	if len(*handled) != 2 || (*handled)[1] != 3 {
+		t.Errorf("expected approved user 3 to be allowed, got %v", *handled)
+	}
+	if len(ft.sent) != 2 || ft.sent[1] != en.T("access.approved") {
+		t.Errorf("unexpected messages %q", ft.sent)
+	}
+
+	if _, err := bot.NewAccess("test", bot.AccessConfig{Policy: bot.AccessInvite}, nil, i18n, ft, l); err == nil {
+		t.Error("expected error for invite policy without codes")
+	}
+
+	r, ft, handled = newRouter(bot.AccessConfig{Policy: bot.AccessInvite, InviteCodes: []string{"abc"}})
+	r.Handle(command(4, "/start abc", 6))
+	r.Handle(command(4, "/list", 5))
+	r.Handle(command(5, "/start xyz", 6))
+	if len(*handled) != 2 || (*handled)[1] != 4 {
+		t.Errorf("expected only invited user 4 to be allowed, got %v", *handled)
+	}
+	if len(ft.sent) != 1 || ft.sent[0] != en.T("access.invite") {
+		t.Errorf("unexpected messages %q", ft.sent)
+	}
+
+	// many strangers make rejections to be pruned, but recent ones are kept,
+	// so the user isn't rejected again
+	for usr := int64(100); usr < 20100; usr++ {
+		r.Handle(command(usr, "/start", 6))
+	}
+	ft.sent = nil
+	r.Handle(command(5, "/start", 6))
+	if len(ft.sent) != 0 {
+		t.Errorf("expected the rejection not to be repeated, got %q", ft.sent)
+	}
+}
+
+func TestAccessStore(t *testing.T) {
+	l := zap.NewNop().Sugar()
+	c, err := bot.LoadCatalog(fstest.MapFS{}, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &accessStore{users: make(map[int64]bot.UserAccess)}
+	ft := &fakeTransport{}
+	a, err := bot.NewAccess("test", bot.AccessConfig{Policy: bot.AccessUsers}, store, bot.NewI18n("test", c, nil, l), ft, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handled int
+	r := bot.NewRouter("test", l)
+	r.Use(a.Middleware())
+	r.UnknownCommand(func(req *bot.Request) { handled++ })
+	r.DefaultCallback(func(req *bot.Request) { handled++ })
+
+	// the stranger is looked up once, then rejected from the cache
+	r.Handle(command(3, "/start", 6))
+	loads := store.loads
+	r.Handle(command(3, "/start", 6))
+	cbq := &tg.Update{CallbackQuery: &tg.CallbackQuery{ID: "42", From: &tg.User{ID: 3}, Data: "x",
+		Message: &tg.Message{Chat: &tg.Chat{ID: 3}}}}
+	r.Handle(cbq)
+	if handled != 0 || store.loads != loads {
+		t.Errorf("expected the stranger to be rejected without loads, got %d handled, %d loads", handled, store.loads-loads)
+	}
+	if len(ft.reqs) != 1 {
+		t.Errorf("expected the blocked callback query to be answered, got %v", ft.reqs)
+	}
+
+	if err := a.Approve(3, ""); err != nil {
+		t.Fatal(err)
+	}
+	r.Handle(command(3, "/start", 6))
+	r.Handle(cbq)
+	if handled != 2 {
+		t.Errorf("expected the approved user to be allowed, got %d handled", handled)
+	}
+}
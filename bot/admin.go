@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// adminTimeout limits the time of stopping a bot by the admin API
+const adminTimeout = 30 * time.Second
+
+var errNoMigrations = errors.New("bot has no migrations")
+
+// Loader decodes configuration of the named bot and initializes it. If reload
+// is true, the configuration is read again from its source.
+type Loader func(name string, l *zap.SugaredLogger, reload bool) error
+
+// AdminBot is a supervised bot instance as listed by the admin API
+type AdminBot struct {
+	Name                 string    `json:"name"`
+	State                string    `json:"state"`
+	Initialized          bool      `json:"initialized"`
+	Disabled             bool      `json:"disabled"`
+	Restarts             int       `json:"restarts"`
+	LastError            string    `json:"last_error,omitempty"`
+	Since                time.Time `json:"since"`
+	RequiredConfigFields []string  `json:"required_config_fields,omitempty"`
+}
+
+// AdminHandlers registers the admin API in the server. Requests must have the
+// header "Authorization: Bearer <token>". The API is:
+//   - GET /admin/bots lists supervised bot instances
+//   - POST /admin/bots/<name>/stop stops the bot
+//   - POST /admin/bots/<name>/start initializes the bot if needed and starts it
+//   - POST /admin/bots/<name>/reinit stops the bot, reloads its configuration,
+//     initializes and starts it
+//   - GET /admin/bots/<name>/migrations lists migrations of the bot database
+//     (see Migrator)
+func AdminHandlers(srv *Server, s *Supervisor, token string, load Loader) {
+	a := &admin{supervisor: s, load: load, logger: s.logger}
+	srv.Handle("/admin/", a.auth(token, http.HandlerFunc(a.serve)))
+}
+
+type admin struct {
+	supervisor *Supervisor
+	load       Loader
+	logger     *zap.SugaredLogger
+}
+
+func (a *admin) auth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			a.logger.Warnw("unauthorized admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether the request has the header "Authorization: Bearer
+// <token>". No request is authorized with empty token.
+func authorized(r *http.Request, token string) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func (a *admin) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/"), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "bots":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.list(w)
+
+	case len(parts) == 3 && parts[0] == "bots" && parts[2] == "migrations":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.migrations(w, r, parts[1])
+
+	case len(parts) == 3 && parts[0] == "bots":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.control(w, r, parts[1], parts[2])
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a *admin) list(w http.ResponseWriter) {
+	var bots []AdminBot
+	for _, rep := range a.supervisor.Report(context.Background()) {
+		ab := AdminBot{
+			Name:        rep.Name,
+			State:       rep.State,
+			Initialized: rep.Initialized,
+			Disabled:    rep.Disabled,
+			Restarts:    rep.Restarts,
+			LastError:   rep.LastError,
+			Since:       rep.Since,
+		}
+		if b := a.supervisor.Bot(rep.Name); b != nil {
+			ab.RequiredConfigFields = requiredFields(b.Config())
+		}
+		bots = append(bots, ab)
+	}
+
+	a.reply(w, http.StatusOK, bots)
+}
+
+func (a *admin) control(w http.ResponseWriter, r *http.Request, name, action string) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	var err error
+	switch action {
+	case "stop":
+		err = a.supervisor.StopBot(ctx, name)
+	case "start":
+		err = a.supervisor.StartBot(name, func(l *zap.SugaredLogger) error {
+			return a.load(name, l, false)
+		})
+	case "reinit":
+		err = a.supervisor.ReinitBot(ctx, name, func(l *zap.SugaredLogger) error {
+			return a.load(name, l, true)
+		})
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	a.logger.Infow("admin request", "bot", name, "action", action, "err", err)
+
+	switch {
+	case errors.Is(err, ErrUnknownBot):
+		a.reply(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrBotRunning), errors.Is(err, ErrFarmNotRunning):
+		a.reply(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrStopTimeout):
+		a.reply(w, http.StatusGatewayTimeout, map[string]string{"error": err.Error()})
+	case err != nil:
+		a.reply(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	default:
+		a.reply(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+func (a *admin) migrations(w http.ResponseWriter, r *http.Request, name string) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	var statuses []MigrationStatus
+	err := a.supervisor.WithBot(name, func(b Bot) error {
+		mb, ok := b.(Migrated)
+		if !ok {
+			return errNoMigrations
+		}
+
+		m := mb.Migrator()
+		if m == nil {
+			return ErrBotNotInitialized
+		}
+
+		var err error
+		statuses, err = m.Status(ctx)
+		return err
+	})
+
+	switch {
+	case errors.Is(err, ErrUnknownBot), errors.Is(err, errNoMigrations):
+		a.reply(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrBotNotInitialized):
+		a.reply(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case err != nil:
+		a.reply(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	default:
+		a.reply(w, http.StatusOK, statuses)
+	}
+}
+
+func (a *admin) reply(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		a.logger.Errorw("failed writing admin response", "err", err)
+	}
+}
@@ -0,0 +1,111 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type fakeBot struct {
+	inits, runs, stops int32
+}
+
+func (b *fakeBot) Name() string { return "fake" }
+func (b *fakeBot) Config() any  { return &struct{}{} }
+
+func (b *fakeBot) Init(*zap.SugaredLogger) error {
+	atomic.AddInt32(&b.inits, 1)
+	return nil
+}
+
+func (b *fakeBot) Run(ctx context.Context) error {
+	atomic.AddInt32(&b.runs, 1)
+	<-ctx.Done()
+	return nil
+}
+
+func (b *fakeBot) Stop(context.Context) error {
+	atomic.AddInt32(&b.stops, 1)
+	return nil
+}
+
+func TestAdmin(t *testing.T) {
+	l := zap.NewNop().Sugar()
+	b := &fakeBot{}
+
+	s := bot.NewSupervisor(l)
+	s.Add(b.Name(), b, l)
+
+	srv := bot.NewServer("127.0.0.1:0", l)
+	var reloads int32
+	bot.AdminHandlers(srv, s, "secret", func(name string, l *zap.SugaredLogger, reload bool) error {
+		if reload {
+			atomic.AddInt32(&reloads, 1)
+		}
+		return b.Init(l)
+	})
+	h := srv.Handler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	do := func(path, token string) int {
+		req, _ := http.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	waitState := func(state bot.State) {
+		for i := 0; i < 100; i++ {
+			if s.Status()[0].State == state {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected state %v, got %v", state, s.Status()[0].State)
+	}
+
+	waitState(bot.StateRunning)
+
+	if code := do("/admin/bots/fake/stop", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", code)
+	}
+	if code := do("/admin/bots/fake/stop", "secret"); code != http.StatusOK {
+		t.Errorf("expected 200 on stop, got %d", code)
+	}
+	waitState(bot.StateStopped)
+	if code := do("/admin/bots/fake/start", "secret"); code != http.StatusOK {
+		t.Errorf("expected 200 on start, got %d", code)
+	}
+	waitState(bot.StateRunning)
+	if code := do("/admin/bots/fake/start", "secret"); code != http.StatusConflict {
+		t.Errorf("expected 409 on start of running bot, got %d", code)
+	}
+	if code := do("/admin/bots/fake/reinit", "secret"); code != http.StatusOK {
+		t.Errorf("expected 200 on reinit, got %d", code)
+	}
+	waitState(bot.StateRunning)
+	if code := do("/admin/bots/nobot/stop", "secret"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown bot, got %d", code)
+	}
+
+	cancel()
+	<-done
+	s.Stop(context.Background())
+
+	if b.inits != 2 || b.runs != 3 || b.stops != 3 || reloads != 1 {
+		t.Errorf("unexpected inits %d, runs %d, stops %d, reloads %d", b.inits, b.runs, b.stops, reloads)
+	}
+}
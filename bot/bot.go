@@ -1,6 +1,10 @@
 package bot
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
 	"sync"
 
 	"go.uber.org/zap"
@@ -8,53 +12,67 @@ import (
 
 // Bot is an interface each bot should implement
 type Bot interface {
-	// Name returns the name of the bot
+	// Name returns the instance name of the bot given to its Factory
 	Name() string
+	// Config returns a pointer to the bot configuration struct. The struct is
+	// filled from the bot's section of the configuration file (see BaseConfig)
+	// before Init is called.
+	Config() any
 	// Init method initializes the bot (connects to database, configures Telegram
 	// Bot, etc.). On failure, Init should log the error and return it rather than
 	// panic.
-	Init(Config, *zap.SugaredLogger) error
+	Init(*zap.SugaredLogger) error
 	// Run starts the process of handling messages from the Telegram Bot. Multiple
 	// bots are supposed to run concurrently, so Run should be started in a new
-	// goroutine.
-	Run()
+	// goroutine. Run blocks until ctx is done or the bot fails, it stops
+	// receiving updates before returning.
+	Run(ctx context.Context) error
+	// Stop waits for in-flight handlers to finish until ctx is done, then
+	// releases resources acquired in Init (database, logger, etc.). Stop is
+	// called after Run has returned.
+	Stop(ctx context.Context) error
 }
 
+// Factory creates a bot with the instance name. Every instance has its own
+// configuration section, so instances of one type may use different tokens and
+// databases.
+type Factory func(name string) Bot
+
 var (
 	botsRegistry = make(map[string]Record)
 	botsMu       sync.Mutex
 )
 
-// Register adds the bot to the list of bots to run. To register a bot call
+// Register adds the bot type to the registry, so instances of the type can be
+// declared in the configuration (see Instances). To register a bot type call
 // Register in the init function.
-func Register(bot Bot, rcf []string) bool {
+func Register(typ string, f Factory) bool {
 	botsMu.Lock()
 	defer botsMu.Unlock()
 
-	name := bot.Name()
-	_, ok := botsRegistry[name]
+	_, ok := botsRegistry[typ]
 	if ok {
 		return false
 	}
 
-	botsRegistry[name] = Record{
-		Name:                 name,
-		Bot:                  &bot,
-		RequiredConfigFields: rcf,
+	botsRegistry[typ] = Record{
+		Type:                 typ,
+		New:                  f,
+		RequiredConfigFields: requiredFields(f(typ).Config()),
 	}
 
 	return true
 }
 
-// Record represents named bot record in the bots registry. It also contains
-// fields to verify bot configuration.
+// Record represents a bot type in the bots registry. It also contains fields to
+// verify bot configuration.
 type Record struct {
-	Name                 string
-	Bot                  *Bot
+	Type                 string
+	New                  Factory
 	RequiredConfigFields []string
 }
 
-// GetThemAll returns list of bots.
+// GetThemAll returns list of bot types sorted by type.
 func GetThemAll() []Record {
 	botsMu.Lock()
 	defer botsMu.Unlock()
@@ -63,6 +81,96 @@ func GetThemAll() []Record {
 	for _, r := range botsRegistry {
 		bots = append(bots, r)
 	}
+	sort.Slice(bots, func(i, j int) bool {
+		return bots[i].Type < bots[j].Type
+	})
 
 	return bots
 }
+
+// Instance is a named bot of a registered type
+type Instance struct {
+	Name string
+	Type string
+}
+
+// Instances returns bot instances declared in the farm configuration. names
+// are the instances to run (see FarmConfig), the type of an instance is set by
+// TypeField of its section and defaults to the instance name. If names are
+// empty, every registered type has one instance named after the type.
+func Instances(names []string, configs map[string]any) ([]Instance, error) {
+	if len(names) == 0 {
+		instances := []Instance{}
+		for _, rec := range GetThemAll() {
+			instances = append(instances, Instance{Name: rec.Type, Type: rec.Type})
+		}
+		return instances, nil
+	}
+
+	instances := make([]Instance, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name == "" || name == FarmSection {
+			return nil, fmt.Errorf("invalid bot instance name %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("bot instance %q is declared twice", name)
+		}
+		seen[name] = true
+
+		typ, err := instanceType(name, configs[name])
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, Instance{Name: name, Type: typ})
+	}
+
+	return instances, nil
+}
+
+// instanceType returns the type of the named instance set in its section or
+// in the environment
+func instanceType(name string, section any) (string, error) {
+	typ := name
+	if raw, ok := section.(RawConfig); ok {
+		if t, ok := raw[TypeField]; ok {
+			if typ, ok = t.(string); !ok {
+				return "", fmt.Errorf("%s: %s must be a string", name, TypeField)
+			}
+		}
+	}
+	if t, ok := os.LookupEnv(EnvName(name, TypeField)); ok {
+		typ = t
+	}
+
+	botsMu.Lock()
+	defer botsMu.Unlock()
+
+	if _, ok := botsRegistry[typ]; !ok {
+		return "", fmt.Errorf("%s: bot type %q isn't registered", name, typ)
+	}
+
+	return typ, nil
+}
+
+// NewBot creates the bot instance
+func NewBot(inst Instance) (Bot, error) {
+	botsMu.Lock()
+	rec, ok := botsRegistry[inst.Type]
+	botsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("bot type %q isn't registered", inst.Type)
+	}
+
+	return rec.New(inst.Name), nil
+}
+
+func requiredFields(cfg any) []string {
+	fields := []string{}
+	for _, f := range ConfigFields(cfg) {
+		if f.Required {
+			fields = append(fields, f.Name)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,52 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"reflect"
+	"testing"
+)
+
+// namedBot is a fakeBot created by its factory
+type namedBot struct {
+	fakeBot
+	name string
+}
+
+func (b *namedBot) Name() string { return b.name }
+
+func TestInstances(t *testing.T) {
+	factory := func(name string) bot.Bot { return &namedBot{name: name} }
+	if !bot.Register("Named", factory) || bot.Register("Named", factory) {
+		t.Fatal("expected the type to be registered once")
+	}
+
+	configs := map[string]any{
+		"First":  bot.RawConfig{"Type": "Named"},
+		"Second": bot.RawConfig{"Type": "Named"},
+	}
+	instances, err := bot.Instances([]string{"First", "Second", "Named"}, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bot.Instance{{"First", "Named"}, {"Second", "Named"}, {"Named", "Named"}}
+	if !reflect.DeepEqual(instances, want) {
+		t.Errorf("expected %v, got %v", want, instances)
+	}
+
+	first, _ := bot.NewBot(instances[0])
+	second, _ := bot.NewBot(instances[1])
+	if first == second || first.Name() != "First" || second.Name() != "Second" {
+		t.Errorf("expected independent instances, got %q and %q", first.Name(), second.Name())
+	}
+
+	for _, names := range [][]string{{"First", "First"}, {"Unknown"}, {bot.FarmSection}} {
+		if _, err := bot.Instances(names, configs); err == nil {
+			t.Errorf("expected error for instances %v", names)
+		}
+	}
+
+	instances, err = bot.Instances(nil, configs)
+	if err != nil || !reflect.DeepEqual(instances, []bot.Instance{{"Named", "Named"}}) {
+		t.Errorf("expected an instance of every registered type, got %v, %v", instances, err)
+	}
+}
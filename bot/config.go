@@ -1,13 +1,530 @@
 package bot
 
-// Mandatory parameters required to start the bot.
-const (
-	CfgTgToken         = "TgToken"
-	CfgDbConnStr       = "DBConnStr"
-	CfgDbRetryAttempts = "DBRetryAttempts"
-	CfgDbRetryDelay    = "DBRetryDelay"
-	CfgDbTimeout       = "DBTimeout"
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Config keeps bot configuration
-type Config = map[string]any
+// EnvPrefix is the prefix of environment variables overriding configuration
+// values. The variable name is EnvPrefix + "_" + BOT NAME + "_" + FIELD NAME in
+// upper case, e.g. BOTFARM_FINDINGMEMO_TGTOKEN.
+const EnvPrefix = "BOTFARM"
+
+// TypeField is the field of a bot configuration section with the type of the
+// bot instance (see Instances). It's accepted in every section.
+const TypeField = "Type"
+
+// RawConfig keeps bot configuration as it's read from the configuration file
+type RawConfig = map[string]any
+
+// BaseConfig keeps parameters required by every bot. Bots embed it into their
+// configuration structs.
+//
+// Configuration structs describe their fields with tags:
+//
+//	cfg:"Name[,required]" - field name in the configuration file
+//	default:"value"       - value used when the field isn't set
+//	min:"value"           - minimal allowed value (numbers and durations)
+//	max:"value"           - maximal allowed value (numbers and durations)
+//	oneof:"a b c"         - space-separated list of allowed values (strings)
+//
+// Supported field types are string, bool, int, int64, float64, []int64,
+// []string and time.Duration. A duration is set either as a string ("1m30s")
+// or as a number of seconds.
+type BaseConfig struct {
+	TgToken         string        `cfg:"TgToken,required"`
+	TgAPIEndpoint   string        `cfg:"TgAPIEndpoint"` // see NewTransport
+	DBConnStr       string        `cfg:"DBConnStr,required"`
+	DBRetryAttempts int           `cfg:"DBRetryAttempts" default:"3" min:"1" max:"100"`
+	DBRetryDelay    time.Duration `cfg:"DBRetryDelay" default:"100ms" min:"0s" max:"1m"`
+	DBTimeout       time.Duration `cfg:"DBTimeout" default:"5s" min:"1s" max:"10m"`
+	// Locale is the locale of users whose language isn't supported (see
+	// Catalog)
+	Locale string `cfg:"Locale" default:"en"`
+	// Migrate enables applying database migrations on Init (see Migrator)
+	Migrate bool `cfg:"Migrate" default:"true"`
+	// ConvTimeout is the time after which an abandoned conversation is reset
+	ConvTimeout time.Duration `cfg:"ConvTimeout" default:"30m" min:"1m"`
+	// UpdateWorkers is the number of users whose updates are handled
+	// concurrently
+	UpdateWorkers int `cfg:"UpdateWorkers" default:"8" min:"1" max:"1000"`
+	// SendRate limits messages sent by the bot per second, ChatSendRate and
+	// ChatSendBurst limit messages sent to a chat (see Sender)
+	SendRate      float64 `cfg:"SendRate" default:"25" min:"0.1" max:"30"`
+	ChatSendRate  float64 `cfg:"ChatSendRate" default:"1" min:"0.01"`
+	ChatSendBurst int     `cfg:"ChatSendBurst" default:"3" min:"1"`
+	// SendAttempts and SendRetryDelay control retries of failed requests to
+	// Telegram
+	SendAttempts   int           `cfg:"SendAttempts" default:"3" min:"1" max:"10"`
+	SendRetryDelay time.Duration `cfg:"SendRetryDelay" default:"1s" min:"0s" max:"1m"`
+	// BroadcastRate limits messages per second sent by CmdBroadcast
+	BroadcastRate float64 `cfg:"BroadcastRate" default:"10" min:"0.1" max:"30"`
+	// Access is the access policy of the bot, AllowedUsers, AllowedChats and
+	// InviteCodes are its parameters (see Access)
+	Access       string   `cfg:"Access" default:"open" oneof:"open users chats invite"`
+	AllowedUsers []int64  `cfg:"AllowedUsers"`
+	AllowedChats []int64  `cfg:"AllowedChats"`
+	InviteCodes  []string `cfg:"InviteCodes"`
+}
+
+// Base returns the BaseConfig embedded into a bot configuration struct (see
+// BaseOf)
+func (c *BaseConfig) Base() *BaseConfig {
+	return c
+}
+
+// BaseOf returns the BaseConfig embedded into the configuration struct of
+// a bot or nil if the struct doesn't embed it
+func BaseOf(cfg any) *BaseConfig {
+	if b, ok := cfg.(interface{ Base() *BaseConfig }); ok {
+		return b.Base()
+	}
+
+	return nil
+}
+
+// DBConfig returns parameters of the bot DB
+func (c *BaseConfig) DBConfig() DBConfig {
+	return DBConfig{
+		Timeout:    c.DBTimeout,
+		Attempts:   c.DBRetryAttempts,
+		RetryDelay: c.DBRetryDelay,
+	}
+}
+
+// SenderConfig returns parameters of the bot Sender
+func (c *BaseConfig) SenderConfig() SenderConfig {
+	return SenderConfig{
+		Rate:       c.SendRate,
+		ChatRate:   c.ChatSendRate,
+		ChatBurst:  c.ChatSendBurst,
+		Attempts:   c.SendAttempts,
+		RetryDelay: c.SendRetryDelay,
+	}
+}
+
+// AccessConfig returns the access policy of the bot
+func (c *BaseConfig) AccessConfig() AccessConfig {
+	return AccessConfig{
+		Policy:      c.Access,
+		Users:       c.AllowedUsers,
+		Chats:       c.AllowedChats,
+		InviteCodes: c.InviteCodes,
+	}
+}
+
+// FarmSection is the name of the configuration file section with FarmConfig
+const FarmSection = "Farm"
+
+// FarmConfig keeps parameters shared by all bots of the farm.
+type FarmConfig struct {
+	// UpdateMode is either ModePolling or ModeWebhook
+	UpdateMode string `cfg:"UpdateMode" default:"polling" oneof:"polling webhook"`
+	// ListenAddr is the address of the farm HTTP server serving metrics and
+	// webhooks
+	ListenAddr string `cfg:"ListenAddr" default:":8080"`
+	// WebhookURL is the public URL of the farm HTTP server, e.g. it's the URL
+	// of the reverse proxy
+	WebhookURL string `cfg:"WebhookURL"`
+	// WebhookSecret is used to derive webhook paths and secret tokens, it's
+	// required in the webhook mode. Replicas of the farm must share it.
+	WebhookSecret string `cfg:"WebhookSecret"`
+	// WebhookDeleteOnStop makes bots delete their webhooks when they stop, so
+	// Telegram keeps updates until they're started again. It suits a single
+	// replica of the farm: replicas would delete webhooks the others serve.
+	WebhookDeleteOnStop bool `cfg:"WebhookDeleteOnStop"`
+	// UserRateLimit and UserRateBurst limit requests of a user to a bot (see
+	// RateLimit)
+	UserRateLimit float64 `cfg:"UserRateLimit" default:"1" min:"0.01"`
+	UserRateBurst int     `cfg:"UserRateBurst" default:"10" min:"1"`
+	// SlowRequest is the handling time after which a request is logged as slow
+	SlowRequest time.Duration `cfg:"SlowRequest" default:"5s" min:"1ms"`
+	// AdminToken authenticates requests to the admin API (see AdminHandlers).
+	// The admin API is disabled if it's empty.
+	AdminToken string `cfg:"AdminToken"`
+	// Admins are Telegram user ids allowed to use admin commands of the bots
+	// (see Operator)
+	Admins []int64 `cfg:"Admins"`
+	// Instances are names of bot instances to run, every instance is
+	// configured by the section with its name. If it's empty, every
+	// registered bot type is run as an instance named after the type (see
+	// Instances).
+	Instances []string `cfg:"Instances"`
+}
+
+// FieldError describes a problem with a configuration field
+type FieldError struct {
+	Field string
+	Err   string
+}
+
+// ConfigError lists all problems found in the configuration of a bot
+type ConfigError struct {
+	Bot    string
+	Fields []FieldError
+}
+
+func (e *ConfigError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s's configuration is invalid:", e.Bot))
+	for _, f := range e.Fields {
+		sb.WriteString(fmt.Sprintf("\n\t%s: %s", f.Field, f.Err))
+	}
+	return sb.String()
+}
+
+func (e *ConfigError) add(field, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Err: fmt.Sprintf(format, args...)})
+}
+
+// DecodeConfig fills the configuration struct dst points to with values from
+// raw overridden by environment variables, applies defaults and validates the
+// result. All found problems are returned as *ConfigError. Fields of raw that
+// aren't fields of the struct are ignored (see UnknownFields).
+func DecodeConfig(botName string, raw RawConfig, dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%s's configuration must be a pointer to struct, got %T", botName, dst)
+	}
+
+	v = v.Elem()
+	v.Set(reflect.Zero(v.Type()))
+
+	cfgErr := &ConfigError{Bot: botName}
+	decodeStruct(botName, raw, v, cfgErr)
+
+	if len(cfgErr.Fields) > 0 {
+		return cfgErr
+	}
+
+	return nil
+}
+
+// UnknownFields returns sorted fields of raw that aren't fields of the
+// configuration struct cfg points to, e.g. misspelled fields or fields of
+// another version of the bot. They're worth a warning.
+func UnknownFields(raw RawConfig, cfg any) []string {
+	known := map[string]bool{TypeField: true}
+	for _, f := range ConfigFields(cfg) {
+		known[f.Name] = true
+	}
+
+	unknown := []string{}
+	for k := range raw {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+// ConfigField describes a field of a configuration struct
+type ConfigField struct {
+	Name     string
+	Type     string
+	Required bool
+	Default  string
+}
+
+// ConfigFields lists fields of the configuration struct cfg points to.
+func ConfigFields(cfg any) []ConfigField {
+	var fields []ConfigField
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fields
+	}
+
+	walkFields(v.Elem().Type(), func(f reflect.StructField, name string, required bool) {
+		fields = append(fields, ConfigField{
+			Name:     name,
+			Type:     f.Type.String(),
+			Required: required,
+			Default:  f.Tag.Get("default"),
+		})
+	})
+
+	return fields
+}
+
+// EnvName returns the name of the environment variable overriding the field
+func EnvName(botName, field string) string {
+	return strings.ToUpper(EnvPrefix + "_" + botName + "_" + field)
+}
+
+func walkFields(t reflect.Type, f func(reflect.StructField, string, bool)) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			walkFields(sf.Type, f)
+			continue
+		}
+
+		tag, ok := sf.Tag.Lookup("cfg")
+		if !ok || !sf.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		f(sf, name, opts == "required")
+	}
+}
+
+func decodeStruct(botName string, raw RawConfig, v reflect.Value, cfgErr *ConfigError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			decodeStruct(botName, raw, fv, cfgErr)
+			continue
+		}
+
+		tag, ok := sf.Tag.Lookup("cfg")
+		if !ok || !sf.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		val, set := raw[name]
+		if env, ok := os.LookupEnv(EnvName(botName, name)); ok {
+			val, set = env, true
+		}
+
+		if !set {
+			def, ok := sf.Tag.Lookup("default")
+			switch {
+			case ok:
+				val = def
+			case opts == "required":
+				cfgErr.add(name, "required field is missing (set it in the configuration file or in %s)", EnvName(botName, name))
+				continue
+			default:
+				continue
+			}
+		}
+
+		if err := setField(fv, val); err != nil {
+			cfgErr.add(name, "%v", err)
+			continue
+		}
+
+		if opts == "required" && fv.IsZero() {
+			cfgErr.add(name, "required field is empty")
+			continue
+		}
+
+		if err := checkRange(fv, sf.Tag); err != nil {
+			cfgErr.add(name, "%v", err)
+		}
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setField converts val read from JSON or from the environment to the field
+// type and sets it
+func setField(fv reflect.Value, val any) error {
+	if fv.Type() == durationType {
+		d, err := toDuration(val)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("expected string, got %v", val)
+		}
+		fv.SetString(s)
+
+	case reflect.Bool:
+		switch b := val.(type) {
+		case bool:
+			fv.SetBool(b)
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return fmt.Errorf("expected boolean, got %q", b)
+			}
+			fv.SetBool(parsed)
+		default:
+			return fmt.Errorf("expected boolean, got %v", val)
+		}
+
+	case reflect.Int, reflect.Int64:
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+
+	case reflect.Float64:
+		f, err := toFloat(val)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+
+	case reflect.Slice:
+		items, err := toSlice(val)
+		if err != nil {
+			return err
+		}
+
+		s := reflect.MakeSlice(fv.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setField(s.Index(i), item); err != nil {
+				return fmt.Errorf("item %d: %v", i, err)
+			}
+		}
+		fv.Set(s)
+
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+
+	return nil
+}
+
+func toInt(val any) (int64, error) {
+	switch n := val.(type) {
+	case float64:
+		if n != float64(int64(n)) {
+			return 0, fmt.Errorf("expected integer, got %v", n)
+		}
+		return int64(n), nil
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected integer, got %q", n)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("expected integer, got %v", val)
+}
+
+func toFloat(val any) (float64, error) {
+	switch n := val.(type) {
+	case float64:
+		return n, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected number, got %q", n)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("expected number, got %v", val)
+}
+
+func toDuration(val any) (time.Duration, error) {
+	switch d := val.(type) {
+	case float64:
+		return time.Duration(d * float64(time.Second)), nil
+	case string:
+		if secs, err := strconv.ParseFloat(d, 64); err == nil {
+			return time.Duration(secs * float64(time.Second)), nil
+		}
+		parsed, err := time.ParseDuration(d)
+		if err != nil {
+			return 0, fmt.Errorf("expected duration, got %q", d)
+		}
+		return parsed, nil
+	}
+	return 0, fmt.Errorf("expected duration, got %v", val)
+}
+
+// toSlice accepts a JSON array or a comma-separated list from the environment
+func toSlice(val any) ([]any, error) {
+	switch s := val.(type) {
+	case []any:
+		return s, nil
+	case string:
+		items := []any{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("expected list, got %v", val)
+}
+
+// checkRange validates numeric and duration fields against min and max tags
+// and string fields against oneof tag
+func checkRange(fv reflect.Value, tag reflect.StructTag) error {
+	if oneOf, ok := tag.Lookup("oneof"); ok && fv.Kind() == reflect.String {
+		allowed := strings.Fields(oneOf)
+		for _, a := range allowed {
+			if fv.String() == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s, got %q", strings.Join(allowed, ", "), fv.String())
+	}
+
+	for _, bound := range []string{"min", "max"} {
+		s, ok := tag.Lookup(bound)
+		if !ok {
+			continue
+		}
+
+		var cmp int
+		switch {
+		case fv.Type() == durationType:
+			limit, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("invalid %s tag %q", bound, s)
+			}
+			cmp = compare(fv.Int(), int64(limit))
+
+		case fv.Kind() == reflect.Int || fv.Kind() == reflect.Int64:
+			limit, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s tag %q", bound, s)
+			}
+			cmp = compare(fv.Int(), limit)
+
+		case fv.Kind() == reflect.Float64:
+			limit, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s tag %q", bound, s)
+			}
+			cmp = compare(fv.Float(), limit)
+
+		default:
+			continue
+		}
+
+		if bound == "min" && cmp < 0 {
+			return fmt.Errorf("must be at least %s", s)
+		}
+		if bound == "max" && cmp > 0 {
+			return fmt.Errorf("must be at most %s", s)
+		}
+	}
+
+	return nil
+}
+
+func compare[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
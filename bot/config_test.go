@@ -0,0 +1,117 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	bot.BaseConfig
+	Count   int           `cfg:"Count" default:"2" min:"1" max:"5"`
+	Rate    float64       `cfg:"Rate" default:"0.5" min:"0.1"`
+	Period  time.Duration `cfg:"Period" default:"1m" max:"1h"`
+	Mode    string        `cfg:"Mode" default:"fast" oneof:"fast slow"`
+	Enabled bool          `cfg:"Enabled"`
+	IDs     []int64       `cfg:"IDs"`
+}
+
+// baseRaw returns required fields of BaseConfig
+func baseRaw() bot.RawConfig {
+	return bot.RawConfig{"TgToken": "token", "DBConnStr": "postgres://"}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	var cfg testConfig
+	if err := bot.DecodeConfig("Test", baseRaw(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Count != 2 || cfg.Rate != 0.5 || cfg.Period != time.Minute || cfg.Mode != "fast" || cfg.Enabled {
+		t.Errorf("expected defaults, got %+v", cfg)
+	}
+	if cfg.SendRate != 25 || cfg.DBTimeout != 5*time.Second || cfg.Access != "open" {
+		t.Errorf("expected defaults of the base configuration, got %+v", cfg.BaseConfig)
+	}
+
+	// values as they're decoded from JSON
+	raw := baseRaw()
+	raw["Count"] = float64(3)
+	raw["Rate"] = float64(2)
+	raw["Period"] = float64(90) // seconds
+	raw["Mode"] = "slow"
+	raw["Enabled"] = true
+	raw["IDs"] = []any{float64(1), float64(2)}
+	raw["Type"] = "Test"
+	raw["Unknown"] = "ignored"
+	if err := bot.DecodeConfig("Test", raw, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := testConfig{BaseConfig: cfg.BaseConfig, Count: 3, Rate: 2, Period: 90 * time.Second, Mode: "slow",
+		Enabled: true, IDs: []int64{1, 2}}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+
+	if got := bot.UnknownFields(raw, &cfg); !reflect.DeepEqual(got, []string{"Unknown"}) {
+		t.Errorf("expected Unknown to be reported, got %v", got)
+	}
+}
+
+func TestDecodeConfigEnv(t *testing.T) {
+	t.Setenv("BOTFARM_TEST_TGTOKEN", "env-token")
+	t.Setenv("BOTFARM_TEST_DBCONNSTR", "postgres://env")
+	t.Setenv("BOTFARM_TEST_COUNT", " 4 ")
+	t.Setenv("BOTFARM_TEST_RATE", "1.5")
+	t.Setenv("BOTFARM_TEST_PERIOD", "30m")
+	t.Setenv("BOTFARM_TEST_ENABLED", "true")
+	t.Setenv("BOTFARM_TEST_IDS", "1, 2,,3")
+
+	// the environment overrides the file
+	var cfg testConfig
+	if err := bot.DecodeConfig("Test", bot.RawConfig{"Count": float64(1)}, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := testConfig{BaseConfig: cfg.BaseConfig, Count: 4, Rate: 1.5, Period: 30 * time.Minute, Mode: "fast",
+		Enabled: true, IDs: []int64{1, 2, 3}}
+	if !reflect.DeepEqual(cfg, want) || cfg.TgToken != "env-token" || cfg.DBConnStr != "postgres://env" {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+}
+
+func TestDecodeConfigErrors(t *testing.T) {
+	raw := bot.RawConfig{
+		"TgToken": "",
+		"Count":   float64(1.5),
+		"Rate":    float64(0.01),
+		"Period":  "2h",
+		"Mode":    "medium",
+		"Enabled": "maybe",
+		"IDs":     []any{"x"},
+	}
+
+	var cfg testConfig
+	err := bot.DecodeConfig("Test", raw, &cfg)
+	var cfgErr *bot.ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("expected ConfigError, got %v", err)
+	}
+
+	got := map[string]bool{}
+	for _, f := range cfgErr.Fields {
+		got[f.Field] = true
+	}
+	for _, field := range []string{"TgToken", "DBConnStr", "Count", "Rate", "Period", "Mode", "Enabled", "IDs"} {
+		if !got[field] {
+			t.Errorf("expected an error in %s, got %v", field, err)
+		}
+	}
+	if len(cfgErr.Fields) != 8 {
+		t.Errorf("expected 8 errors, got %v", err)
+	}
+
+	if err := bot.DecodeConfig("Test", raw, cfg); err == nil {
+		t.Error("expected an error for a non-pointer configuration")
+	}
+}
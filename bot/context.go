@@ -1,18 +1,18 @@
 package bot
 
 import (
-	"database/sql"
-
-	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.uber.org/zap"
 )
 
 // Bot context keeps references to common (Telegram Bot API, database, logger)
 // and individual parameters of a bot.
 type Context struct {
-	Bot    *tg.BotAPI
-	DB     *sql.DB
+	Name   string // bot name
+	Bot    Transport
+	DB     *DB
 	Logger *zap.SugaredLogger
+	I18n   *I18n     // selects the user's language, may be nil
+	L      Localizer // messages in the user's language, set by CloneWith
 	values map[string]any
 }
 
@@ -36,11 +36,17 @@ func (ctx *Context) Put(key string, val any) {
 // Clone creates a copy of the context where Logger and Values are updated with "usr"=usr
 func (ctx *Context) CloneWith(usr int64) *Context {
 	newCtx := Context{
+		Name: ctx.Name,
 		Bot: ctx.Bot,
 		DB: ctx.DB,
 		Logger: ctx.Logger.With("usr", usr),
+		I18n: ctx.I18n,
+		L: ctx.L,
 		values: make(map[string]any),
 	}
+	if ctx.I18n != nil {
+		newCtx.L = ctx.I18n.For(usr)
+	}
 
 	for k, v := range ctx.values {
 		newCtx.Put(k, v)
@@ -0,0 +1,359 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// storeTimeout limits the time of loading and saving a session
+const storeTimeout = 5 * time.Second
+
+// StateIdle is the initial state of every conversation
+const StateIdle = "idle"
+
+// CmdCancel is the command that cancels any ongoing conversation
+const CmdCancel = "cancel"
+
+// ErrSessionUnavailable is returned when the session of the user can't be
+// loaded from the store, e.g. the database is down. The session isn't changed
+// then, so the user should retry later.
+var ErrSessionUnavailable = errors.New("conversation session is unavailable")
+
+// TxtSessionUnavailable is the key of the base message asking the user to
+// retry when the session is unavailable
+const TxtSessionUnavailable = "conversation.unavailable"
+
+// Session is the conversation with a user. P is the type of the payload
+// carried between the steps of the conversation (e.g. a movie being added).
+type Session[P any] struct {
+	User    int64
+	State   string
+	Anchor  int // ID of the message the conversation is bound to, if any
+	Payload P
+	Updated time.Time
+
+	conv *Conversation[P]
+}
+
+// To moves the conversation to the next state. Moving to the current state or
+// to StateIdle is always allowed, other transitions must be declared with
+// Conversation.State.
+func (s *Session[P]) To(next string) error {
+	if next == s.State || next == StateIdle {
+		s.State = next
+		return nil
+	}
+
+	st, ok := s.conv.states[s.State]
+	if !ok || !st.next[next] {
+		return fmt.Errorf("transition from %q to %q isn't allowed", s.State, next)
+	}
+
+	s.State = next
+	return nil
+}
+
+// Reset moves the conversation to StateIdle and clears the payload. The anchor
+// message is kept.
+func (s *Session[P]) Reset() {
+	var p P
+	s.State = StateIdle
+	s.Payload = p
+}
+
+// StepHandler handles a message at a state of a conversation and returns the
+// next state.
+type StepHandler[P any] func(req *Request, s *Session[P]) string
+
+type convState[P any] struct {
+	handler StepHandler[P]
+	next    map[string]bool
+}
+
+// Conversation describes a multi-step dialog as a finite state machine with
+// named states, a handler per state and allowed transitions between states.
+// Conversations of different users are independent, steps of a conversation
+// with a user are handled one at a time.
+type Conversation[P any] struct {
+	timeout     time.Duration
+	states      map[string]*convState[P]
+	store       StateStore
+	unavailable HandlerFunc
+
+	mu       sync.Mutex
+	sessions map[int64]*lockedSession[P]
+	prune    int // number of sessions to drop idle ones at
+}
+
+type lockedSession[P any] struct {
+	mu     sync.Mutex
+	s      Session[P]
+	loaded bool
+	saved  SessionRecord
+	dirty  bool // the last change failed to be saved
+	refs   int  // callers using the session, guarded by Conversation.mu
+}
+
+// NewConversation creates a conversation. A conversation that isn't updated
+// for timeout gets back to StateIdle; zero timeout means no timeout.
+func NewConversation[P any](timeout time.Duration) *Conversation[P] {
+	c := &Conversation[P]{
+		timeout:  timeout,
+		states:   make(map[string]*convState[P]),
+		sessions: make(map[int64]*lockedSession[P]),
+		prune:    maxIdleEntries,
+	}
+	c.State(StateIdle, nil)
+	return c
+}
+
+// State declares the state with the handler of messages received at this state
+// and the states the conversation is allowed to move to from it. The handler
+// may be nil if the state doesn't expect messages.
+func (c *Conversation[P]) State(name string, h StepHandler[P], next ...string) {
+	st := &convState[P]{handler: h, next: make(map[string]bool)}
+	for _, n := range next {
+		st.next[n] = true
+	}
+	c.states[name] = st
+}
+
+// Persist makes the conversation keep sessions in the store. Sessions are
+// loaded from the store on the first update of the user and saved after every
+// change. Once there are many sessions, saved sessions that are idle or timed
+// out are dropped from memory, they're loaded again when needed. Persist
+// should be called before the conversation is used.
+func (c *Conversation[P]) Persist(store StateStore) {
+	c.store = store
+}
+
+// Unavailable sets the handler called by Handle and the /cancel command of
+// Routes when the session of the user is unavailable (see
+// ErrSessionUnavailable), e.g. to ask the user to retry. It should be set
+// before the conversation is used.
+func (c *Conversation[P]) Unavailable(h HandlerFunc) {
+	c.unavailable = h
+}
+
+// With runs f with the session of the user. Changes made by f are kept. If the
+// session is unavailable, f isn't run and an error wrapping
+// ErrSessionUnavailable is returned.
+func (c *Conversation[P]) With(req *Request, f func(s *Session[P])) error {
+	ls := c.session(req.User)
+	defer c.release(ls)
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if err := c.load(req.Bot, ls); errors.Is(err, ErrSessionUnavailable) {
+		req.Logger.Errorw("failed loading conversation", "err", err)
+		return err
+	} else if err != nil {
+		req.Logger.Errorw("failed decoding conversation; starting over", "err", err)
+	}
+
+	c.expire(req, &ls.s)
+	f(&ls.s)
+	ls.s.Updated = time.Now()
+
+	err := c.save(req.Bot, ls)
+	if err != nil {
+		req.Logger.Errorw("failed saving conversation", "err", err)
+	}
+	ls.dirty = err != nil
+
+	return nil
+}
+
+// Get returns a copy of the user's session of the named bot
+func (c *Conversation[P]) Get(bot string, usr int64) (Session[P], error) {
+	ls := c.session(usr)
+	defer c.release(ls)
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	err := c.load(bot, ls)
+	return ls.s, err
+}
+
+// Start starts a new conversation at the given state regardless of the
+// current state, e.g. when the user sends a command.
+func (c *Conversation[P]) Start(req *Request, state string) error {
+	return c.With(req, func(s *Session[P]) {
+		s.Reset()
+		s.State = state
+	})
+}
+
+// Cancel gets the user's conversation back to StateIdle.
+func (c *Conversation[P]) Cancel(req *Request) error {
+	return c.With(req, func(s *Session[P]) {
+		s.Reset()
+	})
+}
+
+// Handle passes the message to the handler of the current state and moves the
+// conversation to the state returned by the handler. It's a HandlerFunc.
+func (c *Conversation[P]) Handle(req *Request) {
+	err := c.With(req, func(s *Session[P]) {
+		st, ok := c.states[s.State]
+		if !ok || st.handler == nil {
+			req.Logger.Debugw("no handler for conversation state", "state", s.State)
+			return
+		}
+
+		next := st.handler(req, s)
+		if err := s.To(next); err != nil {
+			req.Logger.Errorw("conversation got out of sync; resetting", "err", err)
+			s.Reset()
+		}
+	})
+	if err != nil && c.unavailable != nil {
+		c.unavailable(req)
+	}
+}
+
+// Routes registers Handle as the text handler of the router and the universal
+// /cancel command. reply, if not nil, is called after the conversation is
+// canceled.
+func (c *Conversation[P]) Routes(r *Router, reply HandlerFunc) {
+	r.Text(c.Handle)
+	r.Command(CmdCancel, func(req *Request) {
+		if err := c.Cancel(req); err != nil {
+			if c.unavailable != nil {
+				c.unavailable(req)
+			}
+			return
+		}
+		if reply != nil {
+			reply(req)
+		}
+	})
+}
+
+// session returns the session of the user, which must be released with
+// release after use
+func (c *Conversation[P]) session(usr int64) *lockedSession[P] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ls, ok := c.sessions[usr]
+	if !ok {
+		if c.store != nil {
+			c.prune = pruneIdle(c.sessions, c.prune, c.idle)
+		}
+
+		ls = &lockedSession[P]{s: Session[P]{User: usr, State: StateIdle, conv: c}}
+		c.sessions[usr] = ls
+	}
+	ls.refs++
+
+	return ls
+}
+
+func (c *Conversation[P]) release(ls *lockedSession[P]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ls.refs--
+}
+
+// idle reports whether the session may be dropped, i.e. nobody uses it and
+// it's idle or timed out and saved, so it's loaded as it is. c.mu must be
+// held.
+func (c *Conversation[P]) idle(ls *lockedSession[P]) bool {
+	if ls.refs > 0 || ls.dirty {
+		return false
+	}
+
+	return ls.s.State == StateIdle || (c.timeout != 0 && time.Since(ls.s.Updated) > c.timeout)
+}
+
+// load loads the session from the store once. If the store fails, an error
+// wrapping ErrSessionUnavailable is returned, and the session is loaded again
+// next time. A session that can't be decoded isn't loaded again, the
+// conversation starts over instead.
+func (c *Conversation[P]) load(bot string, ls *lockedSession[P]) error {
+	if c.store == nil || ls.loaded {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	rec, err := c.store.Load(ctx, bot, ls.s.User)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSessionUnavailable, err)
+	}
+	ls.loaded = true
+	if rec == nil {
+		return nil
+	}
+
+	var p P
+	if len(rec.Payload) > 0 {
+		if err := json.Unmarshal(rec.Payload, &p); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := c.states[rec.State]; !ok {
+		return fmt.Errorf("unknown conversation state %q", rec.State)
+	}
+
+	ls.s.State = rec.State
+	ls.s.Anchor = rec.Anchor
+	ls.s.Payload = p
+	ls.s.Updated = rec.Updated
+	ls.saved = *rec
+
+	return nil
+}
+
+// save saves the session if it's changed since it was loaded or saved last
+// time. Sessions at StateIdle are saved only on changes since they don't
+// expire.
+func (c *Conversation[P]) save(bot string, ls *lockedSession[P]) error {
+	if c.store == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(ls.s.Payload)
+	if err != nil {
+		return err
+	}
+
+	rec := SessionRecord{State: ls.s.State, Anchor: ls.s.Anchor, Payload: payload, Updated: ls.s.Updated}
+	if rec.State == StateIdle && rec.State == ls.saved.State && rec.Anchor == ls.saved.Anchor &&
+		bytes.Equal(rec.Payload, ls.saved.Payload) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err = c.store.Save(ctx, bot, ls.s.User, rec); err != nil {
+		return err
+	}
+
+	ls.saved = rec
+	return nil
+}
+
+// expire resets the conversation that hasn't been updated for too long
+func (c *Conversation[P]) expire(req *Request, s *Session[P]) {
+	if c.timeout == 0 || s.State == StateIdle || s.Updated.IsZero() {
+		return
+	}
+
+	if time.Since(s.Updated) > c.timeout {
+		req.Logger.Infow("conversation timed out", "state", s.State)
+		s.Reset()
+	}
+}
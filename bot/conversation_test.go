@@ -0,0 +1,152 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+func text(usr int64, text string) *tg.Update {
+	return &tg.Update{Message: &tg.Message{From: &tg.User{ID: usr}, Chat: &tg.Chat{ID: usr}, Text: text}}
+}
+
+func TestConversation(t *testing.T) {
+	r := bot.NewRouter("test", zap.NewNop().Sugar())
+	c := bot.NewConversation[[]string](0)
+
+	c.State(bot.StateIdle, nil, "first")
+	c.State("first", func(req *bot.Request, s *bot.Session[[]string]) string {
+		s.Payload = append(s.Payload, req.Message().Text)
+		return "second"
+	}, "second")
+	c.State("second", func(req *bot.Request, s *bot.Session[[]string]) string {
+		s.Payload = append(s.Payload, req.Message().Text)
+		return "first" // not allowed
+	})
+	c.Routes(r, nil)
+	r.Command("start", func(req *bot.Request) { c.Start(req, "first") })
+
+	r.Handle(command(1, "/start", 6))
+	r.Handle(text(1, "a"))
+	r.Handle(text(2, "b"))
+
+	if s, _ := c.Get("test", 1); s.State != "second" || len(s.Payload) != 1 || s.Payload[0] != "a" {
+		t.Errorf("unexpected session %+v", s)
+	}
+	if s, _ := c.Get("test", 2); s.State != bot.StateIdle || s.Payload != nil {
+		t.Errorf("unexpected session of another user %+v", s)
+	}
+
+	r.Handle(text(1, "c"))
+	if s, _ := c.Get("test", 1); s.State != bot.StateIdle || s.Payload != nil {
+		t.Errorf("session wasn't reset on invalid transition %+v", s)
+	}
+
+	r.Handle(command(1, "/start", 6))
+	r.Handle(command(1, "/cancel", 7))
+	if s, _ := c.Get("test", 1); s.State != bot.StateIdle {
+		t.Errorf("session wasn't canceled %+v", s)
+	}
+}
+
+func TestConversationPersist(t *testing.T) {
+	store := bot.NewMemoryStore()
+	newConv := func() (*bot.Router, *bot.Conversation[[]string]) {
+		r := bot.NewRouter("test", zap.NewNop().Sugar())
+		c := bot.NewConversation[[]string](0)
+		c.Persist(store)
+		c.State(bot.StateIdle, nil, "first")
+		c.State("first", func(req *bot.Request, s *bot.Session[[]string]) string {
+			s.Payload = append(s.Payload, req.Message().Text)
+			s.Anchor = 42
+			return "first"
+		})
+		c.Routes(r, nil)
+		r.Command("start", func(req *bot.Request) { c.Start(req, "first") })
+		return r, c
+	}
+
+	r, _ := newConv()
+	r.Handle(command(1, "/start", 6))
+	r.Handle(text(1, "a"))
+
+	// restart
+	r, c := newConv()
+	r.Handle(text(1, "b"))
+
+	s, err := c.Get("test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.State != "first" || s.Anchor != 42 || !reflect.DeepEqual(s.Payload, []string{"a", "b"}) {
+		t.Errorf("session wasn't restored %+v", s)
+	}
+
+	// many users make idle sessions to be dropped, the ongoing one continues
+	for usr := int64(2); usr < 20002; usr++ {
+		r.Handle(text(usr, "hi"))
+	}
+	r.Handle(text(1, "c"))
+	if s, _ := c.Get("test", 1); s.State != "first" || !reflect.DeepEqual(s.Payload, []string{"a", "b", "c"}) {
+		t.Errorf("ongoing session wasn't kept %+v", s)
+	}
+}
+
+// downStore is a StateStore failing to load sessions while it's down
+type downStore struct {
+	bot.StateStore
+	down bool
+}
+
+func (s *downStore) Load(ctx context.Context, bot string, usr int64) (*bot.SessionRecord, error) {
+	if s.down {
+		return nil, errors.New("connection refused")
+	}
+	return s.StateStore.Load(ctx, bot, usr)
+}
+
+func TestConversationStoreDown(t *testing.T) {
+	store := &downStore{StateStore: bot.NewMemoryStore()}
+	err := store.Save(context.Background(), "test", 1, bot.SessionRecord{State: "first", Payload: []byte(`["a"]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bot.NewRouter("test", zap.NewNop().Sugar())
+	c := bot.NewConversation[[]string](0)
+	c.Persist(store)
+	c.State(bot.StateIdle, nil, "first")
+	c.State("first", func(req *bot.Request, s *bot.Session[[]string]) string {
+		s.Payload = append(s.Payload, req.Message().Text)
+		return "first"
+	})
+	c.Routes(r, nil)
+	unavailable := 0
+	c.Unavailable(func(req *bot.Request) { unavailable++ })
+
+	store.down = true
+	r.Handle(text(1, "b"))
+	r.Handle(command(1, "/cancel", 7))
+	if unavailable != 2 {
+		t.Errorf("unavailable handler was called %d times, want 2", unavailable)
+	}
+	if _, err := c.Get("test", 1); !errors.Is(err, bot.ErrSessionUnavailable) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// the saved session isn't overwritten and is loaded once the store is back
+	store.down = false
+	r.Handle(text(1, "c"))
+	s, err := c.Get("test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.State != "first" || !reflect.DeepEqual(s.Payload, []string{"a", "c"}) {
+		t.Errorf("session wasn't restored %+v", s)
+	}
+}
@@ -0,0 +1,192 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// OpenDB connects to the Postgres database of the named bot. Queries made
+// through the connection are measured (see Metrics).
+func OpenDB(name, connStr string) (*sql.DB, error) {
+	cfg, err := pgx.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Tracer = queryTracer{bot: name}
+
+	d := stdlib.OpenDB(*cfg)
+	if err = d.Ping(); err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// DBConfig controls timeouts and retries of database operations
+type DBConfig struct {
+	Timeout    time.Duration // time limit of an attempt
+	Attempts   int           // attempts made on transient errors
+	RetryDelay time.Duration // delay before the first retry, doubled on every retry
+}
+
+// Querier is implemented by *sql.DB, *sql.Conn and *sql.Tx
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// DB is the database of a bot. Operations made with Do, Tx and Exec have
+// a deadline and are retried on errors that leave the database unchanged (see
+// IsRetryable and IsTxRetryable). Other errors are returned right away.
+type DB struct {
+	*sql.DB
+	bot string
+	cfg DBConfig
+}
+
+// ConnectDB connects to the Postgres database of the named bot (see OpenDB)
+func ConnectDB(name, connStr string, cfg DBConfig) (*DB, error) {
+	d, err := OpenDB(name, connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDB(name, d, cfg), nil
+}
+
+// NewDB wraps the database of the named bot
+func NewDB(name string, d *sql.DB, cfg DBConfig) *DB {
+	if cfg.Attempts < 1 {
+		cfg.Attempts = 1
+	}
+
+	return &DB{DB: d, bot: name, cfg: cfg}
+}
+
+// Do calls f with the database. op names the operation in errors and metrics.
+// ctx passed to f is canceled when f returns, so f must read all rows it
+// queries. f is called again only if its query didn't reach the database (see
+// IsRetryable), so f making several changes should use Tx instead.
+func (d *DB) Do(ctx context.Context, op string, f func(ctx context.Context, q Querier) error) error {
+	return d.retry(ctx, op, IsRetryable, func(ctx context.Context) error {
+		return f(ctx, d.DB)
+	})
+}
+
+// Tx calls f in a transaction and commits it. The whole transaction is
+// retried on serialization failures and deadlocks (see IsTxRetryable), so f
+// may be called several times.
+func (d *DB) Tx(ctx context.Context, op string, opts *sql.TxOptions, f func(ctx context.Context, tx *sql.Tx) error) error {
+	return d.retry(ctx, op, IsTxRetryable, func(ctx context.Context) error {
+		tx, err := d.BeginTx(ctx, opts)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err = f(ctx, tx); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+}
+
+// Exec executes the query that doesn't return rows
+func (d *DB) Exec(ctx context.Context, op, query string, args ...any) (sql.Result, error) {
+	var res sql.Result
+	err := d.Do(ctx, op, func(ctx context.Context, q Querier) (err error) {
+		res, err = q.ExecContext(ctx, query, args...)
+		return err
+	})
+	return res, err
+}
+
+func (d *DB) retry(ctx context.Context, op string, retryable func(error) bool,
+	f func(ctx context.Context) error) error {
+	delay := d.cfg.RetryDelay
+	for i := 0; ; i++ {
+		err := d.attempt(ctx, f)
+		if err == nil {
+			return nil
+		}
+
+		if !retryable(err) {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if i == d.cfg.Attempts-1 {
+			retriesExhaustedTotal.WithLabelValues(d.bot, op).Inc()
+			return fmt.Errorf("%s: %d attempts failed: %w", op, d.cfg.Attempts, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", op, err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+		retriesTotal.WithLabelValues(d.bot, op).Inc()
+	}
+}
+
+func (d *DB) attempt(ctx context.Context, f func(ctx context.Context) error) error {
+	if d.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.cfg.Timeout)
+		defer cancel()
+	}
+
+	return f(ctx)
+}
+
+// IsRetryable reports whether the database error leaves the database
+// unchanged, so the failed statement can be executed again: the server
+// refused the connection or the connection broke before the statement was
+// sent.
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "57P01", // admin_shutdown
+			"57P03", // cannot_connect_now
+			"53300": // too_many_connections
+			return true
+		}
+		// connection exceptions
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	// SafeToRetry doesn't unwrap errors
+	for ; err != nil; err = errors.Unwrap(err) {
+		if pgconn.SafeToRetry(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTxRetryable reports whether the transaction failed on a transient error,
+// so the whole transaction can be retried: a serialization failure or
+// a deadlock rolled it back or it failed with an error IsRetryable accepts.
+func IsTxRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01") {
+		return true
+	}
+
+	return IsRetryable(err)
+}
@@ -0,0 +1,153 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// unsent is a connection error raised before the query is sent
+type unsent struct{}
+
+func (unsent) Error() string     { return "connection refused" }
+func (unsent) SafeToRetry() bool { return true }
+
+// commitDriver is a database/sql driver whose transactions fail to commit with
+// queued errors
+type commitDriver struct {
+	mu      sync.Mutex
+	errs    []error
+	commits int
+}
+
+func (d *commitDriver) Open(string) (driver.Conn, error) { return commitConn{d}, nil }
+
+type commitConn struct{ d *commitDriver }
+
+func (c commitConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c commitConn) Close() error                        { return nil }
+func (c commitConn) Begin() (driver.Tx, error)           { return c, nil }
+func (c commitConn) Rollback() error                     { return nil }
+
+func (c commitConn) Commit() error {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+
+	c.d.commits++
+	if len(c.d.errs) == 0 {
+		return nil
+	}
+	err := c.d.errs[0]
+	c.d.errs = c.d.errs[1:]
+	return err
+}
+
+func TestDBRetry(t *testing.T) {
+	d := bot.NewDB("test", nil, bot.DBConfig{Timeout: time.Second, Attempts: 3, RetryDelay: time.Millisecond})
+	serialization := &pgconn.PgError{Code: "40001"}
+
+	calls := 0
+	err := d.Do(context.Background(), "test", func(ctx context.Context, q bot.Querier) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected the attempt to have a deadline")
+		}
+		calls++
+		if calls < 3 {
+			return unsent{}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("expected success after 3 attempts, got %v after %d", err, calls)
+	}
+
+	calls = 0
+	err = d.Do(context.Background(), "test", func(ctx context.Context, q bot.Querier) error {
+		calls++
+		return unsent{}
+	})
+	if !errors.As(err, new(unsent)) || calls != 3 {
+		t.Errorf("expected connection error after 3 attempts, got %v after %d", err, calls)
+	}
+
+	// statements outside transactions aren't retried when they may have
+	// changed the database
+	for _, perm := range []error{sql.ErrNoRows, serialization, io.ErrUnexpectedEOF} {
+		calls = 0
+		err = d.Do(context.Background(), "test", func(ctx context.Context, q bot.Querier) error {
+			calls++
+			return perm
+		})
+		if !errors.Is(err, perm) || calls != 1 {
+			t.Errorf("expected %v after 1 attempt, got %v after %d", perm, err, calls)
+		}
+	}
+}
+
+func TestTxRetry(t *testing.T) {
+	drv := &commitDriver{errs: []error{&pgconn.PgError{Code: "40001"}, &pgconn.PgError{Code: "40P01"}}}
+	name := fmt.Sprintf("commit-%p", drv)
+	sql.Register(name, drv)
+	conn, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	d := bot.NewDB("test", conn, bot.DBConfig{Timeout: time.Second, Attempts: 3, RetryDelay: time.Millisecond})
+	calls := 0
+	err = d.Tx(context.Background(), "test", nil, func(ctx context.Context, tx *sql.Tx) error {
+		calls++
+		return nil
+	})
+	if err != nil || calls != 3 || drv.commits != 3 {
+		t.Errorf("expected the transaction to commit on the 3rd attempt, got %v after %d", err, calls)
+	}
+
+	drv.errs = []error{io.ErrUnexpectedEOF}
+	calls = 0
+	err = d.Tx(context.Background(), "test", nil, func(ctx context.Context, tx *sql.Tx) error {
+		calls++
+		return nil
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || calls != 1 {
+		t.Errorf("expected the lost commit not to be retried, got %v after %d", err, calls)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		stmt bool // IsRetryable
+		tx   bool // IsTxRetryable
+	}{
+		{err: unsent{}, stmt: true, tx: true},
+		{err: fmt.Errorf("query: %w", unsent{}), stmt: true, tx: true},
+		{err: driver.ErrBadConn, stmt: true, tx: true},
+		{err: &pgconn.PgError{Code: "53300"}, stmt: true, tx: true},
+		{err: &pgconn.PgError{Code: "08006"}, stmt: true, tx: true},
+		{err: &pgconn.PgError{Code: "40001"}, tx: true},
+		{err: &pgconn.PgError{Code: "40P01"}, tx: true},
+		{err: &pgconn.PgError{Code: "23505"}},
+		{err: &pgconn.PgError{Code: "0"}},
+		{err: &pgconn.PgError{}},
+		{err: io.ErrUnexpectedEOF},
+		{err: sql.ErrNoRows},
+	} {
+		if got := bot.IsRetryable(tc.err); got != tc.stmt {
+			t.Errorf("IsRetryable(%v) = %t", tc.err, got)
+		}
+		if got := bot.IsTxRetryable(tc.err); got != tc.tx {
+			t.Errorf("IsTxRetryable(%v) = %t", tc.err, got)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"context"
+	"sync"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// Dispatcher passes updates of a bot to the handler. Updates of a user are
+// handled one at a time in the order they're received, so handlers don't race
+// on the user's state. Updates of different users are handled concurrently by
+// up to workers goroutines.
+type Dispatcher struct {
+	handle func(*tg.Update)
+	logger *zap.SugaredLogger
+	sem    chan struct{}
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	queues map[int64][]*tg.Update // a key is present while its worker runs
+	closed bool
+}
+
+// NewDispatcher creates a dispatcher that runs h with up to workers
+// goroutines. A panic in h is recovered and logged with l.
+func NewDispatcher(workers int, h func(*tg.Update), l *zap.SugaredLogger) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &Dispatcher{
+		handle: h,
+		logger: l,
+		sem:    make(chan struct{}, workers),
+		queues: make(map[int64][]*tg.Update),
+	}
+}
+
+// Dispatch queues the update for handling. It blocks while all workers are
+// busy with other users. Once Wait is called, Dispatch drops updates and
+// returns false.
+func (d *Dispatcher) Dispatch(u *tg.Update) bool {
+	key := updateKey(u)
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return false
+	}
+
+	if q, ok := d.queues[key]; ok {
+		d.queues[key] = append(q, u)
+		d.mu.Unlock()
+		return true
+	}
+
+	d.queues[key] = []*tg.Update{u}
+	d.wg.Add(1)
+	d.mu.Unlock()
+
+	d.sem <- struct{}{}
+	go d.work(key)
+
+	return true
+}
+
+// work handles updates queued for the key until the queue is empty
+func (d *Dispatcher) work(key int64) {
+	defer d.wg.Done()
+	defer func() { <-d.sem }()
+
+	for {
+		d.mu.Lock()
+		q := d.queues[key]
+		if len(q) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		u := q[0]
+		q[0] = nil
+		d.queues[key] = q[1:]
+		d.mu.Unlock()
+
+		d.run(u)
+	}
+}
+
+func (d *Dispatcher) run(u *tg.Update) {
+	defer Recover(d.logger, "handler")
+	d.handle(u)
+}
+
+// Wait stops accepting new updates and waits until queued updates are handled
+// or ctx is done, whichever comes first.
+func (d *Dispatcher) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reset makes the dispatcher accept updates again after Wait.
+func (d *Dispatcher) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = false
+}
+
+// updateKey returns the ID of the user who sent the update or the chat ID if
+// there's no user (e.g. channel posts)
+func updateKey(u *tg.Update) int64 {
+	if usr := u.SentFrom(); usr != nil {
+		return usr.ID
+	}
+	if cht := u.FromChat(); cht != nil {
+		return cht.ID
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+func TestDispatcher(t *testing.T) {
+	const users, updates, workers = 10, 20, 3
+
+	var mu sync.Mutex
+	got := make(map[int64][]int)
+	var running, maxRunning int32
+
+	d := bot.NewDispatcher(workers, func(u *tg.Update) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		got[u.Message.From.ID] = append(got[u.Message.From.ID], u.UpdateID)
+		mu.Unlock()
+	}, zap.NewNop().Sugar())
+
+	for i := 0; i < updates; i++ {
+		for usr := int64(1); usr <= users; usr++ {
+			d.Dispatch(&tg.Update{UpdateID: i, Message: &tg.Message{From: &tg.User{ID: usr}}})
+		}
+	}
+
+	if err := d.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if maxRunning > workers {
+		t.Errorf("expected up to %d workers, got %d", workers, maxRunning)
+	}
+	for usr := int64(1); usr <= users; usr++ {
+		if len(got[usr]) != updates {
+			t.Fatalf("expected %d updates of user %d, got %d", updates, usr, len(got[usr]))
+		}
+		for i, id := range got[usr] {
+			if id != i {
+				t.Fatalf("updates of user %d are out of order: %v", usr, got[usr])
+			}
+		}
+	}
+
+	if d.Dispatch(&tg.Update{Message: &tg.Message{From: &tg.User{ID: 1}}}) {
+		t.Error("update dispatched after Wait")
+	}
+}
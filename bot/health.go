@@ -0,0 +1,237 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthTimeout limits the time of health checks of a bot
+const healthTimeout = 5 * time.Second
+
+// HealthChecker is implemented by bots that can check their dependencies
+// (database, background loops, etc.).
+type HealthChecker interface {
+	// Health returns results of named checks, nil means the check passed
+	Health(ctx context.Context) map[string]error
+}
+
+// lastUpdates keeps the time of the last update received by every bot
+var lastUpdates sync.Map
+
+// touch records that the bot received an update
+func touch(bot string) {
+	lastUpdates.Store(bot, time.Now())
+}
+
+// LastUpdate returns the time of the last update received by the bot or zero
+// time if there were none.
+func LastUpdate(bot string) time.Time {
+	t, ok := lastUpdates.Load(bot)
+	if !ok {
+		return time.Time{}
+	}
+	return t.(time.Time)
+}
+
+// Report is the health report of a bot
+type Report struct {
+	Name        string            `json:"name"`
+	State       string            `json:"state"`
+	Initialized bool              `json:"initialized"`
+	Disabled    bool              `json:"disabled"`
+	Healthy     bool              `json:"healthy"`
+	Restarts    int               `json:"restarts"`
+	LastError   string            `json:"last_error,omitempty"`
+	Since       time.Time         `json:"since"`
+	LastUpdate  *time.Time        `json:"last_update,omitempty"`
+	Checks      map[string]string `json:"checks,omitempty"`
+}
+
+// Report returns health reports of all supervised bots. A bot is healthy if
+// it's running and all its health checks pass.
+func (s *Supervisor) Report(ctx context.Context) []Report {
+	s.mu.Lock()
+	bots := append([]*supervised{}, s.bots...)
+	s.mu.Unlock()
+
+	reports := make([]Report, len(bots))
+	var wg sync.WaitGroup
+	for i, sb := range bots {
+		wg.Add(1)
+		go func(i int, sb *supervised) {
+			defer wg.Done()
+			reports[i] = s.report(ctx, sb)
+		}(i, sb)
+	}
+	wg.Wait()
+
+	return reports
+}
+
+// ReportBot returns the health report of the named bot
+func (s *Supervisor) ReportBot(ctx context.Context, name string) (Report, error) {
+	sb := s.find(name)
+	if sb == nil {
+		return Report{}, ErrUnknownBot
+	}
+	return s.report(ctx, sb), nil
+}
+
+// report returns the health report of the bot. Health checks hold sb.op, as
+// they use resources the bot creates in Init and releases in Stop. A bot that
+// is being started or stopped is reported unhealthy without waiting for it.
+func (s *Supervisor) report(ctx context.Context, sb *supervised) Report {
+	busy := !sb.op.TryLock()
+	if !busy {
+		defer sb.op.Unlock()
+	}
+
+	s.mu.Lock()
+	st := sb.status
+	r := Report{
+		Name:        st.Name,
+		State:       st.State.String(),
+		Initialized: sb.initialized,
+		Disabled:    sb.disabled,
+		Healthy:     st.State == StateRunning,
+		Restarts:    st.Restarts,
+		Since:       st.Since,
+	}
+	if st.LastError != nil {
+		r.LastError = st.LastError.Error()
+	}
+	s.mu.Unlock()
+
+	if t := LastUpdate(r.Name); !t.IsZero() {
+		r.LastUpdate = &t
+	}
+
+	if busy {
+		if r.Healthy {
+			r.Healthy = false
+			r.Checks = map[string]string{"supervisor": "bot is being started or stopped"}
+		}
+		return r
+	}
+
+	check(ctx, sb.bot, &r)
+	return r
+}
+
+// check adds results of health checks to the report
+func check(ctx context.Context, b Bot, r *Report) {
+	hc, ok := b.(HealthChecker)
+	if !ok || !r.Healthy {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	checks := func() (res map[string]error) {
+		defer func() {
+			if p := recover(); p != nil {
+				res = map[string]error{"panic": fmt.Errorf("health check panicked: %v", p)}
+			}
+		}()
+		return hc.Health(ctx)
+	}()
+
+	r.Checks = make(map[string]string, len(checks))
+	for name, err := range checks {
+		if err != nil {
+			r.Checks[name] = err.Error()
+			r.Healthy = false
+		} else {
+			r.Checks[name] = "ok"
+		}
+	}
+}
+
+// redacted replaces error messages in /status responses to requests without
+// the admin token, as they may reveal internals of the farm
+const redacted = "redacted"
+
+// HealthHandlers registers the health endpoints in the server:
+//   - /healthz reports that the farm process is alive
+//   - /readyz reports whether all bots but ones disabled with
+//     Supervisor.StopBot or failed to initialize are healthy, so one
+//     misconfigured bot doesn't make the whole farm unready. Such bots are
+//     still reported by /readyz/<name> and /status.
+//   - /readyz/<name> reports whether the named bot is healthy
+//   - /status lists health reports of all bots in JSON. Errors are redacted
+//     unless the request has the admin token like admin API requests (see
+//     AdminHandlers).
+func HealthHandlers(srv *Server, s *Supervisor, adminToken string) {
+	srv.Handle("/healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	}))
+
+	srv.Handle("/readyz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var unhealthy []string
+		for _, rep := range s.Report(r.Context()) {
+			if !rep.Healthy && !rep.Disabled && rep.State != StateFailed.String() {
+				unhealthy = append(unhealthy, rep.Name)
+			}
+		}
+
+		if len(unhealthy) > 0 {
+			http.Error(w, "unhealthy bots: "+strings.Join(unhealthy, ", "), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}))
+
+	srv.Handle("/readyz/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep, err := s.ReportBot(r.Context(), strings.TrimPrefix(r.URL.Path, "/readyz/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if !rep.Healthy {
+			http.Error(w, rep.Name+" isn't healthy", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}))
+
+	srv.Handle("/status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reports := s.Report(r.Context())
+		ready := true
+		for _, rep := range reports {
+			ready = ready && (rep.Healthy || rep.Disabled)
+		}
+
+		if !authorized(r, adminToken) {
+			for i := range reports {
+				redact(&reports[i])
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			Ready bool     `json:"ready"`
+			Bots  []Report `json:"bots"`
+		}{ready, reports}); err != nil {
+			s.logger.Errorw("failed writing status", "err", err)
+		}
+	}))
+}
+
+// redact replaces error messages in the report
+func redact(r *Report) {
+	if r.LastError != "" {
+		r.LastError = redacted
+	}
+	for name, res := range r.Checks {
+		if res != "ok" {
+			r.Checks[name] = redacted
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// healthBot checks the connection it opens in Init and closes in Stop. All of
+// them take a while like with a real database.
+type healthBot struct {
+	fakeBot
+	conn chan struct{}
+	err  error // returned by the database check
+}
+
+func newHealthBot(t *testing.T, err error) *healthBot {
+	b := &healthBot{err: err}
+	if err := b.Init(zap.NewNop().Sugar()); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (b *healthBot) Init(l *zap.SugaredLogger) error {
+	b.conn = make(chan struct{})
+	time.Sleep(time.Millisecond)
+	return b.fakeBot.Init(l)
+}
+
+func (b *healthBot) Stop(ctx context.Context) error {
+	close(b.conn)
+	time.Sleep(time.Millisecond)
+	return b.fakeBot.Stop(ctx)
+}
+
+func (b *healthBot) Health(context.Context) map[string]error {
+	time.Sleep(time.Millisecond)
+	select {
+	case <-b.conn:
+		return map[string]error{"db": errors.New("connection is closed")}
+	default:
+		return map[string]error{"db": b.err}
+	}
+}
+
+// runFarm runs the supervisor until the test ends and waits for all bots to
+// run
+func runFarm(t *testing.T, s *bot.Supervisor) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		s.Stop(context.Background())
+	})
+
+	for i := 0; i < 100; i++ {
+		running := true
+		for _, st := range s.Status() {
+			running = running && st.State == bot.StateRunning
+		}
+		if running {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected all bots to run, got %+v", s.Status())
+}
+
+func TestHealthHandlers(t *testing.T) {
+	l := zap.NewNop().Sugar()
+	s := bot.NewSupervisor(l)
+	s.Add("ok", newHealthBot(t, nil), l)
+	s.Add("failing", newHealthBot(t, errors.New("db is down")), l)
+	s.Add("stopped", newHealthBot(t, nil), l)
+
+	srv := bot.NewServer("127.0.0.1:0", l)
+	bot.HealthHandlers(srv, s, "secret")
+	h := srv.Handler()
+
+	runFarm(t, s)
+	if err := s.StopBot(context.Background(), "stopped"); err != nil {
+		t.Fatal(err)
+	}
+	s.AddFailed("broken", &fakeBot{}, errors.New("invalid config"), l)
+
+	get := func(path, token string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	for _, tc := range []struct {
+		path, token string
+		code        int
+		body        string
+		hidden      string
+	}{
+		{path: "/healthz", code: http.StatusOK, body: "ok"},
+		{path: "/readyz", code: http.StatusServiceUnavailable, body: "unhealthy bots: failing\n"},
+		{path: "/readyz/ok", code: http.StatusOK, body: "ok"},
+		{path: "/readyz/failing", code: http.StatusServiceUnavailable, body: "failing isn't healthy"},
+		{path: "/readyz/stopped", code: http.StatusServiceUnavailable, body: "stopped isn't healthy"},
+		{path: "/readyz/broken", code: http.StatusServiceUnavailable, body: "broken isn't healthy"},
+		{path: "/readyz/unknown", code: http.StatusNotFound},
+		{path: "/status", code: http.StatusOK, body: `"db":"redacted"`, hidden: "db is down"},
+		{path: "/status", token: "wrong", code: http.StatusOK, body: `"db":"redacted"`, hidden: "db is down"},
+		{path: "/status", token: "secret", code: http.StatusOK, body: `"db":"db is down"`},
+		{path: "/status", code: http.StatusOK, body: `"name":"broken","state":"failed"`},
+	} {
+		code, body := get(tc.path, tc.token)
+		if code != tc.code || !strings.Contains(body, tc.body) {
+			t.Errorf("%s: expected %d %q, got %d %q", tc.path, tc.code, tc.body, code, body)
+		}
+		if tc.hidden != "" && strings.Contains(body, tc.hidden) {
+			t.Errorf("%s: expected %q to be redacted, got %q", tc.path, tc.hidden, body)
+		}
+	}
+
+	// bots disabled with StopBot or failed to initialize aren't required to be
+	// ready
+	if err := s.StopBot(context.Background(), "failing"); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := get("/readyz", ""); code != http.StatusOK {
+		t.Errorf("expected the farm to be ready, got %d %q", code, body)
+	}
+}
+
+func TestReportDuringReinit(t *testing.T) {
+	l := zap.NewNop().Sugar()
+	b := newHealthBot(t, nil)
+	s := bot.NewSupervisor(l)
+	s.Add("health", b, l)
+	runFarm(t, s)
+
+	// health checks don't use the connection while the bot opens or closes
+	// it; the race detector catches them if they do
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := s.ReinitBot(context.Background(), "health", b.Init); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	stop := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stop)
+	}()
+	for {
+		select {
+		case <-stop:
+			for i := 0; i < 100; i++ {
+				if rep, _ := s.ReportBot(context.Background(), "health"); rep.Healthy {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			t.Error("expected the reinitialized bot to be healthy")
+			return
+		default:
+		}
+
+		for _, rep := range s.Report(context.Background()) {
+			if rep.Checks["db"] == "connection is closed" {
+				t.Errorf("health checked while the bot was stopped: %+v", rep)
+			}
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package bot
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LocalesDir is the directory of message catalogs of a bot. Each catalog is
+// a JSON file named after its locale, e.g. en.json, with messages by key. A
+// message is either a text or an object with plural forms ("zero", "one",
+// "two", "few", "many", "other") selected by the Count argument. Texts are
+// text/template templates of the arguments, e.g. "Hello, {{.Name}}".
+const LocalesDir = "locales"
+
+// CmdLanguage is the command that changes the language of the bot for the user
+const CmdLanguage = "language"
+
+// langAuto resets the language to the one of the user's Telegram client
+const langAuto = "auto"
+
+// localeTimeout limits the time of loading and saving the user's locale
+const localeTimeout = 5 * time.Second
+
+// localeIdle is how long the locale of a user is cached after it was used last
+// if locales are kept in the store
+const localeIdle = time.Hour
+
+// baseLocales keep messages shared by all bots, e.g. of CmdLanguage
+//
+//go:embed locales/*.json
+var baseLocales embed.FS
+
+// message is a message of a catalog with its plural forms. Messages without
+// plural forms have only "other".
+type message map[string]*template.Template
+
+// Catalog keeps messages of a bot in all supported locales
+type Catalog struct {
+	fallback string
+	messages map[string]map[string]message // by locale and key
+}
+
+// LoadCatalog reads catalogs from LocalesDir of fsys on top of the messages
+// shared by all bots. fallback is the locale used when the user's locale
+// isn't supported or a message isn't translated.
+func LoadCatalog(fsys fs.FS, fallback string) (*Catalog, error) {
+	c := &Catalog{fallback: fallback, messages: make(map[string]map[string]message)}
+	for _, f := range []fs.FS{baseLocales, fsys} {
+		if err := c.load(f); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := c.messages[fallback]; !ok {
+		return nil, fmt.Errorf("no catalog for fallback locale %q", fallback)
+	}
+
+	return c, nil
+}
+
+func (c *Catalog) load(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, path.Join(LocalesDir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+
+		var raw map[string]json.RawMessage
+		if err = json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("invalid catalog %s: %w", file, err)
+		}
+
+		locale := strings.ToLower(strings.TrimSuffix(path.Base(file), ".json"))
+		msgs, ok := c.messages[locale]
+		if !ok {
+			msgs = make(map[string]message)
+			c.messages[locale] = msgs
+		}
+
+		for key, val := range raw {
+			forms := map[string]string{}
+			var text string
+			if err := json.Unmarshal(val, &text); err == nil {
+				forms["other"] = text
+			} else if err := json.Unmarshal(val, &forms); err != nil {
+				return fmt.Errorf("%s: message %q must be a string or an object with plural forms", file, key)
+			}
+
+			if _, ok := forms["other"]; !ok {
+				return fmt.Errorf("%s: message %q has no \"other\" plural form", file, key)
+			}
+
+			msg := make(message, len(forms))
+			for form, text := range forms {
+				t, err := template.New(key).Option("missingkey=zero").Parse(text)
+				if err != nil {
+					return fmt.Errorf("%s: message %q: %w", file, key, err)
+				}
+				msg[form] = t
+			}
+			msgs[key] = msg
+		}
+	}
+
+	return nil
+}
+
+// Locales returns supported locales
+func (c *Catalog) Locales() []string {
+	locales := make([]string, 0, len(c.messages))
+	for l := range c.messages {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+
+	return locales
+}
+
+// Match returns the supported locale of the IETF language tag, e.g. "pt-br"
+// matches "pt-br" or "pt". Unsupported languages match the fallback locale.
+func (c *Catalog) Match(lang string) string {
+	lang = strings.ToLower(strings.ReplaceAll(lang, "_", "-"))
+	for lang != "" {
+		if _, ok := c.messages[lang]; ok {
+			return lang
+		}
+
+		i := strings.LastIndex(lang, "-")
+		if i < 0 {
+			break
+		}
+		lang = lang[:i]
+	}
+
+	return c.fallback
+}
+
+// Supports reports whether the language matches a supported locale
+func (c *Catalog) Supports(lang string) bool {
+	lang = strings.ToLower(strings.ReplaceAll(lang, "_", "-"))
+	locale := c.Match(lang)
+	return lang == locale || strings.HasPrefix(lang, locale+"-")
+}
+
+// Localizer looks up messages of a locale
+type Localizer struct {
+	catalog *Catalog
+	locale  string
+}
+
+// Localizer returns the localizer of the supported locale
+func (c *Catalog) Localizer(locale string) Localizer {
+	return Localizer{catalog: c, locale: c.Match(locale)}
+}
+
+// Locale returns the locale of the localizer
+func (l Localizer) Locale() string {
+	return l.locale
+}
+
+// T returns the message with the key. args are key-value pairs of template
+// arguments, e.g. T("greeting", "Name", name). The Count argument selects
+// the plural form. Messages missing in the locale are taken from the fallback
+// locale, unknown keys are returned as they are.
+func (l Localizer) T(key string, args ...any) string {
+	if l.catalog == nil {
+		return key
+	}
+
+	msg, ok := l.catalog.messages[l.locale][key]
+	locale := l.locale
+	if !ok {
+		msg, ok = l.catalog.messages[l.catalog.fallback][key]
+		locale = l.catalog.fallback
+	}
+	if !ok {
+		return key
+	}
+
+	data := make(map[string]any, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		data[fmt.Sprint(args[i])] = args[i+1]
+	}
+
+	t := msg["other"]
+	if n, ok := data["Count"]; ok {
+		if form, ok := msg[pluralForm(locale, n)]; ok {
+			t = form
+		}
+	}
+
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return key
+	}
+
+	return sb.String()
+}
+
+// pluralForm returns the CLDR plural category of the number n in the locale
+func pluralForm(locale string, n any) string {
+	var i int64
+	switch v := n.(type) {
+	case int:
+		i = int64(v)
+	case int64:
+		i = v
+	case int32:
+		i = int64(v)
+	case uint:
+		i = int64(v)
+	default:
+		return "other"
+	}
+	if i < 0 {
+		i = -i
+	}
+
+	lang, _, _ := strings.Cut(locale, "-")
+	switch lang {
+	case "ru", "uk", "be":
+		switch {
+		case i%10 == 1 && i%100 != 11:
+			return "one"
+		case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	case "pl":
+		switch {
+		case i == 1:
+			return "one"
+		case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	case "fr", "pt":
+		if i == 0 || i == 1 {
+			return "one"
+		}
+	case "ja", "ko", "zh", "vi", "th", "id":
+		return "other"
+	default:
+		if i == 1 {
+			return "one"
+		}
+	}
+
+	return "other"
+}
+
+// I18n selects the locale of every user of a bot. The locale is chosen by
+// the user with CmdLanguage or matches the language of the user's Telegram
+// client. Locales are kept in the store, so messages sent not in reply to the
+// user (e.g. reminders) are localized too.
+type I18n struct {
+	bot     string
+	catalog *Catalog
+	store   LocaleStore
+	logger  *zap.SugaredLogger
+
+	mu    sync.Mutex
+	users map[int64]*cachedLocale
+	prune int // number of cached locales to drop idle ones at
+}
+
+// cachedLocale is the locale of a user cached by I18n
+type cachedLocale struct {
+	UserLocale
+	used time.Time
+}
+
+// NewI18n creates I18n of the named bot. store may be nil, then locales are
+// kept in memory. Otherwise locales of users idle for localeIdle are dropped
+// from memory once there are many of them; they're loaded again when needed.
+func NewI18n(bot string, c *Catalog, store LocaleStore, l *zap.SugaredLogger) *I18n {
+	return &I18n{bot: bot, catalog: c, store: store, logger: l, users: make(map[int64]*cachedLocale),
+		prune: maxIdleEntries}
+}
+
+// Catalog returns the message catalog
+func (i *I18n) Catalog() *Catalog {
+	return i.catalog
+}
+
+// For returns the localizer of the user
+func (i *I18n) For(usr int64) Localizer {
+	return i.catalog.Localizer(i.user(usr).Locale)
+}
+
+// Middleware keeps the locale of users who didn't choose it with CmdLanguage
+// matching the language of their Telegram clients. It should be added to the
+// bot router.
+func (i *I18n) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) {
+			if from := req.Update.SentFrom(); from != nil && from.LanguageCode != "" {
+				i.detect(req.User, from.LanguageCode)
+			}
+			next(req)
+		}
+	}
+}
+
+// Set sets the locale chosen by the user
+func (i *I18n) Set(usr int64, locale string) error {
+	return i.save(usr, UserLocale{Locale: i.catalog.Match(locale), Explicit: true})
+}
+
+// Reset makes the user's locale match lang, the language of the user's
+// Telegram client, again
+func (i *I18n) Reset(usr int64, lang string) error {
+	return i.save(usr, UserLocale{Locale: i.catalog.Match(lang)})
+}
+
+// Handler returns the handler of CmdLanguage. reply sends the response to the
+// user in the new language.
+func (i *I18n) Handler(reply func(req *Request, text string)) HandlerFunc {
+	return func(req *Request) {
+		var err error
+		switch arg := strings.ToLower(strings.TrimSpace(req.Args)); {
+		case arg == "":
+			reply(req, i.languages(req.User))
+			return
+
+		case arg == langAuto:
+			lang := ""
+			if from := req.Update.SentFrom(); from != nil {
+				lang = from.LanguageCode
+			}
+			err = i.Reset(req.User, lang)
+
+		case !i.catalog.Supports(arg):
+			l := i.For(req.User)
+			reply(req, l.T("language.unknown")+"\n\n"+i.languages(req.User))
+			return
+
+		default:
+			err = i.Set(req.User, arg)
+		}
+		if err != nil {
+			req.Logger.Errorw("failed saving locale", "err", err)
+		}
+
+		l := i.For(req.User)
+		reply(req, l.T("language.set", "Language", l.T("language.name")))
+	}
+}
+
+// languages lists supported languages
+func (i *I18n) languages(usr int64) string {
+	l := i.For(usr)
+
+	var sb strings.Builder
+	sb.WriteString(l.T("language.current", "Language", l.T("language.name"), "Command", CmdLanguage))
+	for _, locale := range i.catalog.Locales() {
+		sb.WriteString(fmt.Sprintf("\n/%s %s - %s", CmdLanguage, locale, i.catalog.Localizer(locale).T("language.name")))
+	}
+	sb.WriteString(fmt.Sprintf("\n/%s %s - %s", CmdLanguage, langAuto, l.T("language.auto")))
+
+	return sb.String()
+}
+
+// detect updates the locale of the user unless it's explicit
+func (i *I18n) detect(usr int64, lang string) {
+	ul := i.user(usr)
+	locale := i.catalog.Match(lang)
+	if ul.Explicit || ul.Locale == locale {
+		return
+	}
+
+	if err := i.save(usr, UserLocale{Locale: locale}); err != nil {
+		i.logger.Errorw("failed saving locale", "user", usr, "err", err)
+	}
+}
+
+// user returns the locale of the user loading it from the store once
+func (i *I18n) user(usr int64) UserLocale {
+	i.mu.Lock()
+	cl, ok := i.users[usr]
+	if ok {
+		cl.used = time.Now()
+	}
+	i.mu.Unlock()
+	if ok {
+		return cl.UserLocale
+	}
+
+	ul := UserLocale{Locale: i.catalog.fallback}
+	if i.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), localeTimeout)
+		defer cancel()
+
+		loaded, err := i.store.LoadLocale(ctx, i.bot, usr)
+		switch {
+		case err != nil:
+			// don't cache, so it's loaded again next time
+			i.logger.Errorw("failed loading locale", "user", usr, "err", err)
+			return ul
+		case loaded != nil:
+			ul = *loaded
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if cached, ok := i.users[usr]; ok {
+		return cached.UserLocale
+	}
+	i.cache(usr, ul)
+
+	return ul
+}
+
+func (i *I18n) save(usr int64, ul UserLocale) error {
+	i.mu.Lock()
+	i.cache(usr, ul)
+	i.mu.Unlock()
+
+	if i.store == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), localeTimeout)
+	defer cancel()
+
+	return i.store.SaveLocale(ctx, i.bot, usr, ul)
+}
+
+// cache caches the locale of the user dropping locales of idle users if
+// they're kept in the store. i.mu must be held.
+func (i *I18n) cache(usr int64, ul UserLocale) {
+	now := time.Now()
+	if _, ok := i.users[usr]; !ok && i.store != nil {
+		i.prune = pruneIdle(i.users, i.prune, func(cl *cachedLocale) bool { return now.Sub(cl.used) > localeIdle })
+	}
+	i.users[usr] = &cachedLocale{UserLocale: ul, used: now}
+}
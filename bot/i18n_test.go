@@ -0,0 +1,60 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCatalog(t *testing.T) {
+	fsys := fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{
+  "hello": "Hello, {{.Name}}",
+  "memos": {"one": "{{.Count}} memo", "other": "{{.Count}} memos"}
+}`)},
+		"locales/ru.json": {Data: []byte(`{
+  "memos": {"one": "{{.Count}} заметка", "few": "{{.Count}} заметки", "many": "{{.Count}} заметок", "other": "{{.Count}} заметки"}
+}`)},
+	}
+
+	c, err := bot.LoadCatalog(fsys, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		lang, locale string
+	}{
+		{"ru", "ru"},
+		{"ru-RU", "ru"},
+		{"pt-br", "en"},
+		{"", "en"},
+	} {
+		if got := c.Match(tc.lang); got != tc.locale {
+			t.Errorf("Match(%q) = %q, expected %q", tc.lang, got, tc.locale)
+		}
+	}
+
+	en, ru := c.Localizer("en"), c.Localizer("ru")
+	for _, tc := range []struct {
+		got, expected string
+	}{
+		{en.T("hello", "Name", "Alain"), "Hello, Alain"},
+		{en.T("memos", "Count", 1), "1 memo"},
+		{en.T("memos", "Count", 2), "2 memos"},
+		{ru.T("memos", "Count", 21), "21 заметка"},
+		{ru.T("memos", "Count", 3), "3 заметки"},
+		{ru.T("memos", "Count", 11), "11 заметок"},
+		{ru.T("hello", "Name", "Ален"), "Hello, Ален"},
+		{ru.T("unknown"), "unknown"},
+		{ru.T("language.name"), "Русский"},
+	} {
+		if tc.got != tc.expected {
+			t.Errorf("got %q, expected %q", tc.got, tc.expected)
+		}
+	}
+
+	if _, err := bot.LoadCatalog(fsys, "de"); err == nil {
+		t.Error("expected error for missing fallback locale")
+	}
+}
@@ -0,0 +1,84 @@
+package bot
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Tasks keeps track of goroutines started by a bot (update handlers,
+// reminders, etc.), so the bot can wait for them to finish on shutdown.
+// A panic in a task is recovered and logged with Logger, so it doesn't take
+// down the other bots. The zero value is ready to use.
+type Tasks struct {
+	Logger *zap.SugaredLogger
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// Go runs f in a new goroutine. Once Wait is called, Go doesn't start new
+// goroutines anymore and returns false.
+func (t *Tasks) Go(f func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return false
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer Recover(t.Logger, "handler")
+		f()
+	}()
+
+	return true
+}
+
+// Wait stops accepting new tasks and waits until all running tasks finish or
+// ctx is done, whichever comes first.
+func (t *Tasks) Wait(ctx context.Context) error {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reset makes Tasks accept new tasks again after Wait.
+func (t *Tasks) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = false
+}
+
+// Recover recovers from a panic and logs it along with the stack trace. It
+// must be deferred directly: defer Recover(l, "handler"). what describes the
+// panicked code in the log message.
+func Recover(l *zap.SugaredLogger, what string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	if l != nil {
+		l.Errorw(what+" panicked", "panic", r, "stack", string(debug.Stack()))
+	}
+}
@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"fmt"
+	"io/fs"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MenuCommand is a command listed in the menu of a bot
+type MenuCommand struct {
+	Command string
+	// Description is the key of the command description in the bot Catalog
+	Description string
+}
+
+// Menu is implemented by bots with command menus, so the menus can be set
+// with SetCommands without initializing the bots
+type Menu interface {
+	// Menu returns commands of the menu in the order they're listed
+	Menu() []MenuCommand
+	// Locales returns the file system with message catalogs of the bot (see
+	// LoadCatalog)
+	Locales() fs.FS
+}
+
+// SetCommands sets the menu of the bot in every locale of the catalog. Users
+// whose language isn't supported see the menu in the fallback locale.
+// Telegram accepts only two-letter language codes, so menus of other locales,
+// e.g. "pt-br", aren't set.
+func SetCommands(t Transport, c *Catalog, cmds []MenuCommand) error {
+	set := func(locale, lang string) error {
+		l := c.Localizer(locale)
+		commands := make([]tg.BotCommand, len(cmds))
+		for i, cmd := range cmds {
+			commands[i] = tg.BotCommand{Command: cmd.Command, Description: l.T(cmd.Description)}
+		}
+
+		config := tg.NewSetMyCommands(commands...)
+		config.LanguageCode = lang
+		if _, err := t.Request(config); err != nil {
+			return fmt.Errorf("failed setting commands in locale %q: %w", locale, err)
+		}
+
+		return nil
+	}
+
+	if err := set(c.fallback, ""); err != nil {
+		return err
+	}
+	for _, locale := range c.Locales() {
+		if len(locale) != 2 {
+			continue
+		}
+		if err := set(locale, locale); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"testing"
+	"testing/fstest"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// menuTransport records menus set with setMyCommands
+type menuTransport struct {
+	fakeTransport
+	menus map[string][]tg.BotCommand // by language code
+}
+
+func (t *menuTransport) Request(c tg.Chattable) (*tg.APIResponse, error) {
+	if cfg, ok := c.(tg.SetMyCommandsConfig); ok {
+		t.menus[cfg.LanguageCode] = cfg.Commands
+	}
+	return &tg.APIResponse{Ok: true}, nil
+}
+
+func TestSetCommands(t *testing.T) {
+	c, err := bot.LoadCatalog(fstest.MapFS{
+		"locales/en.json":    {Data: []byte(`{"menu.start": "Start"}`)},
+		"locales/ru.json":    {Data: []byte(`{"menu.start": "Начать"}`)},
+		"locales/pt-br.json": {Data: []byte(`{"menu.start": "Começar"}`)},
+	}, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mt := &menuTransport{menus: make(map[string][]tg.BotCommand)}
+	err = bot.SetCommands(mt, c, []bot.MenuCommand{
+		{Command: "start", Description: "menu.start"},
+		{Command: bot.CmdLanguage, Description: "menu.language"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mt.menus) != 3 {
+		t.Fatalf("expected default, en and ru menus, got %v", mt.menus)
+	}
+	for lang, want := range map[string]string{"": "Start", "en": "Start", "ru": "Начать"} {
+		menu := mt.menus[lang]
+		if len(menu) != 2 || menu[0].Description != want || menu[1].Description == "menu.language" {
+			t.Errorf("unexpected menu in %q: %v", lang, menu)
+		}
+	}
+}
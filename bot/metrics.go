@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsNamespace prefixes names of all metrics of the farm
+const MetricsNamespace = "botfarm"
+
+var (
+	updatesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "updates_total",
+		Help:      "Updates received by kind.",
+	}, []string{"bot", "kind"})
+
+	commandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "commands_total",
+		Help:      "Commands handled by name.",
+	}, []string{"bot", "command"})
+
+	handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Time of handling updates.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"bot", "kind"})
+
+	telegramErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "telegram_errors_total",
+		Help:      "Failed Telegram Bot API requests by method.",
+	}, []string{"bot", "method"})
+
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "retries_total",
+		Help:      "Retries of failed operations by operation.",
+	}, []string{"bot", "op"})
+
+	retriesExhaustedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "retries_exhausted_total",
+		Help:      "Operations that failed after all attempts by operation.",
+	}, []string{"bot", "op"})
+
+	telegramThrottledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "telegram_throttled_total",
+		Help:      "Telegram Bot API requests rejected with 429 Too Many Requests.",
+	}, []string{"bot"})
+
+	sendQueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "send_queue_length",
+		Help:      "Outbound requests waiting in the Sender queue.",
+	}, []string{"bot"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time of database queries by statement type.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"bot", "op"})
+
+	scheduledJobs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "scheduled_jobs",
+		Help:      "Jobs waiting in the Scheduler queue.",
+	}, []string{"bot"})
+
+	jobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "job_runs_total",
+		Help:      "Jobs run by the Scheduler.",
+	}, []string{"bot"})
+
+	jobsClaimedElsewhere = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "jobs_claimed_elsewhere_total",
+		Help:      "Due jobs not run by the Scheduler, because another replica claimed them.",
+	}, []string{"bot"})
+
+	dbErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "db_errors_total",
+		Help:      "Failed database queries by statement type.",
+	}, []string{"bot", "op"})
+)
+
+// MetricsHandler serves metrics of the farm in Prometheus format.
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Metrics counts updates and commands and measures handling time.
+func Metrics() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) {
+			updatesTotal.WithLabelValues(req.Bot, req.Kind).Inc()
+			if req.Kind == KindCommand {
+				commandsTotal.WithLabelValues(req.Bot, req.Command).Inc()
+			}
+
+			start := time.Now()
+			next(req)
+			handlerDuration.WithLabelValues(req.Bot, req.Kind).Observe(time.Since(start).Seconds())
+		}
+	}
+}
+
+// queryTracer measures database queries of a bot
+type queryTracer struct {
+	bot string
+}
+
+type queryStart struct {
+	op    string
+	start time.Time
+}
+
+type queryStartKey struct{}
+
+func (t queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{op: queryOp(data.SQL), start: time.Now()})
+}
+
+func (t queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	qs, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+
+	dbQueryDuration.WithLabelValues(t.bot, qs.op).Observe(time.Since(qs.start).Seconds())
+	if data.Err != nil {
+		dbErrorsTotal.WithLabelValues(t.bot, qs.op).Inc()
+	}
+}
+
+// queryOp returns the statement type (select, insert, etc.) of the query
+func queryOp(sql string) string {
+	f := strings.Fields(sql)
+	if len(f) == 0 {
+		return "unknown"
+	}
+	return strings.ToLower(f[0])
+}
@@ -0,0 +1,39 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestMetrics(t *testing.T) {
+	r := bot.NewRouter("metrics-test", zap.NewNop().Sugar())
+	r.Use(bot.Metrics())
+	r.Command("start", func(req *bot.Request) {})
+
+	r.Handle(command(1, "/start", 6))
+
+	srv := httptest.NewServer(bot.MetricsHandler())
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	for _, m := range []string{
+		`botfarm_updates_total{bot="metrics-test",kind="command"} 1`,
+		`botfarm_commands_total{bot="metrics-test",command="start"} 1`,
+		`botfarm_handler_duration_seconds_count{bot="metrics-test",kind="command"} 1`,
+	} {
+		if !strings.Contains(string(body), m) {
+			t.Errorf("metric %s not found", m)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+// Logging logs every request with the user ID and the request details.
+func Logging() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) {
+			switch req.Kind {
+			case KindCommand:
+				req.Logger.Infow("handling command", "cmd", req.Command)
+			case KindCallback:
+				req.Logger.Infow("handling callback", "data", req.Callback().Data)
+			default:
+				req.Logger.Infow("handling update", "kind", req.Kind)
+			}
+
+			next(req)
+		}
+	}
+}
+
+// Recovery recovers from panics in handlers, so a failing handler doesn't
+// take down other bots.
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) {
+			defer Recover(req.Logger, "handler")
+			next(req)
+		}
+	}
+}
+
+// Timing logs handling time. Requests handled longer than slow are logged as
+// warnings.
+func Timing(slow time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) {
+			start := time.Now()
+			next(req)
+			d := time.Since(start)
+
+			if d > slow {
+				req.Logger.Warnw("slow request", "kind", req.Kind, "cmd", req.Command, "duration", d)
+			} else {
+				req.Logger.Debugw("request handled", "kind", req.Kind, "cmd", req.Command, "duration", d)
+			}
+		}
+	}
+}
+
+// Auth passes to the handler only requests allowed by allow. Other requests
+// are passed to deny, which may be nil.
+func Auth(allow func(*Request) bool, deny HandlerFunc) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) {
+			if allow(req) {
+				next(req)
+				return
+			}
+
+			req.Logger.Warnw("request denied", "kind", req.Kind, "cmd", req.Command)
+			if deny != nil {
+				deny(req)
+			}
+		}
+	}
+}
+
+// RateLimit limits requests of every user of a bot to rate per second with
+// bursts of up to burst requests. Requests over the limit are dropped. Limiters
+// of idle users are dropped once there are many of them.
+func RateLimit(rate float64, burst int) Middleware {
+	type key struct {
+		bot string
+		usr int64
+	}
+
+	var mu sync.Mutex
+	buckets := newLimiters[key](rate, burst)
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) {
+			k := key{req.Bot, req.User}
+
+			mu.Lock()
+			now := time.Now()
+			allowed := buckets.get(k, now).take(now)
+			mu.Unlock()
+
+			if !allowed {
+				req.Logger.Warnw("rate limit exceeded; request dropped", "kind", req.Kind, "cmd", req.Command)
+				return
+			}
+
+			next(req)
+		}
+	}
+}
+
+// maxIdleEntries is the number of entries of per-chat or per-user state, e.g.
+// rate limiters, after which entries of idle chats or users are dropped
+const maxIdleEntries = 10000
+
+// pruneIdle drops entries of m for which idle returns true if m has at least
+// limit entries. It returns the next limit: the rest of entries are busy, so
+// they're checked again when there are twice as many of them.
+func pruneIdle[K comparable, V any](m map[K]V, limit int, idle func(V) bool) int {
+	if len(m) < limit {
+		return limit
+	}
+
+	for k, v := range m {
+		if idle(v) {
+			delete(m, k)
+		}
+	}
+
+	if n := 2 * len(m); n > maxIdleEntries {
+		return n
+	}
+	return maxIdleEntries
+}
+
+// limiters keeps rate limiters by chat or user. Once there are many of them,
+// limiters that are full, i.e. wouldn't limit anything, are dropped. It isn't
+// thread-safe.
+type limiters[K comparable] struct {
+	rate    float64
+	burst   int
+	buckets map[K]*tokenBucket
+	prune   int // number of limiters to drop idle ones at
+}
+
+func newLimiters[K comparable](rate float64, burst int) *limiters[K] {
+	return &limiters[K]{rate: rate, burst: burst, buckets: make(map[K]*tokenBucket), prune: maxIdleEntries}
+}
+
+// get returns the limiter of k creating it if needed
+func (l *limiters[K]) get(k K, now time.Time) *tokenBucket {
+	if b, ok := l.buckets[k]; ok {
+		return b
+	}
+
+	l.prune = pruneIdle(l.buckets, l.prune, func(b *tokenBucket) bool {
+		b.refill(now)
+		return b.tokens >= b.burst
+	})
+
+	b := newTokenBucket(l.rate, l.burst)
+	l.buckets[k] = b
+	return b
+}
+
+// throttle lets every chat or user through at most once per interval. Once
+// there are many of them, those let through more than interval ago, i.e. that
+// would be let through anyway, are dropped. It isn't thread-safe.
+type throttle[K comparable] struct {
+	interval time.Duration
+	last     map[K]time.Time // time k was let through
+	prune    int             // number of entries to drop idle ones at
+}
+
+func newThrottle[K comparable](interval time.Duration) *throttle[K] {
+	return &throttle[K]{interval: interval, last: make(map[K]time.Time), prune: maxIdleEntries}
+}
+
+// allow reports whether k is let through at the moment now
+func (t *throttle[K]) allow(k K, now time.Time) bool {
+	if last, ok := t.last[k]; ok && now.Sub(last) < t.interval {
+		return false
+	}
+
+	t.prune = pruneIdle(t.last, t.prune, func(last time.Time) bool { return now.Sub(last) >= t.interval })
+	t.last[k] = now
+	return true
+}
+
+// recent reports whether k was let through less than the interval before now
+func (t *throttle[K]) recent(k K, now time.Time) bool {
+	last, ok := t.last[k]
+	return ok && now.Sub(last) < t.interval
+}
+
+// forget lets k through next time
+func (t *throttle[K]) forget(k K) {
+	delete(t.last, k)
+}
+
+// tokenBucket implements token bucket rate limiting algorithm. It isn't
+// thread-safe.
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// take takes a token if there's one available at the moment now
+func (b *tokenBucket) take(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// wait returns how long to wait from the moment now until a token is
+// available
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
@@ -0,0 +1,353 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MigrationsDir is the directory of embedded migrations of a bot. Migrations
+// are SQL files named NNNN_description.up.sql and NNNN_description.down.sql
+// where NNNN is the version of the schema after the migration is applied.
+const MigrationsDir = "migrations"
+
+// FrameworkSchema is the name migrations of tables kept by the bot package
+// (conversations, user_locales, user_activity, user_access and
+// scheduled_jobs) are recorded under in schema_migrations. The tables are
+// shared by all bots using the database.
+const FrameworkSchema = "botfarm"
+
+// Migrations keeps migrations of tables kept by the bot package
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned change of the database schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration is applied
+type MigrationStatus struct {
+	Schema  string     `json:"schema"` // FrameworkSchema or the bot name
+	Version int        `json:"version"`
+	Name    string     `json:"name"`
+	Applied *time.Time `json:"applied,omitempty"`
+}
+
+// Migrator applies migrations of a bot to its database. Migrations of the bot
+// package tables (see FrameworkSchema) are applied before the bot ones.
+// Applied migrations are recorded in the schema_migrations table. Migrations
+// are applied under an advisory lock, so replicas of the farm sharing the
+// database don't apply them concurrently.
+type Migrator struct {
+	bot        string
+	db         *sql.DB
+	migrations []Migration // sorted by version
+	framework  *Migrator   // nil for the framework migrator itself
+	logger     *zap.SugaredLogger
+}
+
+// Migrated is implemented by bots that keep their schema with Migrator
+type Migrated interface {
+	// Migrator returns the migrator of the bot or nil if the bot isn't
+	// initialized
+	Migrator() *Migrator
+}
+
+// Schema is implemented by bots with embedded migrations, so their databases
+// can be migrated without initializing the bots
+type Schema interface {
+	// Migrations returns the file system with MigrationsDir
+	Migrations() fs.FS
+}
+
+// NewMigrator creates the migrator of the named bot with migrations read from
+// MigrationsDir of fsys.
+func NewMigrator(bot string, db *sql.DB, fsys fs.FS, l *zap.SugaredLogger) (*Migrator, error) {
+	if bot == FrameworkSchema {
+		return nil, fmt.Errorf("bot can't be named %q", FrameworkSchema)
+	}
+
+	framework, err := newMigrator(FrameworkSchema, db, Migrations, l.With("schema", FrameworkSchema))
+	if err != nil {
+		return nil, fmt.Errorf("failed loading framework migrations: %w", err)
+	}
+
+	m, err := newMigrator(bot, db, fsys, l)
+	if err != nil {
+		return nil, err
+	}
+	m.framework = framework
+
+	return m, nil
+}
+
+func newMigrator(schema string, db *sql.DB, fsys fs.FS, l *zap.SugaredLogger) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{bot: schema, db: db, migrations: migrations, logger: l}, nil
+}
+
+// LoadMigrations reads migrations from MigrationsDir of fsys. Every migration
+// must have both up and down files.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, MigrationsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := migrationFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			return nil, fmt.Errorf("unexpected migration file %q", e.Name())
+		}
+
+		version, _ := strconv.Atoi(m[1])
+		if version <= 0 {
+			return nil, fmt.Errorf("migration %q: version must be positive", e.Name())
+		}
+
+		sql, err := fs.ReadFile(fsys, path.Join(MigrationsDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migrations %q and %q have the same version", mig.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			mig.Up = string(sql)
+		} else {
+			mig.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have up and down files", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies all migrations that aren't applied yet
+func (m *Migrator) Up(ctx context.Context) error {
+	if m.framework != nil {
+		if err := m.framework.Up(ctx); err != nil {
+			return err
+		}
+	}
+
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+
+			err := m.apply(ctx, conn, mig.Up, `INSERT INTO schema_migrations(bot, version, name, applied)
+VALUES($1, $2, $3, $4)`, m.bot, mig.Version, mig.Name, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("failed applying migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			m.logger.Infow("applied migration", "version", mig.Version, "name", mig.Name)
+		}
+
+		return nil
+	})
+}
+
+// Down reverts applied migrations of the bot with versions greater than
+// version. Framework migrations aren't reverted, as other bots may use their
+// tables.
+func (m *Migrator) Down(ctx context.Context, version int) error {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok || mig.Version <= version {
+				continue
+			}
+
+			err := m.apply(ctx, conn, mig.Down, `DELETE FROM schema_migrations WHERE bot=$1 AND version=$2`,
+				m.bot, mig.Version)
+			if err != nil {
+				return fmt.Errorf("failed reverting migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			m.logger.Infow("reverted migration", "version", mig.Version, "name", mig.Name)
+		}
+
+		return nil
+	})
+}
+
+// Status lists known migrations, framework ones first, and when they were
+// applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	if m.framework != nil {
+		var err error
+		if statuses, err = m.framework.Status(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var exists bool
+	err := m.db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+	if exists {
+		if applied, err = m.applied(ctx, m.db); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, mig := range m.migrations {
+		st := MigrationStatus{Schema: m.bot, Version: mig.Version, Name: mig.Name}
+		if t, ok := applied[mig.Version]; ok {
+			st.Applied = &t
+		}
+		statuses = append(statuses, st)
+	}
+
+	return statuses, nil
+}
+
+// querier is either *sql.DB or *sql.Conn
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// locked calls f holding the migration lock of the bot
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn, applied map[int]time.Time) error) error {
+	// advisory locks belong to the session, so all queries go through conn
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	key := m.lockKey()
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, key); err != nil {
+		return fmt.Errorf("failed acquiring migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			m.logger.Errorw("failed releasing migration lock", "err", err)
+		}
+	}()
+
+	if err = m.createTable(ctx, conn); err != nil {
+		return err
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	latest := 0
+	if len(m.migrations) > 0 {
+		latest = m.migrations[len(m.migrations)-1].Version
+	}
+	for v := range applied {
+		if v > latest {
+			m.logger.Warnw("database has migrations unknown to the bot", "version", v)
+			break
+		}
+	}
+
+	return f(conn, applied)
+}
+
+// apply executes the migration script and records the change in one
+// transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) createTable(ctx context.Context, db querier) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations(
+    bot text NOT NULL,
+    version int NOT NULL,
+    name text NOT NULL,
+    applied timestamptz NOT NULL,
+    PRIMARY KEY (bot, version)
+)`)
+	return err
+}
+
+// applied returns versions of applied migrations with the time they were
+// applied
+func (m *Migrator) applied(ctx context.Context, db querier) (map[int]time.Time, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version, applied FROM schema_migrations WHERE bot=$1`, m.bot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var v int
+		var t time.Time
+		if err := rows.Scan(&v, &t); err != nil {
+			return nil, err
+		}
+		applied[v] = t
+	}
+
+	return applied, rows.Err()
+}
+
+// lockKey returns the key of the advisory lock guarding migrations of the bot
+func (m *Migrator) lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("schema_migrations:" + m.bot))
+	return int64(h.Sum64())
+}
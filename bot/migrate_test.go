@@ -0,0 +1,58 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"testing"
+	"testing/fstest"
+
+	"go.uber.org/zap"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_add_index.up.sql":   {Data: []byte("CREATE INDEX i ON t(a);")},
+		"migrations/0002_add_index.down.sql": {Data: []byte("DROP INDEX i;")},
+		"migrations/0001_init.up.sql":        {Data: []byte("CREATE TABLE t(a int);")},
+		"migrations/0001_init.down.sql":      {Data: []byte("DROP TABLE t;")},
+	}
+
+	migrations, err := bot.LoadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 2 || migrations[0].Name != "init" || migrations[1].Version != 2 {
+		t.Fatalf("unexpected migrations %+v", migrations)
+	}
+	if migrations[1].Down != "DROP INDEX i;" {
+		t.Errorf("unexpected down migration %q", migrations[1].Down)
+	}
+
+	delete(fsys, "migrations/0002_add_index.down.sql")
+	if _, err := bot.LoadMigrations(fsys); err == nil {
+		t.Error("expected error for migration without down file")
+	}
+
+	fsys["migrations/0002_add_index.down.sql"] = &fstest.MapFile{Data: []byte("DROP INDEX i;")}
+	fsys["migrations/0002_other.up.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+	if _, err := bot.LoadMigrations(fsys); err == nil {
+		t.Error("expected error for duplicate version")
+	}
+}
+
+func TestFrameworkMigrations(t *testing.T) {
+	migrations, err := bot.LoadMigrations(bot.Migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, mig := range migrations {
+		if mig.Version != i+1 {
+			t.Errorf("expected migration %d_%s to have version %d", mig.Version, mig.Name, i+1)
+		}
+	}
+
+	if _, err := bot.NewMigrator(bot.FrameworkSchema, nil, bot.Migrations, zap.NewNop().Sugar()); err == nil {
+		t.Errorf("expected error for a bot named %q", bot.FrameworkSchema)
+	}
+}
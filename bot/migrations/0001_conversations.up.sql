@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS conversations(
+    bot text NOT NULL,
+    user_id bigint NOT NULL,
+    state text NOT NULL,
+    anchor int NOT NULL,
+    payload jsonb NULL,
+    updated timestamptz NOT NULL,
+    PRIMARY KEY (bot, user_id)
+);
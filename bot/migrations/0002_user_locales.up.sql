@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_locales(
+    bot text NOT NULL,
+    user_id bigint NOT NULL,
+    locale text NOT NULL,
+    explicit boolean NOT NULL,
+    PRIMARY KEY (bot, user_id)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_activity(
+    bot text NOT NULL,
+    user_id bigint NOT NULL,
+    seen timestamptz NOT NULL,
+    PRIMARY KEY (bot, user_id)
+);
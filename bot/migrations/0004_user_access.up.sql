@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS user_access(
+    bot text NOT NULL,
+    user_id bigint NOT NULL,
+    status text NOT NULL,
+    name text NOT NULL,
+    updated timestamptz NOT NULL,
+    PRIMARY KEY (bot, user_id)
+);
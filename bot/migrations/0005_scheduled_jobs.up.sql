@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS scheduled_jobs(
+    bot text NOT NULL,
+    key text NOT NULL,
+    schedule text NOT NULL,
+    next timestamptz NOT NULL,
+    PRIMARY KEY (bot, key)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE scheduled_jobs DROP COLUMN IF EXISTS claimed_until;
+ALTER TABLE scheduled_jobs DROP COLUMN IF EXISTS claimed_by;
@@ -0,0 +1,2 @@
+ALTER TABLE scheduled_jobs ADD COLUMN IF NOT EXISTS claimed_by text NULL;
+ALTER TABLE scheduled_jobs ADD COLUMN IF NOT EXISTS claimed_until timestamptz NULL;
@@ -0,0 +1,401 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// Commands available to farm admins in every bot (see SetAdmins)
+const (
+	CmdBroadcast = "broadcast"
+	CmdStats     = "stats"
+	CmdUser      = "user"
+)
+
+const (
+	cbqBroadcastSend   = "admin:broadcast:send"
+	cbqBroadcastCancel = "admin:broadcast:cancel"
+)
+
+const (
+	// activePeriod is the period users are counted as active after their last
+	// request
+	activePeriod = 7 * 24 * time.Hour
+	// activityInterval limits how often the last activity of a user is saved
+	activityInterval = time.Hour
+	// broadcastTimeout is the time the admin has to confirm the broadcast
+	broadcastTimeout = 10 * time.Minute
+	// operatorTimeout limits queries made by admin commands
+	operatorTimeout = 30 * time.Second
+	// activityTimeout limits the time of saving the last activity of a user
+	activityTimeout = 5 * time.Second
+)
+
+// ErrUnknownUser is returned by AdminSource when the user isn't found
+var ErrUnknownUser = errors.New("unknown user")
+
+var (
+	admins   = make(map[int64]bool)
+	adminsMu sync.RWMutex
+)
+
+// SetAdmins sets Telegram user ids of farm admins. Only admins are allowed to
+// use admin commands (see Operator). SetAdmins should be called before bots
+// are run.
+func SetAdmins(ids ...int64) {
+	adminsMu.Lock()
+	defer adminsMu.Unlock()
+
+	admins = make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		admins[id] = true
+	}
+}
+
+// IsAdmin reports whether the user is a farm admin
+func IsAdmin(usr int64) bool {
+	adminsMu.RLock()
+	defer adminsMu.RUnlock()
+
+	return admins[usr]
+}
+
+// adminIDs returns Telegram user ids of farm admins
+func adminIDs() []int64 {
+	adminsMu.RLock()
+	defer adminsMu.RUnlock()
+
+	ids := make([]int64, 0, len(admins))
+	for id := range admins {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// adminOnly passes to h only requests of farm admins. Commands of other users
+// get the unknown command response of the router.
+func adminOnly(r *Router, h HandlerFunc) HandlerFunc {
+	return func(req *Request) {
+		if !IsAdmin(req.User) {
+			if r.unknownCommand != nil && req.Kind == KindCommand {
+				r.unknownCommand(req)
+			}
+			return
+		}
+
+		req.Logger.Infow("admin request", "command", req.Command, "args", req.Args)
+		h(req)
+	}
+}
+
+// Field is a named value shown to admins
+type Field struct {
+	Name  string
+	Value any
+}
+
+// AdminSource is implemented by bots to give admin commands access to their
+// users
+type AdminSource interface {
+	// Chats returns chats of all users of the bot
+	Chats(ctx context.Context) ([]int64, error)
+	// Stats returns counters of the bot, e.g. the number of users
+	Stats(ctx context.Context) ([]Field, error)
+	// UserInfo describes the state of the user or returns ErrUnknownUser
+	UserInfo(ctx context.Context, usr int64) ([]Field, error)
+}
+
+// Operator serves admin commands of a bot:
+//
+//	/broadcast <text> - sends the text to all users of the bot after a preview
+//	                    is confirmed
+//	/stats            - shows counters of the bot
+//	/user <id>        - shows the state of the user
+//
+// The commands are meant for operators of the farm, so they aren't localized.
+// Users who aren't admins get the unknown command response of the bot.
+type Operator struct {
+	bot    string
+	db     *sql.DB
+	src    AdminSource
+	t      Transport // replies to admins
+	bulk   Transport // sends broadcasts
+	rate   float64   // broadcast messages per second
+	tasks  *Tasks
+	logger *zap.SugaredLogger
+
+	mu      sync.Mutex
+	pending map[int64]broadcast // by admin
+	seen    *throttle[int64]    // saved activity by user
+}
+
+// broadcast is a broadcast waiting for confirmation
+type broadcast struct {
+	text    string
+	expires time.Time
+}
+
+// NewOperator creates Operator of the named bot. Broadcasts are sent with
+// bulk at rate messages per second in tasks. Activity of users is kept in the
+// user_activity table of db, which is created by framework migrations (see
+// Migrator). If db is nil, activity isn't tracked.
+func NewOperator(bot string, db *sql.DB, src AdminSource, t, bulk Transport, rate float64, tasks *Tasks, l *zap.SugaredLogger) *Operator {
+	return &Operator{
+		bot:     bot,
+		db:      db,
+		src:     src,
+		t:       t,
+		bulk:    bulk,
+		rate:    rate,
+		tasks:   tasks,
+		logger:  l,
+		pending: make(map[int64]broadcast),
+		seen:    newThrottle[int64](activityInterval),
+	}
+}
+
+// Middleware records the last activity of users. It should be added to the
+// bot router.
+func (o *Operator) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) {
+			if req.User != 0 {
+				o.touch(req.User)
+			}
+			next(req)
+		}
+	}
+}
+
+// Routes registers admin commands in the router
+func (o *Operator) Routes(r *Router) {
+	r.Command(CmdBroadcast, adminOnly(r, o.handleBroadcast))
+	r.Command(CmdStats, adminOnly(r, o.handleStats))
+	r.Command(CmdUser, adminOnly(r, o.handleUser))
+	r.Callback(cbqBroadcastSend, adminOnly(r, o.handleBroadcastSend))
+	r.Callback(cbqBroadcastCancel, adminOnly(r, o.handleBroadcastCancel))
+}
+
+func (o *Operator) handleBroadcast(req *Request) {
+	text := req.Args
+	if text == "" {
+		o.reply(req, fmt.Sprintf("Usage: /%s <text>", CmdBroadcast), nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operatorTimeout)
+	defer cancel()
+
+	chats, err := o.src.Chats(ctx)
+	if err != nil {
+		req.Logger.Errorw("failed listing chats", "err", err)
+		o.reply(req, "Failed listing users: "+err.Error(), nil)
+		return
+	}
+
+	o.mu.Lock()
+	o.pending[req.User] = broadcast{text: text, expires: time.Now().Add(broadcastTimeout)}
+	o.mu.Unlock()
+
+	kb := tg.NewInlineKeyboardMarkup(tg.NewInlineKeyboardRow(
+		tg.NewInlineKeyboardButtonData(fmt.Sprintf("Send to %d users", len(chats)), cbqBroadcastSend),
+		tg.NewInlineKeyboardButtonData("Cancel", cbqBroadcastCancel),
+	))
+	o.reply(req, text, &kb)
+}
+
+func (o *Operator) handleBroadcastSend(req *Request) {
+	o.mu.Lock()
+	b, ok := o.pending[req.User]
+	delete(o.pending, req.User)
+	o.mu.Unlock()
+
+	cbq := req.Callback()
+	if !ok || time.Now().After(b.expires) {
+		o.edit(req, cbq.Message.MessageID, "The broadcast has expired, send it again")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operatorTimeout)
+	chats, err := o.src.Chats(ctx)
+	cancel()
+	if err != nil {
+		req.Logger.Errorw("failed listing chats", "err", err)
+		o.edit(req, cbq.Message.MessageID, "Failed listing users: "+err.Error())
+		return
+	}
+
+	o.edit(req, cbq.Message.MessageID, fmt.Sprintf("Broadcasting to %d users:\n\n%s", len(chats), b.text))
+
+	admin := req.User
+	if !o.tasks.Go(func() { o.broadcast(admin, chats, b.text) }) {
+		o.reply(req, "The bot is stopping, the broadcast is canceled", nil)
+	}
+}
+
+func (o *Operator) handleBroadcastCancel(req *Request) {
+	o.mu.Lock()
+	delete(o.pending, req.User)
+	o.mu.Unlock()
+
+	o.edit(req, req.Callback().Message.MessageID, "The broadcast is canceled")
+}
+
+// broadcast sends the text to the chats and reports the result to the admin
+func (o *Operator) broadcast(admin int64, chats []int64, text string) {
+	o.logger.Infow("broadcast started", "admin", admin, "chats", len(chats))
+
+	limit := newTokenBucket(o.rate, 1)
+	sent, blocked, failed := 0, 0, 0
+	for i, cht := range chats {
+		for !limit.take(time.Now()) {
+			time.Sleep(limit.wait(time.Now()))
+		}
+
+		_, err := o.bulk.SendMessage(tg.NewMessage(cht, text))
+		switch {
+		case err == nil:
+			sent++
+		case errors.Is(err, ErrSenderClosed):
+			failed += len(chats) - i
+			o.logger.Warnw("broadcast interrupted", "admin", admin, "sent", sent)
+			return
+		case IsPermanent(err):
+			blocked++
+		default:
+			failed++
+			o.logger.Warnw("failed broadcasting message", "chat", cht, "err", err)
+		}
+	}
+
+	o.logger.Infow("broadcast finished", "admin", admin, "sent", sent, "blocked", blocked, "failed", failed)
+
+	report := fmt.Sprintf("Broadcast finished: %d sent, %d blocked the bot, %d failed", sent, blocked, failed)
+	if _, err := o.t.SendMessage(tg.NewMessage(admin, report)); err != nil {
+		o.logger.Errorw("failed sending broadcast report", "err", err)
+	}
+}
+
+func (o *Operator) handleStats(req *Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), operatorTimeout)
+	defer cancel()
+
+	fields, err := o.src.Stats(ctx)
+	if err != nil {
+		req.Logger.Errorw("failed getting stats", "err", err)
+		o.reply(req, "Failed getting stats: "+err.Error(), nil)
+		return
+	}
+
+	if o.db != nil {
+		var active int
+		err = o.db.QueryRowContext(ctx, `SELECT count(*) FROM user_activity WHERE bot = $1 AND seen > $2`,
+			o.bot, time.Now().Add(-activePeriod)).Scan(&active)
+		if err != nil {
+			req.Logger.Errorw("failed counting active users", "err", err)
+		}
+		fields = append(fields, Field{"Active users (7 days)", active})
+	}
+
+	o.reply(req, formatFields(o.bot, fields), nil)
+}
+
+func (o *Operator) handleUser(req *Request) {
+	if len(req.ArgList) != 1 {
+		o.reply(req, fmt.Sprintf("Usage: /%s <user id>", CmdUser), nil)
+		return
+	}
+	usr, err := strconv.ParseInt(req.ArgList[0], 10, 64)
+	if err != nil {
+		o.reply(req, fmt.Sprintf("Invalid user id %q", req.ArgList[0]), nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operatorTimeout)
+	defer cancel()
+
+	fields, err := o.src.UserInfo(ctx, usr)
+	if errors.Is(err, ErrUnknownUser) {
+		o.reply(req, fmt.Sprintf("User %d isn't found", usr), nil)
+		return
+	}
+	if err != nil {
+		req.Logger.Errorw("failed getting user info", "user", usr, "err", err)
+		o.reply(req, "Failed getting the user: "+err.Error(), nil)
+		return
+	}
+
+	if o.db != nil {
+		var seen time.Time
+		err = o.db.QueryRowContext(ctx, `SELECT seen FROM user_activity WHERE bot = $1 AND user_id = $2`,
+			o.bot, usr).Scan(&seen)
+		switch {
+		case err == nil:
+			fields = append(fields, Field{"Last seen", seen.UTC().Format(time.RFC3339)})
+		case errors.Is(err, sql.ErrNoRows):
+			fields = append(fields, Field{"Last seen", "never"})
+		default:
+			req.Logger.Errorw("failed getting user activity", "user", usr, "err", err)
+		}
+	}
+
+	o.reply(req, formatFields(fmt.Sprintf("User %d", usr), fields), nil)
+}
+
+// touch saves the last activity of the user at most once per activityInterval
+func (o *Operator) touch(usr int64) {
+	if o.db == nil {
+		return
+	}
+
+	now := time.Now()
+	o.mu.Lock()
+	allowed := o.seen.allow(usr, now)
+	o.mu.Unlock()
+	if !allowed {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), activityTimeout)
+	defer cancel()
+
+	_, err := o.db.ExecContext(ctx, `INSERT INTO user_activity(bot, user_id, seen) VALUES ($1, $2, $3)
+		ON CONFLICT (bot, user_id) DO UPDATE SET seen = EXCLUDED.seen`, o.bot, usr, now.UTC())
+	if err != nil {
+		o.logger.Errorw("failed saving user activity", "user", usr, "err", err)
+	}
+}
+
+func (o *Operator) reply(req *Request, text string, kb *tg.InlineKeyboardMarkup) {
+	m := tg.NewMessage(req.Chat, text)
+	if kb != nil {
+		m.ReplyMarkup = kb
+	}
+	if _, err := o.t.SendMessage(m); err != nil {
+		req.Logger.Errorw("failed replying to admin", "err", err)
+	}
+}
+
+func (o *Operator) edit(req *Request, msgID int, text string) {
+	if err := o.t.EditMessage(tg.NewEditMessageText(req.Chat, msgID, text)); err != nil {
+		req.Logger.Errorw("failed replying to admin", "err", err)
+	}
+}
+
+func formatFields(title string, fields []Field) string {
+	var sb strings.Builder
+	sb.WriteString(title)
+	for _, f := range fields {
+		sb.WriteString(fmt.Sprintf("\n%s: %v", f.Name, f.Value))
+	}
+	return sb.String()
+}
@@ -0,0 +1,77 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+type fakeAdminSource struct{}
+
+func (fakeAdminSource) Chats(context.Context) ([]int64, error) { return []int64{10, 11, 12}, nil }
+
+func (fakeAdminSource) Stats(context.Context) ([]bot.Field, error) {
+	return []bot.Field{{Name: "Users", Value: 3}}, nil
+}
+
+func (fakeAdminSource) UserInfo(_ context.Context, usr int64) ([]bot.Field, error) {
+	if usr != 10 {
+		return nil, bot.ErrUnknownUser
+	}
+	return []bot.Field{{Name: "Chat", Value: 10}}, nil
+}
+
+func TestOperator(t *testing.T) {
+	bot.SetAdmins(1)
+	defer bot.SetAdmins()
+
+	l := zap.NewNop().Sugar()
+	ft := &fakeTransport{errs: map[int64][]error{
+		11: {&bot.PermanentError{Err: &tg.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}}},
+	}}
+	tasks := &bot.Tasks{Logger: l}
+	o := bot.NewOperator("test", nil, fakeAdminSource{}, ft, ft, 1000, tasks, l)
+
+	r := bot.NewRouter("test", l)
+	unknown := 0
+	r.UnknownCommand(func(*bot.Request) { unknown++ })
+	o.Routes(r)
+
+	r.Handle(command(2, "/stats", 6))
+	if unknown != 1 || len(ft.sent) != 0 {
+		t.Fatalf("expected admin command to be unknown to other users, sent %q", ft.sent)
+	}
+
+	r.Handle(command(1, "/stats", 6))
+	r.Handle(command(1, "/user 10", 5))
+	r.Handle(command(1, "/user 20", 5))
+	if len(ft.sent) != 3 || !strings.Contains(ft.sent[0], "Users: 3") ||
+		!strings.Contains(ft.sent[1], "Chat: 10") || !strings.Contains(ft.sent[2], "isn't found") {
+		t.Fatalf("unexpected replies %q", ft.sent)
+	}
+
+	ft.sent = nil
+	r.Handle(command(1, "/broadcast Hello, everyone", 10))
+	r.Handle(&tg.Update{CallbackQuery: &tg.CallbackQuery{
+		From:    &tg.User{ID: 1},
+		Message: &tg.Message{MessageID: 5, Chat: &tg.Chat{ID: 1}},
+		Data:    "admin:broadcast:send",
+	}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tasks.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"Hello, everyone", "Hello, everyone", "Hello, everyone",
+		"Broadcast finished: 2 sent, 1 blocked the bot, 0 failed"}
+	if strings.Join(ft.sent, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, ft.sent)
+	}
+}
@@ -0,0 +1,234 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// Request is an update being handled along with the details parsed from it.
+type Request struct {
+	Update *tg.Update
+	Bot    string // bot name
+	User   int64  // ID of the user who sent the update
+	Chat   int64  // ID of the chat the update came from
+	Kind   string // one of Kind* constants
+
+	// Command is the command name without leading '/' and bot name, Args is
+	// the rest of the message after the command and ArgList is Args split by
+	// white spaces
+	Command string
+	Args    string
+	ArgList []string
+
+	// Logger is the bot logger with the user ID
+	Logger *zap.SugaredLogger
+}
+
+// Update kinds
+const (
+	KindCommand  = "command"
+	KindText     = "text"
+	KindLocation = "location"
+	KindCallback = "callback"
+	KindOther    = "other"
+)
+
+// Message returns the message of the update or the message with the inline
+// keyboard for callback queries.
+func (r *Request) Message() *tg.Message {
+	switch {
+	case r.Update.Message != nil:
+		return r.Update.Message
+	case r.Update.CallbackQuery != nil:
+		return r.Update.CallbackQuery.Message
+	}
+	return nil
+}
+
+// Callback returns the callback query of the update
+func (r *Request) Callback() *tg.CallbackQuery {
+	return r.Update.CallbackQuery
+}
+
+// HandlerFunc handles a request
+type HandlerFunc func(*Request)
+
+// Middleware wraps a handler to do something before and/or after it
+type Middleware func(HandlerFunc) HandlerFunc
+
+var (
+	farmMiddleware   []Middleware
+	farmMiddlewareMu sync.Mutex
+)
+
+// Use adds middleware applied to requests of every bot in the farm. Farm
+// middleware runs before the middleware added with Router.Use. Use should be
+// called before bots are run.
+func Use(mw ...Middleware) {
+	farmMiddlewareMu.Lock()
+	defer farmMiddlewareMu.Unlock()
+
+	farmMiddleware = append(farmMiddleware, mw...)
+}
+
+// Router routes updates of a bot to the registered handlers.
+type Router struct {
+	name       string
+	logger     *zap.SugaredLogger
+	middleware []Middleware
+
+	commands       map[string]HandlerFunc
+	unknownCommand HandlerFunc
+	text           HandlerFunc
+	location       HandlerFunc
+	callbacks      map[string]HandlerFunc
+	callback       HandlerFunc
+}
+
+// NewRouter creates a router for the named bot. l is the bot logger.
+func NewRouter(name string, l *zap.SugaredLogger) *Router {
+	return &Router{
+		name:      name,
+		logger:    l,
+		commands:  make(map[string]HandlerFunc),
+		callbacks: make(map[string]HandlerFunc),
+	}
+}
+
+// Use adds middleware applied to requests of this bot only.
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Command registers a handler for the command name (without leading '/').
+func (r *Router) Command(name string, h HandlerFunc) {
+	r.commands[name] = h
+}
+
+// UnknownCommand registers a handler for commands that aren't registered.
+func (r *Router) UnknownCommand(h HandlerFunc) {
+	r.unknownCommand = h
+}
+
+// Commands returns names of registered commands.
+func (r *Router) Commands() []string {
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Text registers a handler for messages that aren't commands or locations
+// (text, media with captions, etc.).
+func (r *Router) Text(h HandlerFunc) {
+	r.text = h
+}
+
+// Location registers a handler for messages with location.
+func (r *Router) Location(h HandlerFunc) {
+	r.location = h
+}
+
+// Callback registers a handler for callback queries with the given data.
+func (r *Router) Callback(data string, h HandlerFunc) {
+	r.callbacks[data] = h
+}
+
+// DefaultCallback registers a handler for callback queries that don't match
+// any data registered with Callback.
+func (r *Router) DefaultCallback(h HandlerFunc) {
+	r.callback = h
+}
+
+// NewRequest parses the update.
+func (r *Router) NewRequest(u *tg.Update) *Request {
+	req := &Request{Update: u, Bot: r.name, Kind: KindOther}
+
+	switch {
+	case u.Message != nil:
+		msg := u.Message
+		if msg.From != nil {
+			req.User = msg.From.ID
+		}
+		if msg.Chat != nil {
+			req.Chat = msg.Chat.ID
+		}
+
+		switch {
+		case msg.IsCommand():
+			req.Kind = KindCommand
+			req.Command = msg.Command()
+			req.Args = strings.TrimSpace(msg.CommandArguments())
+			req.ArgList = strings.Fields(req.Args)
+		case msg.Location != nil:
+			req.Kind = KindLocation
+		default:
+			req.Kind = KindText
+		}
+
+	case u.CallbackQuery != nil:
+		req.Kind = KindCallback
+		req.User = u.CallbackQuery.From.ID
+		if u.CallbackQuery.Message != nil && u.CallbackQuery.Message.Chat != nil {
+			req.Chat = u.CallbackQuery.Message.Chat.ID
+		}
+	}
+
+	req.Logger = r.logger.With("usr", req.User)
+
+	return req
+}
+
+// Handle routes the update to its handler through the middleware chain.
+// Updates without a handler are ignored.
+func (r *Router) Handle(u *tg.Update) {
+	touch(r.name)
+	req := r.NewRequest(u)
+
+	h := r.route(req)
+	if h == nil {
+		req.Logger.Debugw("no handler for update", "kind", req.Kind)
+		return
+	}
+
+	farmMiddlewareMu.Lock()
+	mw := append(append([]Middleware{}, farmMiddleware...), r.middleware...)
+	farmMiddlewareMu.Unlock()
+
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+
+	h(req)
+}
+
+func (r *Router) route(req *Request) HandlerFunc {
+	switch req.Kind {
+	case KindCommand:
+		if h, ok := r.commands[req.Command]; ok {
+			return h
+		}
+		return r.unknownCommand
+
+	case KindLocation:
+		if r.location != nil {
+			return r.location
+		}
+		return r.text
+
+	case KindText:
+		return r.text
+
+	case KindCallback:
+		if h, ok := r.callbacks[req.Callback().Data]; ok {
+			return h
+		}
+		return r.callback
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"reflect"
+	"testing"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+func command(usr int64, text string, n int) *tg.Update {
+	return &tg.Update{Message: &tg.Message{
+		From:     &tg.User{ID: usr},
+		Chat:     &tg.Chat{ID: usr},
+		Text:     text,
+		Entities: []tg.MessageEntity{{Type: "bot_command", Length: n}},
+	}}
+}
+
+func TestRouter(t *testing.T) {
+	r := bot.NewRouter("test", zap.NewNop().Sugar())
+
+	var got []string
+	r.Use(func(next bot.HandlerFunc) bot.HandlerFunc {
+		return func(req *bot.Request) {
+			got = append(got, "mw")
+			next(req)
+		}
+	})
+	r.Command("add", func(req *bot.Request) {
+		got = append(got, "add:"+req.Args)
+		if !reflect.DeepEqual(req.ArgList, []string{"buy", "milk"}) {
+			t.Errorf("unexpected arguments %v", req.ArgList)
+		}
+	})
+	r.UnknownCommand(func(req *bot.Request) { got = append(got, "unknown:"+req.Command) })
+	r.Text(func(req *bot.Request) { got = append(got, "text") })
+	r.Callback("yes", func(req *bot.Request) { got = append(got, "yes") })
+
+	r.Handle(command(1, "/add  buy milk ", 4))
+	r.Handle(command(1, "/foo", 4))
+	r.Handle(&tg.Update{Message: &tg.Message{From: &tg.User{ID: 1}, Chat: &tg.Chat{ID: 1}, Text: "hi"}})
+	r.Handle(&tg.Update{CallbackQuery: &tg.CallbackQuery{From: &tg.User{ID: 1}, Data: "yes"}})
+	r.Handle(&tg.Update{CallbackQuery: &tg.CallbackQuery{From: &tg.User{ID: 1}, Data: "no"}})
+
+	expected := []string{"mw", "add:buy milk", "mw", "unknown:foo", "mw", "text", "mw", "yes"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	r := bot.NewRouter("test", zap.NewNop().Sugar())
+	r.Use(bot.RateLimit(0.001, 2))
+
+	handled := map[int64]int{}
+	r.Text(func(req *bot.Request) { handled[req.User]++ })
+	text := func(usr int64) *tg.Update {
+		return &tg.Update{Message: &tg.Message{From: &tg.User{ID: usr}, Chat: &tg.Chat{ID: usr}, Text: "hi"}}
+	}
+
+	for i := 0; i < 3; i++ {
+		r.Handle(text(1))
+	}
+	// many users make limiters of idle ones to be dropped, but not the
+	// limiter of the user over the limit
+	for usr := int64(2); usr < 20000; usr++ {
+		r.Handle(text(usr))
+	}
+	r.Handle(text(1))
+
+	if handled[1] != 2 || handled[2] != 1 || handled[19999] != 1 {
+		t.Errorf("expected the first user to be limited to 2 requests, got %d", handled[1])
+	}
+}
@@ -0,0 +1,284 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes fire times of a job (see Scheduler)
+type Schedule interface {
+	// Next returns the first fire time after t or the zero time if the job
+	// won't fire again
+	Next(t time.Time) time.Time
+	// String describes the schedule. Schedules with equal descriptions fire at
+	// the same times.
+	String() string
+}
+
+// once fires at the given time
+type once time.Time
+
+// At returns the schedule firing once at t
+func At(t time.Time) Schedule {
+	return once(t)
+}
+
+func (o once) Next(t time.Time) time.Time {
+	if at := time.Time(o); at.After(t) {
+		return at
+	}
+	return time.Time{}
+}
+
+func (o once) String() string {
+	return "at " + time.Time(o).UTC().Format(time.RFC3339)
+}
+
+// cronHorizon is the number of days searched for the next fire time of
+// a calendar schedule, so schedules like "0 0 30 2 *" don't loop forever
+const cronHorizon = 5 * 366
+
+// calendar fires at minutes matching its fields in the wall clock time of its
+// location, like cron does. Times skipped or repeated by daylight saving time
+// transitions fire once (see wallTime).
+type calendar struct {
+	spec    string
+	loc     *time.Location
+	minutes uint64 // bits 0-59
+	hours   uint64 // bits 0-23
+	days    uint64 // bits 1-31
+	months  uint64 // bits 1-12
+	weekday uint64 // bits 0-6, Sunday is 0
+	anyDay  bool   // days field is "*"
+	anyWday bool   // weekday field is "*"
+}
+
+// Daily returns the schedule firing every day at hour:min in loc
+func Daily(hour, min int, loc *time.Location) Schedule {
+	return &calendar{
+		spec:    fmt.Sprintf("daily %02d:%02d %s", hour, min, loc),
+		loc:     loc,
+		minutes: 1 << uint(min),
+		hours:   1 << uint(hour),
+		days:    bits(1, 31),
+		months:  bits(1, 12),
+		weekday: bits(0, 6),
+		anyDay:  true,
+		anyWday: true,
+	}
+}
+
+// Weekly returns the schedule firing every week on the weekday at hour:min in
+// loc
+func Weekly(day time.Weekday, hour, min int, loc *time.Location) Schedule {
+	return &calendar{
+		spec:    fmt.Sprintf("weekly %s %02d:%02d %s", strings.ToLower(day.String()[:3]), hour, min, loc),
+		loc:     loc,
+		minutes: 1 << uint(min),
+		hours:   1 << uint(hour),
+		days:    bits(1, 31),
+		months:  bits(1, 12),
+		weekday: 1 << uint(day),
+		anyDay:  true,
+	}
+}
+
+// cronField describes a field of a cron expression
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names of values starting from min
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// 7 is Sunday too
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// ParseCron parses the cron expression "minute hour day-of-month month
+// day-of-week" evaluated in the wall clock time of loc. Fields are "*",
+// values, ranges "a-b" and steps "*/n" or "a-b/n" separated by commas. Months
+// and weekdays may be named by their first three letters. Like in cron, if
+// both day fields are restricted, a day matches either of them.
+func ParseCron(expr string, loc *time.Location) (Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields", expr, len(cronFields))
+	}
+
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := cronFields[i].parse(strings.ToLower(f))
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+
+	// fold Sunday as 7 into 0
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+
+	return &calendar{
+		spec:    fmt.Sprintf("cron %s %s", strings.Join(fields, " "), loc),
+		loc:     loc,
+		minutes: sets[0],
+		hours:   sets[1],
+		days:    sets[2],
+		months:  sets[3],
+		weekday: sets[4],
+		anyDay:  fields[2] == "*",
+		anyWday: fields[4] == "*",
+	}, nil
+}
+
+// parse returns the set of values of the field
+func (f cronField) parse(s string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %s %q", f.name, part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(from); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(to); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// "a/n" means from a to the maximum
+				hi = f.max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range in %s %q", f.name, part)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
+
+// value parses a value of the field
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if s == name {
+			return f.min + i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q", f.name, s)
+	}
+
+	return v, nil
+}
+
+// bits returns the set of values from lo to hi
+func bits(lo, hi int) uint64 {
+	var set uint64
+	for v := lo; v <= hi; v++ {
+		set |= 1 << uint(v)
+	}
+	return set
+}
+
+func (c *calendar) Next(t time.Time) time.Time {
+	t = t.In(c.loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.loc)
+	for i := 0; i < cronHorizon; i++ {
+		d := day.AddDate(0, 0, i)
+		if !c.matchDay(d) {
+			continue
+		}
+
+		for h := 0; h < 24; h++ {
+			if c.hours&(1<<uint(h)) == 0 {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if c.minutes&(1<<uint(m)) == 0 {
+					continue
+				}
+				if next := wallTime(d, h, m, c.loc); next.After(t) {
+					return next
+				}
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// wallTime returns the instant the wall clock in loc shows hour:min on the
+// day. If the clock skips the time, e.g. when daylight saving time starts, it
+// returns the instant the clock jumps, so the job fires late but once. If the
+// clock shows the time twice, it returns the first instant, so a job that
+// already fired isn't fired again.
+func wallTime(d time.Time, hour, min int, loc *time.Location) time.Time {
+	t := time.Date(d.Year(), d.Month(), d.Day(), hour, min, 0, 0, loc)
+	if t.Hour() != hour || t.Minute() != min {
+		// time.Date normalizes skipped times past the jump
+		if start, _ := t.ZoneBounds(); !start.IsZero() {
+			return start
+		}
+		return t
+	}
+
+	// time.Date may return either instant of a repeated time. The clock goes
+	// back by the difference of the offsets, which isn't always an hour.
+	if start, _ := t.ZoneBounds(); !start.IsZero() {
+		_, before := start.Add(-time.Second).Zone()
+		_, after := t.Zone()
+		if shift := time.Duration(before-after) * time.Second; shift > 0 {
+			if earlier := t.Add(-shift); earlier.Hour() == hour && earlier.Minute() == min {
+				return earlier
+			}
+		}
+	}
+
+	return t
+}
+
+// matchDay reports whether the job fires on the day
+func (c *calendar) matchDay(d time.Time) bool {
+	if c.months&(1<<uint(d.Month())) == 0 {
+		return false
+	}
+
+	day := c.days&(1<<uint(d.Day())) != 0
+	wday := c.weekday&(1<<uint(d.Weekday())) != 0
+	if !c.anyDay && !c.anyWday {
+		return day || wday
+	}
+
+	return day && wday
+}
+
+func (c *calendar) String() string {
+	return c.spec
+}
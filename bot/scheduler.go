@@ -0,0 +1,602 @@
+package bot
+
+import (
+	"container/heap"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// schedulerTick is the longest time the scheduler loop sleeps, so it's
+	// seen ticking by health checks
+	schedulerTick = 20 * time.Second
+	// jobTimeout limits the time of loading and saving jobs
+	jobTimeout = 5 * time.Second
+	// jobLease is how long a replica may run a job it claimed before other
+	// replicas take it over
+	jobLease = time.Minute
+	// minJobRetry is the shortest time before a job claimed elsewhere is
+	// claimed again, so clock skew between replicas and the database doesn't
+	// make them retry without pause
+	minJobRetry = time.Second
+)
+
+// ErrNoFireTime is returned by Scheduler.Add for schedules that won't fire
+var ErrNoFireTime = errors.New("schedule has no fire time")
+
+// JobFunc is the function of a job. It's called with the job key and the fire
+// time it's run for. If it returns an error, the job is run again for the same
+// time when its lease expires.
+type JobFunc func(key string, at time.Time) error
+
+// JobRecord is a job as kept by a JobStore
+type JobRecord struct {
+	Key          string
+	Schedule     string // description of the schedule, see Schedule.String
+	Next         time.Time
+	ClaimedBy    string    // replica running the job for Next, if any
+	ClaimedUntil time.Time // time the lease of ClaimedBy expires
+}
+
+// JobStore keeps next fire times of scheduled jobs
+type JobStore interface {
+	// LoadJobs returns jobs of the bot
+	LoadJobs(ctx context.Context, bot string) ([]JobRecord, error)
+	// SaveJob creates or replaces the job releasing its lease
+	SaveJob(ctx context.Context, bot string, rec JobRecord) error
+	DeleteJob(ctx context.Context, bot, key string) error
+	// ClaimJob leases the job due at the fire time to the replica for the
+	// duration. It returns false and the current record of the job if the
+	// job isn't due at that time, i.e. it's run or changed by another replica,
+	// or another replica holds an unexpired lease. The record is zero if there
+	// is no such job.
+	ClaimJob(ctx context.Context, bot, key, replica string, due time.Time, lease time.Duration) (JobRecord, bool, error)
+	// CompleteJob moves the job leased by the replica from the fire time due
+	// to next or deletes it if next is zero. It returns false if the replica
+	// doesn't hold the lease anymore.
+	CompleteJob(ctx context.Context, bot, key, replica string, due, next time.Time) (bool, error)
+}
+
+// job is a scheduled job in the queue of the scheduler
+type job struct {
+	key      string
+	schedule Schedule
+	next     time.Time // time the job is due or, while it's run, checked again
+	due      time.Time // fire time the job is run for, zero between runs
+	running  bool      // a task runs the job
+	f        JobFunc
+	index    int // in the queue
+}
+
+// jobQueue orders jobs by their next fire time
+type jobQueue []*job
+
+func (q jobQueue) Len() int           { return len(q) }
+func (q jobQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x any) {
+	j := x.(*job)
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	n := len(old)
+	j := old[n-1]
+	old[n-1] = nil
+	j.index = -1
+	*q = old[:n-1]
+	return j
+}
+
+// Scheduler runs jobs of a bot at times set by their schedules. Jobs are
+// identified by keys, adding a job with the key of another job replaces it.
+// Next fire times are kept in the store, so a job added again after the bot
+// restarts keeps its fire time. Jobs are run as tasks of the bot.
+//
+// Replicas of the farm sharing the store schedule the same jobs, but a job is
+// run by the replica that claims it first (see JobStore.ClaimJob). The claim
+// is a lease: the job moves to its next fire time only when it succeeds, and
+// if the replica dies or the job fails, the job is run again by any replica
+// once the lease expires. So a job is run at least once as long as any replica
+// is running; jobs that mustn't be repeated have to record that they're done.
+type Scheduler struct {
+	bot     string
+	replica string // identifies the scheduler in leases
+	store   JobStore
+	tasks   *Tasks
+	logger  *zap.SugaredLogger
+	wake    chan struct{}
+	reload  func(key string)
+	lease   time.Duration
+
+	mu     sync.Mutex
+	queue  jobQueue
+	jobs   map[string]*job
+	stored map[string]JobRecord // loaded from the store, but not added yet
+	ticked time.Time            // time the loop started or ticked last
+}
+
+// schedulers counts schedulers created by the process, so replicas in one
+// process have different names
+var schedulers int64
+
+// NewScheduler creates the scheduler of the named bot. store may be nil, then
+// fire times are kept in memory.
+func NewScheduler(bot string, store JobStore, tasks *Tasks, l *zap.SugaredLogger) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		bot:     bot,
+		replica: fmt.Sprintf("%s:%d:%d", host, os.Getpid(), atomic.AddInt64(&schedulers, 1)),
+		store:   store,
+		tasks:   tasks,
+		logger:  l,
+		wake:    make(chan struct{}, 1),
+		lease:   jobLease,
+		jobs:    make(map[string]*job),
+		stored:  make(map[string]JobRecord),
+	}
+}
+
+// SetLease sets how long the scheduler may run a job it claimed before other
+// replicas take it over. It must be set before Run.
+func (s *Scheduler) SetLease(d time.Duration) {
+	s.lease = d
+}
+
+// SetReload sets the function adding the job with the key again when its
+// schedule may have been changed by another replica, i.e. the job was due, but
+// another replica ran or changed it. It must be set before Run.
+func (s *Scheduler) SetReload(f func(key string)) {
+	s.reload = f
+}
+
+// Run loads fire times from the store and starts the scheduler loop. The loop
+// stops when ctx is done. Jobs stay scheduled, so the scheduler may be run
+// again.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if s.store != nil {
+		loadCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+		defer cancel()
+
+		records, err := s.store.LoadJobs(loadCtx, s.bot)
+		if err != nil {
+			return fmt.Errorf("failed loading jobs: %w", err)
+		}
+
+		s.mu.Lock()
+		for _, rec := range records {
+			if _, ok := s.jobs[rec.Key]; !ok {
+				s.stored[rec.Key] = rec
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	s.ticked = time.Now()
+	s.mu.Unlock()
+
+	go s.loop(ctx)
+
+	return nil
+}
+
+// Add schedules the job f with the key replacing the job with the same key.
+// If the store has the fire time of the job with the same schedule, the job
+// fires at that time unless it's passed.
+func (s *Scheduler) Add(key string, schedule Schedule, f JobFunc) error {
+	now := time.Now()
+	next := nextTime(schedule, now)
+	if next.IsZero() {
+		return ErrNoFireTime
+	}
+
+	s.mu.Lock()
+	rec, ok := s.stored[key]
+	delete(s.stored, key)
+	if ok && rec.Schedule == schedule.String() && rec.Next.After(now) {
+		next = rec.Next
+	}
+
+	j, ok := s.jobs[key]
+	if ok {
+		j.schedule, j.next, j.due, j.f = schedule, next, time.Time{}, f
+		heap.Fix(&s.queue, j.index)
+	} else {
+		j = &job{key: key, schedule: schedule, next: next, f: f}
+		heap.Push(&s.queue, j)
+		s.jobs[key] = j
+	}
+	scheduledJobs.WithLabelValues(s.bot).Set(float64(len(s.queue)))
+	s.mu.Unlock()
+
+	s.notify()
+
+	if rec.Schedule == schedule.String() && rec.Next.Equal(next) {
+		return nil
+	}
+	return s.save(JobRecord{Key: key, Schedule: schedule.String(), Next: next})
+}
+
+// Cancel removes the job with the key. It returns false if there's no such
+// job.
+func (s *Scheduler) Cancel(key string) bool {
+	s.mu.Lock()
+	delete(s.stored, key)
+	j, ok := s.jobs[key]
+	if ok {
+		heap.Remove(&s.queue, j.index)
+		delete(s.jobs, key)
+		scheduledJobs.WithLabelValues(s.bot).Set(float64(len(s.queue)))
+	}
+	s.mu.Unlock()
+
+	s.delete(key)
+
+	return ok
+}
+
+// Next returns the next fire time of the job with the key
+func (s *Scheduler) Next(key string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return j.next, true
+}
+
+// Len returns the number of scheduled jobs
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.queue)
+}
+
+// Ticking returns an error if the scheduler loop didn't tick for too long,
+// e.g. it's stuck or not running.
+func (s *Scheduler) Ticking() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ticked.IsZero() {
+		return errors.New("scheduler loop isn't running")
+	}
+
+	if since := time.Since(s.ticked); since > 3*schedulerTick {
+		return fmt.Errorf("scheduler loop didn't tick for %v", since.Truncate(time.Second))
+	}
+
+	return nil
+}
+
+// notify wakes the loop up, so it sleeps until the new earliest job
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.ticked = time.Time{}
+			s.mu.Unlock()
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+
+		wait := s.fireDue(time.Now())
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// fireDue runs jobs due at the moment now and returns the time until the next
+// job
+func (s *Scheduler) fireDue(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ticked = time.Now()
+	stopping := false
+	for len(s.queue) > 0 && !s.queue[0].next.After(now) {
+		j := s.queue[0]
+
+		// the job isn't run again while it's still running
+		if !j.running {
+			if j.due.IsZero() {
+				j.due = j.next
+			}
+
+			// jobs missed while the loop was late fire once
+			due, next := j.due, nextTime(j.schedule, now)
+			key, f := j.key, j.f
+			if !s.tasks.Go(func() { s.run(key, due, next, f) }) {
+				stopping = true
+				break
+			}
+			j.running = true
+		}
+
+		// the job is checked again when its lease expires unless it's done
+		// before
+		j.next = now.Add(s.lease)
+		heap.Fix(&s.queue, 0)
+	}
+	scheduledJobs.WithLabelValues(s.bot).Set(float64(len(s.queue)))
+
+	wait := schedulerTick
+	if len(s.queue) > 0 && !stopping {
+		if d := s.queue[0].next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+
+	return wait
+}
+
+// run claims the job due at the time, runs it and moves it to the next fire
+// time. A job that fails or is run by another replica stays due at the time
+// until its lease expires. Jobs moved by other replicas are added again with
+// the reload function.
+func (s *Scheduler) run(key string, due, next time.Time, f JobFunc) {
+	if s.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+		rec, claimed, err := s.store.ClaimJob(ctx, s.bot, key, s.replica, due, s.lease)
+		cancel()
+
+		switch {
+		case err != nil:
+			s.logger.Errorw("failed claiming job; it's retried", "job", key, "err", err)
+			s.retry(key, due, time.Time{})
+			return
+
+		case !claimed && rec.Next.Equal(due):
+			s.logger.Debugw("job is run by another replica", "job", key, "replica", rec.ClaimedBy)
+			s.retry(key, due, rec.ClaimedUntil)
+			return
+
+		case !claimed:
+			s.logger.Debugw("job is claimed by another replica", "job", key)
+			jobsClaimedElsewhere.WithLabelValues(s.bot).Inc()
+			s.finish(key, due, next)
+			if s.reload != nil {
+				s.reload(key)
+			}
+			return
+		}
+	}
+
+	jobRuns.WithLabelValues(s.bot).Inc()
+	if err := f(key, due); err != nil {
+		s.logger.Errorw("job failed; it's retried when its lease expires", "job", key, "err", err)
+		s.retry(key, due, time.Time{})
+		return
+	}
+
+	if s.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+		completed, err := s.store.CompleteJob(ctx, s.bot, key, s.replica, due, next)
+		cancel()
+
+		if err != nil {
+			s.logger.Errorw("failed completing job; it may be run again by another replica", "job", key, "err", err)
+		} else if !completed {
+			s.logger.Warnw("job lease expired before the job completed", "job", key)
+		}
+	}
+	s.finish(key, due, next)
+}
+
+// finish moves the job run for the fire time due to next or removes it if
+// next is zero
+func (s *Scheduler) finish(key string, due, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[key]
+	if !ok {
+		return
+	}
+	j.running = false
+
+	// the job was replaced while it was run
+	if !j.due.Equal(due) {
+		return
+	}
+
+	j.due = time.Time{}
+	if next.IsZero() {
+		heap.Remove(&s.queue, j.index)
+		delete(s.jobs, key)
+		scheduledJobs.WithLabelValues(s.bot).Set(float64(len(s.queue)))
+		return
+	}
+	j.next = next
+	heap.Fix(&s.queue, j.index)
+	s.notify()
+}
+
+// retry keeps the job run for the fire time due to run it again at the time
+// or, if it's zero, when its lease expires
+func (s *Scheduler) retry(key string, due, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[key]
+	if !ok {
+		return
+	}
+	j.running = false
+
+	if !j.due.Equal(due) || at.IsZero() {
+		return
+	}
+
+	if earliest := time.Now().Add(minJobRetry); at.Before(earliest) {
+		at = earliest
+	}
+	j.next = at
+	heap.Fix(&s.queue, j.index)
+	s.notify()
+}
+
+// nextTime returns the next fire time of the schedule after t. Times are
+// truncated to microseconds, so they're compared with the times kept in
+// Postgres.
+func nextTime(schedule Schedule, t time.Time) time.Time {
+	return schedule.Next(t).Truncate(time.Microsecond)
+}
+
+func (s *Scheduler) save(rec JobRecord) error {
+	if s.store == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	return s.store.SaveJob(ctx, s.bot, rec)
+}
+
+func (s *Scheduler) delete(key string) {
+	if s.store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	if err := s.store.DeleteJob(ctx, s.bot, key); err != nil {
+		s.logger.Errorw("failed deleting job", "job", key, "err", err)
+	}
+}
+
+// PGJobStore is a JobStore that keeps jobs in the scheduled_jobs table of
+// a Postgres database.
+type PGJobStore struct {
+	db *sql.DB
+}
+
+func NewPGJobStore(db *sql.DB) *PGJobStore {
+	return &PGJobStore{db: db}
+}
+
+func (s *PGJobStore) LoadJobs(ctx context.Context, bot string) ([]JobRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT key, schedule, next, claimed_by, claimed_until FROM scheduled_jobs WHERE bot = $1", bot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []JobRecord
+	for rows.Next() {
+		var rec JobRecord
+		var claimedBy sql.NullString
+		var claimedUntil sql.NullTime
+		if err := rows.Scan(&rec.Key, &rec.Schedule, &rec.Next, &claimedBy, &claimedUntil); err != nil {
+			return nil, err
+		}
+		rec.ClaimedBy, rec.ClaimedUntil = claimedBy.String, claimedUntil.Time
+		records = append(records, rec)
+	}
+
+	return records, rows.Err()
+}
+
+func (s *PGJobStore) SaveJob(ctx context.Context, bot string, rec JobRecord) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO scheduled_jobs(bot, key, schedule, next) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (bot, key) DO UPDATE
+		SET schedule = EXCLUDED.schedule, next = EXCLUDED.next, claimed_by = NULL, claimed_until = NULL`,
+		bot, rec.Key, rec.Schedule, rec.Next.UTC())
+	return err
+}
+
+// ClaimJob leases the job comparing lease expiry with the database clock, so
+// clocks of replicas don't matter
+func (s *PGJobStore) ClaimJob(ctx context.Context, bot, key, replica string, due time.Time,
+	lease time.Duration) (JobRecord, bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE scheduled_jobs SET claimed_by = $4, claimed_until = now() + make_interval(secs => $5)
+		WHERE bot = $1 AND key = $2 AND next = $3 AND (claimed_until IS NULL OR claimed_until < now())`,
+		bot, key, due.UTC(), replica, lease.Seconds())
+	if err != nil {
+		return JobRecord{}, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return JobRecord{}, n == 1, err
+	}
+
+	rec := JobRecord{Key: key}
+	var claimedBy sql.NullString
+	var claimedUntil sql.NullTime
+	err = s.db.QueryRowContext(ctx,
+		"SELECT schedule, next, claimed_by, claimed_until FROM scheduled_jobs WHERE bot = $1 AND key = $2", bot, key).
+		Scan(&rec.Schedule, &rec.Next, &claimedBy, &claimedUntil)
+	if err == sql.ErrNoRows {
+		return JobRecord{}, false, nil
+	}
+	rec.ClaimedBy, rec.ClaimedUntil = claimedBy.String, claimedUntil.Time
+	return rec, false, err
+}
+
+func (s *PGJobStore) CompleteJob(ctx context.Context, bot, key, replica string, due, next time.Time) (bool, error) {
+	var res sql.Result
+	var err error
+	if next.IsZero() {
+		res, err = s.db.ExecContext(ctx,
+			"DELETE FROM scheduled_jobs WHERE bot = $1 AND key = $2 AND next = $3 AND claimed_by = $4",
+			bot, key, due.UTC(), replica)
+	} else {
+		res, err = s.db.ExecContext(ctx,
+			`UPDATE scheduled_jobs SET next = $5, claimed_by = NULL, claimed_until = NULL
+			WHERE bot = $1 AND key = $2 AND next = $3 AND claimed_by = $4`,
+			bot, key, due.UTC(), replica, next.UTC())
+	}
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *PGJobStore) DeleteJob(ctx context.Context, bot, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM scheduled_jobs WHERE bot = $1 AND key = $2", bot, key)
+	return err
+}
@@ -0,0 +1,437 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// memoryJobStore is a JobStore shared by replicas
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]bot.JobRecord
+}
+
+func (s *memoryJobStore) LoadJobs(context.Context, string) ([]bot.JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []bot.JobRecord
+	for _, rec := range s.jobs {
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func (s *memoryJobStore) SaveJob(_ context.Context, _ string, rec bot.JobRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[rec.Key] = rec
+	return nil
+}
+
+func (s *memoryJobStore) DeleteJob(_ context.Context, _, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, key)
+	return nil
+}
+
+func (s *memoryJobStore) ClaimJob(_ context.Context, _, key, replica string, due time.Time,
+	lease time.Duration) (bot.JobRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.jobs[key]
+	if !ok || !rec.Next.Equal(due) || (rec.ClaimedBy != "" && time.Now().Before(rec.ClaimedUntil)) {
+		return rec, false, nil
+	}
+	rec.ClaimedBy, rec.ClaimedUntil = replica, time.Now().Add(lease)
+	s.jobs[key] = rec
+	return rec, true, nil
+}
+
+func (s *memoryJobStore) CompleteJob(_ context.Context, _, key, replica string, due, next time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.jobs[key]
+	if !ok || !rec.Next.Equal(due) || rec.ClaimedBy != replica {
+		return false, nil
+	}
+	if next.IsZero() {
+		delete(s.jobs, key)
+	} else {
+		s.jobs[key] = bot.JobRecord{Key: key, Schedule: rec.Schedule, Next: next}
+	}
+	return true, nil
+}
+
+func (s *memoryJobStore) job(key string) (bot.JobRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.jobs[key]
+	return rec, ok
+}
+
+// fireTimes is a schedule firing at the times
+type fireTimes []time.Time
+
+func (ft fireTimes) Next(t time.Time) time.Time {
+	for _, at := range ft {
+		if at.After(t) {
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+func (ft fireTimes) String() string { return fmt.Sprint([]time.Time(ft)) }
+
+func TestSchedule(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	// Friday
+	now := time.Date(2026, 10, 16, 10, 0, 0, 0, berlin)
+	every5, err := bot.ParseCron("*/5 9-17 * * mon-fri", berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstMonday, err := bot.ParseCron("30 8 1-7 * 1", berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		schedule bot.Schedule
+		want     time.Time
+	}{
+		{bot.At(now.Add(time.Hour)), now.Add(time.Hour)},
+		{bot.At(now), time.Time{}},
+		{bot.Daily(9, 30, berlin), time.Date(2026, 10, 17, 9, 30, 0, 0, berlin)},
+		{bot.Daily(10, 1, berlin), time.Date(2026, 10, 16, 10, 1, 0, 0, berlin)},
+		{bot.Weekly(time.Monday, 8, 0, berlin), time.Date(2026, 10, 19, 8, 0, 0, 0, berlin)},
+		{every5, time.Date(2026, 10, 16, 10, 5, 0, 0, berlin)},
+		// either the first seven days of a month or Mondays
+		{firstMonday, time.Date(2026, 10, 19, 8, 30, 0, 0, berlin)},
+	} {
+		if got := tc.schedule.Next(now); !got.Equal(tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.schedule, tc.want, got)
+		}
+	}
+
+	if got := every5.Next(time.Date(2026, 10, 16, 17, 55, 0, 0, berlin)); !got.Equal(time.Date(2026, 10, 19, 9, 0, 0, 0, berlin)) {
+		t.Errorf("expected the job to skip the weekend, got %v", got)
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		if _, err := bot.ParseCron(expr, time.UTC); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+func TestScheduleDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	// clocks jump from 02:00 to 03:00 on March 29, 2026 and go back from 03:00
+	// to 02:00 on October 25, 2026
+	spring := time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC)
+	autumn := time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC) // the first 02:30
+
+	// clocks jump from 02:00 to 02:30 on October 4, 2026 and go back from 02:00
+	// to 01:30 on April 5, 2026
+	lordHowe, err := time.LoadLocation("Australia/Lord_Howe")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	halfSpring := time.Date(2026, 10, 3, 15, 30, 0, 0, time.UTC)
+	halfAutumn := time.Date(2026, 4, 4, 14, 45, 0, 0, time.UTC) // the first 01:45
+
+	every10, err := bot.ParseCron("*/10 2 * * *", berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		schedule bot.Schedule
+		after    time.Time
+		want     []time.Time
+	}{
+		{
+			name:     "wall clock time is kept across transitions",
+			schedule: bot.Daily(9, 0, berlin),
+			after:    time.Date(2026, 3, 28, 9, 0, 0, 0, berlin),
+			want: []time.Time{
+				time.Date(2026, 3, 29, 7, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 30, 7, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "skipped time fires at the jump",
+			schedule: bot.Daily(2, 30, berlin),
+			after:    time.Date(2026, 3, 28, 2, 30, 0, 0, berlin),
+			want: []time.Time{
+				spring,
+				time.Date(2026, 3, 30, 0, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "skipped times fire once",
+			schedule: every10,
+			after:    time.Date(2026, 3, 28, 2, 50, 0, 0, berlin),
+			want: []time.Time{
+				spring,
+				time.Date(2026, 3, 30, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "repeated time fires once",
+			schedule: bot.Daily(2, 30, berlin),
+			after:    time.Date(2026, 10, 24, 2, 30, 0, 0, berlin),
+			want: []time.Time{
+				autumn,
+				time.Date(2026, 10, 26, 1, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "repeated time doesn't fire again after restart",
+			schedule: bot.Daily(2, 30, berlin),
+			after:    autumn.Add(30 * time.Minute), // the second 02:00
+			want: []time.Time{
+				time.Date(2026, 10, 26, 1, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "skipped time fires at a half-hour jump",
+			schedule: bot.Daily(2, 15, lordHowe),
+			after:    time.Date(2026, 10, 3, 2, 15, 0, 0, lordHowe),
+			want: []time.Time{
+				halfSpring,
+				time.Date(2026, 10, 4, 15, 15, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "time repeated after a half-hour shift fires once",
+			schedule: bot.Daily(1, 45, lordHowe),
+			after:    time.Date(2026, 4, 4, 1, 45, 0, 0, lordHowe),
+			want: []time.Time{
+				halfAutumn,
+				time.Date(2026, 4, 5, 15, 15, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "time repeated after a half-hour shift doesn't fire again after restart",
+			schedule: bot.Daily(1, 45, lordHowe),
+			after:    halfAutumn.Add(time.Minute),
+			want: []time.Time{
+				time.Date(2026, 4, 5, 15, 15, 0, 0, time.UTC),
+			},
+		},
+	} {
+		next := tc.after
+		for i, want := range tc.want {
+			if next = tc.schedule.Next(next); !next.Equal(want) {
+				t.Errorf("%s: expected fire %d at %v, got %v", tc.name, i, want, next.UTC())
+				break
+			}
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	l := zap.NewNop().Sugar()
+	tasks := &bot.Tasks{Logger: l}
+	s := bot.NewScheduler("test", nil, tasks, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	fired := map[string]int{}
+	f := func(key string, _ time.Time) error {
+		mu.Lock()
+		fired[key]++
+		mu.Unlock()
+		return nil
+	}
+
+	now := time.Now().Truncate(time.Millisecond)
+	if err := s.Add("past", bot.At(now.Add(-time.Second)), f); err != bot.ErrNoFireTime {
+		t.Errorf("expected ErrNoFireTime, got %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := s.Add(key, bot.At(now.Add(time.Hour)), f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rescheduled jobs fire once at the new time, canceled ones don't fire
+	s.Add("a", bot.At(now.Add(50*time.Millisecond)), f)
+	s.Add("b", bot.At(now.Add(100*time.Millisecond)), f)
+	if !s.Cancel("c") || s.Cancel("c") {
+		t.Error("expected the job to be canceled once")
+	}
+	if next, ok := s.Next("b"); !ok || !next.Equal(now.Add(100*time.Millisecond)) {
+		t.Errorf("unexpected next fire time %v", next)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if err := tasks.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fired["a"] != 1 || fired["b"] != 1 || fired["c"] != 0 || s.Len() != 0 {
+		t.Errorf("unexpected runs %v, %d jobs left", fired, s.Len())
+	}
+	if err := s.Ticking(); err != nil {
+		t.Errorf("expected the scheduler to be ticking, got %v", err)
+	}
+}
+
+func TestSchedulerReplicas(t *testing.T) {
+	l := zap.NewNop().Sugar()
+	store := &memoryJobStore{jobs: make(map[string]bot.JobRecord)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	runs, reloads := 0, 0
+	at := time.Now().Add(100 * time.Millisecond).Truncate(time.Millisecond)
+	var fired time.Time
+
+	var tasks []*bot.Tasks
+	for i := 0; i < 3; i++ {
+		tt := &bot.Tasks{Logger: l}
+		tasks = append(tasks, tt)
+
+		s := bot.NewScheduler("test", store, tt, l)
+		s.SetReload(func(string) {
+			mu.Lock()
+			reloads++
+			mu.Unlock()
+		})
+		if err := s.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		err := s.Add("job", bot.At(at), func(_ string, due time.Time) error {
+			mu.Lock()
+			runs++
+			fired = due
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// replicas that find the job leased try again when the lease expires
+	for i := 0; i < 300; i++ {
+		mu.Lock()
+		done := reloads == 2
+		mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, tt := range tasks {
+		tt.Wait(context.Background())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if runs != 1 || reloads != 2 {
+		t.Errorf("expected the job to run once and be reloaded by 2 replicas, got %d runs and %d reloads", runs, reloads)
+	}
+	if !fired.Equal(at) {
+		t.Errorf("expected the job to get its fire time %v, got %v", at, fired)
+	}
+	if len(store.jobs) != 0 {
+		t.Errorf("expected the fired job to be deleted, got %v", store.jobs)
+	}
+}
+
+func TestSchedulerLease(t *testing.T) {
+	l := zap.NewNop().Sugar()
+	store := &memoryJobStore{jobs: make(map[string]bot.JobRecord)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now().Truncate(time.Millisecond)
+	schedule := fireTimes{now.Add(100 * time.Millisecond), now.Add(time.Hour)}
+
+	// the replica claiming the job first dies running it, the job fails on
+	// the replica taking it over and succeeds when it's run again
+	dead := make(chan struct{})
+	defer close(dead)
+	var mu sync.Mutex
+	var runs []time.Time
+	f := func(_ string, due time.Time) error {
+		mu.Lock()
+		runs = append(runs, due)
+		n := len(runs)
+		mu.Unlock()
+
+		switch n {
+		case 1:
+			<-dead
+		case 2:
+			if rec, _ := store.job("job"); !rec.Next.Equal(schedule[0]) {
+				t.Errorf("expected the job to stay due until it succeeds, got %+v", rec)
+			}
+			return errors.New("failed")
+		}
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		s := bot.NewScheduler("test", store, &bot.Tasks{Logger: l}, l)
+		s.SetLease(100 * time.Millisecond)
+		if err := s.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Add("job", schedule, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rec bot.JobRecord
+	for i := 0; i < 300; i++ {
+		if rec, _ = store.job("job"); rec.Next.Equal(schedule[1]) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(runs) != 3 || !runs[1].Equal(schedule[0]) || !runs[2].Equal(schedule[0]) {
+		t.Errorf("expected the job to be run 3 times for %v, got %v", schedule[0], runs)
+	}
+	if !rec.Next.Equal(schedule[1]) || rec.ClaimedBy != "" {
+		t.Errorf("expected the job to move to %v, got %+v", schedule[1], rec)
+	}
+}
@@ -0,0 +1,382 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// Priority of outbound requests. Requests with higher priority are sent
+// first.
+type Priority int
+
+const (
+	PriorityLow    Priority = iota // bulk messages, e.g. reminders
+	PriorityNormal                 // replies to users
+	PriorityHigh
+)
+
+// ErrSenderClosed is returned for requests that weren't sent because the
+// sender was closed
+var ErrSenderClosed = errors.New("sender is closed")
+
+// PermanentError is an error that won't go away if the request is retried,
+// e.g. the user blocked the bot or the chat doesn't exist.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent reports whether err is a PermanentError
+func IsPermanent(err error) bool {
+	var pe *PermanentError
+	return errors.As(err, &pe)
+}
+
+// SenderConfig limits outbound requests of a bot. Rates must be positive.
+type SenderConfig struct {
+	Rate       float64 // requests per second to all chats
+	ChatRate   float64 // requests per second to a chat
+	ChatBurst  int
+	Attempts   int           // attempts made on transient errors
+	RetryDelay time.Duration // delay before the first retry, doubled on every retry
+}
+
+// Sender is Transport that sends requests through a queue. The queue enforces
+// global and per-chat rate limits, retries transient errors, waits as long as
+// Telegram asks on 429 Too Many Requests and sends requests with higher
+// priority first. Permanent errors are returned as PermanentError. Requests
+// made through Sender have PriorityNormal, WithPriority returns Sender with
+// another priority sharing the same queue.
+type Sender struct {
+	*sendQueue
+	pri Priority
+}
+
+type sendQueue struct {
+	name   string
+	next   Transport
+	cfg    SenderConfig
+	logger *zap.SugaredLogger
+
+	mu          sync.Mutex
+	jobs        []*sendJob // sorted by priority and arrival
+	seq         uint64
+	global      *tokenBucket
+	chats       *limiters[int64]
+	pausedUntil time.Time
+	closed      bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+type sendJob struct {
+	pri       Priority
+	seq       uint64
+	chat      int64 // 0 if the request isn't limited per chat
+	f         func() error
+	attempt   int
+	notBefore time.Time
+	result    chan error
+}
+
+// NewSender creates a sender of the named bot that sends requests with t.
+// Close must be called to stop the sender.
+func NewSender(name string, t Transport, cfg SenderConfig, l *zap.SugaredLogger) *Sender {
+	if cfg.Attempts < 1 {
+		cfg.Attempts = 1
+	}
+
+	q := &sendQueue{
+		name:   name,
+		next:   t,
+		cfg:    cfg,
+		logger: l,
+		global: newTokenBucket(cfg.Rate, int(math.Ceil(cfg.Rate))),
+		chats:  newLimiters[int64](cfg.ChatRate, cfg.ChatBurst),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+
+	return &Sender{sendQueue: q, pri: PriorityNormal}
+}
+
+// WithPriority returns the sender that sends requests with priority p
+func (s *Sender) WithPriority(p Priority) *Sender {
+	return &Sender{sendQueue: s.sendQueue, pri: p}
+}
+
+// Close stops the sender. Queued requests fail with ErrSenderClosed.
+func (s *Sender) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.notify()
+	<-s.done
+}
+
+func (s *Sender) Self() tg.User {
+	return s.next.Self()
+}
+
+func (s *Sender) SendMessage(m tg.MessageConfig) (tg.Message, error) {
+	var msg tg.Message
+	err := s.do(s.pri, m.ChatID, func() (err error) {
+		msg, err = s.next.SendMessage(m)
+		return err
+	})
+	return msg, err
+}
+
+func (s *Sender) EditMessage(e tg.EditMessageTextConfig) error {
+	return s.do(s.pri, e.ChatID, func() error {
+		return s.next.EditMessage(e)
+	})
+}
+
+func (s *Sender) DeleteMessage(cht int64, msgID int) error {
+	return s.do(s.pri, cht, func() error {
+		return s.next.DeleteMessage(cht, msgID)
+	})
+}
+
+func (s *Sender) Request(c tg.Chattable) (*tg.APIResponse, error) {
+	var resp *tg.APIResponse
+	err := s.do(s.pri, 0, func() (err error) {
+		resp, err = s.next.Request(c)
+		return err
+	})
+	return resp, err
+}
+
+func (s *Sender) Updates(ctx context.Context, name string) (<-chan tg.Update, error) {
+	return s.next.Updates(ctx, name)
+}
+
+// do queues f and waits for the result
+func (q *sendQueue) do(pri Priority, chat int64, f func() error) error {
+	job := &sendJob{pri: pri, chat: chat, f: f, result: make(chan error, 1)}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrSenderClosed
+	}
+	q.seq++
+	job.seq = q.seq
+	q.push(job)
+	q.mu.Unlock()
+
+	q.notify()
+	return <-job.result
+}
+
+// push inserts the job keeping the queue sorted. q.mu must be held.
+func (q *sendQueue) push(job *sendJob) {
+	i := sort.Search(len(q.jobs), func(i int) bool {
+		j := q.jobs[i]
+		return j.pri < job.pri || (j.pri == job.pri && j.seq > job.seq)
+	})
+	q.jobs = append(q.jobs, nil)
+	copy(q.jobs[i+1:], q.jobs[i:])
+	q.jobs[i] = job
+	sendQueueLength.WithLabelValues(q.name).Set(float64(len(q.jobs)))
+}
+
+func (q *sendQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends queued requests as soon as rate limits allow
+func (q *sendQueue) run() {
+	defer close(q.done)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		q.mu.Lock()
+		if q.closed {
+			for _, job := range q.jobs {
+				job.result <- ErrSenderClosed
+			}
+			q.jobs = nil
+			sendQueueLength.WithLabelValues(q.name).Set(0)
+			q.mu.Unlock()
+			return
+		}
+		job, wait := q.pop(time.Now())
+		q.mu.Unlock()
+
+		if job != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				q.exec(job)
+			}()
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// pop removes and returns the first job that can be sent at the moment now.
+// If there's none, it returns how long to wait. q.mu must be held.
+func (q *sendQueue) pop(now time.Time) (*sendJob, time.Duration) {
+	const idle = time.Hour
+
+	if len(q.jobs) == 0 {
+		return nil, idle
+	}
+	if now.Before(q.pausedUntil) {
+		return nil, q.pausedUntil.Sub(now)
+	}
+	if w := q.global.wait(now); w > 0 {
+		return nil, w
+	}
+
+	wait := idle
+	for i, job := range q.jobs {
+		if now.Before(job.notBefore) {
+			wait = minDuration(wait, job.notBefore.Sub(now))
+			continue
+		}
+
+		var cb *tokenBucket
+		if job.chat != 0 {
+			cb = q.chats.get(job.chat, now)
+			if w := cb.wait(now); w > 0 {
+				wait = minDuration(wait, w)
+				continue
+			}
+			cb.take(now)
+		}
+
+		q.global.take(now)
+		q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+		sendQueueLength.WithLabelValues(q.name).Set(float64(len(q.jobs)))
+		return job, 0
+	}
+
+	return nil, wait
+}
+
+// exec makes the request and either reports the result or queues the request
+// again if it should be retried
+func (q *sendQueue) exec(job *sendJob) {
+	err := job.f()
+	if err == nil {
+		job.result <- nil
+		return
+	}
+
+	job.attempt++
+	retryAfter, permanent := classify(err)
+
+	switch {
+	case permanent:
+		job.result <- &PermanentError{Err: err}
+		return
+	case job.attempt >= q.cfg.Attempts:
+		retriesExhaustedTotal.WithLabelValues(q.name, "telegram").Inc()
+		job.result <- err
+		return
+	}
+
+	retriesTotal.WithLabelValues(q.name, "telegram").Inc()
+
+	now := time.Now()
+	q.mu.Lock()
+	if retryAfter > 0 {
+		telegramThrottledTotal.WithLabelValues(q.name).Inc()
+		q.logger.Warnw("Telegram asked to slow down", "retry_after", retryAfter)
+		if until := now.Add(retryAfter); until.After(q.pausedUntil) {
+			q.pausedUntil = until
+		}
+		job.notBefore = q.pausedUntil
+	} else {
+		job.notBefore = now.Add(q.cfg.RetryDelay << (job.attempt - 1))
+	}
+
+	if q.closed {
+		q.mu.Unlock()
+		job.result <- ErrSenderClosed
+		return
+	}
+	q.push(job)
+	q.mu.Unlock()
+
+	q.notify()
+}
+
+// classify tells whether the error is permanent or Telegram asked to retry
+// after some time
+func classify(err error) (retryAfter time.Duration, permanent bool) {
+	var tgErr *tg.Error
+	if !errors.As(err, &tgErr) {
+		// network errors, etc.
+		return 0, false
+	}
+
+	switch {
+	case tgErr.Code == http.StatusTooManyRequests:
+		retryAfter = time.Duration(tgErr.RetryAfter) * time.Second
+		if retryAfter <= 0 {
+			retryAfter = time.Second
+		}
+		return retryAfter, false
+	case tgErr.Code >= 500:
+		return 0, false
+	case tgErr.Code >= 400:
+		// blocked by the user, chat not found, bad request, etc.
+		return 0, true
+	}
+
+	return 0, false
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,118 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// fakeTransport fails sendMessage requests with errors queued for the chat
+type fakeTransport struct {
+	mu   sync.Mutex
+	errs map[int64][]error
+	sent []string // texts of sent messages
+	reqs []tg.Chattable
+}
+
+func (t *fakeTransport) Self() tg.User { return tg.User{} }
+
+func (t *fakeTransport) SendMessage(m tg.MessageConfig) (tg.Message, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if errs := t.errs[m.ChatID]; len(errs) > 0 {
+		t.errs[m.ChatID] = errs[1:]
+		return tg.Message{}, errs[0]
+	}
+
+	t.sent = append(t.sent, m.Text)
+	return tg.Message{Chat: &tg.Chat{ID: m.ChatID}}, nil
+}
+
+func (t *fakeTransport) EditMessage(tg.EditMessageTextConfig) error { return nil }
+func (t *fakeTransport) DeleteMessage(int64, int) error             { return nil }
+
+func (t *fakeTransport) Request(c tg.Chattable) (*tg.APIResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.reqs = append(t.reqs, c)
+	return &tg.APIResponse{Ok: true}, nil
+}
+
+func (t *fakeTransport) Updates(context.Context, string) (<-chan tg.Update, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestSender(t *testing.T) {
+	ft := &fakeTransport{errs: map[int64][]error{
+		1: {&tg.Error{Code: 429, Message: "Too Many Requests", ResponseParameters: tg.ResponseParameters{RetryAfter: 1}}},
+		2: {&tg.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}},
+		3: {&tg.Error{Code: 502, Message: "Bad Gateway"}},
+	}}
+	s := bot.NewSender("test", ft, bot.SenderConfig{
+		Rate:       100,
+		ChatRate:   100,
+		ChatBurst:  1,
+		Attempts:   2,
+		RetryDelay: time.Millisecond,
+	}, zap.NewNop().Sugar())
+	defer s.Close()
+
+	start := time.Now()
+	if _, err := s.SendMessage(tg.NewMessage(1, "retry after")); err != nil {
+		t.Errorf("expected the message to be sent after 429, got %v", err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("expected to wait for retry_after, waited %v", d)
+	}
+
+	_, err := s.SendMessage(tg.NewMessage(2, "blocked"))
+	if !bot.IsPermanent(err) {
+		t.Errorf("expected permanent error, got %v", err)
+	}
+
+	if _, err := s.SendMessage(tg.NewMessage(3, "transient")); err != nil {
+		t.Errorf("expected the message to be sent after a transient error, got %v", err)
+	}
+
+	if len(ft.sent) != 2 {
+		t.Errorf("expected 2 sent messages, got %v", ft.sent)
+	}
+}
+
+func TestSenderPriority(t *testing.T) {
+	ft := &fakeTransport{}
+	s := bot.NewSender("test", ft, bot.SenderConfig{
+		Rate:      100,
+		ChatRate:  10,
+		ChatBurst: 1,
+	}, zap.NewNop().Sugar())
+	defer s.Close()
+
+	// the first message uses the only token of the chat, the rest wait for it
+	s.SendMessage(tg.NewMessage(1, "first"))
+
+	var wg sync.WaitGroup
+	for _, p := range []bot.Priority{bot.PriorityLow, bot.PriorityNormal, bot.PriorityHigh} {
+		wg.Add(1)
+		go func(p bot.Priority) {
+			defer wg.Done()
+			s.WithPriority(p).SendMessage(tg.NewMessage(1, fmt.Sprint(p)))
+		}(p)
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+
+	want := []string{"first", fmt.Sprint(bot.PriorityHigh), fmt.Sprint(bot.PriorityNormal), fmt.Sprint(bot.PriorityLow)}
+	if fmt.Sprint(ft.sent) != fmt.Sprint(want) {
+		t.Errorf("expected messages %v, got %v", want, ft.sent)
+	}
+}
@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const readHeaderTimeout = 10 * time.Second
+
+// Server is the HTTP server shared by all bots of the farm.
+type Server struct {
+	logger *zap.SugaredLogger
+	mux    *http.ServeMux
+	srv    *http.Server
+}
+
+func NewServer(addr string, l *zap.SugaredLogger) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		logger: l,
+		mux:    mux,
+		srv: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: readHeaderTimeout,
+		},
+	}
+}
+
+// Handle registers the handler for the given pattern (see http.ServeMux).
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// Handler returns the handler of all registered patterns, e.g. to test them
+// without listening.
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Start starts listening and serving requests in a new goroutine. It returns an
+// error if the server can't listen on its address.
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Infof("listening on %s", ln.Addr())
+
+	go func() {
+		err := s.srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Errorw("HTTP server failed", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// Shutdown gracefully stops the server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
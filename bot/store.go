@@ -0,0 +1,148 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// SessionRecord is a conversation session as kept by a StateStore. Payload is
+// the JSON encoded session payload.
+type SessionRecord struct {
+	State   string
+	Anchor  int
+	Payload []byte
+	Updated time.Time
+}
+
+// StateStore keeps conversation sessions of bot users, so they survive
+// restarts.
+type StateStore interface {
+	// Load returns the user's session or nil if there's none
+	Load(ctx context.Context, bot string, usr int64) (*SessionRecord, error)
+	// Save creates or replaces the user's session
+	Save(ctx context.Context, bot string, usr int64, rec SessionRecord) error
+}
+
+type storeKey struct {
+	bot string
+	usr int64
+}
+
+// MemoryStore is a StateStore that keeps sessions in memory. It's meant for
+// tests.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[storeKey]SessionRecord
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[storeKey]SessionRecord)}
+}
+
+func (s *MemoryStore) Load(_ context.Context, bot string, usr int64) (*SessionRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.sessions[storeKey{bot, usr}]
+	if !ok {
+		return nil, nil
+	}
+
+	rec.Payload = append([]byte(nil), rec.Payload...)
+	return &rec, nil
+}
+
+func (s *MemoryStore) Save(_ context.Context, bot string, usr int64, rec SessionRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec.Payload = append([]byte(nil), rec.Payload...)
+	s.sessions[storeKey{bot, usr}] = rec
+	return nil
+}
+
+// PGStore is a StateStore that keeps sessions in the conversations table of a
+// Postgres database.
+type PGStore struct {
+	db *sql.DB
+}
+
+func NewPGStore(db *sql.DB) *PGStore {
+	return &PGStore{db: db}
+}
+
+func (s *PGStore) Load(ctx context.Context, bot string, usr int64) (*SessionRecord, error) {
+	var rec SessionRecord
+	row := s.db.QueryRowContext(ctx,
+		"SELECT state, anchor, payload, updated FROM conversations WHERE bot = $1 AND user_id = $2", bot, usr)
+	err := row.Scan(&rec.State, &rec.Anchor, &rec.Payload, &rec.Updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &rec, nil
+}
+
+func (s *PGStore) Save(ctx context.Context, bot string, usr int64, rec SessionRecord) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO conversations(bot, user_id, state, anchor, payload, updated) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (bot, user_id) DO UPDATE
+		SET state = EXCLUDED.state, anchor = EXCLUDED.anchor, payload = EXCLUDED.payload, updated = EXCLUDED.updated`,
+		bot, usr, rec.State, rec.Anchor, rec.Payload, rec.Updated)
+	return err
+}
+
+// UserLocale is the locale of a bot user. Explicit locales are chosen by the
+// user with CmdLanguage, others match the language of the user's Telegram
+// client.
+type UserLocale struct {
+	Locale   string
+	Explicit bool
+}
+
+// LocaleStore keeps locales of bot users
+type LocaleStore interface {
+	// LoadLocale returns the user's locale or nil if there's none
+	LoadLocale(ctx context.Context, bot string, usr int64) (*UserLocale, error)
+	// SaveLocale creates or replaces the user's locale
+	SaveLocale(ctx context.Context, bot string, usr int64, ul UserLocale) error
+}
+
+// PGLocaleStore is a LocaleStore that keeps locales in the user_locales table
+// of a Postgres database.
+type PGLocaleStore struct {
+	db *sql.DB
+}
+
+func NewPGLocaleStore(db *sql.DB) *PGLocaleStore {
+	return &PGLocaleStore{db: db}
+}
+
+func (s *PGLocaleStore) LoadLocale(ctx context.Context, bot string, usr int64) (*UserLocale, error) {
+	var ul UserLocale
+	row := s.db.QueryRowContext(ctx,
+		"SELECT locale, explicit FROM user_locales WHERE bot = $1 AND user_id = $2", bot, usr)
+	err := row.Scan(&ul.Locale, &ul.Explicit)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ul, nil
+}
+
+func (s *PGLocaleStore) SaveLocale(ctx context.Context, bot string, usr int64, ul UserLocale) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_locales(bot, user_id, locale, explicit) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (bot, user_id) DO UPDATE SET locale = EXCLUDED.locale, explicit = EXCLUDED.explicit`,
+		bot, usr, ul.Locale, ul.Explicit)
+	return err
+}
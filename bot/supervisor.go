@@ -0,0 +1,486 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	minRestartDelay = 1 * time.Second
+	maxRestartDelay = 5 * time.Minute
+	// lateStopTimeout limits the time given to a bot to stop once its Run
+	// returned after the stop deadline
+	lateStopTimeout = 30 * time.Second
+)
+
+// State is the state of a supervised bot
+type State int
+
+const (
+	StateStopped State = iota
+	StateRunning
+	StateFailed
+	StateRestarting
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStopped:
+		return "stopped"
+	case StateRunning:
+		return "running"
+	case StateFailed:
+		return "failed"
+	case StateRestarting:
+		return "restarting"
+	}
+	return "unknown"
+}
+
+// Status is a snapshot of the supervised bot state. A bot that failed to
+// initialize stays in StateFailed, a bot that failed to run is in
+// StateRestarting until it's started again.
+type Status struct {
+	Name      string
+	State     State
+	Restarts  int
+	LastError error
+	Since     time.Time // time of the last state change
+}
+
+type supervised struct {
+	bot         Bot
+	logger      *zap.SugaredLogger
+	status      Status
+	initialized bool // Init succeeded, so Stop must be called
+	disabled    bool // stopped by StopBot
+
+	cancel context.CancelFunc // stops the run loop, nil if it isn't running
+	done   chan struct{}      // closed when the run loop exits
+
+	op sync.Mutex // serializes starting and stopping the bot
+}
+
+// Errors returned by bot control methods of Supervisor
+var (
+	ErrUnknownBot        = errors.New("unknown bot")
+	ErrBotRunning        = errors.New("bot is already running")
+	ErrFarmNotRunning    = errors.New("farm isn't running")
+	ErrBotNotInitialized = errors.New("bot isn't initialized")
+	ErrStopTimeout       = errors.New("bot didn't stop in time; it's stopped once it finishes running")
+)
+
+// Supervisor runs bots, recovers them from panics and restarts failed bots with
+// exponential backoff. Bots can be stopped and started individually while the
+// farm runs.
+type Supervisor struct {
+	logger *zap.SugaredLogger
+
+	mu   sync.Mutex
+	ctx  context.Context // context passed to Run
+	bots []*supervised
+	wg   sync.WaitGroup
+}
+
+func NewSupervisor(l *zap.SugaredLogger) *Supervisor {
+	return &Supervisor{logger: l}
+}
+
+// Add adds an initialized bot to the supervisor. l is the logger in the bot
+// namespace. Add should be called before Run.
+func (s *Supervisor) Add(name string, b Bot, l *zap.SugaredLogger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bots = append(s.bots, &supervised{
+		bot:         b,
+		logger:      l,
+		status:      Status{Name: name, State: StateStopped, Since: time.Now()},
+		initialized: true,
+	})
+}
+
+// AddFailed adds a bot that failed to initialize, so its status is reported
+// along with other bots. The bot isn't run until it's started with StartBot.
+func (s *Supervisor) AddFailed(name string, b Bot, err error, l *zap.SugaredLogger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bots = append(s.bots, &supervised{
+		bot:    b,
+		logger: l,
+		status: Status{Name: name, State: StateFailed, LastError: err, Since: time.Now()},
+	})
+}
+
+// Run runs all added bots and blocks until ctx is done. It doesn't wait for
+// the bots to stop receiving updates: Stop does that within its deadline.
+func (s *Supervisor) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	for _, sb := range s.bots {
+		if sb.initialized {
+			s.start(sb)
+		}
+	}
+	s.mu.Unlock()
+
+	<-ctx.Done()
+}
+
+// Stop waits for bots to stop receiving updates and then stops all of them
+// concurrently. ctx limits the time given to bots to finish.
+func (s *Supervisor) Stop(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn("not all bots stopped receiving updates in time")
+	}
+
+	s.mu.Lock()
+	bots := append([]*supervised{}, s.bots...)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, sb := range bots {
+		wg.Add(1)
+		go func(sb *supervised) {
+			defer wg.Done()
+
+			sb.op.Lock()
+			defer sb.op.Unlock()
+
+			s.stop(ctx, sb)
+		}(sb)
+	}
+	wg.Wait()
+}
+
+// Status returns the state of all supervised bots.
+func (s *Supervisor) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]Status, 0, len(s.bots))
+	for _, sb := range s.bots {
+		statuses = append(statuses, sb.status)
+	}
+
+	return statuses
+}
+
+// StopBot stops the named bot and releases its resources. The bot can be
+// started again with StartBot or ReinitBot. If the bot doesn't stop receiving
+// updates until ctx is done, ErrStopTimeout is returned, and the bot is
+// stopped once it does.
+func (s *Supervisor) StopBot(ctx context.Context, name string) error {
+	sb := s.find(name)
+	if sb == nil {
+		return ErrUnknownBot
+	}
+
+	sb.op.Lock()
+	defer sb.op.Unlock()
+
+	err := s.stop(ctx, sb)
+
+	s.mu.Lock()
+	sb.disabled = true
+	s.mu.Unlock()
+
+	return err
+}
+
+// StartBot starts the stopped named bot. A bot that isn't initialized (it
+// failed to initialize or was stopped) is initialized with init first. l
+// passed to init is the bot logger.
+func (s *Supervisor) StartBot(name string, init func(l *zap.SugaredLogger) error) error {
+	sb := s.find(name)
+	if sb == nil {
+		return ErrUnknownBot
+	}
+
+	sb.op.Lock()
+	defer sb.op.Unlock()
+
+	return s.initAndStart(sb, init)
+}
+
+// ReinitBot stops the named bot if it's running, initializes it with init and
+// starts it again.
+func (s *Supervisor) ReinitBot(ctx context.Context, name string, init func(l *zap.SugaredLogger) error) error {
+	sb := s.find(name)
+	if sb == nil {
+		return ErrUnknownBot
+	}
+
+	sb.op.Lock()
+	defer sb.op.Unlock()
+
+	if err := s.stop(ctx, sb); err != nil {
+		return err
+	}
+	return s.initAndStart(sb, init)
+}
+
+// Bot returns the supervised bot with the name or nil if there's no such bot
+func (s *Supervisor) Bot(name string) Bot {
+	if sb := s.find(name); sb != nil {
+		return sb.bot
+	}
+	return nil
+}
+
+// WithBot calls f with the named bot while it isn't being started or stopped,
+// so f may use resources the bot creates in Init and releases in Stop. f isn't
+// called and ErrBotNotInitialized is returned if the bot isn't initialized.
+func (s *Supervisor) WithBot(name string, f func(b Bot) error) error {
+	sb := s.find(name)
+	if sb == nil {
+		return ErrUnknownBot
+	}
+
+	sb.op.Lock()
+	defer sb.op.Unlock()
+
+	s.mu.Lock()
+	initialized := sb.initialized
+	s.mu.Unlock()
+
+	if !initialized {
+		return ErrBotNotInitialized
+	}
+	return f(sb.bot)
+}
+
+func (s *Supervisor) find(name string) *supervised {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sb := range s.bots {
+		if sb.status.Name == name {
+			return sb
+		}
+	}
+	return nil
+}
+
+// initAndStart initializes the bot if needed and starts it. sb.op must be held.
+func (s *Supervisor) initAndStart(sb *supervised, init func(l *zap.SugaredLogger) error) error {
+	s.mu.Lock()
+	running, farmCtx, initialized := sb.cancel != nil || !finished(sb.done), s.ctx, sb.initialized
+	s.mu.Unlock()
+
+	if running {
+		return ErrBotRunning
+	}
+	if farmCtx == nil || farmCtx.Err() != nil {
+		return ErrFarmNotRunning
+	}
+
+	if !initialized {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					sb.logger.Errorw("bot panicked on init", "panic", r, "stack", string(debug.Stack()))
+					err = fmt.Errorf("bot panicked on init: %v", r)
+				}
+			}()
+			return init(sb.logger)
+		}()
+		if err != nil {
+			s.setState(sb, StateFailed, err)
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sb.initialized = true
+	sb.disabled = false
+	s.start(sb)
+	return nil
+}
+
+// start starts the run loop of the bot. s.mu must be held.
+func (s *Supervisor) start(sb *supervised) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	done := make(chan struct{})
+	sb.cancel = cancel
+	sb.done = done
+
+	s.wg.Add(1)
+	go func() {
+		defer close(done)
+		s.supervise(ctx, sb)
+	}()
+}
+
+// stop stops the run loop of the bot if it's running and then stops the bot
+// if it's initialized. If Run doesn't return until ctx is done, the bot isn't
+// stopped yet, as Run may still use its resources: ErrStopTimeout is returned,
+// and the bot stays initialized until Run returns and it's stopped. It can't be
+// started until then. sb.op must be held.
+func (s *Supervisor) stop(ctx context.Context, sb *supervised) error {
+	s.mu.Lock()
+	cancel, done := sb.cancel, sb.done
+	sb.cancel = nil
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			sb.logger.Warn("bot didn't stop receiving updates in time; it's stopped once it does")
+			go s.stopWhenDone(sb, done)
+			return ErrStopTimeout
+		}
+	}
+
+	s.release(ctx, sb)
+	return nil
+}
+
+// stopWhenDone stops the bot once the run loop with the done channel exits
+// unless the bot is started again by then
+func (s *Supervisor) stopWhenDone(sb *supervised, done chan struct{}) {
+	<-done
+
+	sb.op.Lock()
+	defer sb.op.Unlock()
+
+	s.mu.Lock()
+	restarted := sb.done != done
+	s.mu.Unlock()
+
+	if restarted {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lateStopTimeout)
+	defer cancel()
+
+	s.release(ctx, sb)
+}
+
+// release stops the bot if it's initialized. Its run loop must not be
+// running. sb.op must be held.
+func (s *Supervisor) release(ctx context.Context, sb *supervised) {
+	s.mu.Lock()
+	initialized := sb.initialized
+	sb.initialized = false
+	s.mu.Unlock()
+
+	if !initialized {
+		return
+	}
+
+	func() {
+		defer Recover(sb.logger, "stop")
+
+		if err := sb.bot.Stop(ctx); err != nil {
+			sb.logger.Errorw("failed stopping bot", "err", err)
+		}
+	}()
+	s.setState(sb, StateStopped, nil)
+}
+
+// finished reports whether the run loop with the done channel has exited
+func finished(done chan struct{}) bool {
+	if done == nil {
+		return true
+	}
+
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Supervisor) setState(sb *supervised, st State, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sb.status.State == StateRestarting && st == StateRunning {
+		sb.status.Restarts++
+	}
+
+	sb.status.State = st
+	sb.status.Since = time.Now()
+	if err != nil {
+		sb.status.LastError = err
+	}
+}
+
+// supervise runs the bot until ctx is done restarting it on failures
+func (s *Supervisor) supervise(ctx context.Context, sb *supervised) {
+	defer s.wg.Done()
+
+	delay := minRestartDelay
+	for {
+		s.setState(sb, StateRunning, nil)
+		started := time.Now()
+		err := runBot(ctx, sb)
+
+		if ctx.Err() != nil {
+			s.setState(sb, StateStopped, err)
+			return
+		}
+
+		if err == nil {
+			err = errors.New("bot stopped unexpectedly")
+		}
+		s.setState(sb, StateRestarting, err)
+
+		// the bot worked long enough to consider the failure a new one
+		if time.Since(started) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+
+		sb.logger.Errorw(fmt.Sprintf("bot failed; restarting in %v", delay), "err", err)
+
+		select {
+		case <-ctx.Done():
+			s.setState(sb, StateStopped, nil)
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// runBot runs the bot turning a panic into an error. Everything the bot starts
+// with ctx passed to Run is stopped when Run returns.
+func runBot(ctx context.Context, sb *supervised) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			sb.logger.Errorw("bot panicked", "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("bot panicked: %v", r)
+		}
+	}()
+
+	return sb.bot.Run(ctx)
+}
@@ -0,0 +1,188 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// flakyBot fails its first run by returning an error or panicking, then runs
+// until ctx is done
+type flakyBot struct {
+	fakeBot
+	panics bool
+}
+
+func (b *flakyBot) Run(ctx context.Context) error {
+	if atomic.AddInt32(&b.runs, 1) == 1 {
+		if b.panics {
+			panic("boom")
+		}
+		return errors.New("failed")
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// stuckBot ignores ctx and runs until released
+type stuckBot struct {
+	fakeBot
+	release chan struct{}
+}
+
+func (b *stuckBot) Run(context.Context) error {
+	atomic.AddInt32(&b.runs, 1)
+	<-b.release
+	return nil
+}
+
+// waitStatus waits until the status of the only supervised bot satisfies ok
+func waitStatus(t *testing.T, s *bot.Supervisor, timeout time.Duration, ok func(bot.Status) bool) bot.Status {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		st := s.Status()[0]
+		if ok(st) {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected status %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	for _, b := range []*flakyBot{{}, {panics: true}} {
+		l := zap.NewNop().Sugar()
+		s := bot.NewSupervisor(l)
+		s.Add(b.Name(), b, l)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			s.Run(ctx)
+			close(done)
+		}()
+
+		st := waitStatus(t, s, time.Second, func(st bot.Status) bool { return st.State == bot.StateRestarting })
+		if st.LastError == nil {
+			t.Errorf("expected the failure to be reported (panics %v)", b.panics)
+		}
+
+		// the first restart is delayed by a second
+		st = waitStatus(t, s, 3*time.Second, func(st bot.Status) bool { return st.State == bot.StateRunning })
+		if st.Restarts != 1 || atomic.LoadInt32(&b.runs) != 2 {
+			t.Errorf("expected the bot to be restarted once (panics %v), got %d restarts and %d runs",
+				b.panics, st.Restarts, b.runs)
+		}
+
+		cancel()
+		<-done
+		s.Stop(context.Background())
+		if b.stops != 1 {
+			t.Errorf("expected the bot to be stopped once (panics %v), got %d", b.panics, b.stops)
+		}
+	}
+}
+
+func TestSupervisorControl(t *testing.T) {
+	l := zap.NewNop().Sugar()
+	b := &fakeBot{}
+	s := bot.NewSupervisor(l)
+	s.Add(b.Name(), b, l)
+
+	if err := s.StartBot(b.Name(), b.Init); !errors.Is(err, bot.ErrFarmNotRunning) {
+		t.Errorf("expected ErrFarmNotRunning before Run, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	waitStatus(t, s, time.Second, func(st bot.Status) bool { return st.State == bot.StateRunning })
+
+	if err := s.StopBot(context.Background(), b.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Status()[0]; st.State != bot.StateStopped || b.stops != 1 {
+		t.Errorf("expected the bot to be stopped, got %v and %d stops", st.State, b.stops)
+	}
+
+	if err := s.StartBot(b.Name(), b.Init); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, s, time.Second, func(st bot.Status) bool { return st.State == bot.StateRunning })
+	if err := s.StartBot(b.Name(), b.Init); !errors.Is(err, bot.ErrBotRunning) {
+		t.Errorf("expected ErrBotRunning, got %v", err)
+	}
+
+	if err := s.ReinitBot(context.Background(), b.Name(), b.Init); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, s, time.Second, func(st bot.Status) bool { return st.State == bot.StateRunning })
+	if b.inits != 2 || b.stops != 2 || atomic.LoadInt32(&b.runs) != 3 {
+		t.Errorf("unexpected inits %d, stops %d, runs %d", b.inits, b.stops, b.runs)
+	}
+
+	if err := s.StopBot(context.Background(), "nobot"); !errors.Is(err, bot.ErrUnknownBot) {
+		t.Errorf("expected ErrUnknownBot, got %v", err)
+	}
+}
+
+func TestSupervisorStuckBot(t *testing.T) {
+	l := zap.NewNop().Sugar()
+	b := &stuckBot{release: make(chan struct{})}
+	s := bot.NewSupervisor(l)
+	s.Add(b.Name(), b, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	waitStatus(t, s, time.Second, func(st bot.Status) bool { return st.State == bot.StateRunning })
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer stopCancel()
+	if err := s.StopBot(stopCtx, b.Name()); !errors.Is(err, bot.ErrStopTimeout) {
+		t.Fatalf("expected ErrStopTimeout, got %v", err)
+	}
+	if atomic.LoadInt32(&b.stops) != 0 {
+		t.Error("expected the bot not to be stopped while it runs")
+	}
+	if err := s.StartBot(b.Name(), b.Init); !errors.Is(err, bot.ErrBotRunning) {
+		t.Errorf("expected ErrBotRunning while the bot runs, got %v", err)
+	}
+
+	// the bot is stopped once its Run returns
+	close(b.release)
+	waitStatus(t, s, time.Second, func(st bot.Status) bool { return st.State == bot.StateStopped })
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&b.stops) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the bot to be stopped after its Run returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// so it's initialized again; its Run returns at once now, so it's
+	// restarted
+	if err := s.StartBot(b.Name(), b.Init); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, s, time.Second, func(st bot.Status) bool { return st.State == bot.StateRestarting })
+	if atomic.LoadInt32(&b.inits) != 1 || atomic.LoadInt32(&b.runs) != 2 {
+		t.Errorf("expected the bot to be initialized and run again, got %d inits and %d runs", b.inits, b.runs)
+	}
+
+	if err := s.StopBot(context.Background(), b.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&b.stops) != 2 {
+		t.Errorf("expected the bot to be stopped twice, got %d", b.stops)
+	}
+}
@@ -0,0 +1,322 @@
+// Package tgtest provides a fake Telegram Bot API server for tests. The server
+// records requests made by bots and lets tests inject updates and errors.
+package tgtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Token is the bot token accepted by the fake server
+const Token = "123456:test-token"
+
+// maxPollWait limits long polling, so tests don't wait for the whole timeout
+// requested by a bot
+const maxPollWait = 500 * time.Millisecond
+
+// BotUser is the bot user returned by getMe
+var BotUser = tg.User{ID: 123456, IsBot: true, FirstName: "Test", UserName: "test_bot"}
+
+// Request is a recorded request to the Bot API
+type Request struct {
+	Method string
+	Params url.Values
+}
+
+// failure is an error returned instead of the result of a request
+type failure struct {
+	code        int
+	description string
+	retryAfter  int // seconds, for 429 Too Many Requests
+}
+
+// Server is a fake Telegram Bot API server
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []Request
+	updates  []tg.Update
+	failures map[string][]failure // by method
+	notify   chan struct{}        // closed and replaced on every change
+	nextMsg  int
+	nextUpd  int
+}
+
+// NewServer starts a fake Telegram Bot API server. Call Close when it's no
+// longer needed.
+func NewServer() *Server {
+	s := &Server{
+		failures: make(map[string][]failure),
+		notify:   make(chan struct{}),
+		nextMsg:  1,
+		nextUpd:  1,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Endpoint returns the API endpoint to pass to tg.NewBotAPIWithAPIEndpoint or
+// bot.NewTransport.
+func (s *Server) Endpoint() string {
+	return s.URL + "/bot%s/%s"
+}
+
+// AddUpdate queues an update for the bot. UpdateID is assigned automatically.
+func (s *Server) AddUpdate(u tg.Update) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u.UpdateID = s.nextUpd
+	s.nextUpd++
+	s.updates = append(s.updates, u)
+	s.changed()
+}
+
+// SendText queues a text message from the user. Commands are marked with
+// bot_command entity, so tg.Message.IsCommand works.
+func (s *Server) SendText(usr int64, text string) {
+	s.mu.Lock()
+	msg := &tg.Message{
+		MessageID: s.nextMsg,
+		From:      &tg.User{ID: usr, FirstName: "User"},
+		Chat:      &tg.Chat{ID: usr, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+	s.nextMsg++
+	s.mu.Unlock()
+
+	if strings.HasPrefix(text, "/") {
+		n := strings.IndexByte(text, ' ')
+		if n < 0 {
+			n = len(text)
+		}
+		msg.Entities = []tg.MessageEntity{{Type: "bot_command", Offset: 0, Length: n}}
+	}
+
+	s.AddUpdate(tg.Update{Message: msg})
+}
+
+// PressButton queues a callback query as if the user pressed an inline button
+// with data under the message msgID.
+func (s *Server) PressButton(usr int64, msgID int, data string) {
+	s.AddUpdate(tg.Update{CallbackQuery: &tg.CallbackQuery{
+		ID:   strconv.Itoa(msgID) + ":" + data,
+		From: &tg.User{ID: usr, FirstName: "User"},
+		Message: &tg.Message{
+			MessageID: msgID,
+			From:      &BotUser,
+			Chat:      &tg.Chat{ID: usr, Type: "private"},
+		},
+		Data: data,
+	}})
+}
+
+// Fail makes the next n requests of the method fail with the error code and
+// the description, e.g. 403 and "Forbidden: bot was blocked by the user".
+// Failed requests are recorded too.
+func (s *Server) Fail(method string, n, code int, description string) {
+	s.fail(method, n, failure{code: code, description: description})
+}
+
+// Throttle makes the next n requests of the method fail with 429 Too Many
+// Requests asking to retry after the number of seconds
+func (s *Server) Throttle(method string, n, retryAfter int) {
+	s.fail(method, n, failure{
+		code:        http.StatusTooManyRequests,
+		description: "Too Many Requests: retry after " + strconv.Itoa(retryAfter),
+		retryAfter:  retryAfter,
+	})
+}
+
+func (s *Server) fail(method string, n int, f failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.failures[method] = append(s.failures[method], f)
+	}
+}
+
+// Requests returns recorded requests of the given method. All requests are
+// returned if method is empty.
+func (s *Server) Requests(method string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reqs []Request
+	for _, r := range s.requests {
+		if method == "" || r.Method == method {
+			reqs = append(reqs, r)
+		}
+	}
+	return reqs
+}
+
+// WaitRequests waits until at least n requests of the given method are
+// recorded and returns them. It returns false on timeout.
+func (s *Server) WaitRequests(method string, n int, timeout time.Duration) ([]Request, bool) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		notify := s.notify
+		s.mu.Unlock()
+
+		if reqs := s.Requests(method); len(reqs) >= n {
+			return reqs, true
+		}
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return s.Requests(method), false
+		}
+	}
+}
+
+// Reset forgets recorded requests and pending failures
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = nil
+	s.failures = make(map[string][]failure)
+}
+
+// changed wakes up waiters; s.mu must be held
+func (s *Server) changed() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	// path is /bot<token>/<method>
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "bot"+Token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	method := parts[1]
+	if method == "getUpdates" {
+		s.getUpdates(w, r.PostForm)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: method, Params: r.PostForm})
+	s.changed()
+
+	if failures := s.failures[method]; len(failures) > 0 {
+		s.failures[method] = failures[1:]
+		s.mu.Unlock()
+
+		f := failures[0]
+		writeFailure(w, f.code, f.description, &tg.ResponseParameters{RetryAfter: f.retryAfter})
+		return
+	}
+
+	var result any = true
+	switch method {
+	case "getMe":
+		result = BotUser
+
+	case "sendMessage":
+		result = s.message(r.PostForm)
+
+	case "editMessageText":
+		m := s.message(r.PostForm)
+		m.MessageID, _ = strconv.Atoi(r.PostForm.Get("message_id"))
+		s.nextMsg--
+		result = m
+	}
+	s.mu.Unlock()
+
+	writeResult(w, result)
+}
+
+// message builds the message sent by the bot; s.mu must be held
+func (s *Server) message(p url.Values) tg.Message {
+	cht, _ := strconv.ParseInt(p.Get("chat_id"), 10, 64)
+	m := tg.Message{
+		MessageID: s.nextMsg,
+		From:      &BotUser,
+		Chat:      &tg.Chat{ID: cht, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      p.Get("text"),
+	}
+	s.nextMsg++
+	return m
+}
+
+// getUpdates returns queued updates starting from the offset. Like Telegram,
+// it confirms and forgets updates before the offset.
+func (s *Server) getUpdates(w http.ResponseWriter, p url.Values) {
+	offset, _ := strconv.Atoi(p.Get("offset"))
+	timeout, _ := strconv.Atoi(p.Get("timeout"))
+	wait := time.Duration(timeout) * time.Second
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+	deadline := time.After(wait)
+
+	for {
+		s.mu.Lock()
+		pending := []tg.Update{}
+		for _, u := range s.updates {
+			if u.UpdateID >= offset {
+				pending = append(pending, u)
+			}
+		}
+		s.updates = pending
+		notify := s.notify
+		s.mu.Unlock()
+
+		if len(pending) > 0 {
+			writeResult(w, pending)
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-deadline:
+			writeResult(w, []tg.Update{})
+			return
+		}
+	}
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tg.APIResponse{Ok: true, Result: raw})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	writeFailure(w, code, description, nil)
+}
+
+func writeFailure(w http.ResponseWriter, code int, description string, params *tg.ResponseParameters) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(tg.APIResponse{Ok: false, ErrorCode: code, Description: description, Parameters: params})
+}
@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Transport is the way bots talk to Telegram. It makes possible to run bots
+// against a fake Telegram Bot API server in tests (see package tgtest).
+type Transport interface {
+	// Self returns the bot user
+	Self() tg.User
+	// SendMessage sends a new message
+	SendMessage(m tg.MessageConfig) (tg.Message, error)
+	// EditMessage replaces text and/or inline keyboard of a message
+	EditMessage(e tg.EditMessageTextConfig) error
+	// DeleteMessage deletes a message
+	DeleteMessage(cht int64, msgID int) error
+	// Request makes any other request to Telegram Bot API
+	Request(c tg.Chattable) (*tg.APIResponse, error)
+	// Updates starts receiving updates for the named bot (see Receiver)
+	Updates(ctx context.Context, name string) (<-chan tg.Update, error)
+}
+
+// APITransport is Transport based on tg.BotAPI. Failed requests are counted
+// in metrics of the bot.
+type APITransport struct {
+	API *tg.BotAPI
+	Bot string
+}
+
+// NewTransport connects the named bot to Telegram Bot API. If endpoint is
+// empty, the official Telegram Bot API server is used, otherwise endpoint is
+// the format string like tg.APIEndpoint.
+func NewTransport(name, token, endpoint string) (*APITransport, error) {
+	if endpoint == "" {
+		endpoint = tg.APIEndpoint
+	}
+
+	api, err := tg.NewBotAPIWithAPIEndpoint(token, endpoint)
+	if err != nil {
+		telegramErrorsTotal.WithLabelValues(name, "getMe").Inc()
+		return nil, err
+	}
+
+	api.Debug = false
+
+	return &APITransport{API: api, Bot: name}, nil
+}
+
+func (t *APITransport) Self() tg.User {
+	return t.API.Self
+}
+
+func (t *APITransport) SendMessage(m tg.MessageConfig) (tg.Message, error) {
+	msg, err := t.API.Send(m)
+	t.count("sendMessage", err)
+	return msg, err
+}
+
+func (t *APITransport) EditMessage(e tg.EditMessageTextConfig) error {
+	_, err := t.API.Request(e)
+	t.count("editMessageText", err)
+	return err
+}
+
+func (t *APITransport) DeleteMessage(cht int64, msgID int) error {
+	// Request is used instead of Send because deleteMessage returns true
+	// rather than a message
+	_, err := t.API.Request(tg.NewDeleteMessage(cht, msgID))
+	t.count("deleteMessage", err)
+	return err
+}
+
+func (t *APITransport) Request(c tg.Chattable) (*tg.APIResponse, error) {
+	resp, err := t.API.Request(c)
+	t.count(requestName(c), err)
+	return resp, err
+}
+
+func (t *APITransport) Updates(ctx context.Context, name string) (<-chan tg.Update, error) {
+	return ReceiveUpdates(ctx, name, t.API)
+}
+
+// count counts the failed request
+func (t *APITransport) count(method string, err error) {
+	if err != nil {
+		telegramErrorsTotal.WithLabelValues(t.Bot, method).Inc()
+	}
+}
+
+// requestName returns the config type name, e.g. "CallbackConfig", since
+// Chattable doesn't expose the API method
+func requestName(c tg.Chattable) string {
+	name := fmt.Sprintf("%T", c)
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
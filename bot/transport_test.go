@@ -0,0 +1,100 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"botfarm/bot/tgtest"
+	"context"
+	"testing"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestTransport(t *testing.T) {
+	srv := tgtest.NewServer()
+	defer srv.Close()
+
+	tr, err := bot.NewTransport("test", tgtest.Token, srv.Endpoint())
+	if err != nil {
+		t.Fatalf("failed creating transport: %v", err)
+	}
+
+	if tr.Self().UserName != tgtest.BotUser.UserName {
+		t.Errorf("expected bot %q, got %q", tgtest.BotUser.UserName, tr.Self().UserName)
+	}
+
+	msg, err := tr.SendMessage(tg.NewMessage(42, "hello"))
+	if err != nil {
+		t.Fatalf("failed sending message: %v", err)
+	}
+
+	if err = tr.EditMessage(tg.NewEditMessageText(42, msg.MessageID, "bye")); err != nil {
+		t.Fatalf("failed editing message: %v", err)
+	}
+
+	if err = tr.DeleteMessage(42, msg.MessageID); err != nil {
+		t.Fatalf("failed deleting message: %v", err)
+	}
+
+	for method, text := range map[string]string{"sendMessage": "hello", "editMessageText": "bye"} {
+		reqs := srv.Requests(method)
+		if len(reqs) != 1 || reqs[0].Params.Get("text") != text {
+			t.Errorf("expected one %s request with text %q, got %v", method, text, reqs)
+		}
+	}
+
+	if len(srv.Requests("deleteMessage")) != 1 {
+		t.Errorf("expected one deleteMessage request")
+	}
+}
+
+func TestTransportUpdates(t *testing.T) {
+	srv := tgtest.NewServer()
+	defer srv.Close()
+
+	tr, err := bot.NewTransport("test", tgtest.Token, srv.Endpoint())
+	if err != nil {
+		t.Fatalf("failed creating transport: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := tr.Updates(ctx, "test")
+	if err != nil {
+		t.Fatalf("failed receiving updates: %v", err)
+	}
+
+	srv.SendText(42, "/add milk")
+	srv.PressButton(42, 7, "data")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case u := <-updates:
+			switch {
+			case u.Message != nil:
+				if !u.Message.IsCommand() || u.Message.Command() != "add" || u.Message.CommandArguments() != "milk" {
+					t.Errorf("unexpected message %+v", u.Message)
+				}
+			case u.CallbackQuery != nil:
+				if u.CallbackQuery.Data != "data" {
+					t.Errorf("unexpected callback data %q", u.CallbackQuery.Data)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for update")
+		}
+	}
+
+	// the pending long poll doesn't delay the exit
+	cancel()
+	stopped := time.After(250 * time.Millisecond)
+	for {
+		select {
+		case _, ok := <-updates:
+			if !ok {
+				return
+			}
+		case <-stopped:
+			t.Fatal("updates weren't stopped until the long poll finished")
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// pollTimeout is the long polling timeout in seconds
+	pollTimeout = 60
+	// pollRetryDelay is the delay before the next attempt to get updates after
+	// a failure
+	pollRetryDelay = 3 * time.Second
+)
+
+// Update modes
+const (
+	ModePolling = "polling"
+	ModeWebhook = "webhook"
+)
+
+// Receiver delivers updates from Telegram to bots.
+type Receiver interface {
+	// Updates starts receiving updates for the named bot. The returned channel
+	// is closed when ctx is done and the receiver has stopped.
+	Updates(ctx context.Context, name string, api *tg.BotAPI) (<-chan tg.Update, error)
+}
+
+var (
+	receiver   Receiver = Poller{}
+	receiverMu sync.Mutex
+)
+
+// SetReceiver sets the receiver used by all bots of the farm. Polling is used
+// by default.
+func SetReceiver(r Receiver) {
+	receiverMu.Lock()
+	defer receiverMu.Unlock()
+
+	receiver = r
+}
+
+// ReceiveUpdates starts receiving updates for the named bot with the receiver
+// configured for the farm.
+func ReceiveUpdates(ctx context.Context, name string, api *tg.BotAPI) (<-chan tg.Update, error) {
+	receiverMu.Lock()
+	r := receiver
+	receiverMu.Unlock()
+
+	return r.Updates(ctx, name, api)
+}
+
+// Poller receives updates with long polling. It's handy for local development
+// because it doesn't require a public URL.
+type Poller struct{}
+
+// Updates removes the webhook, if any, because Telegram doesn't allow getting
+// updates while it's set, and starts polling.
+func (Poller) Updates(ctx context.Context, name string, api *tg.BotAPI) (<-chan tg.Update, error) {
+	if _, err := api.Request(tg.DeleteWebhookConfig{}); err != nil {
+		return nil, err
+	}
+
+	return PollUpdates(ctx, api), nil
+}
+
+// PollUpdates long-polls Telegram for updates until ctx is done. Unlike
+// tg.BotAPI.GetUpdatesChan it can be started again after it's stopped. The
+// returned channel is closed when ctx is done.
+//
+// Updates passed to the channel are confirmed to Telegram on exit. An update
+// fetched after ctx is done is dropped without being confirmed, so Telegram
+// delivers it again on the next start. A pending long poll doesn't delay the
+// exit: it's abandoned when ctx is done.
+func PollUpdates(ctx context.Context, api *tg.BotAPI) <-chan tg.Update {
+	ch := make(chan tg.Update, api.Buffer)
+
+	go func() {
+		defer close(ch)
+
+		cfg := tg.NewUpdate(0)
+		cfg.Timeout = pollTimeout
+		defer confirmUpdates(api, &cfg)
+
+		for ctx.Err() == nil {
+			updates, err := getUpdates(ctx, api, cfg)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				select {
+				case <-ctx.Done():
+				case <-time.After(pollRetryDelay):
+				}
+				continue
+			}
+
+			for _, u := range updates {
+				if u.UpdateID < cfg.Offset {
+					continue
+				}
+
+				select {
+				case ch <- u:
+					cfg.Offset = u.UpdateID + 1
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// getUpdates gets updates with cfg, returning ctx.Err() as soon as ctx is
+// done. tg.BotAPI doesn't take a context, so the abandoned request finishes in
+// the background and its updates are dropped.
+func getUpdates(ctx context.Context, api *tg.BotAPI, cfg tg.UpdateConfig) ([]tg.Update, error) {
+	type result struct {
+		updates []tg.Update
+		err     error
+	}
+
+	res := make(chan result, 1)
+	go func() {
+		updates, err := api.GetUpdates(cfg)
+		res <- result{updates, err}
+	}()
+
+	select {
+	case r := <-res:
+		return r.updates, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// confirmUpdates lets Telegram know that all updates before cfg.Offset were
+// received
+func confirmUpdates(api *tg.BotAPI, cfg *tg.UpdateConfig) {
+	if cfg.Offset == 0 {
+		return
+	}
+
+	cfg.Timeout = 0
+	cfg.Limit = 1
+	api.GetUpdates(*cfg)
+}
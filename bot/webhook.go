@@ -0,0 +1,224 @@
+package bot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	webhookPrefix     = "/webhook/"
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// maxUpdateSize limits the size of update requests
+	maxUpdateSize = 1 << 20
+)
+
+// WebhookReceiver receives updates of all bots via webhooks served by the farm
+// HTTP server. Each bot gets its own secret path, and Telegram is asked to send
+// a secret token with every request. Paths and tokens are derived from the
+// farm secret, so replicas of the farm behind one URL register the same
+// webhook.
+type WebhookReceiver struct {
+	logger       *zap.SugaredLogger
+	baseURL      string
+	secret       string
+	deleteOnStop bool
+
+	mu        sync.Mutex
+	endpoints map[string]*endpoint // by path
+}
+
+type endpoint struct {
+	name  string
+	token string
+	ch    chan tg.Update
+	done  chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+// NewWebhookReceiver creates a receiver and mounts it on srv. baseURL is the
+// public URL the farm server is reachable at. Bot paths and tokens are derived
+// from secret.
+func NewWebhookReceiver(srv *Server, baseURL, secret string, l *zap.SugaredLogger) (*WebhookReceiver, error) {
+	if !strings.HasPrefix(baseURL, "https://") {
+		return nil, errors.New("webhook URL must start with https://")
+	}
+	if secret == "" {
+		return nil, errors.New("webhook secret is required")
+	}
+
+	r := &WebhookReceiver{
+		logger:    l,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		secret:    secret,
+		endpoints: make(map[string]*endpoint),
+	}
+	srv.Handle(webhookPrefix, r)
+
+	return r, nil
+}
+
+// SetDeleteOnStop makes bots delete their webhooks when they stop receiving
+// updates. It must be set before Updates is called.
+func (r *WebhookReceiver) SetDeleteOnStop(on bool) {
+	r.deleteOnStop = on
+}
+
+// Updates sets the webhook for the bot and delivers updates until ctx is done.
+// By default the webhook isn't deleted then, as other replicas of the farm may
+// still serve it; Telegram keeps updates it fails to deliver and retries them.
+// With SetDeleteOnStop it's deleted, so Telegram keeps updates until the bot
+// is started again.
+func (r *WebhookReceiver) Updates(ctx context.Context, name string, api *tg.BotAPI) (<-chan tg.Update, error) {
+	path, token := r.secrets(name)
+
+	ep := &endpoint{
+		name:  name,
+		token: token,
+		ch:    make(chan tg.Update, api.Buffer),
+		done:  make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	r.endpoints[path] = ep
+	r.mu.Unlock()
+
+	params := tg.Params{}
+	params.AddNonEmpty("url", r.baseURL+path)
+	params.AddNonEmpty("secret_token", token)
+	if _, err := api.MakeRequest("setWebhook", params); err != nil {
+		r.remove(path, ep)
+		return nil, err
+	}
+
+	r.logger.Infow("webhook is set", "bot", name)
+
+	go func() {
+		<-ctx.Done()
+		if r.deleteOnStop {
+			if _, err := api.Request(tg.DeleteWebhookConfig{}); err != nil {
+				r.logger.Errorw("failed deleting webhook", "bot", name, "err", err)
+			} else {
+				r.logger.Infow("webhook is deleted", "bot", name)
+			}
+		}
+		r.remove(path, ep)
+	}()
+
+	return ep.ch, nil
+}
+
+// ServeHTTP handles requests from Telegram.
+func (r *WebhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.mu.Lock()
+	ep, ok := r.endpoints[req.URL.Path]
+	r.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	token := req.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(ep.token)) != 1 {
+		r.logger.Warnw("webhook request with wrong secret token", "bot", ep.name, "remote", req.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var u tg.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxUpdateSize)).Decode(&u); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if !ep.deliver(req.Context(), u) {
+		// Telegram retries the update later
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *WebhookReceiver) remove(path string, ep *endpoint) {
+	r.mu.Lock()
+	if r.endpoints[path] == ep {
+		delete(r.endpoints, path)
+	}
+	r.mu.Unlock()
+
+	ep.close()
+}
+
+// secrets returns the path and the secret token for the bot
+func (r *WebhookReceiver) secrets(name string) (string, string) {
+	return webhookPrefix + r.derive("path:"+name), r.derive("token:" + name)
+}
+
+func (r *WebhookReceiver) derive(s string) string {
+	mac := hmac.New(sha256.New, []byte(r.secret))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver passes the update to the bot. It returns false if the endpoint is
+// closed or the request is canceled.
+func (ep *endpoint) deliver(ctx context.Context, u tg.Update) bool {
+	ep.mu.Lock()
+	if ep.closed {
+		ep.mu.Unlock()
+		return false
+	}
+	ep.inflight.Add(1)
+	ep.mu.Unlock()
+	defer ep.inflight.Done()
+
+	select {
+	case ep.ch <- u:
+		return true
+	case <-ep.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// close stops accepting updates and closes the channel once in-flight
+// deliveries are over
+func (ep *endpoint) close() {
+	ep.mu.Lock()
+	if ep.closed {
+		ep.mu.Unlock()
+		return
+	}
+	ep.closed = true
+	close(ep.done)
+	ep.mu.Unlock()
+
+	ep.inflight.Wait()
+	close(ep.ch)
+}
@@ -0,0 +1,153 @@
+package bot_test
+
+import (
+	"botfarm/bot"
+	"botfarm/bot/tgtest"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+const webhookURL = "https://example.com"
+
+// setWebhook returns the path and the secret token the bot registered
+func setWebhook(t *testing.T, tgSrv *tgtest.Server) (string, string) {
+	t.Helper()
+
+	reqs := tgSrv.Requests("setWebhook")
+	if len(reqs) != 1 {
+		t.Fatalf("expected the webhook to be set once, got %d requests", len(reqs))
+	}
+	u := reqs[0].Params.Get("url")
+	if !strings.HasPrefix(u, webhookURL+"/webhook/") {
+		t.Fatalf("unexpected webhook URL %q", u)
+	}
+
+	return strings.TrimPrefix(u, webhookURL), reqs[0].Params.Get("secret_token")
+}
+
+func postUpdate(h http.Handler, method, path, token, body string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestWebhookReceiver(t *testing.T) {
+	l := zap.NewNop().Sugar()
+	tgSrv := tgtest.NewServer()
+	defer tgSrv.Close()
+	api, err := tg.NewBotAPIWithAPIEndpoint(tgtest.Token, tgSrv.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = bot.NewWebhookReceiver(bot.NewServer("127.0.0.1:0", l), webhookURL, "", l); err == nil {
+		t.Error("expected an error without a secret")
+	}
+	if _, err = bot.NewWebhookReceiver(bot.NewServer("127.0.0.1:0", l), "http://example.com", "s", l); err == nil {
+		t.Error("expected an error for a plain HTTP URL")
+	}
+
+	srv := bot.NewServer("127.0.0.1:0", l)
+	r, err := bot.NewWebhookReceiver(srv, webhookURL, "secret", l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := srv.Handler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := r.Updates(ctx, "test", api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, token := setWebhook(t, tgSrv)
+
+	const update = `{"update_id": 7, "message": {"message_id": 1, "text": "hi", "chat": {"id": 42}}}`
+	for _, tc := range []struct {
+		name, method, path, token, body string
+		code                            int
+	}{
+		{"unknown path", http.MethodPost, "/webhook/other", token, update, http.StatusNotFound},
+		{"no token", http.MethodPost, path, "", update, http.StatusForbidden},
+		{"wrong token", http.MethodPost, path, "wrong", update, http.StatusForbidden},
+		{"not POST", http.MethodGet, path, token, "", http.StatusMethodNotAllowed},
+		{"bad JSON", http.MethodPost, path, token, "{", http.StatusBadRequest},
+		{"too large", http.MethodPost, path, token, `{"update_id": 7, "x": "` + strings.Repeat("x", 1<<20) + `"}`,
+			http.StatusRequestEntityTooLarge},
+	} {
+		if code := postUpdate(h, tc.method, tc.path, tc.token, tc.body); code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.code, code)
+		}
+	}
+
+	if code := postUpdate(h, http.MethodPost, path, token, update); code != http.StatusOK {
+		t.Fatalf("expected the update to be accepted, got %d", code)
+	}
+	select {
+	case u := <-updates:
+		if u.UpdateID != 7 || u.Message == nil || u.Message.Text != "hi" {
+			t.Errorf("unexpected update %+v", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("update wasn't delivered")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-updates:
+		if ok {
+			t.Error("expected no more updates")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("updates weren't closed on shutdown")
+	}
+	if code := postUpdate(h, http.MethodPost, path, token, update); code == http.StatusOK {
+		t.Error("expected updates to be rejected after shutdown")
+	}
+	// other replicas may still serve the webhook
+	if reqs := tgSrv.Requests("deleteWebhook"); len(reqs) != 0 {
+		t.Error("expected the webhook not to be deleted on shutdown")
+	}
+
+	// another replica with the same secret registers the same webhook
+	tgSrv.Reset()
+	srv2 := bot.NewServer("127.0.0.1:0", l)
+	r2, err := bot.NewWebhookReceiver(srv2, webhookURL, "secret", l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	if _, err = r2.Updates(ctx2, "test", api); err != nil {
+		t.Fatal(err)
+	}
+	if path2, token2 := setWebhook(t, tgSrv); path2 != path || token2 != token {
+		t.Error("expected the same webhook path and token for the same secret")
+	}
+
+	// a single replica may delete the webhook, so Telegram keeps updates
+	tgSrv.Reset()
+	r2.SetDeleteOnStop(true)
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	updates3, err := r2.Updates(ctx3, "test", api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel3()
+	for range updates3 {
+	}
+	if reqs := tgSrv.Requests("deleteWebhook"); len(reqs) != 1 {
+		t.Errorf("expected the webhook to be deleted on stop, got %d requests", len(reqs))
+	}
+}
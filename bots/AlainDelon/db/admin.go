@@ -0,0 +1,92 @@
+package db
+
+import (
+	"botfarm/bot"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Admin gives admin commands access to users of the bot (see bot.AdminSource)
+type Admin struct {
+	DB *bot.DB
+}
+
+// Chats returns chats of all users
+func (a Admin) Chats(ctx context.Context) ([]int64, error) {
+	var chats []int64
+	err := a.DB.Do(ctx, "Chats", func(ctx context.Context, q bot.Querier) error {
+		rows, err := q.QueryContext(ctx, `SELECT chat_id FROM users`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		chats = chats[:0]
+		for rows.Next() {
+			var cht int64
+			if err = rows.Scan(&cht); err != nil {
+				return err
+			}
+			chats = append(chats, cht)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing chats: %w", err)
+	}
+
+	return chats, nil
+}
+
+// Stats counts users, movies and ratings
+func (a Admin) Stats(ctx context.Context) ([]bot.Field, error) {
+	var users, movies, created, ratings int
+	err := a.DB.Do(ctx, "Stats", func(ctx context.Context, q bot.Querier) error {
+		return q.QueryRowContext(ctx, `SELECT
+(SELECT count(*) FROM users),
+(SELECT count(*) FROM movies),
+(SELECT count(*) FROM movies WHERE created_on>$1),
+(SELECT count(*) FROM ratings)`, clk.Now().UTC().Add(-7*24*time.Hour)).Scan(&users, &movies, &created, &ratings)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed counting users and movies: %w", err)
+	}
+
+	return []bot.Field{
+		{Name: "Users", Value: users},
+		{Name: "Movies", Value: movies},
+		{Name: "Movies created (7 days)", Value: created},
+		{Name: "Ratings", Value: ratings},
+	}, nil
+}
+
+// UserInfo describes the movies and ratings of the user
+func (a Admin) UserInfo(ctx context.Context, usr int64) ([]bot.Field, error) {
+	var cht int64
+	var since time.Time
+	var movies, ratings int
+	err := a.DB.Do(ctx, "UserInfo", func(ctx context.Context, q bot.Querier) error {
+		return q.QueryRowContext(ctx, `SELECT chat_id, created_on,
+(SELECT count(*) FROM movies WHERE created_by=$1),
+(SELECT count(*) FROM ratings WHERE user_id=$1)
+FROM users
+WHERE id=$1`, usr).Scan(&cht, &since, &movies, &ratings)
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, bot.ErrUnknownUser
+	case err != nil:
+		return nil, fmt.Errorf("failed fetching user: %w", err)
+	}
+
+	return []bot.Field{
+		{Name: "Chat", Value: cht},
+		{Name: "Started", Value: since.Format(time.RFC3339)},
+		{Name: "Movies added", Value: movies},
+		{Name: "Ratings", Value: ratings},
+	}, nil
+}
@@ -4,6 +4,7 @@ import (
 	"botfarm/bot"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/jmhodges/clock"
@@ -15,98 +16,74 @@ var (
 
 var txIsoRepeatableRead = &sql.TxOptions{Isolation: sql.LevelRepeatableRead}
 
-func Init(connStr string) (*sql.DB, error) {
-	d, err := sql.Open("pgx", connStr)
-	if err != nil {
-		return nil, err
-	}
-
-	if err = d.Ping(); err != nil {
-		return nil, err
-	}
+func Init(name, connStr string, cfg bot.DBConfig) (*bot.DB, error) {
+	return bot.ConnectDB(name, connStr, cfg)
+}
 
-	return d, nil
+// Movies keeps users, movies and their ratings in Postgres
+type Movies struct {
+	DB *bot.DB
 }
 
-func AddUser(ctx *bot.Context, usr, cht int64) error {
-	tx, err := ctx.DB.BeginTx(context.Background(), txIsoRepeatableRead)
-	if err != nil {
-		ctx.Logger.Errorw("failed starting transaction on adding user", "err", err)
-		return err
-	}
-	defer tx.Rollback()
-
-	var cID int64
-	err = tx.QueryRow(`SELECT chat_id FROM users WHERE id=$1`, usr).Scan(&cID)
-	switch {
-	case err == sql.ErrNoRows:
-		query := `INSERT INTO users (id, chat_id, created_on) VALUES ($1, $2, $3)`
-		if _, err = tx.Exec(query, usr, cht, clk.Now().UTC()); err != nil {
-			ctx.Logger.Errorw("failed adding user", "err", err)
+func (m Movies) AddUser(ctx *bot.Context, usr, cht int64) error {
+	err := m.DB.Tx(context.Background(), "AddUser", txIsoRepeatableRead, func(c context.Context, tx *sql.Tx) error {
+		var cID int64
+		err := tx.QueryRowContext(c, `SELECT chat_id FROM users WHERE id=$1`, usr).Scan(&cID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			query := `INSERT INTO users (id, chat_id, created_on) VALUES ($1, $2, $3)`
+			_, err = tx.ExecContext(c, query, usr, cht, clk.Now().UTC())
 			return err
-		}
 
-	case err != nil:
-		ctx.Logger.Errorw("failed fetching chat ID", "err", err)
-		return err
+		case err != nil:
+			return err
 
-	default:
-		if cID == cht {
+		case cID == cht:
 			ctx.Logger.Info("user is already up-to-date")
 			return nil
-		}
 
-		if _, err = tx.Exec(`UPDATE users SET chat_id=$1`, cht); err != nil {
-			ctx.Logger.Errorw("failed updating chat_id", "err", err)
+		default:
+			_, err = tx.ExecContext(c, `UPDATE users SET chat_id=$1 WHERE id=$2`, cht, usr)
 			return err
 		}
-	}
-
-	if err = tx.Commit(); err != nil {
-		ctx.Logger.Errorw("failed committing Tx for adding user", "err", err)
+	})
+	if err != nil {
+		ctx.Logger.Errorw("failed adding user", "err", err)
 		return err
 	}
 
 	return nil
 }
 
-func AddMovie(ctx *bot.Context, usr int64, mv *Movie) error {
+func (m Movies) AddMovie(ctx *bot.Context, usr int64, mv *Movie) error {
 	query := `INSERT INTO movies (title, alt_title, year, created_on, created_by) VALUES ($1, $2, $3, $4, $5)`
-	if _, err := ctx.DB.Exec(query, mv.Title, mv.AltTitle, mv.Year, clk.Now().UTC(), usr); err != nil {
+	if _, err := m.DB.Exec(context.Background(), "AddMovie", query, mv.Title, mv.AltTitle, mv.Year, clk.Now().UTC(), usr); err != nil {
 		ctx.Logger.Errorw("failed inserting movie", "err", err)
 		return err
 	}
 
+	moviesCreated.WithLabelValues(ctx.Name).Inc()
 	return nil
 }
 
-func DelMovie(ctx *bot.Context, usr int64, movieID int) error {
-	tx, err := ctx.DB.BeginTx(context.Background(), txIsoRepeatableRead)
-	if err != nil {
-		ctx.Logger.Errorw("failed starting delete movie transaction", "err", err)
-		return err
-	}
-	defer tx.Rollback()
+func (m Movies) DelMovie(ctx *bot.Context, usr int64, movieID int) error {
+	err := m.DB.Tx(context.Background(), "DelMovie", txIsoRepeatableRead, func(c context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(c, `DELETE FROM ratings WHERE movie_id=$1`, movieID); err != nil {
+			return err
+		}
 
-	if _, err := tx.Exec(`DELETE FROM ratings WHERE movie_id=$1`, movieID); err != nil {
-		ctx.Logger.Errorw("failed deleting movie", "err", err)
+		_, err := tx.ExecContext(c, `DELETE FROM movies WHERE id=$1`, movieID)
 		return err
-	}
-
-	if _, err := tx.Exec(`DELETE FROM movies WHERE id=$1`, movieID); err != nil {
+	})
+	if err != nil {
 		ctx.Logger.Errorw("failed deleting movie", "err", err)
 		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		ctx.Logger.Errorw("failed committing delete movie", "err", err)
-		return err
-	}
-
 	return nil
 }
 
-func RandomMovie(ctx *bot.Context, usr int64) (*Movie, error) {
+func (m Movies) RandomMovie(ctx *bot.Context, usr int64) (*Movie, error) {
 	query := `SELECT id, title, alt_title, year, rating
 FROM movies m
 	LEFT JOIN (
@@ -114,21 +91,24 @@ FROM movies m
 		FROM ratings
 	) r ON m.id=r.movie_id AND r.movie_id=$1
 ORDER BY RANDOM() LIMIT 1`
-	mv, err := getMovie(ctx, usr, query, usr)
+	mv, err := m.getMovie(ctx, usr, query, usr)
 	if err != nil {
 		ctx.Logger.Errorw("failed fetching random movie", "err", err)
 	}
 
-	return mv, nil
+	return mv, err
 }
 
-func getMovie(ctx *bot.Context, usr int64, query string, args ...any) (*Movie, error) {
+func (m Movies) getMovie(ctx *bot.Context, usr int64, query string, args ...any) (*Movie, error) {
 	var altTitle sql.NullString
 	var year sql.NullInt16
 	var rating sql.NullFloat64
 	var mv Movie
 
-	if err := ctx.DB.QueryRow(query, args...).Scan(&mv.ID, &mv.Title, &altTitle, &year, &rating); err != nil {
+	err := m.DB.Do(context.Background(), "getMovie", func(c context.Context, q bot.Querier) error {
+		return q.QueryRowContext(c, query, args...).Scan(&mv.ID, &mv.Title, &altTitle, &year, &rating)
+	})
+	if err != nil {
 		return &Movie{}, err
 	}
 
@@ -150,56 +130,58 @@ func getMovie(ctx *bot.Context, usr int64, query string, args ...any) (*Movie, e
 	return &mv, nil
 }
 
-func RateMovie(ctx *bot.Context, usr int64, movieID int, rating int) error {
-	var r int
-	err := ctx.DB.QueryRow(`SELECT rating FROM ratings WHERE user_id=$1 AND movie_id=$2`, usr, movieID).Scan(&r)
-	switch {
-	case err == sql.ErrNoRows:
-		query := `INSERT INTO ratings (user_id, movie_id, rating, created_on) VALUES ($1, $2, $3, $4)`
-		if _, err := ctx.DB.Exec(query, usr, movieID, rating, clk.Now().UTC()); err != nil {
-			ctx.Logger.Errorw(fmt.Sprintf("failed rating movie %d", movieID), "err", err)
+func (m Movies) RateMovie(ctx *bot.Context, usr int64, movieID int, rating int) error {
+	created := false
+	err := m.DB.Tx(context.Background(), "RateMovie", txIsoRepeatableRead, func(c context.Context, tx *sql.Tx) error {
+		created = false
+
+		var r int
+		err := tx.QueryRowContext(c, `SELECT rating FROM ratings WHERE user_id=$1 AND movie_id=$2`, usr, movieID).Scan(&r)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			query := `INSERT INTO ratings (user_id, movie_id, rating, created_on) VALUES ($1, $2, $3, $4)`
+			_, err = tx.ExecContext(c, query, usr, movieID, rating, clk.Now().UTC())
+			created = err == nil
 			return err
-		}
 
-	case err != nil:
-		ctx.Logger.Error(fmt.Sprintf("failed rating movie %d", movieID), "err", err)
-		return err
+		case err != nil:
+			return err
 
-	default:
-		if r == rating {
+		case r == rating:
 			return nil
-		}
 
-		query := `UPDATE ratings SET rating=$1, updated_on=$2 WHERE user_id=$3 AND movie_id=$4`
-		if _, err := ctx.DB.Exec(query, rating, clk.Now().UTC(), usr, movieID); err != nil {
-			ctx.Logger.Errorw(fmt.Sprintf("failed rating movie %d", movieID), "err", err)
+		default:
+			query := `UPDATE ratings SET rating=$1, updated_on=$2 WHERE user_id=$3 AND movie_id=$4`
+			_, err = tx.ExecContext(c, query, rating, clk.Now().UTC(), usr, movieID)
 			return err
 		}
+	})
+	if err != nil {
+		ctx.Logger.Errorw(fmt.Sprintf("failed rating movie %d", movieID), "err", err)
+		return err
 	}
 
+	if created {
+		ratingsCreated.WithLabelValues(ctx.Name).Inc()
+	}
 	return nil
 }
 
-func UnrateMovie(ctx *bot.Context, usr int64, movie int) (bool, error) {
-	var r int
-	err := ctx.DB.QueryRow(`SELECT rating FROM ratings WHERE user_id=$1 AND movie_id=$2`, usr, movie).Scan(&r)
-	switch {
-	case err == sql.ErrNoRows:
-		return false, nil
-
-	case err != nil:
+func (m Movies) UnrateMovie(ctx *bot.Context, usr int64, movie int) (bool, error) {
+	query := `DELETE FROM ratings WHERE user_id=$1 AND movie_id=$2`
+	res, err := m.DB.Exec(context.Background(), "UnrateMovie", query, usr, movie)
+	if err != nil {
 		ctx.Logger.Errorw(fmt.Sprintf("failed unrating movie %d", movie), "err", err)
 		return false, err
+	}
 
-	default:
-		query := `DELETE FROM ratings WHERE user_id=$1 AND movie_id=$2`
-		if _, err := ctx.DB.Exec(query, usr, movie); err != nil {
-			ctx.Logger.Errorw(fmt.Sprintf("failed unrating movie %d", movie), "err", err)
-			return false, err
-		}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.Logger.Errorw(fmt.Sprintf("failed unrating movie %d", movie), "err", err)
+		return false, err
 	}
 
-	return true, nil
+	return n > 0, nil
 }
 
 type MovieState int
@@ -218,7 +200,27 @@ const (
 	MovieStateAll
 )
 
-func listMovies(ctx *bot.Context, usr int64, rows *sql.Rows) ([]*Movie, error) {
+// queryMovies lists movies returned by the query
+func (m Movies) queryMovies(ctx *bot.Context, op, query string, args ...any) ([]*Movie, error) {
+	var movies []*Movie
+	err := m.DB.Do(context.Background(), op, func(c context.Context, q bot.Querier) error {
+		rows, err := q.QueryContext(c, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		movies, err = listMovies(ctx, rows)
+		return err
+	})
+	if err != nil {
+		return []*Movie{}, err
+	}
+
+	return movies, nil
+}
+
+func listMovies(ctx *bot.Context, rows *sql.Rows) ([]*Movie, error) {
 	var err error
 	movies := []*Movie{}
 	for rows.Next() {
@@ -246,14 +248,14 @@ func listMovies(ctx *bot.Context, usr int64, rows *sql.Rows) ([]*Movie, error) {
 		} else {
 			mv.Rating = -1
 		}
-		
+
 		movies = append(movies, &mv)
 	}
 
-	return movies, err
+	return movies, rows.Err()
 }
 
-func ListSeenMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+func (m Movies) ListSeenMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
 	query := `SELECT m.id, m.title, m.alt_title, m.year, r2.avg_rating
 FROM movies m
 	JOIN ratings r1 ON m.id=r1.movie_id AND r1.user_id=$1
@@ -263,17 +265,15 @@ FROM movies m
 		GROUP BY movie_id
 	) r2 ON m.id=r2.movie_id
 ORDER BY m.title`
-	rows, err := ctx.DB.Query(query, usr)
+	movies, err := m.queryMovies(ctx, "ListSeenMovies", query, usr)
 	if err != nil {
 		ctx.Logger.Errorw("failed querying seen movies", "err", err)
-		return []*Movie{}, nil
 	}
-	defer rows.Close()
 
-	return listMovies(ctx, usr, rows)
+	return movies, err
 }
 
-func ListUnseenMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+func (m Movies) ListUnseenMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
 	query := `SELECT m.id, m.title, m.alt_title, m.year, r1.avg_rating
 FROM movies m
 	LEFT JOIN (
@@ -288,17 +288,15 @@ FROM movies m
 	) r2 ON m.id=r2.movie_id
 WHERE r2.movie_id IS NULL
 ORDER BY m.title`
-	rows, err := ctx.DB.Query(query, usr)
+	movies, err := m.queryMovies(ctx, "ListUnseenMovies", query, usr)
 	if err != nil {
 		ctx.Logger.Errorw("failed querying seen movies", "err", err)
-		return []*Movie{}, nil
 	}
-	defer rows.Close()
 
-	return listMovies(ctx, usr, rows)
+	return movies, err
 }
 
-func ListAllMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+func (m Movies) ListAllMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
 	query := `SELECT m.id, m.title, m.alt_title, m.year, r.avg_rating
 FROM movies m
 	LEFT JOIN (
@@ -307,33 +305,29 @@ FROM movies m
 		GROUP BY movie_id
 	) r ON m.id=r.movie_id
 ORDER BY m.title`
-	rows, err := ctx.DB.Query(query)
+	movies, err := m.queryMovies(ctx, "ListAllMovies", query)
 	if err != nil {
 		ctx.Logger.Errorw("failed querying all movies", "err", err)
-		return []*Movie{}, nil
 	}
-	defer rows.Close()
 
-	return listMovies(ctx, usr, rows)
+	return movies, err
 }
 
-func ListMyMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+func (m Movies) ListMyMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
 	query := `SELECT m.id, m.title, m.alt_title, m.year, r.rating
 FROM movies m
 	LEFT JOIN ratings r ON m.id=r.movie_id AND r.user_id=$1
 WHERE m.created_by=$1
 ORDER BY m.title`
-	rows, err := ctx.DB.Query(query, usr)
+	movies, err := m.queryMovies(ctx, "ListMyMovies", query, usr)
 	if err != nil {
 		ctx.Logger.Errorw("failed querying all movies", "err", err)
-		return []*Movie{}, nil
 	}
-	defer rows.Close()
 
-	return listMovies(ctx, usr, rows)
+	return movies, err
 }
 
-func ListTopMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+func (m Movies) ListTopMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
 	query := `SELECT m.id, m.title, m.alt_title, m.year, r.avg_rating
 FROM movies m
 	LEFT JOIN (
@@ -343,17 +337,15 @@ FROM movies m
 	) r ON m.id=r.movie_id
 ORDER BY avg_rating DESC NULLS LAST, m.title
 LIMIT 10`
-	rows, err := ctx.DB.Query(query)
+	movies, err := m.queryMovies(ctx, "ListTopMovies", query)
 	if err != nil {
 		ctx.Logger.Errorw("failed querying top movies", "err", err)
-		return []*Movie{}, err
 	}
-	defer rows.Close()
 
-	return listMovies(ctx, usr, rows)
+	return movies, err
 }
 
-func ListLatestMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+func (m Movies) ListLatestMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
 	query := `SELECT m.id, m.title, m.alt_title, m.year, r.avg_rating
 FROM movies m
 	LEFT JOIN (
@@ -363,19 +355,17 @@ FROM movies m
 	) r ON m.id=r.movie_id
 ORDER BY created_on DESC, m.title
 LIMIT 10`
-	rows, err := ctx.DB.Query(query)
+	movies, err := m.queryMovies(ctx, "ListLatestMovies", query)
 	if err != nil {
 		ctx.Logger.Errorw("failed querying latest movies", "err", err)
-		return []*Movie{}, err
 	}
-	defer rows.Close()
 
-	return listMovies(ctx, usr, rows)
+	return movies, err
 }
 
-func GetMovie(ctx *bot.Context, usr int64, id int) (*Movie, error) {
+func (m Movies) GetMovie(ctx *bot.Context, usr int64, id int) (*Movie, error) {
 	query := `SELECT id, title, alt_title, year, NULL FROM movies WHERE id=$1`
-	mv, err := getMovie(ctx, usr, query, id)
+	mv, err := m.getMovie(ctx, usr, query, id)
 	if err != nil {
 		ctx.Logger.Errorw(fmt.Sprintf("failed getting movie %d", id), "err", err)
 		return &Movie{}, err
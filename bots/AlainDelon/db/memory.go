@@ -0,0 +1,260 @@
+package db
+
+import (
+	"botfarm/bot"
+	"database/sql"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// memoryMovie is a movie kept by Memory
+type memoryMovie struct {
+	Movie
+	createdBy int64
+	createdOn int // order of adding
+}
+
+// Memory keeps users, movies and their ratings in memory like Movies keeps
+// them in Postgres. It's meant for tests of the bot, which may make its
+// operations fail with Fail.
+type Memory struct {
+	mu       sync.Mutex
+	users    map[int64]int64 // chats by user
+	movies   map[int]*memoryMovie
+	ratings  map[int]map[int64]int // by movie and user
+	nextID   int
+	failures map[string]error // by operation
+}
+
+// NewMemory creates an empty Memory
+func NewMemory() *Memory {
+	return &Memory{
+		users:    make(map[int64]int64),
+		movies:   make(map[int]*memoryMovie),
+		ratings:  make(map[int]map[int64]int),
+		nextID:   1,
+		failures: make(map[string]error),
+	}
+}
+
+// Fail makes the operation, i.e. the method with the name, return err until
+// it's called again with nil err
+func (m *Memory) Fail(op string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err == nil {
+		delete(m.failures, op)
+	} else {
+		m.failures[op] = err
+	}
+}
+
+// Rating returns the user's rating of the movie or 0 if it isn't rated
+func (m *Memory) Rating(usr int64, movieID int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.ratings[movieID][usr]
+}
+
+func (m *Memory) AddUser(ctx *bot.Context, usr, cht int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["AddUser"]; err != nil {
+		return err
+	}
+
+	m.users[usr] = cht
+	return nil
+}
+
+func (m *Memory) AddMovie(ctx *bot.Context, usr int64, mv *Movie) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["AddMovie"]; err != nil {
+		return err
+	}
+
+	added := &memoryMovie{Movie: *mv, createdBy: usr, createdOn: m.nextID}
+	added.ID = m.nextID
+	m.movies[added.ID] = added
+	m.nextID++
+	return nil
+}
+
+func (m *Memory) DelMovie(ctx *bot.Context, usr int64, movieID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["DelMovie"]; err != nil {
+		return err
+	}
+
+	delete(m.ratings, movieID)
+	delete(m.movies, movieID)
+	return nil
+}
+
+func (m *Memory) GetMovie(ctx *bot.Context, usr int64, id int) (*Movie, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["GetMovie"]; err != nil {
+		return &Movie{}, err
+	}
+
+	mv, ok := m.movies[id]
+	if !ok {
+		return &Movie{}, sql.ErrNoRows
+	}
+
+	found := mv.Movie
+	found.Rating = -1
+	return &found, nil
+}
+
+func (m *Memory) RandomMovie(ctx *bot.Context, usr int64) (*Movie, error) {
+	all, err := m.query("RandomMovie", func(*memoryMovie) bool { return true }, m.avgRating, byTitle)
+	if err != nil {
+		return &Movie{}, err
+	}
+	if len(all) == 0 {
+		return &Movie{}, sql.ErrNoRows
+	}
+	return all[rand.Intn(len(all))], nil
+}
+
+func (m *Memory) RateMovie(ctx *bot.Context, usr int64, movieID int, rating int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["RateMovie"]; err != nil {
+		return err
+	}
+
+	if m.ratings[movieID] == nil {
+		m.ratings[movieID] = make(map[int64]int)
+	}
+	m.ratings[movieID][usr] = rating
+	return nil
+}
+
+func (m *Memory) UnrateMovie(ctx *bot.Context, usr int64, movie int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["UnrateMovie"]; err != nil {
+		return false, err
+	}
+
+	_, ok := m.ratings[movie][usr]
+	delete(m.ratings[movie], usr)
+	return ok, nil
+}
+
+func (m *Memory) ListSeenMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+	return m.query("ListSeenMovies", func(mv *memoryMovie) bool { return m.rated(mv.ID, usr) }, m.avgRating,
+		byTitle)
+}
+
+func (m *Memory) ListUnseenMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+	return m.query("ListUnseenMovies", func(mv *memoryMovie) bool { return !m.rated(mv.ID, usr) }, m.avgRating,
+		byTitle)
+}
+
+func (m *Memory) ListAllMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+	return m.query("ListAllMovies", func(*memoryMovie) bool { return true }, m.avgRating, byTitle)
+}
+
+func (m *Memory) ListMyMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+	rating := func(id int) float32 {
+		if r, ok := m.ratings[id][usr]; ok {
+			return float32(r)
+		}
+		return -1
+	}
+	return m.query("ListMyMovies", func(mv *memoryMovie) bool { return mv.createdBy == usr }, rating, byTitle)
+}
+
+func (m *Memory) ListTopMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+	movies, err := m.query("ListTopMovies", func(*memoryMovie) bool { return true }, m.avgRating,
+		func(a, b *memoryMovie, ra, rb float32) bool {
+			if ra != rb {
+				return ra > rb
+			}
+			return a.Title < b.Title
+		})
+	return limit(movies), err
+}
+
+func (m *Memory) ListLatestMovies(ctx *bot.Context, usr int64) ([]*Movie, error) {
+	movies, err := m.query("ListLatestMovies", func(*memoryMovie) bool { return true }, m.avgRating,
+		func(a, b *memoryMovie, _, _ float32) bool { return a.createdOn > b.createdOn })
+	return limit(movies), err
+}
+
+// query lists movies matching the filter with the ratings in the order
+func (m *Memory) query(op string, filter func(*memoryMovie) bool, rating func(id int) float32,
+	less func(a, b *memoryMovie, ra, rb float32) bool) ([]*Movie, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures[op]; err != nil {
+		return []*Movie{}, err
+	}
+
+	var found []*memoryMovie
+	for _, mv := range m.movies {
+		if filter(mv) {
+			found = append(found, mv)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return less(found[i], found[j], rating(found[i].ID), rating(found[j].ID))
+	})
+
+	movies := []*Movie{}
+	for _, mv := range found {
+		listed := mv.Movie
+		listed.Rating = rating(mv.ID)
+		movies = append(movies, &listed)
+	}
+	return movies, nil
+}
+
+// rated reports whether the user rated the movie; m.mu must be held
+func (m *Memory) rated(movieID int, usr int64) bool {
+	_, ok := m.ratings[movieID][usr]
+	return ok
+}
+
+// avgRating returns the average rating of the movie or -1 if it isn't rated;
+// m.mu must be held
+func (m *Memory) avgRating(movieID int) float32 {
+	ratings := m.ratings[movieID]
+	if len(ratings) == 0 {
+		return -1
+	}
+
+	sum := 0
+	for _, r := range ratings {
+		sum += r
+	}
+	return float32(sum) / float32(len(ratings))
+}
+
+func byTitle(a, b *memoryMovie, _, _ float32) bool {
+	return a.Title < b.Title
+}
+
+// limit keeps the first 10 movies like top and latest lists
+func limit(movies []*Movie) []*Movie {
+	if len(movies) > 10 {
+		return movies[:10]
+	}
+	return movies
+}
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"botfarm/bot"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	moviesCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: bot.MetricsNamespace,
+		Name:      "movies_created_total",
+		Help:      "Movies added by users.",
+	}, []string{"bot"})
+
+	ratingsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: bot.MetricsNamespace,
+		Name:      "ratings_created_total",
+		Help:      "Movies rated by users for the first time.",
+	}, []string{"bot"})
+)
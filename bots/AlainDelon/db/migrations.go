@@ -0,0 +1,8 @@
+package db
+
+import "embed"
+
+// Migrations keeps migrations of the database schema (see bot.Migrator)
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
@@ -1,7 +1,3 @@
-SET TIME ZONE 'UTC'
-
-CREATE DATABASE alain_debot;
-
 CREATE TABLE IF NOT EXISTS users (
     id bigint NOT NULL PRIMARY KEY,
     chat_id bigint NOT NULL UNIQUE,
@@ -21,11 +17,11 @@ CREATE TABLE IF NOT EXISTS movies (
     FOREIGN KEY (created_by) REFERENCES users (id)
 );
 
-CREATE INDEX movies_title_key ON movies USING btree (
+CREATE INDEX IF NOT EXISTS movies_title_key ON movies USING btree (
     title ASC
 );
 
-CREATE INDEX movies_alt_title_key ON movies USING btree (
+CREATE INDEX IF NOT EXISTS movies_alt_title_key ON movies USING btree (
     alt_title ASC
 );
 
@@ -41,6 +37,6 @@ CREATE TABLE IF NOT EXISTS ratings (
     FOREIGN KEY (movie_id) REFERENCES movies (id)
 );
 
-CREATE INDEX movies_movie_id_key ON ratings USING btree (
+CREATE INDEX IF NOT EXISTS movies_movie_id_key ON ratings USING btree (
     movie_id ASC
-);
\ No newline at end of file
+);
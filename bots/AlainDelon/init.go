@@ -4,80 +4,187 @@ import (
 	"botfarm/bot"
 	"botfarm/bots/AlainDelon/db"
 	"botfarm/bots/AlainDelon/tgbot"
+	"context"
 	"errors"
+	"io/fs"
 
-	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"go.uber.org/zap"
 )
 
+// Config is AlainDelon's configuration
+type Config struct {
+	bot.BaseConfig
+}
+
 type AlainDelon struct {
-	ctx *bot.Context
+	name     string
+	ctx      *bot.Context
+	cfg      Config
+	sender   *bot.Sender
+	migrator *bot.Migrator
+	router   *bot.Router
+	updates  *bot.Dispatcher
+	handlers bot.Tasks
+}
+
+// New creates an instance of the bot with the name
+func New(name string) bot.Bot {
+	return &AlainDelon{name: name}
 }
 
 func (ad *AlainDelon) Name() string {
-	return "AlainDebot"
+	return ad.name
 }
 
-func (ad *AlainDelon) Init(cfg bot.Config, l *zap.SugaredLogger) error {
-	v, ok := cfg[bot.CfgDbConnStr].(string)
-	if !ok {
-		l.Error("failed fetching connection string")
-		return errors.New("configuration value doesn't exist")
-	}
-	d, err := db.Init(v)
+func (ad *AlainDelon) Config() any {
+	return &ad.cfg
+}
+
+func (ad *AlainDelon) Init(l *zap.SugaredLogger) (err error) {
+	d, err := db.Init(ad.Name(), ad.cfg.DBConnStr, ad.cfg.DBConfig())
 	if err != nil {
 		l.Errorw("failed to initialize database", "err", err)
 		return err
 	}
 
-	v, ok = cfg[bot.CfgTgToken].(string)
-	if !ok {
-		l.Error("failed fetching Telegram token")
-		return errors.New("configuration value doesn't exist")
+	// Init may be run again, e.g. by the admin API, so nothing is leaked if it
+	// fails
+	ad.sender = nil
+	defer func() {
+		if err == nil {
+			return
+		}
+		if ad.sender != nil {
+			ad.sender.Close()
+			ad.sender = nil
+		}
+		if cerr := d.Close(); cerr != nil {
+			l.Errorw("failed closing database", "err", cerr)
+		}
+	}()
+
+	ad.migrator, err = bot.NewMigrator(ad.Name(), d.DB, db.Migrations, l)
+	if err != nil {
+		l.Errorw("failed to load migrations", "err", err)
+		return err
+	}
+	if ad.cfg.Migrate {
+		if err = ad.migrator.Up(context.Background()); err != nil {
+			l.Errorw("failed to migrate database", "err", err)
+			return err
+		}
+	}
+
+	catalog, err := bot.LoadCatalog(tgbot.Locales, ad.cfg.Locale)
+	if err != nil {
+		l.Errorw("failed to load message catalog", "err", err)
+		return err
 	}
-	b, err := tg.NewBotAPI(v)
+
+	t, err := bot.NewTransport(ad.Name(), ad.cfg.TgToken, ad.cfg.TgAPIEndpoint)
 	if err != nil {
 		l.Errorw("failed to initialize Telegram Bot", "err", err)
 		return err
 	}
 
-	b.Debug = false
+	l.Infof("authorized on account %q", t.Self().UserName)
+
+	ad.sender = bot.NewSender(ad.Name(), t, ad.cfg.SenderConfig(), l)
+	ad.ctx = &bot.Context{Name: ad.Name(), Bot: ad.sender, DB: d, Logger: l,
+		I18n: bot.NewI18n(ad.Name(), catalog, bot.NewPGLocaleStore(d.DB), l)}
+	ad.router = bot.NewRouter(ad.Name(), l)
+	tgbot.Routes(ad.router, ad.ctx, bot.NewPGStore(d.DB), db.Movies{DB: d}, ad.cfg.ConvTimeout)
+
+	operator := bot.NewOperator(ad.Name(), d.DB, db.Admin{DB: d}, ad.sender, ad.sender.WithPriority(bot.PriorityLow),
+		ad.cfg.BroadcastRate, &ad.handlers, l)
+
+	access, err := bot.NewAccess(ad.Name(), ad.cfg.AccessConfig(), bot.NewPGAccessStore(d.DB), ad.ctx.I18n,
+		ad.sender, l)
+	if err != nil {
+		l.Errorw("failed to initialize access policy", "err", err)
+		return err
+	}
 
-	l.Infof("authorized on account %q", b.Self.UserName)
+	ad.router.Use(access.Middleware(), operator.Middleware())
+	operator.Routes(ad.router)
+	access.Routes(ad.router)
 
-	ad.ctx = &bot.Context{Bot: b, DB: d, Logger: l}
+	ad.updates = bot.NewDispatcher(ad.cfg.UpdateWorkers, ad.router.Handle, l)
+	ad.handlers.Logger = l
 	return nil
 }
 
-func (ad *AlainDelon) Run() {
+func (ad *AlainDelon) Run(ctx context.Context) error {
 	if ad.ctx.Bot == nil {
 		ad.ctx.Logger.Error("can't run the bot because it's uninitialized")
-		return
+		return errors.New("bot is uninitialized")
 	}
 
-	uCfg := tg.NewUpdate(0)
-	uCfg.Timeout = 60
+	ad.updates.Reset()
+	ad.handlers.Reset()
 
-	for u := range ad.ctx.Bot.GetUpdatesChan(uCfg) {
-		if u.Message != nil {
-			ctx := ad.ctx.CloneWith(u.Message.From.ID)
+	updates, err := ad.ctx.Bot.Updates(ctx, ad.Name())
+	if err != nil {
+		ad.ctx.Logger.Errorw("failed to start receiving updates", "err", err)
+		return err
+	}
 
-			if u.Message.IsCommand() {
-				go tgbot.HandleCommand(ctx, &u)
-			} else {
-				go tgbot.HandleUpdate(ctx, &u)
-			}
-		}
+	for u := range updates {
+		u := u
+		ad.updates.Dispatch(&u)
+	}
 
-		if u.CallbackQuery != nil {
-			ctx := ad.ctx.CloneWith(u.CallbackQuery.From.ID)
+	return nil
+}
 
-			go tgbot.HandleCallbackQuery(ctx, &u)
-		}
+// Migrations returns migrations of the bot database
+func (ad *AlainDelon) Migrations() fs.FS {
+	return db.Migrations
+}
+
+// Menu returns commands of the bot menu
+func (ad *AlainDelon) Menu() []bot.MenuCommand {
+	return tgbot.Menu()
+}
+
+// Locales returns message catalogs of the bot
+func (ad *AlainDelon) Locales() fs.FS {
+	return tgbot.Locales
+}
+
+// Migrator returns the migrator of the bot database
+func (ad *AlainDelon) Migrator() *bot.Migrator {
+	return ad.migrator
+}
+
+// Health checks the database
+func (ad *AlainDelon) Health(ctx context.Context) map[string]error {
+	return map[string]error{
+		"db": ad.ctx.DB.PingContext(ctx),
+	}
+}
+
+func (ad *AlainDelon) Stop(ctx context.Context) error {
+	err := ad.updates.Wait(ctx)
+	if tasksErr := ad.handlers.Wait(ctx); err == nil {
+		err = tasksErr
 	}
+	if err != nil {
+		ad.ctx.Logger.Warnw("not all handlers finished in time", "err", err)
+	}
+	ad.sender.Close()
+
+	if dbErr := ad.ctx.DB.Close(); dbErr != nil {
+		ad.ctx.Logger.Errorw("failed closing database", "err", dbErr)
+		err = dbErr
+	}
+
+	ad.ctx.Logger.Sync()
+
+	return err
 }
 
 func init() {
-	bot.Register(&AlainDelon{}, []string{bot.CfgDbConnStr, bot.CfgTgToken})
+	bot.Register("AlainDebot", New)
 }
@@ -3,7 +3,6 @@ package tgbot
 import (
 	"botfarm/bot"
 	"botfarm/bots/AlainDelon/db"
-	"fmt"
 	"strconv"
 
 	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -39,55 +38,65 @@ const (
 	cbq5Star = "5stars"
 )
 
-var (
-	backButtonRow = tg.NewInlineKeyboardRow(tg.NewInlineKeyboardButtonData("⬅️ Back", cbqBack))
-)
+func backButtonRow(l bot.Localizer) []tg.InlineKeyboardButton {
+	return tg.NewInlineKeyboardRow(tg.NewInlineKeyboardButtonData(l.T("button.back"), cbqBack))
+}
 
 // keyboards
-var (
-	mainKeyboard = tg.NewInlineKeyboardMarkup(
+
+func mainKeyboard(l bot.Localizer) *tg.InlineKeyboardMarkup {
+	kb := tg.NewInlineKeyboardMarkup(
 		tg.NewInlineKeyboardRow(
-			tg.NewInlineKeyboardButtonData("🪄 Add", cbqAdd),
-			tg.NewInlineKeyboardButtonData("❌ Delete", cbqDel),
+			tg.NewInlineKeyboardButtonData(l.T("button.add"), cbqAdd),
+			tg.NewInlineKeyboardButtonData(l.T("button.delete"), cbqDel),
 		),
 		tg.NewInlineKeyboardRow(
-			tg.NewInlineKeyboardButtonData("⭐ Rate", cbqRate),
-			tg.NewInlineKeyboardButtonData("💥 Unrate", cbqUnrate),
+			tg.NewInlineKeyboardButtonData(l.T("button.rate"), cbqRate),
+			tg.NewInlineKeyboardButtonData(l.T("button.unrate"), cbqUnrate),
 		),
 		tg.NewInlineKeyboardRow(
-			tg.NewInlineKeyboardButtonData("👁️ Watched", cbqWatched),
-			tg.NewInlineKeyboardButtonData("🙈 Unwatched", cbqUnwatched),
+			tg.NewInlineKeyboardButtonData(l.T("button.watched"), cbqWatched),
+			tg.NewInlineKeyboardButtonData(l.T("button.unwatched"), cbqUnwatched),
 		),
 		tg.NewInlineKeyboardRow(
-			tg.NewInlineKeyboardButtonData("🎞️ All movies", cbqAll),
-			tg.NewInlineKeyboardButtonData("📝 My movies", cbqMy),
+			tg.NewInlineKeyboardButtonData(l.T("button.all"), cbqAll),
+			tg.NewInlineKeyboardButtonData(l.T("button.my"), cbqMy),
 		),
 		tg.NewInlineKeyboardRow(
-			tg.NewInlineKeyboardButtonData("💎 Top 10", cbqTop),
-			tg.NewInlineKeyboardButtonData("🕓 Latest 10", cbqLast),
+			tg.NewInlineKeyboardButtonData(l.T("button.top"), cbqTop),
+			tg.NewInlineKeyboardButtonData(l.T("button.latest"), cbqLast),
 		),
 		tg.NewInlineKeyboardRow(
-			tg.NewInlineKeyboardButtonData("👀 Amaze me", cbqAmazeMe),
-			tg.NewInlineKeyboardButtonData("🔍 Find", cbqFind),
+			tg.NewInlineKeyboardButtonData(l.T("button.amaze_me"), cbqAmazeMe),
+			tg.NewInlineKeyboardButtonData(l.T("button.find"), cbqFind),
 		),
 		tg.NewInlineKeyboardRow(
-			tg.NewInlineKeyboardButtonData("🤦🏻‍♂️ I need help", cbqHelp),
+			tg.NewInlineKeyboardButtonData(l.T("button.help"), cbqHelp),
 		),
 	)
+	return &kb
+}
 
-	keyboardSkip = tg.NewInlineKeyboardMarkup(
-		backButtonRow,
+func keyboardSkip(l bot.Localizer) *tg.InlineKeyboardMarkup {
+	kb := tg.NewInlineKeyboardMarkup(
+		backButtonRow(l),
 		tg.NewInlineKeyboardRow(
-			tg.NewInlineKeyboardButtonData("🙅🏻 Skip", cbqSkip),
+			tg.NewInlineKeyboardButtonData(l.T("button.skip"), cbqSkip),
 		),
 	)
+	return &kb
+}
 
-	keyboardBack = tg.NewInlineKeyboardMarkup(
-		backButtonRow,
+func keyboardBack(l bot.Localizer) *tg.InlineKeyboardMarkup {
+	kb := tg.NewInlineKeyboardMarkup(
+		backButtonRow(l),
 	)
+	return &kb
+}
 
-	keyboardRateOptions = tg.NewInlineKeyboardMarkup(
-		backButtonRow,
+func keyboardRateOptions(l bot.Localizer) *tg.InlineKeyboardMarkup {
+	kb := tg.NewInlineKeyboardMarkup(
+		backButtonRow(l),
 		tg.NewInlineKeyboardRow(
 			tg.NewInlineKeyboardButtonData("⭐", cbq1Star),
 			tg.NewInlineKeyboardButtonData("⭐⭐", cbq2Star),
@@ -98,129 +107,122 @@ var (
 			tg.NewInlineKeyboardButtonData("⭐⭐⭐⭐⭐", cbq5Star),
 		),
 	)
-)
+	return &kb
+}
 
-func HandleCallbackQuery(ctx *bot.Context, upd *tg.Update) {
+func (b *tbot) HandleCallbackQuery(ctx *bot.Context, upd *tg.Update, s *session) {
 	cbq := upd.CallbackQuery
 	usr := cbq.From.ID
 	cht := cbq.Message.Chat.ID
 	mID := cbq.Message.MessageID
 
-	if states[usr] == nil {
-		states[usr] = &state{stage: stageIdle, mainMessageID: mID}
+	if s.Anchor == 0 {
+		s.Anchor = mID
 	}
 
-	state := states[usr]
-
 	switch cbq.Data {
 	case cbqBack:
-		replaceMessage(ctx, usr, cht, mID, mainMessage, &mainKeyboard, stageIdle)
+		replaceMessage(ctx, s, cht, mID, ctx.L.T(mainMessage), mainKeyboard(ctx.L), bot.StateIdle)
 
 	case cbqAdd:
-		if state.stage != stageIdle {
-			fixState(ctx, cbq)
+		if s.State != bot.StateIdle {
+			fixState(ctx, cbq, s)
 			return
 		}
-		states[usr].movie = db.Movie{}
-		replaceMessage(ctx, usr, cht, mID, "Enter the title of the movie", &keyboardBack, stageTitle)
+		s.Payload = db.Movie{}
+		replaceMessage(ctx, s, cht, mID, ctx.L.T(txtEnterTitle), keyboardBack(ctx.L), stateTitle)
 
 	case cbqSkip:
-		if state.stage != stageAltTitle && state.stage != stageYear {
-			fixState(ctx, cbq)
+		if s.State != stateAltTitle && s.State != stateYear {
+			fixState(ctx, cbq, s)
 			return
 		}
 
 		var keyboard *tg.InlineKeyboardMarkup
 		var prefix string
-		var stage stage
-		switch state.stage {
-		case stageAltTitle:
-			stage = stageYear
-			keyboard = &keyboardSkip
-			prefix = "Maybe you know the year of release?\n\n"
-
-		case stageYear:
-			db.AddMovie(ctx, usr, &state.movie)
-			stage = stageIdle
-			keyboard = &mainKeyboard
-			prefix = mainMessage
+		var next string
+		switch s.State {
+		case stateAltTitle:
+			next = stateYear
+			keyboard = keyboardSkip(ctx.L)
+			prefix = ctx.L.T(txtEnterYear)
+
+		case stateYear:
+			b.movies.AddMovie(ctx, usr, &s.Payload)
+			next = bot.StateIdle
+			keyboard = mainKeyboard(ctx.L)
+			prefix = ctx.L.T(mainMessage)
 		}
 
-		replaceMessage(ctx, usr, cht, state.mainMessageID, prefix, keyboard, stage)
+		replaceMessage(ctx, s, cht, s.Anchor, prefix, keyboard, next)
 
 	case cbqDel:
-		if state.stage != stageIdle {
-			fixState(ctx, cbq)
+		if s.State != bot.StateIdle {
+			fixState(ctx, cbq, s)
 			return
 		}
-		lst, _ := db.ListMyMovies(ctx, usr)
+		lst, _ := b.movies.ListMyMovies(ctx, usr)
 		keyboard := makeChooseMovieKeyboard(ctx, lst)
-		replaceMessage(ctx, usr, cht, mID, "Pick the movie to delete", &keyboard, stageChooseDel)
+		replaceMessage(ctx, s, cht, mID, ctx.L.T(txtPickToDelete), &keyboard, stateChooseDel)
 
 	case cbqRate:
-		if state.stage != stageIdle {
-			fixState(ctx, cbq)
+		if s.State != bot.StateIdle {
+			fixState(ctx, cbq, s)
 			return
 		}
-		lst, _ := db.ListAllMovies(ctx, usr)
+		lst, _ := b.movies.ListAllMovies(ctx, usr)
 		keyboard := makeChooseMovieKeyboard(ctx, lst)
-		replaceMessage(ctx, usr, cht, mID, "Which one do you want to rate?", &keyboard, stageChooseRate)
+		replaceMessage(ctx, s, cht, mID, ctx.L.T(txtPickToRate), &keyboard, stateChooseRate)
 
 	case cbqUnrate:
-		if state.stage != stageIdle {
-			fixState(ctx, cbq)
+		if s.State != bot.StateIdle {
+			fixState(ctx, cbq, s)
 			return
 		}
-		lst, _ := db.ListSeenMovies(ctx, usr)
+		lst, _ := b.movies.ListSeenMovies(ctx, usr)
 		keyboard := makeChooseMovieKeyboard(ctx, lst)
-		replaceMessage(ctx, usr, cht, mID, "Unrate? Which one?", &keyboard, stageChooseUnrate)
+		replaceMessage(ctx, s, cht, mID, ctx.L.T(txtPickToUnrate), &keyboard, stateChooseUnrate)
 
 	case cbqAmazeMe:
-		if state.stage != stageIdle {
-			fixState(ctx, cbq)
+		if s.State != bot.StateIdle {
+			fixState(ctx, cbq, s)
 			return
 		}
 
-		mv, err := db.RandomMovie(ctx, usr)
+		mv, err := b.movies.RandomMovie(ctx, usr)
 		if err != nil {
 			return
 		}
 
-		movieStr := "Random movie:\n\n" + formatMovie(mv, false)
-		replaceMessage(ctx, usr, cht, mID, movieStr, &keyboardBack, stageAmazeMe)
+		movieStr := ctx.L.T(txtRandomMovie) + formatMovie(ctx.L, mv, false)
+		replaceMessage(ctx, s, cht, mID, movieStr, keyboardBack(ctx.L), stateAmazeMe)
 
 	case cbqWatched:
-		lst, _ := db.ListSeenMovies(ctx, usr)
-		replaceMessage(ctx, usr, cht, mID, joinMovies(lst, false, "You already watched these movies\n\n"), &keyboardBack, stageList)
+		lst, _ := b.movies.ListSeenMovies(ctx, usr)
+		replaceMessage(ctx, s, cht, mID, joinMovies(ctx.L, lst, false, ctx.L.T(txtWatched)), keyboardBack(ctx.L), stateList)
 
 	case cbqUnwatched:
-		lst, _ := db.ListUnseenMovies(ctx, usr)
-		replaceMessage(ctx, usr, cht, mID, joinMovies(lst, false, "You haven't seen these movies\n\n"), &keyboardBack, stageList)
+		lst, _ := b.movies.ListUnseenMovies(ctx, usr)
+		replaceMessage(ctx, s, cht, mID, joinMovies(ctx.L, lst, false, ctx.L.T(txtUnwatched)), keyboardBack(ctx.L), stateList)
 
 	case cbqAll:
-		lst, _ := db.ListAllMovies(ctx, usr)
-		replaceMessage(ctx, usr, cht, mID, joinMovies(lst, false, "All movies\n\n"), &keyboardBack, stageList)
+		lst, _ := b.movies.ListAllMovies(ctx, usr)
+		replaceMessage(ctx, s, cht, mID, joinMovies(ctx.L, lst, false, ctx.L.T(txtAll)), keyboardBack(ctx.L), stateList)
 
 	case cbqMy:
-		lst, _ := db.ListMyMovies(ctx, usr)
-		replaceMessage(ctx, usr, cht, mID, joinMovies(lst, false, "The movies you added (the rates are also yours)\n\n"), &keyboardBack, stageList)
+		lst, _ := b.movies.ListMyMovies(ctx, usr)
+		replaceMessage(ctx, s, cht, mID, joinMovies(ctx.L, lst, false, ctx.L.T(txtMy)), keyboardBack(ctx.L), stateList)
 
 	case cbqTop:
-		lst, _ := db.ListTopMovies(ctx, usr)
-		replaceMessage(ctx, usr, cht, mID, joinMovies(lst, false, "Top 10 rated movies\n\n"), &keyboardBack, stageList)
+		lst, _ := b.movies.ListTopMovies(ctx, usr)
+		replaceMessage(ctx, s, cht, mID, joinMovies(ctx.L, lst, false, ctx.L.T(txtTop)), keyboardBack(ctx.L), stateList)
 
 	case cbqLast:
-		lst, _ := db.ListLatestMovies(ctx, usr)
-		replaceMessage(ctx, usr, cht, mID, joinMovies(lst, false, "10 latest movies added\n\n"), &keyboardBack, stageList)
+		lst, _ := b.movies.ListLatestMovies(ctx, usr)
+		replaceMessage(ctx, s, cht, mID, joinMovies(ctx.L, lst, false, ctx.L.T(txtLatest)), keyboardBack(ctx.L), stateList)
 
 	case cbqHelp:
-		replaceMessage(ctx, usr, cht, mID, helpMessage, &keyboardBack, stageHelp)
-	case cbqTitle:
-		if state.stage != stageAdd {
-			fixState(ctx, cbq)
-			return
-		}
-		replaceMessage(ctx, usr, cht, mID, "What's the name of the movie?", &keyboardBack, stageTitle)
+		replaceMessage(ctx, s, cht, mID, helpText(ctx.L), keyboardBack(ctx.L), stateHelp)
 
 	case cbq1Star:
 		fallthrough
@@ -231,55 +233,76 @@ func HandleCallbackQuery(ctx *bot.Context, upd *tg.Update) {
 	case cbq4Star:
 		fallthrough
 	case cbq5Star:
+		if s.State != stateRate {
+			fixState(ctx, cbq, s)
+			return
+		}
+
 		r, err := strconv.Atoi(cbq.Data[0:1])
 		if err != nil {
 			ctx.Logger.Errorw("impossible came true", "err", err)
 		} else {
-			state.movie.Rating = float32(r)
-			db.RateMovie(ctx, usr, state.movie.ID, int(state.movie.Rating))
+			s.Payload.Rating = float32(r)
+			b.movies.RateMovie(ctx, usr, s.Payload.ID, int(s.Payload.Rating))
 		}
 
-		replaceMessage(ctx, usr, cht, mID, mainMessage, &mainKeyboard, stageIdle)
+		replaceMessage(ctx, s, cht, mID, ctx.L.T(mainMessage), mainKeyboard(ctx.L), bot.StateIdle)
 
 	default:
 		// you only can get here when you chose movie
-		txt := cbq.Data
-		keyboard := &mainKeyboard
-		prefix := mainMessage
-		state.movie = *movieByID(ctx, usr, txt)
-
-		switch state.stage {
-		case stageChooseRate:
-			keyboard = &keyboardRateOptions
-			state.stage = stageRate
-			prefix = fmt.Sprintf("How many starts for %q?", state.movie.Title)
-
-		case stageChooseUnrate:
-			db.UnrateMovie(ctx, usr, state.movie.ID)
-			state.stage = stageIdle
-
-		case stageChooseDel:
-			db.DelMovie(ctx, usr, state.movie.ID)
-			state.stage = stageIdle
+		keyboard := mainKeyboard(ctx.L)
+		prefix := ctx.L.T(mainMessage)
+		next := bot.StateIdle
+		s.Payload = *b.movieByID(ctx, usr, cbq.Data)
+
+		switch s.State {
+		case stateChooseRate:
+			keyboard = keyboardRateOptions(ctx.L)
+			next = stateRate
+			prefix = ctx.L.T(fmtHowManyStars, "Title", s.Payload.Title)
+
+		case stateChooseUnrate:
+			b.movies.UnrateMovie(ctx, usr, s.Payload.ID)
+
+		case stateChooseDel:
+			b.movies.DelMovie(ctx, usr, s.Payload.ID)
 		}
 
-		states[usr] = state
-		replaceMessage(ctx, usr, cht, state.mainMessageID, prefix, keyboard, state.stage)
+		replaceMessage(ctx, s, cht, s.Anchor, prefix, keyboard, next)
 	}
 }
 
 func makeChooseMovieKeyboard(ctx *bot.Context, lst []*db.Movie) tg.InlineKeyboardMarkup {
 	rows := make([][]tg.InlineKeyboardButton, len(lst)+1)
-	rows[0] = backButtonRow
+	rows[0] = backButtonRow(ctx.L)
 	for i, mv := range lst {
-		text := formatMovie(mv, false)
+		text := formatMovie(ctx.L, mv, false)
 		rows[i+1] = tg.NewInlineKeyboardRow(tg.NewInlineKeyboardButtonData(text, strconv.Itoa(mv.ID)))
 	}
 	keyboard := tg.NewInlineKeyboardMarkup(rows...)
 	return keyboard
 }
 
-func replaceMessage(ctx *bot.Context, usr, cht int64, msgID int, msg string, kbMarkup *tg.InlineKeyboardMarkup, stg stage) bool {
+// replaceMessage edits the message and moves the conversation to the next
+// state. The conversation stays at the current state if the message isn't
+// edited.
+func replaceMessage(ctx *bot.Context, s *session, cht int64, msgID int, msg string, kbMarkup *tg.InlineKeyboardMarkup, next string) bool {
+	prev := s.State
+	if err := s.To(next); err != nil {
+		ctx.Logger.Errorw("unexpected button", "err", err)
+		return false
+	}
+
+	if !editMessage(ctx, cht, msgID, msg, kbMarkup) {
+		s.State = prev
+		return false
+	}
+
+	s.Anchor = msgID
+	return true
+}
+
+func editMessage(ctx *bot.Context, cht int64, msgID int, msg string, kbMarkup *tg.InlineKeyboardMarkup) bool {
 	var upd tg.EditMessageTextConfig
 	if kbMarkup == nil {
 		upd = tg.NewEditMessageText(cht, msgID, msg)
@@ -287,22 +310,21 @@ func replaceMessage(ctx *bot.Context, usr, cht int64, msgID int, msg string, kbM
 		upd = tg.NewEditMessageTextAndMarkup(cht, msgID, msg, *kbMarkup)
 	}
 
-	if _, err := ctx.Bot.Send(upd); err != nil {
+	if err := ctx.Bot.EditMessage(upd); err != nil {
 		ctx.Logger.Errorw("failed updating message", "err", err)
 		return false
 	}
 
-	state := states[usr]
-	state.stage = stg
-	state.mainMessageID = msgID
-	states[usr] = state
-
 	return true
 }
 
-func fixState(ctx *bot.Context, cbq *tg.CallbackQuery) {
-	usr := cbq.From.ID
-	cht := cbq.Message.From.ID
+// fixState gets the conversation back to the main keyboard when the user
+// presses a button that doesn't belong to the current state
+func fixState(ctx *bot.Context, cbq *tg.CallbackQuery, s *session) {
+	cht := cbq.Message.Chat.ID
 
-	replaceMessage(ctx, usr, cht, cbq.Message.MessageID, mainMessage, &mainKeyboard, stageIdle)
+	s.Reset()
+	if editMessage(ctx, cht, cbq.Message.MessageID, ctx.L.T(mainMessage), mainKeyboard(ctx.L)) {
+		s.Anchor = cbq.Message.MessageID
+	}
 }
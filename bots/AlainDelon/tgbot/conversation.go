@@ -0,0 +1,170 @@
+package tgbot
+
+import (
+	"botfarm/bot"
+	"botfarm/bots/AlainDelon/db"
+	"strconv"
+	"strings"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Conversation states. The conversation is anchored to the main message with
+// the keyboard, which is edited on every step. The movie being added, rated,
+// etc. is carried as the payload.
+const (
+	stateAmazeMe = "amazeMe"
+	stateList    = "list"
+	stateHelp    = "help"
+
+	stateTitle    = "title"
+	stateAltTitle = "altTitle"
+	stateYear     = "year"
+
+	stateChooseDel    = "chooseDel"
+	stateChooseRate   = "chooseRate"
+	stateChooseUnrate = "chooseUnrate"
+	stateRate         = "rate"
+)
+
+type session = bot.Session[db.Movie]
+
+type handler func(*bot.Context, *tg.Update, *session)
+
+// Routes registers the bot handlers in the router. Every handler gets a copy of
+// ctx with the user and the user's language set and the user's conversation
+// session, which is kept in store. Movies are kept in movies.
+func Routes(r *bot.Router, ctx *bot.Context, store bot.StateStore, movies Store, convTimeout time.Duration) {
+	r.Use(ctx.I18n.Middleware())
+
+	b := &tbot{movies: movies}
+	conv := b.newConversation(ctx, convTimeout)
+	conv.Persist(store)
+	conv.Unavailable(func(req *bot.Request) {
+		sessionUnavailable(ctx.CloneWith(req.User), req)
+	})
+
+	with := func(h handler) bot.HandlerFunc {
+		return func(req *bot.Request) {
+			err := conv.With(req, func(s *session) {
+				h(ctx.CloneWith(req.User), req.Update, s)
+			})
+			if err != nil {
+				sessionUnavailable(ctx.CloneWith(req.User), req)
+			}
+		}
+	}
+
+	r.Command(cmdStart, with(b.HandleStart))
+	r.Command(bot.CmdCancel, with(handleCancel))
+	r.Command(bot.CmdLanguage, ctx.I18n.Handler(func(req *bot.Request, txt string) {
+		with(func(ctx *bot.Context, upd *tg.Update, s *session) {
+			handleLanguage(ctx, upd, s, txt)
+		})(req)
+	}))
+	r.UnknownCommand(with(deleteUserMessage))
+	r.Text(func(req *bot.Request) {
+		conv.Handle(req)
+		deleteUserMessage(ctx.CloneWith(req.User), req.Update, nil)
+	})
+	r.DefaultCallback(with(b.HandleCallbackQuery))
+}
+
+func (b *tbot) newConversation(ctx *bot.Context, timeout time.Duration) *bot.Conversation[db.Movie] {
+	c := bot.NewConversation[db.Movie](timeout)
+
+	step := func(f func(*bot.Context, *tg.Message, *session) string) bot.StepHandler[db.Movie] {
+		return func(req *bot.Request, s *session) string {
+			return f(ctx.CloneWith(req.User), req.Message(), s)
+		}
+	}
+
+	c.State(bot.StateIdle, nil,
+		stateTitle, stateChooseDel, stateChooseRate, stateChooseUnrate, stateAmazeMe, stateList, stateHelp)
+	c.State(stateAmazeMe, nil, stateList, stateHelp)
+	c.State(stateList, nil, stateList, stateHelp)
+	c.State(stateHelp, nil, stateList)
+
+	c.State(stateTitle, step(stepTitle), stateAltTitle)
+	c.State(stateAltTitle, step(stepAltTitle), stateYear)
+	c.State(stateYear, step(b.stepYear))
+
+	c.State(stateChooseDel, nil)
+	c.State(stateChooseRate, nil, stateRate)
+	c.State(stateChooseUnrate, nil)
+	c.State(stateRate, nil)
+
+	return c
+}
+
+func stepTitle(ctx *bot.Context, msg *tg.Message, s *session) string {
+	s.Payload.Title = strings.TrimSpace(msg.Text)
+
+	prefix := ctx.L.T(fmtAltTitle, "Title", s.Payload.Title)
+	if !editAnchor(ctx, s, msg.Chat.ID, prefix, keyboardSkip(ctx.L)) {
+		return s.State
+	}
+
+	return stateAltTitle
+}
+
+func stepAltTitle(ctx *bot.Context, msg *tg.Message, s *session) string {
+	txt := strings.TrimSpace(msg.Text)
+	if len(txt) > 0 && s.Payload.Title != txt {
+		s.Payload.AltTitle = txt
+	}
+
+	prefix := ctx.L.T(fmtReleaseYear, "Title", s.Payload.Title)
+	if !editAnchor(ctx, s, msg.Chat.ID, prefix, keyboardSkip(ctx.L)) {
+		return s.State
+	}
+
+	return stateYear
+}
+
+func (b *tbot) stepYear(ctx *bot.Context, msg *tg.Message, s *session) string {
+	txt := strings.TrimSpace(msg.Text)
+
+	year, err := strconv.Atoi(txt)
+	if err != nil || year < 1850 || year > time.Now().UTC().Year()+2 {
+		prefix := ctx.L.T(fmtInvalidYear, "Year", txt)
+		editAnchor(ctx, s, msg.Chat.ID, prefix, keyboardSkip(ctx.L))
+		return s.State
+	}
+
+	s.Payload.Year = int16(year)
+	b.movies.AddMovie(ctx, s.User, &s.Payload)
+
+	editAnchor(ctx, s, msg.Chat.ID, ctx.L.T(mainMessage), mainKeyboard(ctx.L))
+	return bot.StateIdle
+}
+
+// handleCancel gets back to the main keyboard from any state
+func handleCancel(ctx *bot.Context, upd *tg.Update, s *session) {
+	defer deleteUserMessage(ctx, upd, s)
+
+	s.Reset()
+	if s.Anchor != 0 {
+		editAnchor(ctx, s, upd.Message.Chat.ID, ctx.L.T(mainMessage), mainKeyboard(ctx.L))
+	}
+}
+
+// editAnchor replaces the text and the keyboard of the main message
+func editAnchor(ctx *bot.Context, s *session, cht int64, msg string, kbMarkup *tg.InlineKeyboardMarkup) bool {
+	return editMessage(ctx, cht, s.Anchor, msg, kbMarkup)
+}
+
+// sessionUnavailable asks the user to retry when the conversation session
+// can't be loaded
+func sessionUnavailable(ctx *bot.Context, req *bot.Request) {
+	if cbq := req.Update.CallbackQuery; cbq != nil {
+		if _, err := ctx.Bot.Request(tg.NewCallback(cbq.ID, "")); err != nil {
+			ctx.Logger.Errorw("failed answering callback query", "err", err)
+		}
+	}
+
+	if _, err := ctx.Bot.SendMessage(tg.NewMessage(req.Chat, ctx.L.T(bot.TxtSessionUnavailable))); err != nil {
+		ctx.Logger.Errorw("failed sending message", "err", err)
+	}
+}
@@ -11,107 +11,124 @@ import (
 	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// Keys of messages in the catalog (see Locales)
 const (
-	introductionMessage = "Let me introduce myself. I'm Alain Fabien Maurice Marcel Delon bot, I take care of movies. With my help you can add movies you would like to watch, rate your and other's movies."
-	mainMessage         = "So what you're gonna do?"
-	startMessage        = introductionMessage + "\n\n" + mainMessage
-	helpMessage         = introductionMessage + `
-
-For CLI nerds there's a bunch of one-line commands. These commands, however, require following the precise message format. []'d values are optional.
-/add - add new movie. Format: /add "<title>"/["<alternative title>"][(<year>)]
-/del - delete movie. Format: /del <movie ID>
-/rate - rate movie (1-5). Format: /rate <movie ID> <rating>
-/unrate - unrate movie. Format: /unrate <movie ID>
-/amazeme - show a random unseen movie
-/unseen - list unseen movies
-/seen - list seen movies
-/all - list both unseen and seen movies
-/top - list top 10 movies
-/latest - list 10 latest movies
-/find - find movie by name or year. Format: /find <case-insensitive name or year>
-/help - this help`
+	introductionMessage = "introduction"
+	mainMessage         = "main"
+	helpMessage         = "help"
+
+	txtEnterTitle   = "ask.title"
+	txtEnterYear    = "ask.year"
+	txtPickToDelete = "ask.delete"
+	txtPickToRate   = "ask.rate"
+	txtPickToUnrate = "ask.unrate"
+	txtRandomMovie  = "movies.random"
+	txtWatched      = "movies.watched"
+	txtUnwatched    = "movies.unwatched"
+	txtAll          = "movies.all"
+	txtMy           = "movies.my"
+	txtTop          = "movies.top"
+	txtLatest       = "movies.latest"
+	txtNotRated     = "movie.not_rated"
+
+	fmtAltTitle       = "ask.alt_title"    // Title
+	fmtReleaseYear    = "ask.release_year" // Title
+	fmtHowManyStars   = "ask.stars"        // Title
+	fmtInvalidYear    = "invalid_year"     // Year
+	fmtInvalidMovieID = "invalid_movie_id" // ID
 )
 
-type command struct {
-	name string
-	len  int
-}
-
-var (
-	cmdStart = makeCommand("start")
+const (
+	cmdStart = "start"
 )
 
-type stage uint
-
-type state struct {
-	stage
-	mainMessageID int
-	movie         db.Movie
+// Store keeps users, movies and their ratings (see db.Movies)
+type Store interface {
+	AddUser(ctx *bot.Context, usr, cht int64) error
+	AddMovie(ctx *bot.Context, usr int64, mv *db.Movie) error
+	DelMovie(ctx *bot.Context, usr int64, movieID int) error
+	GetMovie(ctx *bot.Context, usr int64, id int) (*db.Movie, error)
+	RandomMovie(ctx *bot.Context, usr int64) (*db.Movie, error)
+	RateMovie(ctx *bot.Context, usr int64, movieID int, rating int) error
+	UnrateMovie(ctx *bot.Context, usr int64, movie int) (bool, error)
+	ListSeenMovies(ctx *bot.Context, usr int64) ([]*db.Movie, error)
+	ListUnseenMovies(ctx *bot.Context, usr int64) ([]*db.Movie, error)
+	ListAllMovies(ctx *bot.Context, usr int64) ([]*db.Movie, error)
+	ListMyMovies(ctx *bot.Context, usr int64) ([]*db.Movie, error)
+	ListTopMovies(ctx *bot.Context, usr int64) ([]*db.Movie, error)
+	ListLatestMovies(ctx *bot.Context, usr int64) ([]*db.Movie, error)
 }
 
-var states = make(map[int64]*state)
-
-const (
-	stageIdle stage = iota
-	stageAdd
-	stageDel
-	stageRate
-	stageUnrate
-	stageAmazeMe
-	stageFind
-	stageList
-	stageHelp
-
-	stageTitle
-	stageAltTitle
-	stageYear
-
-	stageChooseDel
-	stageChooseRate
-	stageChooseUnrate
-)
+// tbot handles updates of users with movies kept in the store
+type tbot struct {
+	movies Store
+}
 
-func makeCommand(c string) *command {
-	return &command{
-		name: c,
-		len:  len(c) + 2,
+// Menu lists commands of the bot menu (see bot.SetCommands)
+func Menu() []bot.MenuCommand {
+	return []bot.MenuCommand{
+		{Command: cmdStart, Description: "menu.start"},
+		{Command: bot.CmdCancel, Description: "menu.cancel"},
+		{Command: bot.CmdLanguage, Description: "menu.language"},
 	}
 }
 
-func HandleCommand(ctx *bot.Context, upd *tg.Update) {
+func (b *tbot) HandleStart(ctx *bot.Context, upd *tg.Update, s *session) {
 	msg := upd.Message
-	cmd := msg.Command()
 	usr := msg.From.ID
 	cht := msg.Chat.ID
 
-	if states[usr] == nil {
-		states[usr] = &state{stage: stageIdle, mainMessageID: msg.MessageID}
+	defer deleteUserMessage(ctx, upd, s)
+
+	err := b.movies.AddUser(ctx, usr, cht)
+	if err != nil {
+		ctx.Logger.Errorw("failed adding user", "err", err)
+		return
 	}
 
-	switch cmd {
-	case cmdStart.name:
-		err := db.AddUser(ctx, usr, cht)
-		if err != nil {
-			ctx.Logger.Errorw("failed adding user", "err", err)
-			return
-		}
+	s.Reset()
+	sendAnchor(ctx, s, cht, ctx.L.T(introductionMessage)+"\n\n"+ctx.L.T(mainMessage))
+}
 
-		m := tg.NewMessage(cht, startMessage)
-		m.ReplyMarkup = mainKeyboard
-		if _, err := ctx.Bot.Send(m); err != nil {
-			ctx.Logger.Errorw("failed sending response to user", "err", err)
-			return
-		}
+// handleLanguage shows the response to bot.CmdLanguage with the main keyboard
+// in the new language
+func handleLanguage(ctx *bot.Context, upd *tg.Update, s *session, txt string) {
+	defer deleteUserMessage(ctx, upd, s)
+
+	txt += "\n\n" + ctx.L.T(mainMessage)
+	s.Reset()
+	if s.Anchor == 0 || !editAnchor(ctx, s, upd.Message.Chat.ID, txt, mainKeyboard(ctx.L)) {
+		sendAnchor(ctx, s, upd.Message.Chat.ID, txt)
+	}
+}
 
-		states[usr] = &state{mainMessageID: msg.MessageID}
+// sendAnchor sends the main message with the keyboard
+func sendAnchor(ctx *bot.Context, s *session, cht int64, txt string) {
+	m := tg.NewMessage(cht, txt)
+	m.ReplyMarkup = mainKeyboard(ctx.L)
+	sent, err := ctx.Bot.SendMessage(m)
+	if err != nil {
+		ctx.Logger.Errorw("failed sending response to user", "err", err)
+		return
 	}
 
-	dm := tg.NewDeleteMessage(cht, msg.MessageID)
-	// ignore bot.Send errors because it always fails to deserialize response
-	ctx.Bot.Send(dm)
+	s.Anchor = sent.MessageID
+}
+
+func helpText(l bot.Localizer) string {
+	return l.T(introductionMessage) + "\n\n" + l.T(helpMessage)
+}
+
+// deleteUserMessage keeps the chat clean, so the main message with the keyboard
+// stays visible
+func deleteUserMessage(ctx *bot.Context, upd *tg.Update, _ *session) {
+	msg := upd.Message
+	if err := ctx.Bot.DeleteMessage(msg.Chat.ID, msg.MessageID); err != nil {
+		ctx.Logger.Errorw("failed deleting user message", "err", err)
+	}
 }
 
-func joinMovies(movies []*db.Movie, showID bool, prefix ...string) string {
+func joinMovies(l bot.Localizer, movies []*db.Movie, showID bool, prefix ...string) string {
 	prefixLen := 0
 	for _, s := range prefix {
 		prefixLen += len(s)
@@ -122,14 +139,14 @@ func joinMovies(movies []*db.Movie, showID bool, prefix ...string) string {
 	sb.WriteString(strings.Join(prefix, ""))
 
 	for _, movie := range movies {
-		line := formatMovie(movie, showID)
+		line := formatMovie(l, movie, showID)
 		sb.WriteString(line)
 	}
 
 	return sb.String()
 }
 
-func formatMovie(mv *db.Movie, showID bool) string {
+func formatMovie(l bot.Localizer, mv *db.Movie, showID bool) string {
 	fmtStr := []string{}
 	args := []any{}
 	if showID {
@@ -151,7 +168,8 @@ func formatMovie(mv *db.Movie, showID bool) string {
 	}
 
 	if mv.Rating < 0 {
-		fmtStr = append(fmtStr, " - no ⭐ yet\n")
+		fmtStr = append(fmtStr, " - %s\n")
+		args = append(args, l.T(txtNotRated))
 	} else {
 		fmtStr = append(fmtStr, " - %.2f ⭐\n")
 		args = append(args, mv.Rating)
@@ -160,73 +178,17 @@ func formatMovie(mv *db.Movie, showID bool) string {
 	return fmt.Sprintf(strings.Join(fmtStr, ""), args...)
 }
 
-func HandleUpdate(ctx *bot.Context, upd *tg.Update) {
-	msg := upd.Message
-	txt := strings.TrimSpace(msg.Text)
-	usr := msg.From.ID
-	cht := msg.Chat.ID
-
-	if states[usr] == nil {
-		states[usr] = &state{stage: stageIdle, mainMessageID: msg.MessageID}
-	}
-
-	state := states[usr]
-
-	var keyboard *tg.InlineKeyboardMarkup
-	var prefix string
-
-	switch state.stage {
-	case stageTitle:
-		state.movie.Title = txt
-		state.stage = stageAltTitle
-		keyboard = &keyboardSkip
-		prefix = fmt.Sprintf("You may enter an alternative title for %q or just skip it", state.movie.Title)
-
-	case stageAltTitle:
-		if len(txt) > 0 && state.movie.Title != txt {
-			state.movie.AltTitle = txt
-		}
-
-		state.stage = stageYear
-		keyboard = &keyboardSkip
-		prefix = fmt.Sprintf("Maybe you know the release year of %q?", state.movie.Title)
-
-	case stageYear:
-		year, err := strconv.Atoi(txt)
-		if err != nil || year < 1850 || year > time.Now().UTC().Year()+2 {
-			prefix = fmt.Sprintf("Nah, the value %q doesn't seem like a valid release year, isn't it? Enter correct one or skip", txt)
-			keyboard = &keyboardSkip
-		} else {
-			state.movie.Year = int16(year)
-
-			db.AddMovie(ctx, usr, &state.movie)
-
-			state.stage = stageIdle
-			keyboard = &mainKeyboard
-			prefix = mainMessage
-		}
-
-	}
-
-	states[usr] = state
-	replaceMessage(ctx, usr, cht, state.mainMessageID, prefix, keyboard, state.stage)
-
-	dm := tg.NewDeleteMessage(cht, msg.MessageID)
-	// ignore bot.Send errors because it always fails to deserialize response
-	ctx.Bot.Send(dm)
-}
-
-func movieByID(ctx *bot.Context, usr int64, strID string) *db.Movie {
+func (b *tbot) movieByID(ctx *bot.Context, usr int64, strID string) *db.Movie {
 	var mv *db.Movie
 	id, err := strconv.Atoi(strID)
 	if err != nil {
-		cb := tg.NewCallbackWithAlert(time.Now().UTC().String(), fmt.Sprintf("The value %s doesn't seem a valid movie ID, isn't it?", strID))
+		cb := tg.NewCallbackWithAlert(time.Now().UTC().String(), ctx.L.T(fmtInvalidMovieID, "ID", strID))
 		if _, err = ctx.Bot.Request(cb); err != nil {
 			ctx.Logger.Errorw("failed sending alert message", "err", err)
 		}
 		mv = &db.Movie{}
 	} else {
-		mv, _ = db.GetMovie(ctx, usr, id)
+		mv, _ = b.movies.GetMovie(ctx, usr, id)
 	}
 
 	return mv
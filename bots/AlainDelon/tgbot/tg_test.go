@@ -0,0 +1,231 @@
+package tgbot_test
+
+import (
+	"botfarm/bot"
+	"botfarm/bot/tgtest"
+	"botfarm/bots/AlainDelon/db"
+	"botfarm/bots/AlainDelon/tgbot"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	usr    = 42
+	anchor = 100 // the message with the keyboard the user presses buttons under
+)
+
+// chat runs the bot against the fake Telegram server with movies kept in
+// memory
+type chat struct {
+	t   *testing.T
+	srv *tgtest.Server
+	db  *db.Memory
+}
+
+func newChat(t *testing.T) *chat {
+	l := zap.NewNop().Sugar()
+
+	srv := tgtest.NewServer()
+	t.Cleanup(srv.Close)
+	tr, err := bot.NewTransport("test", tgtest.Token, srv.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalog, err := bot.LoadCatalog(tgbot.Locales, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := db.NewMemory()
+	ctx := &bot.Context{Name: "test", Bot: tr, Logger: l, I18n: bot.NewI18n("test", catalog, nil, l)}
+	router := bot.NewRouter("test", l)
+	tgbot.Routes(router, ctx, bot.NewMemoryStore(), mem, time.Minute)
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	updates, err := tr.Updates(runCtx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for u := range updates {
+			u := u
+			router.Handle(&u)
+		}
+	}()
+
+	return &chat{t: t, srv: srv, db: mem}
+}
+
+// wait waits for n more requests of the method than were sent and returns
+// the new ones
+func (c *chat) wait(method string, sent, n int) []tgtest.Request {
+	c.t.Helper()
+
+	reqs, ok := c.srv.WaitRequests(method, sent+n, 5*time.Second)
+	if !ok {
+		c.t.Fatalf("expected %d %s requests, got %d", n, method, len(reqs)-sent)
+	}
+	return reqs[sent:]
+}
+
+// say sends the text to the bot and returns the text the anchor is edited to,
+// if it is. The bot deletes every message of the user after handling it.
+func (c *chat) say(text string) string {
+	c.t.Helper()
+
+	edited := len(c.srv.Requests("editMessageText"))
+	deleted := len(c.srv.Requests("deleteMessage"))
+	c.srv.SendText(usr, text)
+	c.wait("deleteMessage", deleted, 1)
+
+	edits := c.srv.Requests("editMessageText")[edited:]
+	if len(edits) == 0 {
+		return ""
+	}
+	return edits[len(edits)-1].Params.Get("text")
+}
+
+// press presses the button under the anchor and returns the text the anchor
+// is edited to
+func (c *chat) press(data string) tgtest.Request {
+	c.t.Helper()
+
+	edited := len(c.srv.Requests("editMessageText"))
+	c.srv.PressButton(usr, anchor, data)
+	edit := c.wait("editMessageText", edited, 1)[0]
+	if id := edit.Params.Get("message_id"); id != "100" {
+		c.t.Errorf("expected the anchor to be edited, got message %s", id)
+	}
+	return edit
+}
+
+func (c *chat) expect(got, want string) {
+	c.t.Helper()
+
+	if !strings.Contains(got, want) {
+		c.t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func (c *chat) expectPress(data, want string) {
+	c.t.Helper()
+	c.expect(c.press(data).Params.Get("text"), want)
+}
+
+func (c *chat) movies() []*db.Movie {
+	c.t.Helper()
+
+	movies, err := c.db.ListAllMovies(nil, usr)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return movies
+}
+
+func (c *chat) start() {
+	c.t.Helper()
+
+	sent := len(c.srv.Requests("sendMessage"))
+	c.srv.SendText(usr, "/start")
+	c.expect(c.wait("sendMessage", sent, 1)[0].Params.Get("text"), "So what you're gonna do?")
+}
+
+func TestAddMovie(t *testing.T) {
+	c := newChat(t)
+	c.start()
+
+	c.expectPress("cbqAdd", "Enter the title of the movie")
+	c.expect(c.say("Heat"), `alternative title for "Heat"`)
+	c.expectPress("cbqSkip", "Maybe you know the year of release?")
+	c.expect(c.say("1800"), `"1800" doesn't seem like a valid release year`)
+	c.expect(c.say("1995"), "So what you're gonna do?")
+
+	c.expectPress("cbqAdd", "Enter the title of the movie")
+	c.say("Alien")
+	c.expect(c.say("Чужой"), `release year of "Alien"`)
+	c.expectPress("cbqSkip", "So what you're gonna do?")
+
+	movies := c.movies()
+	if len(movies) != 2 || *movies[0] != (db.Movie{ID: 2, Title: "Alien", AltTitle: "Чужой", Rating: -1}) ||
+		*movies[1] != (db.Movie{ID: 1, Title: "Heat", Year: 1995, Rating: -1}) {
+		t.Errorf("unexpected movies %+v", movies)
+	}
+
+	// text isn't a title unless the bot asked for it
+	if txt := c.say("Terminator"); txt != "" {
+		t.Errorf("expected the anchor not to change, got %q", txt)
+	}
+	c.expectPress("cbqMy", `"Alien"/"Чужой" - no ⭐ yet`)
+	c.expectPress("cbqBack", "So what you're gonna do?")
+	if len(c.movies()) != 2 {
+		t.Errorf("expected 2 movies, got %+v", c.movies())
+	}
+}
+
+func TestRateMovie(t *testing.T) {
+	c := newChat(t)
+	for _, title := range []string{"Heat", "Alien"} {
+		c.db.AddMovie(nil, usr, &db.Movie{Title: title})
+	}
+	c.start()
+
+	choose := c.press("cbqRate")
+	c.expect(choose.Params.Get("text"), "Which one do you want to rate?")
+	c.expect(choose.Params.Get("reply_markup"), `"callback_data":"1"`)
+	c.expectPress("1", `How many starts for "Heat"?`)
+	c.expectPress("4stars", "So what you're gonna do?")
+	if r := c.db.Rating(usr, 1); r != 4 {
+		t.Errorf("expected rating 4, got %d", r)
+	}
+
+	top := c.press("cbqTop").Params.Get("text")
+	if !strings.Contains(top, `"Heat" - 4.00 ⭐`) || strings.Index(top, "Heat") > strings.Index(top, "Alien") {
+		t.Errorf("expected the rated movie first, got %q", top)
+	}
+	c.expectPress("cbqBack", "So what you're gonna do?")
+	c.expectPress("cbqWatched", `"Heat"`)
+	c.expectPress("cbqBack", "So what you're gonna do?")
+
+	// stars are accepted only after a movie is chosen
+	c.expectPress("5stars", "So what you're gonna do?")
+	if r := c.db.Rating(usr, 1); r != 4 {
+		t.Errorf("expected rating 4, got %d", r)
+	}
+
+	c.expectPress("cbqUnrate", "Unrate? Which one?")
+	c.expectPress("1", "So what you're gonna do?")
+	if r := c.db.Rating(usr, 1); r != 0 {
+		t.Errorf("expected the movie to be unrated, got %d", r)
+	}
+
+	c.expectPress("cbqDel", "Pick the movie to delete")
+	c.expectPress("2", "So what you're gonna do?")
+	if movies := c.movies(); len(movies) != 1 || movies[0].Title != "Heat" {
+		t.Errorf("expected Alien to be deleted, got %+v", movies)
+	}
+}
+
+func TestEditFailure(t *testing.T) {
+	c := newChat(t)
+	c.start()
+
+	// the conversation stays at the main keyboard if the anchor isn't edited
+	c.srv.Fail("editMessageText", 1, 400, "Bad Request: message to edit not found")
+	c.press("cbqAdd")
+	if txt := c.say("Heat"); txt != "" {
+		t.Errorf("expected the text not to be taken as a title, got %q", txt)
+	}
+
+	c.expectPress("cbqAdd", "Enter the title of the movie")
+	c.expect(c.say("Heat"), `alternative title for "Heat"`)
+	c.expectPress("cbqBack", "So what you're gonna do?")
+	if movies := c.movies(); len(movies) != 0 {
+		t.Errorf("expected no movies, got %+v", movies)
+	}
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"botfarm/bot"
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Chats returns chats of all users (see bot.AdminSource)
+func (d *Database) Chats(ctx context.Context) ([]int64, error) {
+	var chats []int64
+	err := d.db.Do(ctx, "Chats", func(ctx context.Context, q bot.Querier) error {
+		rows, err := q.QueryContext(ctx, `SELECT chat_id FROM users`)
+		if err != nil {
+			return errors.Wrap(err, "failed fetching list of chats")
+		}
+		defer rows.Close()
+
+		chats = chats[:0]
+		for rows.Next() {
+			var cht int64
+			if err = rows.Scan(&cht); err != nil {
+				return errors.Wrap(err, "failed reading chat ID")
+			}
+
+			chats = append(chats, cht)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chats, nil
+}
+
+// Stats counts users and memos (see bot.AdminSource)
+func (d *Database) Stats(ctx context.Context) ([]bot.Field, error) {
+	var users, memos, active, created int
+	err := d.db.Do(ctx, "Stats", func(ctx context.Context, q bot.Querier) error {
+		return q.QueryRowContext(ctx, `SELECT
+(SELECT count(*) FROM users),
+(SELECT count(*) FROM memos),
+(SELECT count(*) FROM memos WHERE state=$1),
+(SELECT count(*) FROM memos WHERE created>$2)`,
+			MemoStateActive, clk.Now().UTC().Add(7*minus24Hours)).Scan(&users, &memos, &active, &created)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed counting users and memos")
+	}
+
+	return []bot.Field{
+		{Name: "Users", Value: users},
+		{Name: "Memos", Value: memos},
+		{Name: "Active memos", Value: active},
+		{Name: "Memos created (7 days)", Value: created},
+	}, nil
+}
+
+// UserInfo describes the reminder settings and memos of the user (see
+// bot.AdminSource)
+func (d *Database) UserInfo(ctx context.Context, usr int64) ([]bot.Field, error) {
+	var cht int64
+	var remind bool
+	var times []string
+	var tz string
+	var memos, active int
+	var last sql.NullTime
+	err := d.db.Do(ctx, "UserInfo", func(ctx context.Context, q bot.Querier) error {
+		err := q.QueryRowContext(ctx, `SELECT chat_id, remind, timezone FROM users WHERE user_id=$1`,
+			usr).Scan(&cht, &remind, &tz)
+		if err != nil {
+			return err
+		}
+
+		rows, err := q.QueryContext(ctx, `SELECT remind_at, weekdays FROM remind_times WHERE user_id=$1 ORDER BY remind_at`,
+			usr)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		times = times[:0]
+		for rows.Next() {
+			var t RemindTime
+			if err = rows.Scan(&t.At, &t.Days); err != nil {
+				return err
+			}
+			times = append(times, t.String())
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		return q.QueryRowContext(ctx, `SELECT count(*), count(*) FILTER (WHERE state=$2), max(created)
+FROM memos
+WHERE chat_id=$1`, cht, MemoStateActive).Scan(&memos, &active, &last)
+	})
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, bot.ErrUnknownUser
+	case err != nil:
+		return nil, errors.Wrap(err, "failed fetching user")
+	}
+
+	lastMemo := "never"
+	if last.Valid {
+		lastMemo = last.Time.UTC().Format(time.RFC3339)
+	}
+
+	return []bot.Field{
+		{Name: "Chat", Value: cht},
+		{Name: "Reminder", Value: remind},
+		{Name: "Remind at", Value: strings.Join(times, "; ")},
+		{Name: "Time zone", Value: tz},
+		{Name: "Memos", Value: memos},
+		{Name: "Active memos", Value: active},
+		{Name: "Last memo", Value: lastMemo},
+	}, nil
+}
@@ -9,8 +9,6 @@ import (
 
 	"github.com/jmhodges/clock"
 	"github.com/pkg/errors"
-
-	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 var (
@@ -27,24 +25,32 @@ const (
 )
 
 type Database struct {
-	db            *sql.DB
-	RetryAttempts int
-	RetryDelay    time.Duration
-	Timeout       time.Duration
+	db *bot.DB
 }
 
-func NewDatabase(connStr string, attempts int, delay, timeout time.Duration) (*Database, error) {
+func NewDatabase(name, connStr string, cfg bot.DBConfig) (*Database, error) {
 	// connection string should look like postgresql://localhost:5432/finding_memo?user=admn&password=passwd
-	d, err := sql.Open("pgx", connStr)
+	d, err := bot.ConnectDB(name, connStr, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = d.Ping(); err != nil {
-		return nil, err
-	}
+	return &Database{db: d}, nil
+}
+
+// Conn returns the database connection pool
+func (d *Database) Conn() *sql.DB {
+	return d.db.DB
+}
+
+// Ping checks the database connection
+func (d *Database) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
 
-	return &Database{db: d, RetryAttempts: attempts, RetryDelay: delay, Timeout: timeout}, nil
+// Close closes the database
+func (d *Database) Close() error {
+	return d.db.Close()
 }
 
 func (d *Database) GetAllMemos(usr int64, short bool) ([]Memo, error) {
@@ -52,14 +58,18 @@ func (d *Database) GetAllMemos(usr int64, short bool) ([]Memo, error) {
 FROM memos
 WHERE chat_id=$1 AND (state=$2 OR (state IN ($3, $4) AND timestamp>$5))
 ORDER BY priority ASC`
-	rows, err := d.db.Query(query, usr, MemoStateActive, MemoStateDone,
-		MemoStateDeleted, clk.Now().UTC().Add(minus24Hours))
-	if err != nil {
-		return []Memo{}, err
-	}
-	defer rows.Close()
+	var memos []Memo
+	err := d.db.Do(context.Background(), "GetAllMemos", func(ctx context.Context, q bot.Querier) error {
+		rows, err := q.QueryContext(ctx, query, usr, MemoStateActive, MemoStateDone,
+			MemoStateDeleted, clk.Now().UTC().Add(minus24Hours))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
 
-	memos, err := extractMemos(rows)
+		memos, err = extractMemos(rows)
+		return err
+	})
 	if err != nil {
 		return []Memo{}, err
 	}
@@ -79,8 +89,10 @@ func (d *Database) DeleteMemo(usr int64, n int) error {
 // GetActiveMemoCount returns the count of active memos for a user
 func (d *Database) GetActiveMemoCount(usr int64) (int, error) {
 	var n int
-	err := d.db.QueryRow(`SELECT count(*) FROM memos WHERE chat_id=$1 AND state=$2`,
-		usr, MemoStateActive).Scan(&n)
+	err := d.db.Do(context.Background(), "GetActiveMemoCount", func(ctx context.Context, q bot.Querier) error {
+		return q.QueryRowContext(ctx, `SELECT count(*) FROM memos WHERE chat_id=$1 AND state=$2`,
+			usr, MemoStateActive).Scan(&n)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -90,30 +102,27 @@ func (d *Database) GetActiveMemoCount(usr int64) (int, error) {
 // CreateUser creates a new user or updates chat ID for the case when the bot was deleted earlier
 // UTC timezone is used by default
 func (d *Database) CreateUser(usr int64) error {
-	tx, err := d.db.BeginTx(context.Background(), repeatableReadIsoLevel)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	var cID int64
-	err = tx.QueryRow(`SELECT chat_id FROM users WHERE user_id=$1`, usr).Scan(&cID)
-
-	switch {
-	case err == sql.ErrNoRows:
-		if _, err := tx.Exec(`INSERT INTO users(user_id, remind, remind_at, timezone)
-VALUES($1, $2, $3, $4, $5)`, usr, true, DefaultTime, DefaultTimeZone); err != nil {
-			return errors.Wrap(err, "failed inserting user")
+	return d.db.Tx(context.Background(), "CreateUser", repeatableReadIsoLevel, func(ctx context.Context, tx *sql.Tx) error {
+		var cID int64
+		err := tx.QueryRowContext(ctx, `SELECT chat_id FROM users WHERE user_id=$1`, usr).Scan(&cID)
+
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			if _, err := tx.ExecContext(ctx, `INSERT INTO users(user_id, chat_id, remind, remind_at, timezone)
+VALUES($1, $2, $3, $4, $5)`, usr, usr, true, DefaultTime, DefaultTimeZone); err != nil {
+				return errors.Wrap(err, "failed inserting user")
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO remind_times(user_id, remind_at, weekdays)
+VALUES($1, $2, $3)`, usr, DefaultTime, EveryDay); err != nil {
+				return errors.Wrap(err, "failed inserting reminder time")
+			}
+
+		case err != nil:
+			return errors.Wrap(err, "failed creating user")
 		}
 
-	case err != nil:
-		return errors.Wrap(err, "failed creating user")
-	}
-
-	if err := tx.Commit(); err != nil {
-		return errors.Wrap(err, "failed adding user")
-	}
-	return nil
+		return nil
+	})
 }
 
 func extractMemos(rows *sql.Rows) ([]Memo, error) {
@@ -136,12 +145,12 @@ func extractMemos(rows *sql.Rows) ([]Memo, error) {
 		memos = append(memos, m)
 	}
 
-	return memos, nil
+	return memos, rows.Err()
 }
 
 // AddMemo inserts new memo at the end of the memo list
 func (d *Database) AddMemo(c int64, text string) error {
-	if _, err := d.db.Exec(`INSERT INTO memos(chat_id, text, state, priority, timestamp)
+	if _, err := d.db.Exec(context.Background(), "AddMemo", `INSERT INTO memos(chat_id, text, state, priority, timestamp)
 VALUES($1, $2, $3, COALESCE(
 (SELECT max(priority) FROM memos WHERE chat_id=$1 AND state=$3), 0)+1, $4)`, c, text, MemoStateActive, clk.Now().UTC()); err != nil {
 		return errors.Wrap(err, "failed to add memo")
@@ -152,25 +161,18 @@ VALUES($1, $2, $3, COALESCE(
 
 // InsertMemo inserts new memo at the beginning of the memo list
 func (d *Database) InsertMemo(c int64, text string) error {
-	tx, err := d.db.BeginTx(context.Background(), repeatableReadIsoLevel)
-	if err != nil {
-		return errors.Wrap(err, "failed to begin transaction")
-	}
-	defer tx.Rollback()
-
-	if _, err = tx.Exec(`UPDATE memos SET priority=priority+1
+	return d.db.Tx(context.Background(), "InsertMemo", repeatableReadIsoLevel, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `UPDATE memos SET priority=priority+1
 WHERE chat_id=$1 AND state=$2`, c, MemoStateActive); err != nil {
-		return errors.Wrap(err, "failed to update priorities")
-	}
-	if _, err = tx.Exec(`INSERT INTO memos(chat_id, text, state, priority, timestamp)
+			return errors.Wrap(err, "failed to update priorities")
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO memos(chat_id, text, state, priority, timestamp)
 VALUES($1, $2, $3, $4, $5)`, c, text, MemoStateActive, priorityMinValue, clk.Now().UTC()); err != nil {
-		return errors.Wrap(err, "failed to insert memo")
-	}
-	if err = tx.Commit(); err != nil {
-		return errors.Wrap(err, "failed to commit")
-	}
+			return errors.Wrap(err, "failed to insert memo")
+		}
 
-	return nil
+		return nil
+	})
 }
 
 // markAs updates memo status of the given memo
@@ -179,81 +181,189 @@ func (d *Database) markAs(state uint, usr int64, n int) error {
 		return errors.New("argument can't be negative")
 	}
 
-	tx, err := d.db.BeginTx(context.Background(), repeatableReadIsoLevel)
-	if err != nil {
-		return errors.Wrap(err, "failed to begin transaction")
-	}
-	defer tx.Rollback()
-
-	if _, err = tx.Exec(`UPDATE memos
+	return d.db.Tx(context.Background(), "markAs", repeatableReadIsoLevel, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `UPDATE memos
 SET state=$1, timestamp=$2
 WHERE chat_id=$3 AND state=$4 AND priority=$5`, state, clk.Now().UTC(), usr, MemoStateActive, n); err != nil {
-		return errors.Wrap(err, "failed to update memo state")
-	}
+			return errors.Wrap(err, "failed to update memo state")
+		}
 
-	if _, err = tx.Exec(`UPDATE memos
+		if _, err := tx.ExecContext(ctx, `UPDATE memos
 SET priority=priority-1
 WHERE chat_id=$1 AND state=$2 AND priority>$3`, usr, MemoStateActive, n); err != nil {
-		return errors.Wrap(err, "failed to update priorities")
-	}
+			return errors.Wrap(err, "failed to update priorities")
+		}
 
-	if err = tx.Commit(); err != nil {
-		return errors.Wrap(err, "failed to commit")
-	}
-	return nil
+		return nil
+	})
 }
 
 // GetUsers returns a list of all user IDs
 func (d *Database) GetUsers() ([]int64, error) {
-	rows, err := d.db.Query(`SELECT user_id FROM users`)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed fetching list of users")
-	}
-	defer rows.Close()
-
 	var users []int64
-	for rows.Next() {
-		var usr int64
-		err = rows.Scan(&usr)
+	err := d.db.Do(context.Background(), "GetUsers", func(ctx context.Context, q bot.Querier) error {
+		rows, err := q.QueryContext(ctx, `SELECT user_id FROM users`)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed reading user ID")
+			return errors.Wrap(err, "failed fetching list of users")
 		}
+		defer rows.Close()
+
+		users = users[:0]
+		for rows.Next() {
+			var usr int64
+			if err = rows.Scan(&usr); err != nil {
+				return errors.Wrap(err, "failed reading user ID")
+			}
 
-		users = append(users, usr)
+			users = append(users, usr)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return users, nil
 }
 
-// GetRemindParams returns the time
+// GetRemindParams returns the reminder schedule of the user
 func (d *Database) GetRemindParams(usr int64) (*RemindParams, error) {
 	var rp RemindParams
-	err := d.db.QueryRow(`SELECT remind, remind_at, chat_id, timezone
+	var last sql.NullTime
+	err := d.db.Do(context.Background(), "GetRemindParams", func(ctx context.Context, q bot.Querier) error {
+		err := q.QueryRowContext(ctx, `SELECT remind, chat_id, timezone, last_reminded
 FROM users
-WHERE user_id=$1`, usr).Scan(&rp.Set, &rp.RemindAt, &rp.ChatID, &rp.TimeZone)
+WHERE user_id=$1`, usr).Scan(&rp.Set, &rp.ChatID, &rp.TimeZone, &last)
+		if err != nil {
+			return err
+		}
+
+		rows, err := q.QueryContext(ctx, `SELECT remind_at, weekdays
+FROM remind_times
+WHERE user_id=$1
+ORDER BY remind_at`, usr)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		rp.Times = rp.Times[:0]
+		for rows.Next() {
+			var t RemindTime
+			if err = rows.Scan(&t.At, &t.Days); err != nil {
+				return err
+			}
+			rp.Times = append(rp.Times, t)
+		}
+
+		return rows.Err()
+	})
 
 	switch {
-	case err == sql.ErrNoRows:
+	case errors.Is(err, sql.ErrNoRows):
 		return nil, nil
 	case err != nil:
 		return nil, errors.Wrap(err, "failed to fetch remind parameters")
 	}
 
+	if last.Valid {
+		rp.LastReminded = last.Time
+	}
+
 	return &rp, nil
 }
 
-// SetRemindAt updates reminder time in DB
-func (d *Database) SetRemindAt(usr int64, at int) error {
-	_, err := d.db.Exec(`UPDATE users SET remind_at=$1, remind=TRUE
-WHERE user_id = $2`, at, usr)
+// ClaimReminder claims sending the reminder due at the time for the lease
+// duration. It returns false if the reminder was already sent or another
+// replica of the bot is sending a reminder to the user, so it's sent once even
+// if replicas try to send it concurrently. A claim that isn't released by
+// MarkReminded or ReleaseReminder expires, so the reminder is sent again if
+// the replica dies while sending it.
+func (d *Database) ClaimReminder(usr int64, at time.Time, lease time.Duration) (bool, error) {
+	res, err := d.db.Exec(context.Background(), "ClaimReminder", `UPDATE users
+SET reminding=$1, reminding_until=now() + make_interval(secs => $3)
+WHERE user_id=$2 AND (last_reminded IS NULL OR last_reminded < $1)
+AND (reminding_until IS NULL OR reminding_until < now())`, at.UTC(), usr, lease.Seconds())
 	if err != nil {
-		return errors.Wrap(err, "failed updating reminder")
+		return false, errors.Wrap(err, "failed claiming reminder")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed claiming reminder")
+	}
+
+	return n == 1, nil
+}
+
+// MarkReminded records that the reminder due at the time is sent and releases
+// its claim
+func (d *Database) MarkReminded(usr int64, at time.Time) error {
+	_, err := d.db.Exec(context.Background(), "MarkReminded", `UPDATE users
+SET last_reminded=GREATEST(last_reminded, $1),
+reminding=NULLIF(reminding, $1),
+reminding_until=CASE WHEN reminding=$1 THEN NULL ELSE reminding_until END
+WHERE user_id=$2`, at.UTC(), usr)
+	if err != nil {
+		return errors.Wrap(err, "failed marking reminder as sent")
+	}
+
+	return nil
+}
+
+// ReleaseReminder releases the claim of the reminder due at the time that
+// wasn't sent, so it may be sent again without waiting for the claim to expire
+func (d *Database) ReleaseReminder(usr int64, at time.Time) error {
+	_, err := d.db.Exec(context.Background(), "ReleaseReminder", `UPDATE users
+SET reminding=NULL, reminding_until=NULL
+WHERE user_id=$2 AND reminding=$1`, at.UTC(), usr)
+	if err != nil {
+		return errors.Wrap(err, "failed releasing reminder")
+	}
+
+	return nil
+}
+
+// SetRemindTimes replaces reminder times of the user and turns reminders on
+func (d *Database) SetRemindTimes(usr int64, times []RemindTime) error {
+	if len(times) == 0 {
+		return errors.New("no reminder times")
+	}
+
+	return d.db.Tx(context.Background(), "SetRemindTimes", nil, func(ctx context.Context, tx *sql.Tx) error {
+		// remind_at is kept for older versions of the bot
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET remind_at=$1, remind=TRUE
+WHERE user_id = $2`, times[0].At, usr); err != nil {
+			return errors.Wrap(err, "failed updating reminder")
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM remind_times WHERE user_id=$1`, usr); err != nil {
+			return errors.Wrap(err, "failed deleting reminder times")
+		}
+
+		for _, t := range times {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO remind_times(user_id, remind_at, weekdays)
+VALUES($1, $2, $3)`, usr, t.At, t.Days); err != nil {
+				return errors.Wrap(err, "failed inserting reminder time")
+			}
+		}
+
+		return nil
+	})
+}
+
+// SetRemind turns reminders of the user on or off
+func (d *Database) SetRemind(usr int64, on bool) error {
+	_, err := d.db.Exec(context.Background(), "SetRemind", `UPDATE users SET remind=$1 WHERE user_id=$2`, on, usr)
+	if err != nil {
+		return errors.Wrap(err, "failed turning reminders on or off")
 	}
 	return nil
 }
 
 func (d *Database) UpdateTZ(usr int64, loc *timezone.GeoLocation, tz string) error {
-	_, err := d.db.Exec(`UPDATE users SET latitude=$1, longitude=$2, timezone=$3 WHERE user_id=$4`, loc.Latitude, loc.Longitude, tz, usr)
+	_, err := d.db.Exec(context.Background(), "UpdateTZ", `UPDATE users SET latitude=$1, longitude=$2, timezone=$3 WHERE user_id=$4`, loc.Latitude, loc.Longitude, tz, usr)
 	if err != nil {
 		return errors.Wrap(err, "failed updating time zone")
 	}
@@ -269,18 +379,16 @@ func (d *Database) MakeFirst(usr int64, n int) error {
 		return nil
 	}
 
-	var err error
-	bot.RobustExecute(d.RetryAttempts, d.RetryDelay, func() bool {
-		d.db.Exec(`UPDATE memos
+	_, err := d.db.Exec(context.Background(), "MakeFirst", `UPDATE memos
 SET priority=CASE
 	WHEN priority=$1 THEN $2
 	ELSE priority+1
 END
 WHERE chat_id=$3 AND state=$4 AND priority<=$1`, n, priorityMinValue, usr, MemoStateActive)
-		return err == nil
-	})
-
-	return err
+	if err != nil {
+		return errors.Wrap(err, "failed moving memo")
+	}
+	return nil
 }
 
 func (d *Database) MakeLast(usr int64, n int) error {
@@ -288,9 +396,7 @@ func (d *Database) MakeLast(usr int64, n int) error {
 		return errors.New("argument can't be negative")
 	}
 
-	var err error
-	bot.RobustExecute(d.RetryAttempts, d.RetryDelay, func() bool {
-		d.db.Exec(`WITH max_priority AS (
+	_, err := d.db.Exec(context.Background(), "MakeLast", `WITH max_priority AS (
 	SELECT MAX(priority) AS value FROM memos WHERE chat_id=$2 AND state=$3
 )
 UPDATE memos
@@ -299,8 +405,8 @@ SET priority=CASE
 	ELSE priority-1
 END
 WHERE chat_id=$2 AND state=$3 AND priority>=$1`, n, usr, MemoStateActive)
-		return err == nil
-	})
-
-	return err
+	if err != nil {
+		return errors.Wrap(err, "failed moving memo")
+	}
+	return nil
 }
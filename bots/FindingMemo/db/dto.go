@@ -1,6 +1,10 @@
 package db
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 const (
 	MemoStateActive uint = iota
@@ -20,9 +24,63 @@ type Memo struct {
 	TS       time.Time // last op time
 }
 
+// Weekdays is a set of days of the week, bit n is set for time.Weekday(n)
+type Weekdays uint8
+
+// Sets of days of the week
+const (
+	EveryDay Weekdays = 1<<7 - 1
+	Weekends Weekdays = 1<<time.Saturday | 1<<time.Sunday
+	Workdays          = EveryDay &^ Weekends
+)
+
+// Has reports whether the day is in the set
+func (w Weekdays) Has(d time.Weekday) bool {
+	return w&(1<<uint(d)) != 0
+}
+
+// String returns "daily", "weekdays", "weekends" or abbreviated names of the
+// days starting from Monday, e.g. "mon,wed,fri"
+func (w Weekdays) String() string {
+	switch w {
+	case EveryDay:
+		return "daily"
+	case Workdays:
+		return "weekdays"
+	case Weekends:
+		return "weekends"
+	}
+
+	var names []string
+	for i := 1; i <= 7; i++ {
+		if d := time.Weekday(i % 7); w.Has(d) {
+			names = append(names, strings.ToLower(d.String()[:3]))
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// RemindTime is a time to send reminders at on some days of the week
+type RemindTime struct {
+	At   int      // number of minutes (hour * 60 + minute)
+	Days Weekdays // days to send reminders on
+}
+
+// String returns the time in the format HH:MM followed by the days unless it's
+// every day
+func (t RemindTime) String() string {
+	s := fmt.Sprintf("%02d:%02d", t.At/60, t.At%60)
+	if t.Days != EveryDay {
+		s += " " + t.Days.String()
+	}
+	return s
+}
+
 type RemindParams struct {
-	RemindAt int    // remind time as number of minutes (hour * 60 + minute)
-	TimeZone string // time zone identifier
-	Set      bool   // remind or not
-	ChatID   int64  // chat ID to send reminders to
+	Times        []RemindTime // reminder times ordered by time of day
+	TimeZone     string       // time zone identifier
+	Set          bool         // remind or not
+	ChatID       int64        // chat ID to send reminders to
+	LastReminded time.Time    // fire time of the last sent reminder, zero if unknown
 }
@@ -0,0 +1,408 @@
+package db
+
+import (
+	"botfarm/bots/FindingMemo/timezone"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Memory keeps users and memos in memory like Database keeps them in Postgres.
+// It's meant for tests of the bot, which may make its operations fail with
+// Fail.
+type Memory struct {
+	mu       sync.Mutex
+	users    map[int64]*RemindParams
+	memos    map[int64][]Memo // by chat
+	nextMemo int
+	claims   map[int64]reminderClaim // by user
+	failures map[string]error        // by operation
+}
+
+// reminderClaim is a claim of sending the reminder due at the time
+type reminderClaim struct {
+	at    time.Time
+	until time.Time
+}
+
+// NewMemory creates an empty Memory
+func NewMemory() *Memory {
+	return &Memory{
+		users:    make(map[int64]*RemindParams),
+		memos:    make(map[int64][]Memo),
+		nextMemo: 1,
+		claims:   make(map[int64]reminderClaim),
+		failures: make(map[string]error),
+	}
+}
+
+// Fail makes the operation, i.e. the method with the name, return err until
+// it's called again with nil err
+func (m *Memory) Fail(op string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err == nil {
+		delete(m.failures, op)
+	} else {
+		m.failures[op] = err
+	}
+}
+
+func (m *Memory) GetAllMemos(usr int64, short bool) ([]Memo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["GetAllMemos"]; err != nil {
+		return []Memo{}, err
+	}
+
+	since := clk.Now().UTC().Add(minus24Hours)
+	var memos []Memo
+	for _, memo := range m.memos[usr] {
+		if memo.State == MemoStateActive || memo.TS.After(since) {
+			memos = append(memos, memo)
+		}
+	}
+	sort.SliceStable(memos, func(i, j int) bool { return memos[i].Priority < memos[j].Priority })
+
+	return memos, nil
+}
+
+// MarkAsDone marks the task as done
+func (m *Memory) MarkAsDone(usr int64, n int) error {
+	return m.markAs("MarkAsDone", MemoStateDone, usr, n)
+}
+
+// DeleteMemo soft-deletes the task
+func (m *Memory) DeleteMemo(usr int64, n int) error {
+	return m.markAs("DeleteMemo", MemoStateDeleted, usr, n)
+}
+
+// GetActiveMemoCount returns the count of active memos for a user
+func (m *Memory) GetActiveMemoCount(usr int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["GetActiveMemoCount"]; err != nil {
+		return 0, err
+	}
+
+	n := 0
+	m.eachActive(usr, func(*Memo) { n++ })
+	return n, nil
+}
+
+// CreateUser creates a new user with the default reminder settings
+func (m *Memory) CreateUser(usr int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["CreateUser"]; err != nil {
+		return err
+	}
+
+	if _, ok := m.users[usr]; !ok {
+		m.users[usr] = &RemindParams{
+			Times:    []RemindTime{{At: DefaultTime, Days: EveryDay}},
+			TimeZone: DefaultTimeZone,
+			Set:      true,
+			ChatID:   usr,
+		}
+	}
+	return nil
+}
+
+// AddMemo inserts new memo at the end of the memo list
+func (m *Memory) AddMemo(c int64, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["AddMemo"]; err != nil {
+		return errors.Wrap(err, "failed to add memo")
+	}
+
+	var last int16
+	m.eachActive(c, func(memo *Memo) {
+		if memo.Priority > last {
+			last = memo.Priority
+		}
+	})
+	m.add(c, text, last+1)
+	return nil
+}
+
+// InsertMemo inserts new memo at the beginning of the memo list
+func (m *Memory) InsertMemo(c int64, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["InsertMemo"]; err != nil {
+		return errors.Wrap(err, "failed to insert memo")
+	}
+
+	m.eachActive(c, func(memo *Memo) { memo.Priority++ })
+	m.add(c, text, priorityMinValue)
+	return nil
+}
+
+// add adds the active memo; m.mu must be held
+func (m *Memory) add(c int64, text string, priority int16) {
+	m.memos[c] = append(m.memos[c], Memo{
+		ID:       m.nextMemo,
+		Text:     text,
+		State:    MemoStateActive,
+		Priority: priority,
+		TS:       clk.Now().UTC(),
+	})
+	m.nextMemo++
+}
+
+// eachActive calls f for active memos of the chat; m.mu must be held
+func (m *Memory) eachActive(c int64, f func(*Memo)) {
+	memos := m.memos[c]
+	for i := range memos {
+		if memos[i].State == MemoStateActive {
+			f(&memos[i])
+		}
+	}
+}
+
+// markAs updates memo status of the given memo
+func (m *Memory) markAs(op string, state uint, usr int64, n int) error {
+	if n < priorityMinValue {
+		return errors.New("argument can't be negative")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures[op]; err != nil {
+		return err
+	}
+
+	var marked *Memo
+	m.eachActive(usr, func(memo *Memo) {
+		switch {
+		case int(memo.Priority) == n:
+			marked = memo
+		case int(memo.Priority) > n:
+			memo.Priority--
+		}
+	})
+	if marked != nil {
+		marked.State = state
+		marked.TS = clk.Now().UTC()
+	}
+	return nil
+}
+
+// GetUsers returns a list of all user IDs
+func (m *Memory) GetUsers() ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["GetUsers"]; err != nil {
+		return nil, err
+	}
+
+	users := make([]int64, 0, len(m.users))
+	for usr := range m.users {
+		users = append(users, usr)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+
+	return users, nil
+}
+
+// GetRemindParams returns the reminder schedule of the user
+func (m *Memory) GetRemindParams(usr int64) (*RemindParams, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["GetRemindParams"]; err != nil {
+		return nil, errors.Wrap(err, "failed to fetch remind parameters")
+	}
+
+	rp, ok := m.users[usr]
+	if !ok {
+		return nil, nil
+	}
+
+	cp := *rp
+	cp.Times = append([]RemindTime(nil), rp.Times...)
+	return &cp, nil
+}
+
+// ClaimReminder claims sending the reminder due at the time for the lease
+// duration. It returns false if the reminder was already sent or another
+// reminder to the user is claimed.
+func (m *Memory) ClaimReminder(usr int64, at time.Time, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["ClaimReminder"]; err != nil {
+		return false, errors.Wrap(err, "failed claiming reminder")
+	}
+
+	rp, ok := m.users[usr]
+	if !ok || !rp.LastReminded.Before(at) {
+		return false, nil
+	}
+
+	now := clk.Now()
+	if c, ok := m.claims[usr]; ok && now.Before(c.until) {
+		return false, nil
+	}
+
+	m.claims[usr] = reminderClaim{at: at.UTC(), until: now.Add(lease)}
+	return true, nil
+}
+
+// MarkReminded records that the reminder due at the time is sent and releases
+// its claim
+func (m *Memory) MarkReminded(usr int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["MarkReminded"]; err != nil {
+		return errors.Wrap(err, "failed marking reminder as sent")
+	}
+
+	rp, ok := m.users[usr]
+	if !ok {
+		return nil
+	}
+
+	if rp.LastReminded.Before(at) {
+		rp.LastReminded = at.UTC()
+	}
+	m.release(usr, at)
+	return nil
+}
+
+// ReleaseReminder releases the claim of the reminder due at the time that
+// wasn't sent
+func (m *Memory) ReleaseReminder(usr int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["ReleaseReminder"]; err != nil {
+		return errors.Wrap(err, "failed releasing reminder")
+	}
+
+	m.release(usr, at)
+	return nil
+}
+
+// release releases the claim of the reminder due at the time. m.mu must be
+// held.
+func (m *Memory) release(usr int64, at time.Time) {
+	if c, ok := m.claims[usr]; ok && c.at.Equal(at) {
+		delete(m.claims, usr)
+	}
+}
+
+// SetRemindTimes replaces reminder times of the user and turns reminders on
+func (m *Memory) SetRemindTimes(usr int64, times []RemindTime) error {
+	if len(times) == 0 {
+		return errors.New("no reminder times")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["SetRemindTimes"]; err != nil {
+		return err
+	}
+
+	if rp, ok := m.users[usr]; ok {
+		rp.Times = append([]RemindTime(nil), times...)
+		sort.Slice(rp.Times, func(i, j int) bool { return rp.Times[i].At < rp.Times[j].At })
+		rp.Set = true
+	}
+	return nil
+}
+
+// SetRemind turns reminders of the user on or off
+func (m *Memory) SetRemind(usr int64, on bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["SetRemind"]; err != nil {
+		return errors.Wrap(err, "failed turning reminders on or off")
+	}
+
+	if rp, ok := m.users[usr]; ok {
+		rp.Set = on
+	}
+	return nil
+}
+
+func (m *Memory) UpdateTZ(usr int64, loc *timezone.GeoLocation, tz string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["UpdateTZ"]; err != nil {
+		return errors.Wrap(err, "failed updating time zone")
+	}
+
+	if rp, ok := m.users[usr]; ok {
+		rp.TimeZone = tz
+	}
+	return nil
+}
+
+func (m *Memory) MakeFirst(usr int64, n int) error {
+	if n < priorityMinValue {
+		return errors.New("argument can't be negative")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["MakeFirst"]; err != nil {
+		return errors.Wrap(err, "failed moving memo")
+	}
+
+	m.eachActive(usr, func(memo *Memo) {
+		switch {
+		case int(memo.Priority) == n:
+			memo.Priority = priorityMinValue
+		case int(memo.Priority) < n:
+			memo.Priority++
+		}
+	})
+	return nil
+}
+
+func (m *Memory) MakeLast(usr int64, n int) error {
+	if n < priorityMinValue {
+		return errors.New("argument can't be negative")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.failures["MakeLast"]; err != nil {
+		return errors.Wrap(err, "failed moving memo")
+	}
+
+	var last int16
+	m.eachActive(usr, func(memo *Memo) {
+		if memo.Priority > last {
+			last = memo.Priority
+		}
+	})
+	m.eachActive(usr, func(memo *Memo) {
+		switch {
+		case int(memo.Priority) == n:
+			memo.Priority = last
+		case int(memo.Priority) > n:
+			memo.Priority--
+		}
+	})
+	return nil
+}
@@ -1,5 +1,3 @@
-SET TIME ZONE 'UTC'
-
 CREATE TABLE IF NOT EXISTS users(
     user_id bigint NOT NULL PRIMARY KEY,
     chat_id bigint NOT NULL,
@@ -17,4 +15,4 @@ CREATE TABLE IF NOT EXISTS memos(
     state smallint NOT NULL CHECK (state >= 0),
     priority smallint NOT NULL CHECK (priority > 0),
     timestamp timestamp NULL
-);
\ No newline at end of file
+);
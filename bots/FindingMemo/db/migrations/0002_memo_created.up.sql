@@ -0,0 +1,2 @@
+ALTER TABLE memos ADD COLUMN IF NOT EXISTS created timestamptz NULL;
+ALTER TABLE memos ALTER COLUMN created SET DEFAULT now();
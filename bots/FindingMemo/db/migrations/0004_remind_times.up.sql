@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS remind_times(
+    user_id bigint NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    remind_at smallint NOT NULL CHECK (remind_at >= 0 AND remind_at < 1440),
+    weekdays smallint NOT NULL CHECK (weekdays > 0 AND weekdays < 128),
+    PRIMARY KEY (user_id, remind_at)
+);
+
+INSERT INTO remind_times(user_id, remind_at, weekdays)
+SELECT user_id, remind_at, 127 FROM users
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS reminding_until;
+ALTER TABLE users DROP COLUMN IF EXISTS reminding;
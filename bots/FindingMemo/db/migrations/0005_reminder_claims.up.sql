@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reminding timestamptz NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reminding_until timestamptz NULL;
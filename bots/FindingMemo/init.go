@@ -6,110 +6,209 @@ import (
 	"botfarm/bots/FindingMemo/reminder"
 	"botfarm/bots/FindingMemo/tgbot"
 	"botfarm/bots/FindingMemo/timezone"
-	"errors"
+	"context"
+	"io/fs"
 	"time"
 
-	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.uber.org/zap"
 )
 
+// Config is FindingMemo's configuration
+type Config struct {
+	bot.BaseConfig
+	// MissedReminderGrace is how late reminders missed while the bot was down
+	// are still sent on start; 0 disables catching up
+	MissedReminderGrace time.Duration `cfg:"MissedReminderGrace" default:"3h" min:"0s" max:"24h"`
+}
+
 type FindingMemo struct {
 	*tgbot.TBot
+	name     string
+	cfg      Config
+	db       *db.Database
+	sender   *bot.Sender
+	migrator *bot.Migrator
+	router   *bot.Router
+	updates  *bot.Dispatcher
+	handlers bot.Tasks
 }
 
-func requiredConfigFields() []string {
-	return []string{
-		bot.CfgDbConnStr,
-		bot.CfgTgToken,
-	}
+// New creates an instance of the bot with the name
+func New(name string) bot.Bot {
+	return &FindingMemo{name: name}
 }
 
 func (fm *FindingMemo) Name() string {
-	return "FindingMemo"
+	return fm.name
 }
 
-func (fm *FindingMemo) Init(cfg bot.Config, l *zap.SugaredLogger) error {
+func (fm *FindingMemo) Config() any {
+	return &fm.cfg
+}
+
+func (fm *FindingMemo) Init(l *zap.SugaredLogger) (err error) {
 	// Time zone
-	err := timezone.Init()
+	err = timezone.Init()
 	if err != nil {
 		l.Errorw("failed to initialize time zones", "err", err)
 		return err
 	}
 
 	// Database
-	v, ok := cfg[bot.CfgDbConnStr].(string)
-	if !ok {
-		l.Error("failed fetching connection string")
-		return errors.New("configuration value doesn't exist")
-	}
-
-	n, ok := cfg[bot.CfgDbRetryAttempts].(float32)
-	if !ok {
-		l.Info("retry timeout isn't set; using default value")
-		n = 1
+	d, err := db.NewDatabase(fm.Name(), fm.cfg.DBConnStr, fm.cfg.DBConfig())
+	if err != nil {
+		l.Errorw("failed to initialize database", "err", err)
+		return err
 	}
+	fm.db = d
+
+	// Init may be run again, e.g. by the admin API, so nothing is leaked if it
+	// fails
+	fm.sender = nil
+	defer func() {
+		if err == nil {
+			return
+		}
+		if fm.sender != nil {
+			fm.sender.Close()
+			fm.sender = nil
+		}
+		if cerr := d.Close(); cerr != nil {
+			l.Errorw("failed closing database", "err", cerr)
+		}
+	}()
 
-	delay, ok := cfg[bot.CfgDbRetryDelay].(float32)
-	if !ok {
-		l.Info("retry timeout isn't set; using default value")
-		delay = 1
+	fm.migrator, err = bot.NewMigrator(fm.Name(), d.Conn(), db.Migrations, l)
+	if err != nil {
+		l.Errorw("failed to load migrations", "err", err)
+		return err
 	}
-
-	timeout, ok := cfg[bot.CfgDbTimeout].(float32)
-	if !ok {
-		l.Info("retry timeout isn't set; using default value")
-		delay = 1
+	if fm.cfg.Migrate {
+		if err = fm.migrator.Up(context.Background()); err != nil {
+			l.Errorw("failed to migrate database", "err", err)
+			return err
+		}
 	}
 
-	d, err := db.NewDatabase(v, int(n), (time.Duration(delay) * time.Second),
-		(time.Duration(timeout) * time.Second))
+	// Localization
+	catalog, err := bot.LoadCatalog(tgbot.Locales, fm.cfg.Locale)
 	if err != nil {
-		l.Errorw("failed to initialize database", "err", err)
+		l.Errorw("failed to load message catalog", "err", err)
 		return err
 	}
 
 	// TBot
-	v, ok = cfg[bot.CfgTgToken].(string)
-	if !ok {
-		l.Error("failed fetching Telegram token")
-		return errors.New("configuration value doesn't exist")
+	t, err := bot.NewTransport(fm.Name(), fm.cfg.TgToken, fm.cfg.TgAPIEndpoint)
+	if err != nil {
+		l.Errorw("failed to initialize Telegram Bot", "err", err)
+		return err
 	}
 
-	fm.TBot, err = tgbot.NewTBot(v, d, l)
+	fm.sender = bot.NewSender(fm.Name(), t, fm.cfg.SenderConfig(), l)
+	fm.TBot = tgbot.NewTBot(fm.Name(), fm.sender, fm.sender.WithPriority(bot.PriorityLow), d,
+		bot.NewI18n(fm.Name(), catalog, bot.NewPGLocaleStore(d.Conn()), l), bot.NewPGStore(d.Conn()),
+		fm.cfg.ConvTimeout, l)
+	fm.router = bot.NewRouter(fm.Name(), l)
+	fm.TBot.Routes(fm.router)
+
+	operator := bot.NewOperator(fm.Name(), d.Conn(), d, fm.sender, fm.sender.WithPriority(bot.PriorityLow),
+		fm.cfg.BroadcastRate, &fm.handlers, l)
+
+	access, err := bot.NewAccess(fm.Name(), fm.cfg.AccessConfig(), bot.NewPGAccessStore(d.Conn()), fm.TBot.I18n,
+		fm.sender, l)
 	if err != nil {
-		return errors.New("failed creating TBot")
+		l.Errorw("failed to initialize access policy", "err", err)
+		return err
 	}
 
+	fm.router.Use(access.Middleware(), operator.Middleware())
+	operator.Routes(fm.router)
+	access.Routes(fm.router)
+
+	fm.updates = bot.NewDispatcher(fm.cfg.UpdateWorkers, fm.router.Handle, l)
+	fm.handlers.Logger = l
+
 	// Reminder
-	rm := reminder.NewManager(d, fm.TBot.SendReminder, l)
-	fm.TBot.ReminderManager = rm
+	scheduler := bot.NewScheduler(fm.Name(), bot.NewPGJobStore(d.Conn()), &fm.handlers, l)
+	fm.TBot.ReminderManager = reminder.NewManager(fm.Name(), d, scheduler, &fm.handlers, fm.cfg.MissedReminderGrace,
+		fm.TBot.SendReminder, l)
 
 	return nil
 }
 
-func (fm *FindingMemo) Run() {
+func (fm *FindingMemo) Run(ctx context.Context) error {
+	fm.updates.Reset()
+	fm.handlers.Reset()
+
 	// Run reminder
-	fm.TBot.ReminderManager.Run()
+	if err := fm.TBot.ReminderManager.Run(ctx); err != nil {
+		fm.TBot.Logger.Errorw("failed to run reminders", "err", err)
+		return err
+	}
 
 	// Run bot
-	uCfg := tg.NewUpdate(0)
-	uCfg.Timeout = 60
-
-	for u := range fm.TBot.Bot.GetUpdatesChan(uCfg) {
-		switch {
-		case u.Message != nil:
-			if u.Message.IsCommand() {
-				go fm.TBot.HandleCommand(u.Message)
-			} else {
-				go fm.TBot.HandleMessage(u.Message)
-			}
-
-		case u.CallbackQuery != nil:
-			go fm.TBot.HandleCallback(u.CallbackQuery)
-		}
+	updates, err := fm.TBot.Transport.Updates(ctx, fm.Name())
+	if err != nil {
+		fm.TBot.Logger.Errorw("failed to start receiving updates", "err", err)
+		return err
 	}
+
+	for u := range updates {
+		u := u
+		fm.updates.Dispatch(&u)
+	}
+
+	return nil
+}
+
+// Migrations returns migrations of the bot database
+func (fm *FindingMemo) Migrations() fs.FS {
+	return db.Migrations
+}
+
+// Menu returns commands of the bot menu
+func (fm *FindingMemo) Menu() []bot.MenuCommand {
+	return tgbot.Menu()
+}
+
+// Locales returns message catalogs of the bot
+func (fm *FindingMemo) Locales() fs.FS {
+	return tgbot.Locales
+}
+
+// Migrator returns the migrator of the bot database
+func (fm *FindingMemo) Migrator() *bot.Migrator {
+	return fm.migrator
+}
+
+// Health checks the database and the reminder loop
+func (fm *FindingMemo) Health(ctx context.Context) map[string]error {
+	return map[string]error{
+		"db":        fm.db.Ping(ctx),
+		"reminders": fm.TBot.ReminderManager.Ticking(),
+	}
+}
+
+func (fm *FindingMemo) Stop(ctx context.Context) error {
+	err := fm.updates.Wait(ctx)
+	if tasksErr := fm.handlers.Wait(ctx); err == nil {
+		err = tasksErr
+	}
+	if err != nil {
+		fm.TBot.Logger.Warnw("not all handlers finished in time", "err", err)
+	}
+	fm.sender.Close()
+
+	if dbErr := fm.db.Close(); dbErr != nil {
+		fm.TBot.Logger.Errorw("failed closing database", "err", dbErr)
+		err = dbErr
+	}
+
+	fm.TBot.Logger.Sync()
+
+	return err
 }
 
 func init() {
-	bot.Register(&FindingMemo{}, requiredConfigFields())
+	bot.Register("FindingMemo", New)
 }
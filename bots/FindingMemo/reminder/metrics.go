@@ -0,0 +1,28 @@
+package reminder
+
+import (
+	"botfarm/bot"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: bot.MetricsNamespace,
+		Name:      "reminder_queue_length",
+		Help:      "Reminders scheduled.",
+	}, []string{"bot"})
+
+	remindersSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: bot.MetricsNamespace,
+		Name:      "reminders_sent_total",
+		Help:      "Reminders sent to users.",
+	}, []string{"bot"})
+
+	missedRemindersSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: bot.MetricsNamespace,
+		Name:      "missed_reminders_sent_total",
+		Help:      "Reminders sent late because the bot was down at their time.",
+	}, []string{"bot"})
+)
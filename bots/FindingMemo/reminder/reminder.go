@@ -1,72 +1,171 @@
 package reminder
 
 import (
+	"botfarm/bot"
 	"botfarm/bots/FindingMemo/db"
-	"container/heap"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/jmhodges/clock"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
-const reminderTick = 20 * time.Second
-
-var (
-	clk = clock.New()
+const (
+	// jobPrefix prefixes keys of reminder jobs in the scheduler
+	jobPrefix = "remind:"
+	// claimLease is how long a replica may send a reminder it claimed before
+	// other replicas send it again. It's long enough for reminders waiting in
+	// the queue of the sender when many users are reminded at the same time.
+	claimLease = 10 * time.Minute
 )
 
-type Manager struct {
-	db            *db.Database
-	logger        *zap.SugaredLogger
-	reminderQueue *reminderQueue
-	sendReminder  func(int64)
+// errReminderClaimed is returned by the reminder job if another replica is
+// sending a reminder to the user
+var errReminderClaimed = errors.New("reminder is being sent by another replica")
+
+// Store keeps reminder settings of users (see db.Database)
+type Store interface {
+	GetUsers() ([]int64, error)
+	GetRemindParams(usr int64) (*db.RemindParams, error)
+	ClaimReminder(usr int64, at time.Time, lease time.Duration) (bool, error)
+	MarkReminded(usr int64, at time.Time) error
+	ReleaseReminder(usr int64, at time.Time) error
 }
 
-type Reminder struct {
+type Manager struct {
+	name         string // bot name
+	db           Store
+	scheduler    *bot.Scheduler
+	tasks        *bot.Tasks
+	grace        time.Duration // how late missed reminders are still sent
 	logger       *zap.SugaredLogger
-	at           time.Time
-	usr          int64
-	sendReminder func(int64)
+	sendReminder func(usr int64, missed time.Time) error
+
+	mu   sync.Mutex
+	jobs map[int64][]string // keys of scheduled jobs by user
 }
 
-func NewManager(d *db.Database, sr func(int64), l *zap.SugaredLogger) *Manager {
-	return &Manager{
-		db:            d,
-		logger:        l,
-		reminderQueue: NewReminderQueue(),
-		sendReminder:  sr,
+// NewManager creates reminder manager. Reminders are scheduled with s, which
+// calls sr in a task of the bot. On start, reminders missed less than grace
+// ago are sent in tasks; sr gets their fire time then. sr returns an error if
+// the reminder isn't delivered.
+func NewManager(name string, d Store, s *bot.Scheduler, tasks *bot.Tasks, grace time.Duration,
+	sr func(usr int64, missed time.Time) error, l *zap.SugaredLogger) *Manager {
+	m := &Manager{
+		name:         name,
+		db:           d,
+		scheduler:    s,
+		tasks:        tasks,
+		grace:        grace,
+		logger:       l,
+		sendReminder: sr,
+		jobs:         make(map[int64][]string),
 	}
-}
+	s.SetReload(m.reload)
 
-func (r *Manager) Run() {
-	ch := time.NewTicker(reminderTick).C
+	return m
+}
 
-	users, err := r.db.GetUsers()
+// Run initializes reminders for all users and starts the scheduler. The
+// scheduler stops when ctx is done.
+func (m *Manager) Run(ctx context.Context) error {
+	users, err := m.db.GetUsers()
 	if err != nil {
-		r.logger.Fatalw("failed getting list of users", "err", err)
+		return errors.Wrap(err, "failed getting list of users")
+	}
+
+	if err = m.scheduler.Run(ctx); err != nil {
+		return errors.Wrap(err, "failed running scheduler")
 	}
 
-	r.logger.Infof("initializing reminders for %d users", len(users))
+	m.logger.Infof("initializing reminders for %d users", len(users))
 
+	now := time.Now()
 	for _, usr := range users {
-		err = r.Set(usr)
+		rp, schedules, err := m.set(usr)
+		if err != nil {
+			m.logger.Errorw("failed scheduling reminders; the user won't get some of them", "user", usr, "err", err)
+		}
+		if rp != nil {
+			m.catchUp(usr, rp, schedules, now)
+		}
+	}
+
+	return nil
+}
+
+// catchUp sends the latest reminder of the user missed within the grace
+// period, e.g. while the bot was down. Earlier missed reminders are covered by
+// it. If it isn't known when the user got a reminder last, e.g. reminders were
+// sent before they were recorded or the user hasn't got any yet, nothing is
+// sent: the reminder may have been sent or not been due at all.
+func (m *Manager) catchUp(usr int64, rp *db.RemindParams, schedules []bot.Schedule, now time.Time) {
+	if m.grace <= 0 || rp.LastReminded.IsZero() {
+		return
+	}
+
+	var missed time.Time
+	for _, schedule := range schedules {
+		for t := schedule.Next(now.Add(-m.grace)); !t.IsZero() && t.Before(now); t = schedule.Next(t) {
+			if t.After(missed) {
+				missed = t
+			}
+		}
+	}
+	if missed.IsZero() || !rp.LastReminded.Before(missed) {
+		return
+	}
+
+	// another replica may be catching up too
+	if ok, err := m.db.ClaimReminder(usr, missed, claimLease); err != nil || !ok {
 		if err != nil {
-			r.logger.Errorw("failed to fetch remind parameters; the user won't get reminders", "err", err)
+			m.logger.Errorw("failed claiming missed reminder", "user", usr, "err", err)
 		}
+		return
 	}
 
-	go remind(ch, r.reminderQueue)
+	m.logger.Infow("missed reminder is being sent", "user", usr, "missed", missed)
+	m.tasks.Go(func() {
+		sent, err := m.deliver(usr, missed, missed)
+		if err != nil {
+			m.logger.Errorw("failed sending missed reminder", "user", usr, "err", err)
+		}
+		if sent {
+			remindersSent.WithLabelValues(m.name).Inc()
+			missedRemindersSent.WithLabelValues(m.name).Inc()
+		}
+	})
+}
+
+// Ticking returns an error if the scheduler loop didn't tick for too long, e.g.
+// it's stuck or not running.
+func (m *Manager) Ticking() error {
+	return m.scheduler.Ticking()
 }
 
+// Set schedules reminders of the user replacing the previous ones. If the user
+// turned reminders off, they're canceled.
 func (m *Manager) Set(usr int64) error {
+	_, _, err := m.set(usr)
+	return err
+}
+
+// set schedules reminders of the user and returns their parameters and
+// schedules. If some reminders fail to be scheduled, the others still replace
+// the previous ones, and the error is returned along with the parameters and
+// schedules of the scheduled reminders.
+func (m *Manager) set(usr int64) (*db.RemindParams, []bot.Schedule, error) {
 	rp, err := m.db.GetRemindParams(usr)
 	if err != nil {
-		return errors.Wrap(err, "failed getting reminder parameters")
+		return nil, nil, errors.Wrap(err, "failed getting reminder parameters")
 	}
 
 	if rp == nil {
-		return errors.New("no reminder parameters found")
+		return nil, nil, errors.New("no reminder parameters found")
 	}
 
 	// TODO: add location cache
@@ -76,44 +175,164 @@ func (m *Manager) Set(usr int64) error {
 		loc = time.UTC
 	}
 
-	hh := rp.RemindAt / 60
-	mm := rp.RemindAt - 60*hh
-	now := clk.Now().In(loc)
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	// TODO: compare current time with last seen
-	if (hh < now.Hour()) || (hh == now.Hour() && mm <= now.Minute()) {
-		now = now.Add(24 * time.Hour)
+	var keys []string
+	var schedules []bot.Schedule
+	var failed error
+	if rp.Set {
+		for _, t := range rp.Times {
+			schedule, err := remindSchedule(t, loc)
+			if err != nil {
+				failed = errors.Wrap(err, "failed building reminder schedule")
+				continue
+			}
+
+			key := jobKey(usr, t.At)
+			if err = m.scheduler.Add(key, schedule, m.job(usr)); err != nil {
+				failed = errors.Wrap(err, "failed scheduling reminder")
+				// only the job without fire times isn't scheduled, others
+				// just failed to be saved
+				if errors.Is(err, bot.ErrNoFireTime) {
+					continue
+				}
+			}
+			keys = append(keys, key)
+			schedules = append(schedules, schedule)
+		}
 	}
 
-	reminder := &Reminder{
-		usr:          usr,
-		at:           time.Date(now.Year(), now.Month(), now.Day(), hh, mm, 0, 0, loc).UTC(),
-		logger:       m.logger,
-		sendReminder: m.sendReminder,
+	old, ok := m.jobs[usr]
+	if !ok {
+		// the job of a single daily reminder kept by older versions of the bot
+		old = []string{jobPrefix + strconv.FormatInt(usr, 10)}
 	}
+	for _, key := range old {
+		if !contains(keys, key) {
+			m.scheduler.Cancel(key)
+		}
+	}
+	m.jobs[usr] = keys
 
-	heap.Push(m.reminderQueue, reminder)
+	queueLength.WithLabelValues(m.name).Set(float64(m.scheduler.Len()))
 
-	return nil
+	return rp, schedules, failed
 }
 
-func remind(ch <-chan time.Time, reminderQueue *reminderQueue) {
-	for range ch {
-		now := clk.Now().UTC()
-		for {
-			r, ok := reminderQueue.Peek().(*Reminder)
-			if !ok || now.Before(r.at) {
-				break
+// job returns the job sending a reminder to the user. The reminder is claimed
+// before it's sent and recorded as sent only after it's delivered, so it isn't
+// sent twice when the job is run again, e.g. by another replica, and it isn't
+// lost if the replica dies while sending it: the job stays due and sends it
+// again once the claim expires. If the reminder can't be claimed or sent, the
+// job fails and is run again later.
+func (m *Manager) job(usr int64) bot.JobFunc {
+	return func(_ string, at time.Time) error {
+		ok, err := m.db.ClaimReminder(usr, at, claimLease)
+		if err != nil {
+			return errors.Wrap(err, "failed claiming reminder")
+		}
+		if !ok {
+			// the reminder may have been sent on startup as a missed one
+			rp, err := m.db.GetRemindParams(usr)
+			if err != nil {
+				return errors.Wrap(err, "failed getting reminder parameters")
+			}
+			if rp == nil || !rp.LastReminded.Before(at) {
+				return nil
 			}
+			return errReminderClaimed
+		}
+
+		// reminder doesn't have user in its context, so adding it now
+		m.logger.Infow("reminder is being sent", "user", usr)
+
+		sent, err := m.deliver(usr, at, time.Time{})
+		if sent {
+			remindersSent.WithLabelValues(m.name).Inc()
+		}
+		return err
+	}
+}
 
-			heap.Pop(reminderQueue)
+// deliver sends the claimed reminder due at the time and records it as sent.
+// If sending fails, the claim is released and the error is returned, so the
+// reminder is sent again. Reminders that can't be delivered at all, e.g. the
+// user blocked the bot, are recorded as sent without being retried. It
+// returns true if the reminder is delivered.
+func (m *Manager) deliver(usr int64, at, missed time.Time) (bool, error) {
+	err := m.sendReminder(usr, missed)
+	if err != nil && !bot.IsPermanent(err) {
+		if rerr := m.db.ReleaseReminder(usr, at); rerr != nil {
+			m.logger.Errorw("failed releasing reminder", "user", usr, "err", rerr)
+		}
+		return false, errors.Wrap(err, "failed sending reminder")
+	}
 
-			// reminder doesn't have user in its context, so adding it now
-			r.logger.Info("reminder is being sent")
+	// the delivered reminder isn't failed if marking fails, as it would be
+	// sent again once the claim expires
+	if merr := m.db.MarkReminded(usr, at); merr != nil {
+		m.logger.Errorw("failed marking reminder as sent", "user", usr, "err", merr)
+	}
+
+	return err == nil, nil
+}
+
+// Cancel cancels reminders of the user
+func (m *Manager) Cancel(usr int64) {
+	m.mu.Lock()
+	for _, key := range m.jobs[usr] {
+		m.scheduler.Cancel(key)
+	}
+	delete(m.jobs, usr)
+	m.mu.Unlock()
+
+	queueLength.WithLabelValues(m.name).Set(float64(m.scheduler.Len()))
+}
+
+// reload schedules reminders of the user again, as their parameters may have
+// been changed by another replica of the farm
+func (m *Manager) reload(key string) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(key, jobPrefix), ":")
+	usr, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		m.logger.Errorw("unexpected reminder job", "job", key)
+		return
+	}
+
+	if err = m.Set(usr); err != nil {
+		m.logger.Errorw("failed reloading reminder", "user", usr, "err", err)
+	}
+}
+
+// remindSchedule returns the schedule of the reminder time in loc
+func remindSchedule(t db.RemindTime, loc *time.Location) (bot.Schedule, error) {
+	hh := t.At / 60
+	mm := t.At - 60*hh
+	if t.Days == db.EveryDay {
+		return bot.Daily(hh, mm, loc), nil
+	}
+
+	var days []string
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if t.Days.Has(d) {
+			days = append(days, strconv.Itoa(int(d)))
+		}
+	}
+
+	return bot.ParseCron(fmt.Sprintf("%d %d * * %s", mm, hh, strings.Join(days, ",")), loc)
+}
+
+// jobKey returns the key of the user's reminder job at the time of day
+func jobKey(usr int64, at int) string {
+	return fmt.Sprintf("%s%d:%d", jobPrefix, usr, at)
+}
 
-			go r.sendReminder(r.usr)
-			r.at = r.at.Add(24 * time.Hour)
-			heap.Push(reminderQueue, r)
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
 		}
 	}
+	return false
 }
@@ -0,0 +1,330 @@
+package reminder
+
+import (
+	"botfarm/bot"
+	"botfarm/bots/FindingMemo/db"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const usr = 42
+
+// reminder is a reminder sent by the manager
+type reminder struct {
+	usr    int64
+	missed time.Time
+}
+
+// newManager creates a manager with the scheduler keeping jobs in memory. Sent
+// reminders are delivered to the returned channel.
+func newManager(d Store, grace time.Duration) (*Manager, chan reminder) {
+	l := zap.NewNop().Sugar()
+	sent := make(chan reminder, 10)
+	tasks := &bot.Tasks{Logger: l}
+	m := NewManager("test", d, bot.NewScheduler("test", nil, tasks, l), tasks, grace, func(usr int64, missed time.Time) error {
+		sent <- reminder{usr, missed}
+		return nil
+	}, l)
+	return m, sent
+}
+
+// expectSent checks that the reminder is sent or, if want is nil, that nothing
+// is sent
+func expectSent(t *testing.T, sent chan reminder, want *reminder) {
+	t.Helper()
+
+	timeout := time.Second
+	if want == nil {
+		timeout = 100 * time.Millisecond
+	}
+
+	select {
+	case got := <-sent:
+		if want == nil || got.usr != want.usr || !got.missed.Equal(want.missed) {
+			t.Errorf("expected reminder %+v, got %+v", want, got)
+		}
+	case <-time.After(timeout):
+		if want != nil {
+			t.Errorf("expected reminder %+v", *want)
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	mem := db.NewMemory()
+	if err := mem.CreateUser(usr); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reminder was missed 10 minutes ago, the previous one was sent
+	fire := time.Now().UTC().Add(-10 * time.Minute).Truncate(time.Minute)
+	times := []db.RemindTime{{At: fire.Hour()*60 + fire.Minute(), Days: db.EveryDay}}
+	if err := mem.SetRemindTimes(usr, times); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.MarkReminded(usr, fire.Add(-24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, sent := newManager(mem, time.Hour)
+	if err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectSent(t, sent, &reminder{usr, fire})
+	if m.scheduler.Len() != 1 {
+		t.Errorf("expected one reminder job, got %d", m.scheduler.Len())
+	}
+
+	// another replica doesn't send the reminder again
+	replica, replicaSent := newManager(mem, time.Hour)
+	if err := replica.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectSent(t, replicaSent, nil)
+
+	// the reminder job is run once for a fire time
+	next := fire.Add(24 * time.Hour)
+	replica.job(usr)("", next)
+	m.job(usr)("", next)
+	expectSent(t, replicaSent, &reminder{usr: usr})
+	expectSent(t, sent, nil)
+
+	times = append(times, db.RemindTime{At: 0, Days: db.Weekends})
+	if err := mem.SetRemindTimes(usr, times); err != nil {
+		t.Fatal(err)
+	}
+	m.reload(jobKey(usr, 0))
+	if m.scheduler.Len() != 2 {
+		t.Errorf("expected two reminder jobs after reload, got %d", m.scheduler.Len())
+	}
+
+	if err := mem.SetRemind(usr, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set(usr); err != nil {
+		t.Fatal(err)
+	}
+	if m.scheduler.Len() != 0 {
+		t.Errorf("expected reminders to be canceled, got %d jobs", m.scheduler.Len())
+	}
+
+	if err := m.Set(usr + 1); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	at := func(hh int) time.Time { return time.Date(2026, 10, 16, hh, 0, 0, 0, time.UTC) }
+	yesterday := at(11).Add(-24 * time.Hour)
+
+	for _, tc := range []struct {
+		name     string
+		grace    time.Duration
+		hours    []int     // reminder times
+		last     time.Time // last reminder the manager knows of
+		marked   time.Time // last reminder recorded in the database
+		claimed  bool      // another replica is sending the reminder
+		claimErr error
+		want     time.Time // fire time of the sent reminder, zero if none
+	}{
+		{name: "inside grace", grace: 3 * time.Hour, hours: []int{11}, last: yesterday, marked: yesterday, want: at(11)},
+		{name: "latest of missed", grace: 3 * time.Hour, hours: []int{9, 11}, last: yesterday, marked: yesterday,
+			want: at(11)},
+		{name: "last reminder unknown", grace: 3 * time.Hour, hours: []int{11}},
+		{name: "outside grace", grace: 30 * time.Minute, hours: []int{11}, last: yesterday, marked: yesterday},
+		{name: "sent before the downtime", grace: 3 * time.Hour, hours: []int{11}, last: at(11), marked: at(11)},
+		{name: "already marked by another replica", grace: 3 * time.Hour, hours: []int{11}, last: yesterday,
+			marked: at(11)},
+		{name: "claimed by another replica", grace: 3 * time.Hour, hours: []int{11}, last: yesterday,
+			marked: yesterday, claimed: true},
+		{name: "claiming fails", grace: 3 * time.Hour, hours: []int{11}, last: yesterday, marked: yesterday,
+			claimErr: errors.New("db is down")},
+		{name: "catching up is off", hours: []int{11}, last: yesterday, marked: yesterday},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := db.NewMemory()
+			if err := mem.CreateUser(usr); err != nil {
+				t.Fatal(err)
+			}
+			if !tc.marked.IsZero() {
+				if err := mem.MarkReminded(usr, tc.marked); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.claimed {
+				if _, err := mem.ClaimReminder(usr, at(11), time.Hour); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mem.Fail("ClaimReminder", tc.claimErr)
+
+			var schedules []bot.Schedule
+			for _, hh := range tc.hours {
+				schedules = append(schedules, bot.Daily(hh, 0, time.UTC))
+			}
+
+			m, sent := newManager(mem, tc.grace)
+			m.catchUp(usr, &db.RemindParams{LastReminded: tc.last}, schedules, now)
+
+			if tc.want.IsZero() {
+				expectSent(t, sent, nil)
+			} else {
+				expectSent(t, sent, &reminder{usr, tc.want})
+			}
+		})
+	}
+}
+
+func TestJobClaimFailure(t *testing.T) {
+	mem := db.NewMemory()
+	if err := mem.CreateUser(usr); err != nil {
+		t.Fatal(err)
+	}
+	m, sent := newManager(mem, time.Hour)
+	at := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+
+	// the reminder isn't sent unless it's claimed, the job fails to be run
+	// again
+	mem.Fail("ClaimReminder", errors.New("db is down"))
+	if err := m.job(usr)("", at); err == nil {
+		t.Error("expected the job to fail")
+	}
+	expectSent(t, sent, nil)
+
+	mem.Fail("ClaimReminder", nil)
+	if err := m.job(usr)("", at); err != nil {
+		t.Fatal(err)
+	}
+	expectSent(t, sent, &reminder{usr: usr})
+
+	if err := m.job(usr)("", at); err != nil {
+		t.Fatal(err)
+	}
+	expectSent(t, sent, nil)
+}
+
+func TestJobSendFailure(t *testing.T) {
+	mem := db.NewMemory()
+	if err := mem.CreateUser(usr); err != nil {
+		t.Fatal(err)
+	}
+	m, sent := newManager(mem, time.Hour)
+	at := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	send := m.sendReminder
+
+	// the undelivered reminder isn't recorded as sent, so it's sent again
+	m.sendReminder = func(int64, time.Time) error { return errors.New("telegram is down") }
+	if err := m.job(usr)("", at); err == nil {
+		t.Error("expected the job to fail")
+	}
+	if rp, _ := mem.GetRemindParams(usr); !rp.LastReminded.IsZero() {
+		t.Errorf("expected the reminder not to be marked, got %v", rp.LastReminded)
+	}
+
+	m.sendReminder = send
+	if err := m.job(usr)("", at); err != nil {
+		t.Fatal(err)
+	}
+	expectSent(t, sent, &reminder{usr: usr})
+
+	// the reminder claimed by a replica that died is sent again once the claim
+	// expires
+	next := at.Add(24 * time.Hour)
+	if _, err := mem.ClaimReminder(usr, next, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.job(usr)("", next); err != nil {
+		t.Fatal(err)
+	}
+	expectSent(t, sent, &reminder{usr: usr})
+
+	// the reminder that can't ever be delivered isn't retried
+	next = next.Add(24 * time.Hour)
+	m.sendReminder = func(int64, time.Time) error {
+		return &bot.PermanentError{Err: errors.New("Forbidden: bot was blocked by the user")}
+	}
+	if err := m.job(usr)("", next); err != nil {
+		t.Errorf("expected the permanent failure not to be retried, got %v", err)
+	}
+	if rp, _ := mem.GetRemindParams(usr); !rp.LastReminded.Equal(next) {
+		t.Errorf("expected the reminder to be marked, got %v", rp.LastReminded)
+	}
+}
+
+// failingStore is a job store failing to save the job with the key
+type failingStore struct {
+	key string
+}
+
+func (s failingStore) LoadJobs(context.Context, string) ([]bot.JobRecord, error) { return nil, nil }
+func (s failingStore) DeleteJob(context.Context, string, string) error           { return nil }
+
+func (s failingStore) SaveJob(_ context.Context, _ string, rec bot.JobRecord) error {
+	if rec.Key == s.key {
+		return errors.New("db is down")
+	}
+	return nil
+}
+
+func (s failingStore) ClaimJob(context.Context, string, string, string, time.Time,
+	time.Duration) (bot.JobRecord, bool, error) {
+	return bot.JobRecord{}, true, nil
+}
+
+func (s failingStore) CompleteJob(context.Context, string, string, string, time.Time, time.Time) (bool, error) {
+	return true, nil
+}
+
+func TestSetFailure(t *testing.T) {
+	mem := db.NewMemory()
+	if err := mem.CreateUser(usr); err != nil {
+		t.Fatal(err)
+	}
+	l := zap.NewNop().Sugar()
+	tasks := &bot.Tasks{Logger: l}
+	scheduler := bot.NewScheduler("test", failingStore{key: jobKey(usr, 600)}, tasks, l)
+	m := NewManager("test", mem, scheduler, tasks, 0, func(int64, time.Time) error { return nil }, l)
+
+	daily := func(at ...int) []db.RemindTime {
+		var times []db.RemindTime
+		for _, a := range at {
+			times = append(times, db.RemindTime{At: a, Days: db.EveryDay})
+		}
+		return times
+	}
+
+	if err := mem.SetRemindTimes(usr, daily(480, 540)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set(usr); err != nil {
+		t.Fatal(err)
+	}
+
+	// the previous reminders are replaced even if a new one fails
+	if err := mem.SetRemindTimes(usr, daily(540, 600)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set(usr); err == nil {
+		t.Error("expected the failure to be returned")
+	}
+	if _, ok := scheduler.Next(jobKey(usr, 480)); ok {
+		t.Error("expected the previous reminder to be canceled")
+	}
+	if scheduler.Len() != 2 {
+		t.Errorf("expected two reminder jobs, got %d", scheduler.Len())
+	}
+
+	// none of the scheduled reminders is left behind
+	m.Cancel(usr)
+	if scheduler.Len() != 0 {
+		t.Errorf("expected reminders to be canceled, got %d jobs", scheduler.Len())
+	}
+}
@@ -0,0 +1,318 @@
+package tgbot
+
+import (
+	"botfarm/bot"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cmdStart     = "start"
+	cmdAdd       = "add"
+	cmdIns       = "ins"
+	cmdDone      = "done"
+	cmdDel       = "del"
+	cmdList      = "list"
+	cmdListAll   = "listall"
+	cmdRemindAt  = "remindat"
+	cmdRemindOn  = "remindon"
+	cmdRemindOff = "remindoff"
+	cmdMakeFirst = "makefirst"
+	cmdMakeLast  = "makelast"
+	cmdHelp      = "help"
+	cmdSettings  = "settings"
+)
+
+// Menu lists commands of the bot menu (see bot.SetCommands)
+func Menu() []bot.MenuCommand {
+	cmds := []bot.MenuCommand{}
+	for _, cmd := range []string{cmdList, cmdListAll, cmdAdd, cmdIns, cmdDone, cmdDel, cmdMakeFirst, cmdMakeLast,
+		cmdRemindAt, cmdRemindOff, cmdRemindOn, cmdSettings, cmdHelp} {
+		cmds = append(cmds, bot.MenuCommand{Command: cmd, Description: "menu." + cmd})
+	}
+
+	return append(cmds,
+		bot.MenuCommand{Command: bot.CmdCancel, Description: "menu.cancel"},
+		bot.MenuCommand{Command: bot.CmdLanguage, Description: "menu.language"},
+	)
+}
+
+// Routes registers the bot handlers in the router
+func (b *TBot) Routes(r *bot.Router) {
+	r.Use(b.I18n.Middleware())
+
+	command := func(name string, h bot.HandlerFunc) {
+		r.Command(name, func(req *bot.Request) {
+			// Commands interrupt any ongoing command
+			if b.conv.Cancel(req) != nil {
+				b.handleSessionUnavailable(req)
+				return
+			}
+			h(req)
+		})
+	}
+
+	command(cmdStart, b.handleStart)
+	command(cmdHelp, b.handleHelp)
+	command(cmdList, b.handleList)
+	command(cmdListAll, b.handleListAll)
+	command(cmdAdd, b.handleAdd)
+	command(cmdIns, b.handleIns)
+	command(cmdDel, b.handleDel)
+	command(cmdDone, b.handleDone)
+	command(cmdMakeFirst, b.handleMakeFirst)
+	command(cmdMakeLast, b.handleMakeLast)
+	command(cmdRemindAt, b.handleRemindAt)
+	command(cmdRemindOn, b.handleRemindOn)
+	command(cmdRemindOff, b.handleRemindOff)
+	command(cmdSettings, b.handleSettings)
+	command(bot.CmdLanguage, b.I18n.Handler(func(req *bot.Request, txt string) {
+		b.SendMessage(req.User, txt, -1, nil)
+	}))
+	r.UnknownCommand(b.handleUnknownCommand)
+
+	b.conv.Unavailable(b.handleSessionUnavailable)
+	b.conv.Routes(r, b.handleCancel)
+	r.Location(b.handleLocation)
+
+	r.Callback(cbqShowAll, b.handleShowAll)
+	r.Callback(cbqRetry, b.handleShowAll)
+}
+
+// startConversation starts the conversation at the state, the user is asked to
+// retry if the session is unavailable
+func (b *TBot) startConversation(req *bot.Request, state string) {
+	if b.conv.Start(req, state) != nil {
+		b.handleSessionUnavailable(req)
+	}
+}
+
+func (b *TBot) handleSessionUnavailable(req *bot.Request) {
+	b.SendMessage(req.User, b.tr(req.User, bot.TxtSessionUnavailable), -1, nil)
+}
+
+func (b *TBot) handleStart(req *bot.Request) {
+	usr := req.User
+	msg := req.Message()
+
+	err := b.DB.CreateUser(usr)
+	if err != nil {
+		b.Logger.Errorw("failed creating user", "err", err)
+		b.SendMessage(usr, b.tr(usr, txtFailedStartingBot), msg.MessageID, nil)
+		return
+	}
+
+	err = b.ReminderManager.Set(usr)
+	if err != nil {
+		b.Logger.Warn("failed setting reminder")
+		b.SendMessage(usr, b.tr(usr, txtFailedSetReminder), msg.MessageID, nil)
+		return
+	}
+
+	b.Logger.Info("user has started the bot")
+
+	b.SendMessage(usr, b.tr(usr, txtWelcomeMessage), -1, nil)
+
+	memos, err := b.DB.GetAllMemos(usr, true)
+	if err != nil {
+		b.Logger.Errorw("failed listing memos", "err", err)
+		b.SendMessage(usr, b.tr(usr, txtFailedFetchMemos), msg.MessageID, nil)
+		return
+	}
+
+	b.sendMemosForToday(usr, memos, true)
+}
+
+func (b *TBot) handleHelp(req *bot.Request) {
+	b.SendMessage(req.User, b.tr(req.User, txtHelpMessage), -1, nil)
+}
+
+func (b *TBot) handleList(req *bot.Request) {
+	b.listMemos(req, false)
+}
+
+func (b *TBot) handleListAll(req *bot.Request) {
+	b.listMemos(req, true)
+}
+
+func (b *TBot) listMemos(req *bot.Request, showAll bool) {
+	memos, err := b.DB.GetAllMemos(req.User, true)
+	if err != nil {
+		b.Logger.Errorw("failed listing memos", "err", err)
+		b.SendMessage(req.User, b.tr(req.User, txtFailedFetchMemos), req.Message().MessageID, nil)
+		return
+	}
+
+	b.sendMemosForToday(req.User, memos, showAll)
+}
+
+func (b *TBot) handleAdd(req *bot.Request) {
+	if req.Args != "" {
+		b.addMemo(req.User, req.Args)
+		return
+	}
+
+	if b.SendMessage(req.User, b.tr(req.User, txtSendMeMemo), -1, nil) != nil {
+		return
+	}
+
+	b.startConversation(req, stateAdd)
+}
+
+func (b *TBot) handleIns(req *bot.Request) {
+	if req.Args != "" {
+		b.insertMemo(req.User, req.Args)
+		return
+	}
+
+	if b.SendMessage(req.User, b.tr(req.User, txtSendMeMemo), -1, nil) != nil {
+		return
+	}
+
+	b.startConversation(req, stateIns)
+}
+
+func (b *TBot) handleDel(req *bot.Request) {
+	if req.Args != "" {
+		b.delMemo(req.User, req.Message().MessageID, req.Args)
+		return
+	}
+
+	b.askForMemo(req, txtNothingToDelete, txtWhatToDelete, stateDel)
+}
+
+func (b *TBot) handleDone(req *bot.Request) {
+	if req.Args != "" {
+		b.markAsDone(req.User, req.Message().MessageID, req.Args)
+		return
+	}
+
+	b.askForMemo(req, txtNothingToMarkDone, txtWhatToMarkDone, stateDone)
+}
+
+func (b *TBot) handleMakeFirst(req *bot.Request) {
+	if req.Args != "" {
+		b.reorder(req.User, req.Message().MessageID, req.Args, b.DB.MakeFirst)
+		return
+	}
+
+	b.askForMemo(req, txtNothingToMove, txtWhatToMakeFirst, stateMakeFirst)
+}
+
+func (b *TBot) handleMakeLast(req *bot.Request) {
+	if req.Args != "" {
+		b.reorder(req.User, req.Message().MessageID, req.Args, b.DB.MakeLast)
+		return
+	}
+
+	b.askForMemo(req, txtNothingToMove, txtWhatToMakeLast, stateMakeLast)
+}
+
+// askForMemo shows the list of memos and asks the user to choose one of them
+// for the next state
+func (b *TBot) askForMemo(req *bot.Request, txtNothing, txtQuestion string, next string) {
+	usr := req.User
+
+	memos, err := b.DB.GetAllMemos(usr, true)
+	if err != nil {
+		b.Logger.Errorw("failed listing memos", "err", err)
+		b.SendMessage(usr, b.tr(usr, txtFailedFetchMemos), req.Message().MessageID, nil)
+		return
+	}
+
+	if len(memos) == 0 {
+		b.SendMessage(usr, b.tr(usr, txtNothing), -1, nil)
+		return
+	}
+
+	b.sendMemosForToday(usr, memos, true)
+	if b.SendMessage(usr, b.tr(usr, txtQuestion), -1, nil) != nil {
+		return
+	}
+
+	b.startConversation(req, next)
+}
+
+func (b *TBot) handleRemindAt(req *bot.Request) {
+	usr := req.User
+
+	if req.Args != "" {
+		remindAt, err := b.updateReminder(usr, req.Args)
+		if err != nil {
+			if !errors.Is(err, errInvalidRemindTimes) {
+				b.Logger.Errorw("failed updating reminder", "err", err)
+			}
+			b.SendMessage(usr, b.reminderErrorText(usr, err), req.Message().MessageID, nil)
+			return
+		}
+
+		b.SendMessage(usr, b.tr(usr, fmtGotRemindTime, "Time", remindAt), -1, nil)
+		return
+	}
+
+	if b.SendMessage(usr, b.tr(usr, txtEnterRemindTime), -1, nil) != nil {
+		return
+	}
+
+	b.startConversation(req, stateRemindAt)
+}
+
+func (b *TBot) handleRemindOn(req *bot.Request) {
+	usr := req.User
+
+	if err := b.setReminding(usr, true); err != nil {
+		b.Logger.Errorw("failed turning reminders on", "err", err)
+		b.SendMessage(usr, b.tr(usr, txtFailedUpdateReminder), req.Message().MessageID, nil)
+		return
+	}
+
+	rp, err := b.DB.GetRemindParams(usr)
+	if err != nil || rp == nil {
+		b.Logger.Warnw("failed getting reminder parameters", "err", err)
+		b.SendMessage(usr, b.tr(usr, txtFailedFetchRemindParameters), req.Message().MessageID, nil)
+		return
+	}
+
+	b.SendMessage(usr, b.tr(usr, fmtRemindersOn, "Time", formatRemindTimes(rp.Times), "TimeZone", rp.TimeZone), -1, nil)
+}
+
+func (b *TBot) handleRemindOff(req *bot.Request) {
+	usr := req.User
+
+	if err := b.setReminding(usr, false); err != nil {
+		b.Logger.Errorw("failed turning reminders off", "err", err)
+		b.SendMessage(usr, b.tr(usr, txtFailedUpdateReminder), req.Message().MessageID, nil)
+		return
+	}
+
+	b.SendMessage(usr, b.tr(usr, fmtRemindersOff, "Command", cmdRemindOn), -1, nil)
+}
+
+func (b *TBot) handleSettings(req *bot.Request) {
+	usr := req.User
+
+	rp, err := b.DB.GetRemindParams(usr)
+	if err != nil {
+		b.Logger.Warn("failed getting user config")
+		b.SendMessage(usr, b.tr(usr, txtFailedFetchRemindParameters), req.Message().MessageID, nil)
+		return
+	}
+
+	var txt string
+	if rp == nil {
+		b.Logger.Errorw("no remind params found")
+		txt = b.tr(usr, txtNoRemindTimeHere)
+	} else if rp.Set {
+		txt = b.tr(usr, fmtYourSettings, "Time", formatRemindTimes(rp.Times), "TimeZone", rp.TimeZone,
+			"Command", cmdRemindAt, "Off", cmdRemindOff)
+	} else {
+		txt = b.tr(usr, fmtYourSettingsOff, "Time", formatRemindTimes(rp.Times), "TimeZone", rp.TimeZone,
+			"Command", cmdRemindAt, "On", cmdRemindOn)
+	}
+
+	b.SendMessage(usr, txt, -1, nil)
+}
+
+func (b *TBot) handleUnknownCommand(req *bot.Request) {
+	b.SendMessage(req.User, b.tr(req.User, txtUnknownCommand), req.Message().MessageID, nil)
+}
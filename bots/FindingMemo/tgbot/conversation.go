@@ -0,0 +1,142 @@
+package tgbot
+
+import (
+	"botfarm/bot"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// States of commands waiting for user input
+const (
+	stateAdd       = "add"
+	stateIns       = "ins"
+	stateDel       = "del"
+	stateDone      = "done"
+	stateRemindAt  = "remindat"
+	stateMakeFirst = "makefirst"
+	stateMakeLast  = "makelast"
+)
+
+type session = bot.Session[struct{}]
+
+func (b *TBot) newConversation(timeout time.Duration) *bot.Conversation[struct{}] {
+	c := bot.NewConversation[struct{}](timeout)
+
+	c.State(bot.StateIdle, b.stepIdle)
+	c.State(stateAdd, b.stepAdd)
+	c.State(stateIns, b.stepIns)
+	c.State(stateDel, b.stepDel)
+	c.State(stateDone, b.stepDone)
+	c.State(stateRemindAt, b.stepRemindAt)
+	c.State(stateMakeFirst, b.stepMakeFirst)
+	c.State(stateMakeLast, b.stepMakeLast)
+
+	return c
+}
+
+// stepIdle saves any text that isn't a part of a command as a memo
+func (b *TBot) stepIdle(req *bot.Request, s *session) string {
+	msg := req.Message()
+	usr := req.User
+
+	switch {
+	case msg.Text != "":
+		if err := b.DB.InsertMemo(usr, msg.Text); err != nil {
+			b.Logger.Errorw("failed inserting memo", "err", err)
+			break
+		}
+
+		b.SendMessage(usr, b.tr(usr, txtWhatWasThatText), msg.MessageID, nil)
+
+	case msg.Caption != "":
+		if err := b.DB.InsertMemo(usr, msg.Caption); err != nil {
+			b.Logger.Errorw("failed inserting memo", "err", err)
+			break
+		}
+
+		b.SendMessage(usr, b.tr(usr, txtWhatWasThatCaption), msg.MessageID, nil)
+
+	default:
+		b.SendMessage(usr, b.tr(usr, txtDoNotUnderstandWhatHappened), msg.MessageID, nil)
+	}
+
+	return bot.StateIdle
+}
+
+func (b *TBot) stepAdd(req *bot.Request, s *session) string {
+	b.addMemo(req.User, memoText(req))
+	return bot.StateIdle
+}
+
+func (b *TBot) stepIns(req *bot.Request, s *session) string {
+	b.insertMemo(req.User, memoText(req))
+	return bot.StateIdle
+}
+
+func (b *TBot) stepDel(req *bot.Request, s *session) string {
+	msg := req.Message()
+	b.delMemo(req.User, msg.MessageID, msg.Text)
+	return bot.StateIdle
+}
+
+func (b *TBot) stepDone(req *bot.Request, s *session) string {
+	msg := req.Message()
+	b.markAsDone(req.User, msg.MessageID, msg.Text)
+	return bot.StateIdle
+}
+
+// stepRemindAt waits until the user enters valid time
+func (b *TBot) stepRemindAt(req *bot.Request, s *session) string {
+	msg := req.Message()
+	usr := req.User
+
+	remindAt, err := b.updateReminder(usr, strings.TrimSpace(msg.Text))
+	if err != nil {
+		b.SendMessage(usr, b.reminderErrorText(usr, err), msg.MessageID, nil)
+		if errors.Is(err, errInvalidRemindTimes) {
+			return s.State
+		}
+
+		// the schedule may have been saved, so the user isn't asked again
+		b.Logger.Errorw("failed updating reminder", "err", err)
+		return bot.StateIdle
+	}
+
+	rp, err := b.DB.GetRemindParams(usr)
+	if err != nil {
+		b.Logger.Warn("failed on setting reminder:")
+		b.SendMessage(usr, b.tr(usr, txtErrorAccessingDatabase), msg.MessageID, nil)
+		return s.State
+	}
+
+	txt := b.tr(usr, fmtRemindTimeUpdated, "Time", remindAt, "TimeZone", rp.TimeZone)
+	b.SendMessage(usr, txt, -1, nil)
+	return bot.StateIdle
+}
+
+func (b *TBot) stepMakeFirst(req *bot.Request, s *session) string {
+	msg := req.Message()
+	b.reorder(req.User, msg.MessageID, msg.Text, b.DB.MakeFirst)
+	return bot.StateIdle
+}
+
+func (b *TBot) stepMakeLast(req *bot.Request, s *session) string {
+	msg := req.Message()
+	b.reorder(req.User, msg.MessageID, msg.Text, b.DB.MakeLast)
+	return bot.StateIdle
+}
+
+func (b *TBot) handleCancel(req *bot.Request) {
+	b.SendMessage(req.User, b.tr(req.User, txtCanceled), -1, nil)
+}
+
+// memoText returns the text of the message or the caption of the media
+func memoText(req *bot.Request) string {
+	msg := req.Message()
+	if msg.Text != "" {
+		return msg.Text
+	}
+	return msg.Caption
+}
@@ -0,0 +1,8 @@
+package tgbot
+
+import "embed"
+
+// Locales keeps message catalogs of the bot (see bot.Catalog)
+//
+//go:embed locales/*.json
+var Locales embed.FS
@@ -0,0 +1,146 @@
+package tgbot
+
+import (
+	"botfarm/bots/FindingMemo/db"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxRemindTimes limits the number of reminder times of a user
+const maxRemindTimes = 10
+
+// weekdays lists days of the week in the order of ranges, Monday first
+var weekdays = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// parseRemindTimes parses the reminder schedule: times in the format HH:MM
+// separated by semicolons or new lines. Every time may be followed by days,
+// e.g. "09:00 mon-fri; 11:00 weekends; 18:00". Days of the same time are
+// merged.
+func parseRemindTimes(txt string) ([]db.RemindTime, error) {
+	byTime := map[int]db.Weekdays{}
+	for _, entry := range strings.FieldsFunc(txt, func(r rune) bool { return r == ';' || r == '\n' }) {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		at, err := parseTime(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		days, err := parseWeekdays(strings.Join(fields[1:], ""))
+		if err != nil {
+			return nil, err
+		}
+
+		byTime[at] |= days
+	}
+
+	if len(byTime) == 0 {
+		return nil, errUnknownFormat
+	}
+	if len(byTime) > maxRemindTimes {
+		return nil, errOutOfRange
+	}
+
+	times := make([]db.RemindTime, 0, len(byTime))
+	for at, days := range byTime {
+		times = append(times, db.RemindTime{At: at, Days: days})
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].At < times[j].At })
+
+	return times, nil
+}
+
+// parseTime parses time of day in the format HH:MM and returns the number of
+// minutes
+func parseTime(txt string) (int, error) {
+	parts := strings.Split(txt, ":")
+	if len(parts) != 2 {
+		return 0, errUnknownFormat
+	}
+
+	hour, err := validateInt(parts[0], 0, 23)
+	if err != nil {
+		return 0, err
+	}
+
+	min, err := validateInt(parts[1], 0, 59)
+	if err != nil {
+		return 0, err
+	}
+
+	return hour*60 + min, nil
+}
+
+// parseWeekdays parses days of the week: "daily", "weekdays", "weekends" or
+// days and ranges of days like "mon-fri" separated by commas. No days means
+// every day.
+func parseWeekdays(txt string) (db.Weekdays, error) {
+	switch strings.ToLower(txt) {
+	case "", "daily":
+		return db.EveryDay, nil
+	case "weekdays":
+		return db.Workdays, nil
+	case "weekends":
+		return db.Weekends, nil
+	}
+
+	var days db.Weekdays
+	for _, part := range strings.Split(strings.ToLower(txt), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := weekdayIndex(from)
+		if err != nil {
+			return 0, err
+		}
+		last := first
+		if isRange {
+			if last, err = weekdayIndex(to); err != nil {
+				return 0, err
+			}
+		}
+
+		// ranges may wrap around the week, e.g. "sat-mon"
+		for i := first; ; i = (i + 1) % len(weekdays) {
+			days |= 1 << uint(weekdays[i])
+			if i == last {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+// weekdayIndex returns the index of the day named by its first three letters
+// or in full in weekdays
+func weekdayIndex(name string) (int, error) {
+	if len(name) >= 3 {
+		for i, d := range weekdays {
+			if strings.HasPrefix(strings.ToLower(d.String()), name) {
+				return i, nil
+			}
+		}
+	}
+
+	return 0, errUnknownFormat
+}
+
+// formatRemindTimes formats the reminder schedule, so it can be parsed by
+// parseRemindTimes
+func formatRemindTimes(times []db.RemindTime) string {
+	if len(times) == 0 {
+		return "-"
+	}
+
+	entries := make([]string, 0, len(times))
+	for _, t := range times {
+		entries = append(entries, t.String())
+	}
+
+	return strings.Join(entries, "; ")
+}
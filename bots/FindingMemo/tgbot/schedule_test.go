@@ -0,0 +1,129 @@
+package tgbot
+
+import (
+	"botfarm/bots/FindingMemo/db"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func days(d ...time.Weekday) db.Weekdays {
+	var w db.Weekdays
+	for _, day := range d {
+		w |= 1 << uint(day)
+	}
+	return w
+}
+
+func TestParseWeekdays(t *testing.T) {
+	for _, tc := range []struct {
+		txt  string
+		want db.Weekdays
+		err  bool
+	}{
+		{txt: "", want: db.EveryDay},
+		{txt: "daily", want: db.EveryDay},
+		{txt: "Weekdays", want: db.Workdays},
+		{txt: "weekends", want: db.Weekends},
+		{txt: "mon", want: days(time.Monday)},
+		{txt: "Monday,wed", want: days(time.Monday, time.Wednesday)},
+		{txt: "mon-fri", want: db.Workdays},
+		{txt: "tue-tue", want: days(time.Tuesday)},
+
+		// ranges wrap around the week
+		{txt: "fri-mon", want: days(time.Friday, time.Saturday, time.Sunday, time.Monday)},
+		{txt: "sun-sat", want: db.EveryDay},
+
+		// duplicates are merged
+		{txt: "mon,mon-tue,tue", want: days(time.Monday, time.Tuesday)},
+		{txt: "sat-sun,sun", want: db.Weekends},
+
+		{txt: "mo", err: true},
+		{txt: "funday", err: true},
+		{txt: "mon-", err: true},
+		{txt: "-fri", err: true},
+		{txt: "mon-fri-sat", err: true},
+		{txt: "mon,,tue", err: true},
+		{txt: "weekdays,sat", err: true},
+	} {
+		got, err := parseWeekdays(tc.txt)
+		switch {
+		case tc.err && err == nil:
+			t.Errorf("%q: expected an error, got %v", tc.txt, got)
+		case !tc.err && err != nil:
+			t.Errorf("%q: unexpected error %v", tc.txt, err)
+		case got != tc.want:
+			t.Errorf("%q: expected %v, got %v", tc.txt, tc.want, got)
+		}
+	}
+}
+
+func TestParseRemindTimes(t *testing.T) {
+	// maxRemindTimes times every day and one more
+	var most []string
+	var mostTimes []db.RemindTime
+	for i := 0; i < maxRemindTimes; i++ {
+		most = append(most, fmt.Sprintf("%02d:00", i))
+		mostTimes = append(mostTimes, db.RemindTime{At: i * 60, Days: db.EveryDay})
+	}
+	tooMany := strings.Join(most, "; ") + "; 23:00"
+
+	for _, tc := range []struct {
+		txt  string
+		want []db.RemindTime
+		err  error // errAny for errors of strconv
+	}{
+		{txt: "09:00", want: []db.RemindTime{{At: 9 * 60, Days: db.EveryDay}}},
+		{txt: "0:00; 23:59", want: []db.RemindTime{{At: 0, Days: db.EveryDay}, {At: 23*60 + 59, Days: db.EveryDay}}},
+		{txt: "18:00; 09:00 mon-fri\n11:00 weekends", want: []db.RemindTime{
+			{At: 9 * 60, Days: db.Workdays}, {At: 11 * 60, Days: db.Weekends}, {At: 18 * 60, Days: db.EveryDay},
+		}},
+		{txt: " 22:30 sat - mon ;", want: []db.RemindTime{
+			{At: 22*60 + 30, Days: days(time.Saturday, time.Sunday, time.Monday)},
+		}},
+
+		// days of the same time are merged
+		{txt: "09:00 mon; 9:00 tue", want: []db.RemindTime{{At: 9 * 60, Days: days(time.Monday, time.Tuesday)}}},
+		{txt: "09:00 weekends; 09:00", want: []db.RemindTime{{At: 9 * 60, Days: db.EveryDay}}},
+		{txt: strings.Join(most, "; ") + "; 00:00 mon", want: mostTimes},
+
+		{txt: "24:00", err: errOutOfRange},
+		{txt: "-1:00", err: errOutOfRange},
+		{txt: "12:60", err: errOutOfRange},
+		{txt: "09:00; 25:00 mon", err: errOutOfRange},
+		{txt: tooMany, err: errOutOfRange},
+
+		{txt: "", err: errUnknownFormat},
+		{txt: " ;\n", err: errUnknownFormat},
+		{txt: "0900", err: errUnknownFormat},
+		{txt: "09:00:00", err: errUnknownFormat},
+		{txt: "09:00 someday", err: errUnknownFormat},
+		{txt: "nine:00", err: errAny},
+		{txt: "09:3o", err: errAny},
+	} {
+		got, err := parseRemindTimes(tc.txt)
+		if tc.err != nil {
+			if err == nil || tc.err != errAny && !errors.Is(err, tc.err) {
+				t.Errorf("%q: expected error %v, got %v, %v", tc.txt, tc.err, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.txt, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%q: expected %v, got %v", tc.txt, tc.want, got)
+		}
+
+		// the formatted schedule is parsed back
+		if again, err := parseRemindTimes(formatRemindTimes(got)); err != nil || fmt.Sprint(again) != fmt.Sprint(got) {
+			t.Errorf("%q: expected %v after formatting, got %v, %v", tc.txt, got, again, err)
+		}
+	}
+}
+
+// errAny matches any error
+var errAny = errors.New("any error")
@@ -23,511 +23,142 @@ const (
 	cbqRetry   = "cbqRetry"
 )
 
-var (
-	keyboardShowAll = tg.NewInlineKeyboardMarkup(tg.NewInlineKeyboardRow(tg.NewInlineKeyboardButtonData("Show all", cbqShowAll)))
-	keyboardRetry   = tg.NewInlineKeyboardMarkup(tg.NewInlineKeyboardRow(tg.NewInlineKeyboardButtonData("Retry", cbqRetry)))
-)
-
-type Stage int
-
+// Keys of messages in the catalog (see Locales)
 const (
-	stageIdle Stage = iota
-	stageAdd
-	stageIns
-	stageDel
-	stageDone
-	stageRemindAt
-	stageMakeFirst
-	stageMakeLast
+	txtWelcomeMessage              = "welcome"
+	txtHelpMessage                 = "help"
+	txtUnknownCommand              = "unknown_command"
+	txtDoNotUnderstandWhatHappened = "not_understood"
+	txtWhatWasThatText             = "saved_text"
+	txtWhatWasThatCaption          = "saved_caption"
+	txtErrorAccessingDatabase      = "error.database"
+	txtNothingToDelete             = "nothing_to_delete"
+	txtNothingToMarkDone           = "nothing_to_mark_done"
+	txtNothingToMove               = "nothing_to_move"
+	txtFailedDeleMemo              = "error.delete_memo"
+	txtFailedAddMemo               = "error.add_memo"
+	txtFailedInsertMemo            = "error.insert_memo"
+	txtFailedFetchMemos            = "error.fetch_memos"
+	txtFailedStartingBot           = "error.start"
+	txtFailedSetReminder           = "error.set_reminder"
+	txtFailedReorder               = "error.reorder"
+	txtFailedUpdateReminder        = "error.update_reminder"
+	txtFailedFetchRemindParameters = "error.fetch_remind_params"
+	txtExpectedValidTimeFormat     = "invalid_time"
+	txtNoRemindTimeHere            = "no_remind_time"
+	txtWhatToDelete                = "ask.delete"
+	txtWhatToMarkDone              = "ask.done"
+	txtWhatToMakeFirst             = "ask.make_first"
+	txtWhatToMakeLast              = "ask.make_last"
+	txtSendMeMemo                  = "ask.memo"
+	txtEnterRemindTime             = "ask.remind_time"
+	txtNoActiveMemos               = "memos.none"
+	txtYourActiveMemos             = "memos.active" // Count
+	txtYourDoneMemos               = "memos.done"
+	txtYourDeletedMemos            = "memos.deleted"
+	txtCanceled                    = "canceled"
+	txtShowAll                     = "button.show_all"
+	txtRetry                       = "button.retry"
+
+	fmtGotRemindTime         = "remind_time_set"     // Time
+	fmtTimeZoneAccepted      = "time_zone_set"       // TimeZone
+	fmtRemindTimeUpdated     = "remind_time_updated" // Time, TimeZone
+	fmtRemindersOn           = "reminders_on"        // Time, TimeZone
+	fmtRemindersOff          = "reminders_off"       // Command
+	fmtYourSettings          = "settings"            // Time, TimeZone, Command, Off
+	fmtYourSettingsOff       = "settings.off"        // Time, TimeZone, Command, On
+	fmtNumberInRangeExpected = "number_expected"     // Max
+	fmtMissedReminder        = "reminder.missed"     // Time
 )
 
-const (
-	txtWelcomeMessage = "Hello, I'm an experienced memo keeper. I write down your memos and remind about them from time to time. By the way, you can tell me when to send you the reminder, so I won't wake you up when you decided to stay in bed ;) Send me your location so I'll know in which time zone is your time"
-	txtHelpMessage    = `As you may know, I keep your memos in order and periodically remind about them. You can send me a message or one of these commands:
-/list - to see short list of your memos
-/listall - to see full list of your memos
-/ins - to add a new memo at the beginning of the list
-/add - to add a new memo at the end of the list
-/del - to immediately delete the memo
-/done - to mark the memo as done, I'll hide done memos in approximately 24 hours
-/remindat - to let me know when to send you a reminder (send location to update time zone)
-/makefirst - to move a memo to the beginning of the list
-/makelast - to move a memo to the end of the list
-/settings - to list settings`
-	txtUnknownCommand              = "I don't known this command. Use /help to list the commands I know"
-	txtDoNotUnderstandWhatHappened = "E-mm, I didn't understand what have just happened"
-	txtWhatWasThatText             = "Looks like you wanted to insert a memo from a media. Saved the message text as a memo"
-	txtWhatWasThatCaption          = "Looks like you wanted to insert a memo. Saved the caption as a memo"
-	txtErrorAccessingDatabase      = "Oops, I couldn't get your memos. Retry again. If it didn't help, try again later"
-	txtNothingToDelete             = "There's nothing to delete"
-	txtNothingToMarkDone           = "There's nothing to mark as done"
-	txtNothingToMove               = "There's no memos to move"
-	txtFailedDeleMemo              = "I failed to delete the memo. Please retry now or later"
-	txtFailedAddMemo               = "I failed to add the memo. Please retry now or later"
-	txtFailedInsertMemo            = "I failed to insert the memo. Please retry now or later"
-	txtFailedFetchMemos            = "I'm sorry, I couldn't fetch the list of memos"
-	txtFailedStartingBot           = "Hey, I couldn't start. Let's try again!"
-	txtFailedSetReminder           = "Hm. I couldn't set a reminder"
-	txtFailedReorder               = "Argh, I failed to move the memo!"
-	txtFailedUpdateReminder        = "Oh, no! I couldn't update the reminder! Try again!"
-	txtFailedFetchRemindParameters = "I'm sorry, I couldn't fetch the reminder parameters"
-	txtExpectedValidTimeFormat     = "I expect a valid time in the format HH:MM. Please repeat the command and enter correct value"
-	txtNoRemindTimeHere            = "I don't see remind time here"
-	txtWhatToDelete                = "Which memo do you want to delete?"
-	txtWhatToMarkDone              = "Which memo do you want to mark as done?"
-	txtWhatToMakeFirst             = "Which memo do you want to move to the beginning of the list?"
-	txtWhatToMakeLast              = "Which memo do you want to move to the end of the list?"
-	txtGotRemindTime               = "Gotcha, I'll remind at "
-	txtSendMeMemo                  = "Send me your memo"
-	txtEnterRemindTime             = "Enter hour and minute to send you a reminder in the format HH:MM. Send location to update timezone"
-	txtNoActiveMemos               = "Congrats, you don't have any active memos at the moment!\n"
-	txtYourActiveMemos             = "Your active memos:\n"
-	txtYourDoneMemos               = "\nMemos you've recently done:\n"
-	txtYourDeletedMemos            = "\nMemos you've recently deleted:\n"
-
-	fmtTimeZoneAccepted      = "Time zone identified as %s, it will be used in time offset and transition to daylight saving time if any"
-	fmtRemindTimeUpdated     = "I got it, I'll remind you about your memos at %s in %s time zone"
-	fmtYourSettings          = "Reminder time: %02d:%02d (%s).\n\nUse command '/%s' to change the time.\nYou can send location to update the time zone"
-	fmtNumberInRangeExpected = "I expected a number in the range of 1-%d. Please repeat the command and enter correct value"
-	fmtMemo                  = "[<code>%d</code>] %s\n"
-)
+const fmtMemo = "[<code>%d</code>] %s\n"
 
 var (
 	errUnknownFormat = errors.New("unknown format")
 	errOutOfRange    = errors.New("value is out of range")
+	// errInvalidRemindTimes wraps errors of parsing reminder schedules
+	errInvalidRemindTimes = errors.New("invalid reminder times")
 )
 
-type state struct {
-	stage Stage
-}
-
-type Command struct {
-	Name string
-	Len  int
-}
-
-func makeCommand(name string) *Command {
-	return &Command{
-		Name: name,
-		Len:  len(name) + 2, // leading '/' and trailing space
-	}
+// Store keeps memos and reminder settings of users (see db.Database)
+type Store interface {
+	CreateUser(usr int64) error
+	GetAllMemos(usr int64, short bool) ([]db.Memo, error)
+	GetActiveMemoCount(usr int64) (int, error)
+	AddMemo(usr int64, text string) error
+	InsertMemo(usr int64, text string) error
+	MarkAsDone(usr int64, n int) error
+	DeleteMemo(usr int64, n int) error
+	MakeFirst(usr int64, n int) error
+	MakeLast(usr int64, n int) error
+	GetRemindParams(usr int64) (*db.RemindParams, error)
+	SetRemindTimes(usr int64, times []db.RemindTime) error
+	SetRemind(usr int64, on bool) error
+	UpdateTZ(usr int64, loc *timezone.GeoLocation, tz string) error
 }
 
-var (
-	cmdStart     = makeCommand("start")
-	cmdAdd       = makeCommand("add")
-	cmdIns       = makeCommand("ins")
-	cmdDone      = makeCommand("done")
-	cmdDel       = makeCommand("del")
-	cmdList      = makeCommand("list")
-	cmdListAll   = makeCommand("listall")
-	cmdRemindAt  = makeCommand("remindat")
-	cmdMakeFirst = makeCommand("makefirst")
-	cmdMakeLast  = makeCommand("makelast")
-	cmdHelp      = makeCommand("help")
-	cmdSettings  = makeCommand("settings")
-)
-
 type TBot struct {
-	Bot             *tg.BotAPI
-	DB              *db.Database
+	Name            string
+	Transport       bot.Transport
+	Bulk            bot.Transport // sends reminders with low priority
+	DB              Store
 	Logger          *zap.SugaredLogger
 	ReminderManager *reminder.Manager
-	RetryDelay      time.Duration
-	RetryAttempts   int
-	states          map[int64]*state
+	I18n            *bot.I18n
+	conv            *bot.Conversation[struct{}]
 }
 
-func NewTBot(tgtoken string, d *db.Database, l *zap.SugaredLogger) (*TBot, error) {
-	b, err := tg.NewBotAPI(tgtoken)
-	if err != nil {
-		l.Errorw("failed to initialize Telegram Bot", "err", err)
-		return &TBot{}, err
-	}
-
-	b.Debug = false
-
-	l.Infof("authorized on account %q (%q, %d)", b.Self.FirstName, b.Self.UserName, b.Self.ID)
-
-	return &TBot{
-		Bot:           b,
-		DB:            d,
-		Logger:        l,
-		RetryAttempts: 3,
-		RetryDelay:    1 * time.Second,
-		states:        make(map[int64]*state),
-	}, nil
+// NewTBot creates TBot. Replies are sent with t, reminders are sent with bulk.
+// Texts are sent in the user's language selected by i18n. Commands waiting for
+// user input are kept in store and canceled after convTimeout.
+func NewTBot(name string, t, bulk bot.Transport, d Store, i18n *bot.I18n, store bot.StateStore,
+	convTimeout time.Duration, l *zap.SugaredLogger) *TBot {
+	self := t.Self()
+	l.Infof("authorized on account %q (%q, %d)", self.FirstName, self.UserName, self.ID)
+
+	b := &TBot{
+		Name:      name,
+		Transport: t,
+		Bulk:      bulk,
+		DB:        d,
+		Logger:    l,
+		I18n:      i18n,
+	}
+	b.conv = b.newConversation(convTimeout)
+	b.conv.Persist(store)
+
+	return b
 }
 
-func (b *TBot) HandleMessage(msg *tg.Message) {
-	usr := msg.From.ID
-
-	userState := b.states[usr]
-	if userState == nil {
-		userState = &state{stageIdle}
-		b.states[usr] = userState
-	}
-
-	switch userState.stage {
-	case stageIdle:
-		switch {
-		case msg.Location != nil:
-			loc := msg.Location
-			tzName, err := b.updateTimeZone(usr, loc)
-			if err != nil {
-				b.Logger.Errorw("couldn't update time zone", "err", err)
-			}
-
-			txt := fmt.Sprintf(fmtTimeZoneAccepted, tzName)
-			b.SendMessage(usr, txt, msg.MessageID, nil)
-
-		case msg.Text != "":
-			if err := b.DB.InsertMemo(usr, msg.Text); err != nil {
-				b.Logger.Errorw("failed inserting memo", "err", err)
-				return
-			}
-
-			b.SendMessage(usr, txtWhatWasThatText, msg.MessageID, nil)
-
-		case msg.Caption != "":
-			if err := b.DB.InsertMemo(usr, msg.Caption); err != nil {
-				b.Logger.Errorw("failed inserting memo", "err", err)
-				return
-			}
-
-			b.SendMessage(usr, txtWhatWasThatCaption, msg.MessageID, nil)
-
-		default:
-			b.SendMessage(usr, txtDoNotUnderstandWhatHappened, msg.MessageID, nil)
-		}
-
-	case stageAdd:
-		var txt string
-		switch {
-		case msg.Text != "":
-			txt = msg.Text
-		case msg.Caption != "":
-			txt = msg.Text
-		}
-
-		b.addMemo(usr, txt)
-		userState.stage = stageIdle
-
-	case stageIns:
-		var txt string
-		switch {
-		case msg.Text != "":
-			txt = msg.Text
-		case msg.Caption != "":
-			txt = msg.Text
-		}
-
-		b.insertMemo(usr, txt)
-		userState.stage = stageIdle
-
-	case stageDel:
-		b.delMemo(usr, msg.MessageID, msg.Text)
-		userState.stage = stageIdle
-
-	case stageDone:
-		b.markAsDone(usr, msg.MessageID, msg.Text)
-		userState.stage = stageIdle
-
-	case stageRemindAt:
-		remindAt := strings.TrimSpace(msg.Text)
-		err := b.updateReminder(usr, remindAt)
-		if err != nil {
-			b.Logger.Errorw("failed updating reminder", "err", err)
-			b.SendMessage(usr, txtExpectedValidTimeFormat, msg.MessageID, nil)
-			return
-		}
-
-		rp, err := b.DB.GetRemindParams(usr)
-		if err != nil {
-			b.Logger.Warn("failed on setting reminder:")
-			b.SendMessage(usr, txtErrorAccessingDatabase, msg.MessageID, nil)
-			return
-		}
+// handleLocation updates the user's time zone. The location is accepted at
+// any state, e.g. while the bot waits for the reminder time.
+func (b *TBot) handleLocation(req *bot.Request) {
+	msg := req.Message()
 
-		txt := fmt.Sprintf(fmtRemindTimeUpdated, remindAt, rp.TimeZone)
-		b.SendMessage(usr, txt, -1, nil)
-		userState.stage = stageIdle
-
-	case stageMakeFirst:
-		b.reorder(usr, msg.MessageID, msg.Text, b.DB.MakeFirst)
-		userState.stage = stageIdle
-
-	case stageMakeLast:
-		b.reorder(usr, msg.MessageID, msg.Text, b.DB.MakeLast)
-		userState.stage = stageIdle
-	}
-}
-
-func (b *TBot) HandleCommand(msg *tg.Message) {
-	usr := msg.From.ID
-
-	userState := b.states[usr]
-	if userState == nil {
-		userState = &state{stageIdle}
-		b.states[usr] = userState
-	}
-
-	if userState.stage != stageIdle {
-		// Commands interrupt any ongoing command
-		userState.stage = stageIdle
+	tzName, err := b.updateTimeZone(req.User, msg.Location)
+	if err != nil {
+		b.Logger.Errorw("couldn't update time zone", "err", err)
 	}
 
-	cmd := msg.Command()
-	switch cmd {
-	case cmdStart.Name:
-		err := b.DB.CreateUser(usr)
-		if err != nil {
-			b.Logger.Errorw("failed creating user", "err", err)
-			b.SendMessage(usr, txtFailedStartingBot, msg.MessageID, nil)
-			return
-		}
-
-		err = b.ReminderManager.Set(usr)
-		if err != nil {
-			b.Logger.Warn("failed setting reminder")
-			b.SendMessage(usr, txtFailedSetReminder, msg.MessageID, nil)
-			return
-		}
-
-		b.Logger.Info("user has started the bot")
-
-		b.SendMessage(usr, txtWelcomeMessage, -1, nil)
-
-		memos, err := b.DB.GetAllMemos(usr, true)
-		if err != nil {
-			b.Logger.Errorw("failed listing memos", "err", err)
-			b.SendMessage(usr, txtFailedFetchMemos, msg.MessageID, nil)
-			return
-		}
-
-		b.sendMemosForToday(usr, memos, true)
-
-	case cmdHelp.Name:
-		b.SendMessage(usr, txtHelpMessage, -1, nil)
-
-	case cmdList.Name:
-		memos, err := b.DB.GetAllMemos(usr, true)
-		if err != nil {
-			b.Logger.Errorw("failed listing memos", "err", err)
-			b.SendMessage(usr, txtFailedFetchMemos, msg.MessageID, nil)
-			return
-		}
-
-		b.sendMemosForToday(usr, memos, false)
-
-	case cmdListAll.Name:
-		memos, err := b.DB.GetAllMemos(usr, true)
-		if err != nil {
-			b.Logger.Errorw("failed listing memos", "err", err)
-			b.SendMessage(usr, txtFailedFetchMemos, msg.MessageID, nil)
-			return
-		}
-
-		b.sendMemosForToday(usr, memos, true)
-
-	case cmdAdd.Name:
-		if len(msg.Text) > cmdAdd.Len {
-			txt := strings.TrimSpace(msg.Text[cmdAdd.Len:])
-			b.addMemo(usr, txt)
-			return
-		}
-
-		err := b.SendMessage(usr, txtSendMeMemo, -1, nil)
-		if err != nil {
-			return
-		}
-
-		userState.stage = stageAdd
-
-	case cmdIns.Name:
-		if len(msg.Text) > cmdIns.Len {
-			txt := strings.TrimSpace(msg.Text[cmdIns.Len:])
-			b.insertMemo(usr, txt)
-			return
-		}
-
-		if b.SendMessage(usr, txtSendMeMemo, -1, nil) != nil {
-			return
-		}
-
-		userState.stage = stageIns
-
-	case cmdDel.Name:
-		if len(msg.Text) > cmdDel.Len {
-			txt := strings.TrimSpace(msg.Text[cmdDel.Len:])
-			b.delMemo(usr, msg.MessageID, txt)
-			return
-		}
-
-		memos, err := b.DB.GetAllMemos(usr, true)
-		if err != nil {
-			b.Logger.Errorw("failed listing memos", "err", err)
-			b.SendMessage(usr, txtFailedFetchMemos, msg.MessageID, nil)
-			return
-		}
-
-		if len(memos) == 0 {
-			b.SendMessage(usr, txtNothingToDelete, -1, nil)
-			return
-		}
-
-		b.sendMemosForToday(usr, memos, true)
-		if b.SendMessage(usr, txtWhatToDelete, msg.MessageID, nil) != nil {
-			return
-		}
-
-		userState.stage = stageDel
-
-	case cmdDone.Name:
-		if len(msg.Text) > cmdDone.Len {
-			txt := strings.TrimSpace(msg.Text[cmdDel.Len:])
-			b.markAsDone(usr, msg.MessageID, txt)
-			return
-		}
-
-		memos, err := b.DB.GetAllMemos(usr, true)
-		if err != nil {
-			b.Logger.Errorw("failed listing memos", "err", err)
-			b.SendMessage(usr, txtFailedFetchMemos, msg.MessageID, nil)
-			return
-		}
-
-		if len(memos) == 0 {
-			b.SendMessage(usr, txtNothingToMarkDone, -1, nil)
-			return
-		}
-
-		b.sendMemosForToday(usr, memos, true)
-		if b.SendMessage(usr, txtWhatToMarkDone, -1, nil) != nil {
-			return
-		}
-
-		userState.stage = stageDone
-
-	case cmdMakeFirst.Name:
-		if len(msg.Text) > cmdMakeFirst.Len {
-			txt := strings.TrimSpace(msg.Text[cmdMakeFirst.Len:])
-			b.reorder(usr, msg.MessageID, txt, b.DB.MakeFirst)
-			return
-		}
-
-		memos, err := b.DB.GetAllMemos(usr, true)
-		if err != nil {
-			b.Logger.Errorw("failed listing memos", "err", err)
-			b.SendMessage(usr, txtFailedFetchMemos, msg.MessageID, nil)
-			return
-		}
-
-		if len(memos) == 0 {
-			b.SendMessage(usr, txtNothingToMove, -1, nil)
-			return
-		}
-
-		b.sendMemosForToday(usr, memos, true)
-		if b.SendMessage(usr, txtWhatToMakeFirst, -1, nil) != nil {
-			return
-		}
-
-		userState.stage = stageMakeFirst
-
-	case cmdMakeLast.Name:
-		if len(msg.Text) > cmdMakeLast.Len {
-			txt := strings.TrimSpace(msg.Text[cmdMakeLast.Len:])
-			b.reorder(usr, msg.MessageID, txt, b.DB.MakeLast)
-			return
-		}
-
-		memos, err := b.DB.GetAllMemos(usr, true)
-		if err != nil {
-			b.Logger.Errorw("failed listing memos", "err", err)
-			b.SendMessage(usr, txtFailedFetchMemos, msg.MessageID, nil)
-			return
-		}
-
-		if len(memos) == 0 {
-			b.SendMessage(usr, txtNothingToMove, -1, nil)
-			return
-		}
-
-		b.sendMemosForToday(usr, memos, true)
-		if b.SendMessage(usr, txtWhatToMakeLast, -1, nil) != nil {
-			return
-		}
-
-		userState.stage = stageMakeLast
-
-	case cmdRemindAt.Name:
-		if len(msg.Text) > cmdRemindAt.Len {
-			remindTimeTxt := strings.TrimSpace(msg.Text[cmdRemindAt.Len:])
-			if b.updateReminder(usr, remindTimeTxt) != nil {
-				b.Logger.Error("failed updating reminder")
-				b.SendMessage(usr, txtFailedUpdateReminder, msg.MessageID, nil)
-				return
-			}
-
-			b.SendMessage(usr, txtGotRemindTime+remindTimeTxt, -1, nil)
-			return
-		}
-
-		if b.SendMessage(usr, txtEnterRemindTime, -1, nil) != nil {
-			return
-		}
-
-		userState.stage = stageRemindAt
-
-	case cmdSettings.Name:
-		rp, err := b.DB.GetRemindParams(usr)
-		if err != nil {
-			b.Logger.Warn("failed getting user config")
-			b.SendMessage(usr, txtFailedFetchRemindParameters, msg.MessageID, nil)
-			return
-		}
-
-		var txt string
-		if rp == nil {
-			b.Logger.Errorw("no remind params found")
-			txt = txtNoRemindTimeHere
-		} else {
-			h := rp.RemindAt / 60
-			m := rp.RemindAt - h*60
-			txt = fmt.Sprintf(fmtYourSettings, h, m, rp.TimeZone, cmdRemindAt.Name)
-		}
-
-		b.SendMessage(usr, txt, -1, nil)
-
-	default:
-		b.SendMessage(usr, txtUnknownCommand, msg.MessageID, nil)
-	}
+	txt := b.tr(req.User, fmtTimeZoneAccepted, "TimeZone", tzName)
+	b.SendMessage(req.User, txt, msg.MessageID, nil)
 }
 
-func (b *TBot) HandleCallback(cbq *tg.CallbackQuery) {
-	usr := cbq.From.ID
+func (b *TBot) handleShowAll(req *bot.Request) {
+	usr := req.User
+	cbq := req.Callback()
 
-	userState := b.states[usr]
-	if userState == nil {
-		userState = &state{stageIdle}
-		b.states[usr] = userState
+	memos, err := b.DB.GetAllMemos(usr, true)
+	if err != nil {
+		b.Logger.Errorw("failed listing memos", "err", err)
+		b.ReplaceMessage(usr, b.tr(usr, txtFailedFetchMemos), cbq.Message.MessageID, keyboardRetry(b.I18n.For(usr)))
+		return
 	}
 
-	switch cbq.Data {
-	case cbqShowAll, cbqRetry:
-		memos, err := b.DB.GetAllMemos(usr, true)
-		if err != nil {
-			b.Logger.Errorw("failed listing memos", "err", err)
-			b.ReplaceMessage(usr, txtFailedFetchMemos, cbq.Message.MessageID, &keyboardRetry)
-			return
-		}
-
-		active, done, deleted := groupByState(memos)
-		var sb strings.Builder
-		formatAllMemos(&sb, active, done, deleted)
-		b.ReplaceMessage(usr, sb.String(), cbq.Message.MessageID, nil)
-	}
+	active, done, deleted := groupByState(memos)
+	var sb strings.Builder
+	formatAllMemos(&sb, b.I18n.For(usr), active, done, deleted)
+	b.ReplaceMessage(usr, sb.String(), cbq.Message.MessageID, nil)
 }
 
 func (b *TBot) updateTimeZone(usr int64, loc *tg.Location) (string, error) {
@@ -560,18 +191,18 @@ func (b *TBot) delMemo(usr int64, replyID int, txt string) {
 	n, err := b.DB.GetActiveMemoCount(usr)
 	if err != nil {
 		b.Logger.Errorw("failed getting number of memos", "err", err)
-		b.SendMessage(usr, txtErrorAccessingDatabase, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtErrorAccessingDatabase), -1, nil)
 		return
 	}
 
 	if n == 0 {
-		b.SendMessage(usr, txtNothingToDelete, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtNothingToDelete), -1, nil)
 		return
 	}
 
 	val, err := validateInt(txt, 1, n)
 	if err != nil {
-		txt := fmt.Sprintf(fmtNumberInRangeExpected, n)
+		txt := b.tr(usr, fmtNumberInRangeExpected, "Max", n)
 		b.SendMessage(usr, txt, replyID, nil)
 		return
 	}
@@ -579,14 +210,14 @@ func (b *TBot) delMemo(usr int64, replyID int, txt string) {
 	err = b.DB.DeleteMemo(usr, val)
 	if err != nil {
 		b.Logger.Errorw("failed deleted memo", "err", err)
-		b.SendMessage(usr, txtFailedDeleMemo, replyID, nil)
+		b.SendMessage(usr, b.tr(usr, txtFailedDeleMemo), replyID, nil)
 		return
 	}
 
 	memos, err := b.DB.GetAllMemos(usr, true)
 	if err != nil {
 		b.Logger.Errorw("failed listing memos", "err", err)
-		b.SendMessage(usr, txtFailedFetchMemos, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtFailedFetchMemos), -1, nil)
 		return
 	}
 
@@ -597,17 +228,17 @@ func (b *TBot) markAsDone(usr int64, replyID int, txt string) {
 	n, err := b.DB.GetActiveMemoCount(usr)
 	if err != nil {
 		b.Logger.Errorw("failed getting number of memos", "err", err)
-		b.SendMessage(usr, txtErrorAccessingDatabase, replyID, nil)
+		b.SendMessage(usr, b.tr(usr, txtErrorAccessingDatabase), replyID, nil)
 		return
 	}
 
 	if n == 0 {
-		b.SendMessage(usr, txtNothingToMarkDone, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtNothingToMarkDone), -1, nil)
 	}
 
 	val, err := validateInt(txt, 1, n)
 	if err != nil {
-		txt := fmt.Sprintf(fmtNumberInRangeExpected, n)
+		txt := b.tr(usr, fmtNumberInRangeExpected, "Max", n)
 		b.SendMessage(usr, txt, replyID, nil)
 		return
 	}
@@ -615,14 +246,14 @@ func (b *TBot) markAsDone(usr int64, replyID int, txt string) {
 	err = b.DB.MarkAsDone(usr, val)
 	if err != nil {
 		b.Logger.Errorw("failed marking memo as done", "err", err)
-		b.SendMessage(usr, txtErrorAccessingDatabase, replyID, nil)
+		b.SendMessage(usr, b.tr(usr, txtErrorAccessingDatabase), replyID, nil)
 		return
 	}
 
 	memos, err := b.DB.GetAllMemos(usr, true)
 	if err != nil {
 		b.Logger.Errorw("failed listing memos", "err", err)
-		b.SendMessage(usr, txtFailedFetchMemos, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtFailedFetchMemos), -1, nil)
 		return
 	}
 
@@ -633,14 +264,14 @@ func (b *TBot) addMemo(usr int64, txt string) {
 	err := b.DB.AddMemo(usr, txt)
 	if err != nil {
 		b.Logger.Errorw("failed adding memo", "err", err)
-		b.SendMessage(usr, txtFailedAddMemo, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtFailedAddMemo), -1, nil)
 		return
 	}
 
 	memos, err := b.DB.GetAllMemos(usr, true)
 	if err != nil {
 		b.Logger.Errorw("failed listing memos", "err", err)
-		b.SendMessage(usr, txtFailedFetchMemos, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtFailedFetchMemos), -1, nil)
 		return
 	}
 
@@ -651,72 +282,91 @@ func (b *TBot) insertMemo(usr int64, txt string) {
 	err := b.DB.InsertMemo(usr, txt)
 	if err != nil {
 		b.Logger.Errorw("failed inserting memo", "err", err)
-		b.SendMessage(usr, txtFailedInsertMemo, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtFailedInsertMemo), -1, nil)
 		return
 	}
 
 	memos, err := b.DB.GetAllMemos(usr, true)
 	if err != nil {
 		b.Logger.Errorw("failed listing memos", "err", err)
-		b.SendMessage(usr, txtFailedFetchMemos, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtFailedFetchMemos), -1, nil)
 		return
 	}
 
 	b.sendMemosForToday(usr, memos, false)
 }
 
-func (b *TBot) updateReminder(usr int64, txt string) error {
-	parts := strings.Split(txt, ":")
-	if len(parts) != 2 {
-		return errUnknownFormat
+// updateReminder replaces the reminder schedule of the user with the parsed
+// one and returns it formatted. Parse errors wrap errInvalidRemindTimes.
+func (b *TBot) updateReminder(usr int64, txt string) (string, error) {
+	times, err := parseRemindTimes(txt)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidRemindTimes, err)
 	}
 
-	hour, err := validateInt(parts[0], 0, 23)
+	err = b.DB.SetRemindTimes(usr, times)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	min, err := validateInt(parts[1], 0, 59)
-	if err != nil {
-		return err
+	return formatRemindTimes(times), b.ReminderManager.Set(usr)
+}
+
+// reminderErrorText returns the text telling the user why updateReminder
+// failed
+func (b *TBot) reminderErrorText(usr int64, err error) string {
+	if errors.Is(err, errInvalidRemindTimes) {
+		return b.tr(usr, txtExpectedValidTimeFormat)
 	}
+	return b.tr(usr, txtFailedUpdateReminder)
+}
 
-	t := hour*60 + min
-	err = b.DB.SetRemindAt(usr, t)
-	if err != nil {
+// setReminding turns reminders of the user on or off
+func (b *TBot) setReminding(usr int64, on bool) error {
+	if err := b.DB.SetRemind(usr, on); err != nil {
 		return err
 	}
 
 	return b.ReminderManager.Set(usr)
 }
 
-// SendReminder is a callback that's invoked by reminder
-func (b *TBot) SendReminder(usr int64) {
+// SendReminder is a callback that's invoked by reminder. If the reminder is
+// sent late, missed is its fire time, otherwise it's zero. It returns an error
+// if the reminder isn't delivered, so it's sent again.
+func (b *TBot) SendReminder(usr int64, missed time.Time) error {
+	// reminders give way to replies to users
+	rb := *b
+	rb.Transport = b.Bulk
+	b = &rb
+
 	memos, err := b.DB.GetAllMemos(usr, true)
 	if err != nil {
-		b.Logger.Errorw("failed listing memos", "err", err)
-		b.SendMessage(usr, txtFailedFetchMemos, -1, nil)
-		return
+		return errors.Wrap(err, "failed listing memos")
 	}
 
-	b.sendMemosForToday(usr, memos, false)
+	header := ""
+	if !missed.IsZero() {
+		header = b.tr(usr, fmtMissedReminder, "Time", missed.Format("15:04"))
+	}
+
+	return b.sendMemos(usr, header, memos, false)
 }
 
 func (b *TBot) reorder(usr int64, replyID int, txt string, f func(int64, int) error) {
 	n, err := b.DB.GetActiveMemoCount(usr)
 	if err != nil {
 		b.Logger.Errorw("failed getting number of memos", "err", err)
-		b.SendMessage(usr, txtErrorAccessingDatabase, replyID, nil)
+		b.SendMessage(usr, b.tr(usr, txtErrorAccessingDatabase), replyID, nil)
 		return
 	}
 
 	if n == 0 {
-		b.SendMessage(usr, txtNothingToMove, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtNothingToMove), -1, nil)
 	}
 
 	val, err := validateInt(txt, 1, n)
 	if err != nil {
-		txt := fmt.Sprintf(fmtNumberInRangeExpected, n)
+		txt := b.tr(usr, fmtNumberInRangeExpected, "Max", n)
 		b.SendMessage(usr, txt, replyID, nil)
 		return
 	}
@@ -724,14 +374,14 @@ func (b *TBot) reorder(usr int64, replyID int, txt string, f func(int64, int) er
 	err = f(usr, val)
 	if err != nil {
 		b.Logger.Errorw("failed reordering memo", "err", err)
-		b.SendMessage(usr, txtFailedReorder, replyID, nil)
+		b.SendMessage(usr, b.tr(usr, txtFailedReorder), replyID, nil)
 		return
 	}
 
 	memos, err := b.DB.GetAllMemos(usr, true)
 	if err != nil {
 		b.Logger.Errorw("failed listing memos", "err", err)
-		b.SendMessage(usr, txtFailedFetchMemos, -1, nil)
+		b.SendMessage(usr, b.tr(usr, txtFailedFetchMemos), -1, nil)
 		return
 	}
 
@@ -749,14 +399,8 @@ func (b *TBot) SendMessage(usr int64, txt string, replyTo int, kbMarkup *tg.Inli
 		m.BaseChat.ReplyMarkup = kbMarkup
 	}
 
-	var err error
-	bot.RobustExecute(b.RetryAttempts, b.RetryDelay, func() bool {
-		_, err = b.Bot.Request(m)
-		return err == nil
-	})
-	if err != nil {
-		b.Logger.Errorw("failed sending message", "err", err)
-	}
+	_, err := b.Transport.SendMessage(m)
+	b.logSendError("failed sending message", usr, err)
 	return err
 }
 
@@ -772,45 +416,58 @@ func (b TBot) ReplaceMessage(usr int64, txt string, msgID int, kbMarkup *tg.Inli
 		Text:                  txt,
 	}
 
-	var err error
-	ok := bot.RobustExecute(b.RetryAttempts, b.RetryDelay, func() bool {
-		_, err := b.Bot.Request(updText)
-		if err != nil && strings.HasPrefix(err.Error(), "Bad Request: message is not modified") {
-			err = nil
-		}
-		return err == nil
-	})
-	if !ok {
-		b.Logger.Errorw("failed updating message text", "err", err)
+	err := b.Transport.EditMessage(updText)
+	if err != nil && strings.HasPrefix(err.Error(), "Bad Request: message is not modified") {
+		err = nil
 	}
+	b.logSendError("failed updating message text", usr, err)
+
+	return err == nil
+}
 
-	return ok
+// logSendError logs the error of a request to Telegram. Permanent errors (the
+// user blocked the bot, etc.) aren't worth retrying, so they're only warnings.
+func (b *TBot) logSendError(msg string, usr int64, err error) {
+	switch {
+	case err == nil:
+	case bot.IsPermanent(err):
+		b.Logger.Warnw(msg, "user", usr, "err", err)
+	default:
+		b.Logger.Errorw(msg, "user", usr, "err", err)
+	}
 }
 
 func (b *TBot) sendMemosForToday(usr int64, memos []db.Memo, showAll bool) error {
+	return b.sendMemos(usr, "", memos, showAll)
+}
+
+// sendMemos sends the memos preceded by the header
+func (b *TBot) sendMemos(usr int64, header string, memos []db.Memo, showAll bool) error {
 	activeMemos, doneMemos, deletedMemos := groupByState(memos)
+	l := b.I18n.For(usr)
 
 	var sb strings.Builder
+	sb.WriteString(header)
 	var kb *tg.InlineKeyboardMarkup
 	if showAll {
-		formatAllMemos(&sb, activeMemos, doneMemos, deletedMemos)
+		formatAllMemos(&sb, l, activeMemos, doneMemos, deletedMemos)
 	} else {
-		formatFirstMemos(&sb, activeMemos)
+		formatFirstMemos(&sb, l, activeMemos)
 		if len(activeMemos) > numShortCount || len(doneMemos) > 0 || len(deletedMemos) > 0 {
-			kb = &keyboardShowAll
+			kb = keyboardShowAll(l)
 		}
 	}
 
 	return b.SendMessage(usr, sb.String(), -1, kb)
 }
 
-func formatAllMemos(sb *strings.Builder, activeMemos []string, doneMemos []string, deletedMemos []string) {
+func formatAllMemos(sb *strings.Builder, l bot.Localizer, activeMemos []string, doneMemos []string, deletedMemos []string) {
 	sb.Grow(numAssumedAvgMemo * (len(activeMemos) + len(doneMemos) + len(deletedMemos)))
 
 	if len(activeMemos) == 0 {
-		sb.WriteString(txtNoActiveMemos)
+		sb.WriteString(l.T(txtNoActiveMemos))
 	} else {
-		sb.WriteString(txtYourActiveMemos)
+		sb.WriteString(l.T(txtYourActiveMemos, "Count", len(activeMemos)))
 	}
 
 	for i, txt := range activeMemos {
@@ -818,30 +475,30 @@ func formatAllMemos(sb *strings.Builder, activeMemos []string, doneMemos []strin
 	}
 
 	if len(doneMemos) > 0 {
-		sb.WriteString(txtYourDoneMemos)
+		sb.WriteString(l.T(txtYourDoneMemos))
 		for i, txt := range doneMemos {
 			sb.WriteString(fmt.Sprintf(fmtMemo, i+1, txt))
 		}
 	}
 
 	if len(deletedMemos) > 0 {
-		sb.WriteString(txtYourDeletedMemos)
+		sb.WriteString(l.T(txtYourDeletedMemos))
 		for i, txt := range deletedMemos {
 			sb.WriteString(fmt.Sprintf(fmtMemo, i+1, txt))
 		}
 	}
 }
 
-func formatFirstMemos(sb *strings.Builder, activeMemos []string) {
+func formatFirstMemos(sb *strings.Builder, l bot.Localizer, activeMemos []string) {
 	n := numShortCount
 	if len(activeMemos) < numShortCount {
 		n = len(activeMemos)
 	}
 
 	if len(activeMemos) == 0 {
-		sb.WriteString(txtNoActiveMemos)
+		sb.WriteString(l.T(txtNoActiveMemos))
 	} else {
-		sb.WriteString(txtYourActiveMemos)
+		sb.WriteString(l.T(txtYourActiveMemos, "Count", len(activeMemos)))
 	}
 
 	for i, txt := range activeMemos[:n] {
@@ -849,6 +506,21 @@ func formatFirstMemos(sb *strings.Builder, activeMemos []string) {
 	}
 }
 
+// tr returns the message with the key in the user's language
+func (b *TBot) tr(usr int64, key string, args ...any) string {
+	return b.I18n.For(usr).T(key, args...)
+}
+
+func keyboardShowAll(l bot.Localizer) *tg.InlineKeyboardMarkup {
+	kb := tg.NewInlineKeyboardMarkup(tg.NewInlineKeyboardRow(tg.NewInlineKeyboardButtonData(l.T(txtShowAll), cbqShowAll)))
+	return &kb
+}
+
+func keyboardRetry(l bot.Localizer) *tg.InlineKeyboardMarkup {
+	kb := tg.NewInlineKeyboardMarkup(tg.NewInlineKeyboardRow(tg.NewInlineKeyboardButtonData(l.T(txtRetry), cbqRetry)))
+	return &kb
+}
+
 func groupByState(memos []db.Memo) ([]string, []string, []string) {
 	var activeMemos []string
 	var doneMemos []string
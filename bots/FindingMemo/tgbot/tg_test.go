@@ -0,0 +1,294 @@
+package tgbot_test
+
+import (
+	"botfarm/bot"
+	"botfarm/bot/tgtest"
+	"botfarm/bots/FindingMemo/db"
+	"botfarm/bots/FindingMemo/reminder"
+	"botfarm/bots/FindingMemo/tgbot"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const usr = 42
+
+// chat runs the bot against the fake Telegram server with memos kept in memory
+type chat struct {
+	t    *testing.T
+	srv  *tgtest.Server
+	db   *db.Memory
+	tbot *tgbot.TBot
+}
+
+func newChat(t *testing.T) *chat {
+	l := zap.NewNop().Sugar()
+
+	srv := tgtest.NewServer()
+	t.Cleanup(srv.Close)
+	tr, err := bot.NewTransport("test", tgtest.Token, srv.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := bot.NewSender("test", tr, bot.SenderConfig{Rate: 100, ChatRate: 100, ChatBurst: 100, Attempts: 2,
+		RetryDelay: 10 * time.Millisecond}, l)
+	t.Cleanup(sender.Close)
+
+	catalog, err := bot.LoadCatalog(tgbot.Locales, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := db.NewMemory()
+	tasks := &bot.Tasks{Logger: l}
+	b := tgbot.NewTBot("test", sender, sender.WithPriority(bot.PriorityLow), mem, bot.NewI18n("test", catalog, nil, l),
+		bot.NewMemoryStore(), time.Minute, l)
+	b.ReminderManager = reminder.NewManager("test", mem, bot.NewScheduler("test", nil, tasks, l), tasks, time.Hour,
+		b.SendReminder, l)
+	router := bot.NewRouter("test", l)
+	b.Routes(router)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err = b.ReminderManager.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	updates, err := tr.Updates(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for u := range updates {
+			u := u
+			router.Handle(&u)
+		}
+	}()
+
+	return &chat{t: t, srv: srv, db: mem, tbot: b}
+}
+
+// say sends the text to the bot and returns texts of n messages it replies
+// with
+func (c *chat) say(text string, n int) []string {
+	c.t.Helper()
+
+	sent := len(c.srv.Requests("sendMessage"))
+	c.srv.SendText(usr, text)
+	return c.replies(sent, n)
+}
+
+// replies waits for n messages sent after the first sent ones
+func (c *chat) replies(sent, n int) []string {
+	c.t.Helper()
+
+	reqs, ok := c.srv.WaitRequests("sendMessage", sent+n, 5*time.Second)
+	if !ok {
+		c.t.Fatalf("expected %d replies, got %d", n, len(reqs)-sent)
+	}
+
+	var texts []string
+	for _, r := range reqs[sent:] {
+		texts = append(texts, r.Params.Get("text"))
+	}
+	return texts
+}
+
+// expect checks that the reply to the text contains want
+func (c *chat) expect(text, want string) {
+	c.t.Helper()
+
+	if got := c.say(text, 1)[0]; !strings.Contains(got, want) {
+		c.t.Errorf("%s: expected a reply with %q, got %q", text, want, got)
+	}
+}
+
+// memos returns texts of the user's memos in the state
+func (c *chat) memos(state uint) []string {
+	c.t.Helper()
+
+	memos, err := c.db.GetAllMemos(usr, true)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	var texts []string
+	for _, m := range memos {
+		if m.State == state {
+			texts = append(texts, m.Text)
+		}
+	}
+	return texts
+}
+
+func (c *chat) expectMemos(state uint, want ...string) {
+	c.t.Helper()
+
+	if got := c.memos(state); strings.Join(got, ",") != strings.Join(want, ",") {
+		c.t.Errorf("expected memos %v in state %d, got %v", want, state, got)
+	}
+}
+
+func TestMemos(t *testing.T) {
+	c := newChat(t)
+
+	if replies := c.say("/start", 2); !strings.Contains(replies[1], "you don't have any active memos") {
+		t.Errorf("expected no memos after start, got %q", replies[1])
+	}
+
+	c.expect("/add milk", "[<code>1</code>] milk")
+	c.expect("/add", "Send me your memo")
+	c.expect("bread", "Your 2 active memos")
+	c.expect("/ins eggs", "[<code>1</code>] eggs")
+	c.expect("tea", "Saved the message text as a memo")
+	c.expectMemos(db.MemoStateActive, "tea", "eggs", "milk", "bread")
+
+	c.expect("/makelast 1", "[<code>4</code>] tea")
+	c.expect("/makefirst 3", "[<code>1</code>] bread")
+	c.expectMemos(db.MemoStateActive, "bread", "eggs", "milk", "tea")
+
+	if replies := c.say("/del", 2); replies[1] != "Which memo do you want to delete?" {
+		t.Errorf("expected the question, got %q", replies[1])
+	}
+	c.expect("5", "range of 1-4")
+	c.expect("/del 2", "Your 3 active memos")
+	if replies := c.say("/done", 2); replies[1] != "Which memo do you want to mark as done?" {
+		t.Errorf("expected the question, got %q", replies[1])
+	}
+	c.expect("1", "[<code>1</code>] milk")
+	c.expectMemos(db.MemoStateActive, "milk", "tea")
+	c.expectMemos(db.MemoStateDeleted, "eggs")
+	c.expectMemos(db.MemoStateDone, "bread")
+
+	c.expect("/listall", "Memos you've recently deleted")
+	c.expect("/add", "Send me your memo")
+	c.expect("/cancel", "let's forget about it")
+	c.expect("/unknown", "I don't known this command")
+}
+
+func TestMemoErrors(t *testing.T) {
+	c := newChat(t)
+	c.say("/start", 2)
+
+	c.db.Fail("AddMemo", errors.New("db is down"))
+	c.expect("/add milk", "I failed to add the memo")
+	c.db.Fail("AddMemo", nil)
+	c.db.Fail("GetActiveMemoCount", errors.New("db is down"))
+	c.expect("/del 1", "I couldn't get your memos")
+	c.db.Fail("GetActiveMemoCount", nil)
+
+	// the sender waits as long as Telegram asks and sends the reply again
+	c.srv.Throttle("sendMessage", 1, 1)
+	start := time.Now()
+	if replies := c.say("/add milk", 2); replies[0] != replies[1] || !strings.Contains(replies[1], "milk") {
+		t.Errorf("expected the reply to be sent again, got %q", replies)
+	}
+	if time.Since(start) < time.Second {
+		t.Error("expected the sender to wait after 429")
+	}
+	c.expectMemos(db.MemoStateActive, "milk")
+
+	// the question isn't sent, so the next message isn't taken as a memo
+	c.srv.Fail("sendMessage", 1, 403, "Forbidden: bot was blocked by the user")
+	sent := len(c.srv.Requests("sendMessage"))
+	c.srv.SendText(usr, "/add")
+	c.replies(sent, 1)
+	c.expect("bread", "Saved the message text as a memo")
+	c.expectMemos(db.MemoStateActive, "bread", "milk")
+}
+
+func TestRemindAt(t *testing.T) {
+	c := newChat(t)
+	c.say("/start", 2)
+
+	c.expect("/remindat 18:00; 09:00 mon-fri", "I'll remind at 09:00 weekdays; 18:00")
+	c.expect("/remindat", "Enter hour and minute")
+	c.expect("25:00", "I expect valid times")
+	c.expect("07:30 sat-sun", "at 07:30 weekends in UTC time zone")
+	c.expect("/settings", "Reminder schedule: 07:30 weekends (UTC)")
+
+	rp, err := c.db.GetRemindParams(usr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rp.Set || len(rp.Times) != 1 || rp.Times[0] != (db.RemindTime{At: 7*60 + 30, Days: db.Weekends}) {
+		t.Errorf("unexpected reminder parameters %+v", rp)
+	}
+
+	c.expect("/remindat 10:61", "I expect valid times")
+
+	// failures of valid schedules aren't reported as format errors and end
+	// the conversation
+	c.db.Fail("SetRemindTimes", errors.New("db is down"))
+	c.expect("/remindat 10:00", "I couldn't update the reminder")
+	c.expect("/remindat", "Enter hour and minute")
+	c.expect("10:00", "I couldn't update the reminder")
+	c.db.Fail("SetRemindTimes", nil)
+	c.expect("10:00", "Saved the message text as a memo")
+}
+
+func TestRemindOnOff(t *testing.T) {
+	c := newChat(t)
+	c.say("/start", 2)
+
+	reminding := func() bool {
+		t.Helper()
+
+		rp, err := c.db.GetRemindParams(usr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rp.Set
+	}
+
+	c.expect("/remindoff", "I won't send you reminders. Use /remindon")
+	if reminding() {
+		t.Error("expected reminders to be off")
+	}
+	c.expect("/settings", "Reminders are off. Reminder schedule: 09:00 (UTC)")
+	c.expect("/remindoff", "I won't send you reminders")
+
+	c.expect("/remindon", "I'll remind you about your memos at 09:00 in UTC time zone")
+	if !reminding() {
+		t.Error("expected reminders to be on")
+	}
+	c.expect("/settings", "Reminder schedule: 09:00 (UTC).")
+
+	c.db.Fail("SetRemind", errors.New("db is down"))
+	c.expect("/remindoff", "I couldn't update the reminder")
+	if !reminding() {
+		t.Error("expected reminders to stay on")
+	}
+	c.db.Fail("SetRemind", nil)
+}
+
+func TestSendReminder(t *testing.T) {
+	c := newChat(t)
+	c.say("/start", 2)
+	c.say("/add milk", 1)
+
+	sent := len(c.srv.Requests("sendMessage"))
+	if err := c.tbot.SendReminder(usr, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.replies(sent, 1)[0]; !strings.HasPrefix(got, "Your active memo") {
+		t.Errorf("unexpected reminder %q", got)
+	}
+
+	sent++
+	if err := c.tbot.SendReminder(usr, time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.replies(sent, 1)[0]; !strings.HasPrefix(got, "I was away at 09:00") {
+		t.Errorf("expected the missed reminder, got %q", got)
+	}
+
+	// the undelivered reminder is reported, so it's sent again
+	c.srv.Fail("sendMessage", 2, 500, "Internal Server Error")
+	if err := c.tbot.SendReminder(usr, time.Time{}); err == nil {
+		t.Error("expected the undelivered reminder to fail")
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 )
 
-var timeZones []Zone
+var (
+	timeZones []Zone
+	zonesMu   sync.Mutex
+)
 var emptyZoneListError = errors.New("empty zone list")
 
 type Zone struct {
@@ -16,7 +20,15 @@ type Zone struct {
 	TZ string
 }
 
+// Init loads time zones once, so instances of the bot can share them
 func Init() error {
+	zonesMu.Lock()
+	defer zonesMu.Unlock()
+
+	if timeZones != nil {
+		return nil
+	}
+
 	z, err := LoadZonesFromFile("bots/FindingMemo/data/zone1970.tab")
 	if err != nil {
 		return err
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"botfarm/bot"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// commandTimeout limits the time of commands working with bot databases
+const commandTimeout = 5 * time.Minute
+
+// listBots prints registered bot types and their required configuration
+// fields
+func listBots(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tREQUIRED CONFIGURATION FIELDS")
+	for _, rec := range bot.GetThemAll() {
+		fmt.Fprintf(tw, "%s\t%s\n", rec.Type, strings.Join(rec.RequiredConfigFields, ", "))
+	}
+
+	return tw.Flush()
+}
+
+// validateConfig decodes configuration of the farm and every declared bot
+// instance and prints found problems
+func validateConfig(w io.Writer) error {
+	cfgFile, botConfigs, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	check := func(section string, cfg any, err error) {
+		if err != nil {
+			failed = true
+			fmt.Fprintf(w, "%s: %v\n", section, err)
+		} else {
+			fmt.Fprintf(w, "%s: ok\n", section)
+		}
+		for _, f := range unknownFields(botConfigs, section, cfg) {
+			fmt.Fprintf(w, "%s: unknown field %s is ignored\n", section, f)
+		}
+	}
+
+	_, err = farmConfig(botConfigs)
+	check(bot.FarmSection, &bot.FarmConfig{}, err)
+
+	bots, err := instances(botConfigs)
+	if err != nil {
+		check("Instances", nil, err)
+	}
+
+	known := map[string]bool{bot.FarmSection: true}
+	for _, b := range bots {
+		known[b.Name()] = true
+
+		err := decodeBot(b, botConfigs)
+		if base := bot.BaseOf(b.Config()); err == nil && base != nil {
+			err = base.AccessConfig().Validate()
+		}
+		check(b.Name(), b.Config(), err)
+	}
+
+	sections := []string{}
+	for section := range botConfigs {
+		if !known[section] {
+			sections = append(sections, section)
+		}
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		fmt.Fprintf(w, "%s: section of an undeclared bot instance is ignored\n", section)
+	}
+
+	if failed {
+		return fmt.Errorf("configuration file %q is invalid", cfgFile)
+	}
+
+	return nil
+}
+
+// migrate applies, reverts or lists migrations of the bot database without
+// initializing the bot
+func migrate(logger *zap.SugaredLogger, args []string, w io.Writer) error {
+	if len(args) < 2 || len(args) > 3 || (len(args) == 3 && args[0] != "down") {
+		return errors.New("usage: botfarm migrate up|down|status <bot> or botfarm migrate down <bot> <version>")
+	}
+	action, name := args[0], args[1]
+
+	_, botConfigs, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	b, schema, err := migratedBot(name, botConfigs)
+	if err != nil {
+		return err
+	}
+	if err := decodeBot(b, botConfigs); err != nil {
+		return err
+	}
+	base := bot.BaseOf(b.Config())
+	if base == nil {
+		return fmt.Errorf("bot %q has no database configuration", name)
+	}
+
+	d, err := bot.OpenDB(name, base.DBConnStr)
+	if err != nil {
+		return fmt.Errorf("couldn't connect to the database: %w", err)
+	}
+	defer d.Close()
+
+	m, err := bot.NewMigrator(name, d, schema.Migrations(), logger.With("bot", name))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "up":
+		return m.Up(ctx)
+
+	case "down":
+		version := previousVersion(name, statuses)
+		if len(args) == 3 {
+			if version, err = strconv.Atoi(args[2]); err != nil || version < 0 {
+				return fmt.Errorf("invalid version %q", args[2])
+			}
+		}
+		return m.Down(ctx, version)
+
+	case "status":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SCHEMA\tVERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "-"
+			if s.Applied != nil {
+				applied = s.Applied.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", s.Schema, s.Version, s.Name, applied)
+		}
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf("unknown migrate action %q", action)
+	}
+}
+
+// migratedBot returns the named bot instance with embedded migrations
+func migratedBot(name string, botConfigs map[string]any) (bot.Bot, bot.Schema, error) {
+	b, err := findBot(name, botConfigs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	schema, ok := b.(bot.Schema)
+	if !ok {
+		return nil, nil, fmt.Errorf("bot %q has no migrations", name)
+	}
+
+	return b, schema, nil
+}
+
+// previousVersion returns the version preceding the latest applied migration
+// of the bot, so reverting to it reverts only the latest migration
+func previousVersion(name string, statuses []bot.MigrationStatus) int {
+	var own []bot.MigrationStatus
+	for _, s := range statuses {
+		if s.Schema == name {
+			own = append(own, s)
+		}
+	}
+
+	latest := -1
+	for i, s := range own {
+		if s.Applied != nil {
+			latest = i
+		}
+	}
+
+	if latest <= 0 {
+		return 0
+	}
+
+	return own[latest-1].Version
+}
+
+// setCommands sets command menus of the bot without initializing the bot
+func setCommands(logger *zap.SugaredLogger, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: botfarm set-commands <bot>")
+	}
+	name := args[0]
+
+	_, botConfigs, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	b, err := findBot(name, botConfigs)
+	if err != nil {
+		return err
+	}
+	menu, ok := b.(bot.Menu)
+	if !ok {
+		return fmt.Errorf("bot %q has no command menu", name)
+	}
+
+	if err := decodeBot(b, botConfigs); err != nil {
+		return err
+	}
+	base := bot.BaseOf(b.Config())
+	if base == nil {
+		return fmt.Errorf("bot %q has no Telegram configuration", name)
+	}
+
+	catalog, err := bot.LoadCatalog(menu.Locales(), base.Locale)
+	if err != nil {
+		return err
+	}
+
+	t, err := bot.NewTransport(name, base.TgToken, base.TgAPIEndpoint)
+	if err != nil {
+		return fmt.Errorf("couldn't connect to Telegram: %w", err)
+	}
+
+	if err := bot.SetCommands(t, catalog, menu.Menu()); err != nil {
+		return err
+	}
+	logger.Infow("set command menus", "bot", name, "locales", catalog.Locales())
+
+	return nil
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"botfarm/bot"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	_ "botfarm/bots/AlainDelon"
 	_ "botfarm/bots/FindingMemo"
@@ -16,6 +22,9 @@ import (
 
 const stopOnFailure = false
 
+// shutdownTimeout limits time given to bots to finish handling updates
+const shutdownTimeout = 30 * time.Second
+
 // getLogger creates a logger in global namespace
 func getLogger() (*zap.SugaredLogger, func() error) {
 	logger, _ := zap.NewDevelopment(zap.Fields(zap.String("ns", "Global")))
@@ -40,85 +49,319 @@ func readConfig(cfgFile string) (map[string]any, error) {
 	return botfarmConfig, nil
 }
 
-// validateConfig makes sure that all required fields are present in the config
-func validateConfig(rec bot.Record, cfg bot.Config) error {
-	missingFields := []string{}
-	for _, field := range rec.RequiredConfigFields {
-		if _, ok := cfg[field]; !ok {
-			missingFields = append(missingFields, field)
+// section returns the named section of the configuration. The whole section
+// may be missing as it may come from the environment.
+func section(botConfigs map[string]any, name string) (bot.RawConfig, error) {
+	c, ok := botConfigs[name]
+	if !ok {
+		return bot.RawConfig{}, nil
+	}
+
+	raw, ok := c.(bot.RawConfig)
+	if !ok {
+		return nil, fmt.Errorf("configuration of %q isn't an object", name)
+	}
+
+	return raw, nil
+}
+
+// farmConfig decodes the farm section of the configuration
+func farmConfig(botConfigs map[string]any) (*bot.FarmConfig, error) {
+	raw, err := section(botConfigs, bot.FarmSection)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg bot.FarmConfig
+	if err := bot.DecodeConfig(bot.FarmSection, raw, &cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// unknownFields returns fields of the configuration section that aren't
+// fields of cfg
+func unknownFields(botConfigs map[string]any, name string, cfg any) []string {
+	raw, err := section(botConfigs, name)
+	if err != nil {
+		return nil
+	}
+
+	return bot.UnknownFields(raw, cfg)
+}
+
+// farm keeps the configuration of the farm, so bots can be initialized again
+// at runtime
+type farm struct {
+	cfgFile string
+	bots    map[string]bot.Bot // by instance name
+
+	mu      sync.Mutex
+	configs map[string]any
+}
+
+// instances creates bot instances declared in the configuration
+func instances(botConfigs map[string]any) ([]bot.Bot, error) {
+	farmCfg, err := farmConfig(botConfigs)
+	if err != nil {
+		return nil, err
+	}
+
+	declared, err := bot.Instances(farmCfg.Instances, botConfigs)
+	if err != nil {
+		return nil, err
+	}
+
+	bots := make([]bot.Bot, len(declared))
+	for i, inst := range declared {
+		if bots[i], err = bot.NewBot(inst); err != nil {
+			return nil, err
+		}
+	}
+
+	return bots, nil
+}
+
+// findBot creates the named bot instance declared in the configuration
+func findBot(name string, botConfigs map[string]any) (bot.Bot, error) {
+	bots, err := instances(botConfigs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range bots {
+		if b.Name() == name {
+			return b, nil
 		}
 	}
 
-	if len(missingFields) > 0 {
-		return errors.New(fmt.Sprintf("%v's configuration is missing field(s): %s", rec.Name, strings.Join(missingFields, ", ")))
+	return nil, fmt.Errorf("bot instance %q isn't declared", name)
+}
+
+// decodeBot decodes configuration of the bot from the farm configuration
+func decodeBot(b bot.Bot, botConfigs map[string]any) error {
+	cfg, err := section(botConfigs, b.Name())
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return bot.DecodeConfig(b.Name(), cfg, b.Config())
+}
+
+// initBot decodes configuration of the named bot and initializes the bot. If
+// reload is true, the configuration file is read again.
+func (f *farm) initBot(name string, l *zap.SugaredLogger, reload bool) error {
+	b, ok := f.bots[name]
+	if !ok {
+		return fmt.Errorf("bot instance %q isn't declared", name)
+	}
+
+	f.mu.Lock()
+	if reload {
+		configs, err := readConfig(f.cfgFile)
+		if err != nil {
+			f.mu.Unlock()
+			l.Errorw(fmt.Sprintf("Couldn't read configuration from file %q", f.cfgFile), "err", err)
+			return err
+		}
+		f.configs = configs
+	}
+	configs := f.configs
+	f.mu.Unlock()
+
+	if err := decodeBot(b, configs); err != nil {
+		l.Error(err)
+		return err
+	}
+	if unknown := unknownFields(configs, name, b.Config()); len(unknown) > 0 {
+		l.Warnw("unknown configuration fields are ignored", "fields", unknown)
+	}
+
+	return b.Init(l)
 }
 
+// loadConfig reads the configuration file named by CONFIG_FILE
+func loadConfig() (string, map[string]any, error) {
+	cfgFile, ok := os.LookupEnv("CONFIG_FILE")
+	if !ok {
+		return "", nil, errors.New("configuration file name isn't set")
+	}
+
+	botConfigs, err := readConfig(cfgFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("couldn't read configuration from file %q: %w", cfgFile, err)
+	}
+	if botConfigs == nil {
+		return "", nil, fmt.Errorf("configuration file %q is empty", cfgFile)
+	}
+
+	return cfgFile, botConfigs, nil
+}
+
+const usage = `Usage: botfarm [command] [arguments]
+
+Commands:
+  run [--only Bot1,Bot2]          run declared bot instances or only the listed ones (default)
+  list                            list registered bot types and their required configuration fields
+  validate-config                 check configuration of the farm and every declared bot instance
+  migrate up|down|status <bot>    apply, revert or list migrations of the bot instance database;
+                                  up applies framework migrations first, down reverts the
+                                  latest bot migration or, given a version, all bot
+                                  migrations newer than the version
+  set-commands <bot>              set command menus of the bot instance in every supported language
+
+The configuration file is named by the CONFIG_FILE environment variable. Bot
+instances are listed in Farm.Instances, the type of an instance is set by the
+Type field of its section. Without Farm.Instances every registered bot type is
+run as an instance named after the type.
+`
+
 // Botfarm entry point
 func main() {
 	logger, syncLogs := getLogger()
 	defer syncLogs()
 
-	cfgFile, ok := os.LookupEnv("CONFIG_FILE")
-	if !ok {
-		logger.Fatalf("Configuration file name isn't set")
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
 
-	botConfigs, err := readConfig(cfgFile)
-	if err != nil || botConfigs == nil {
-		logger.Fatalw(fmt.Sprintf("Couldn't read configuration from file %q", cfgFile), "err", err)
+	var err error
+	switch cmd {
+	case "run":
+		err = runFarm(logger, args)
+	case "list":
+		err = listBots(os.Stdout)
+	case "validate-config":
+		err = validateConfig(os.Stdout)
+	case "migrate":
+		err = migrate(logger, args, os.Stdout)
+	case "set-commands":
+		err = setCommands(logger, args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		err = fmt.Errorf("unknown command %q", cmd)
 	}
 
-	for _, rec := range bot.GetThemAll() {
-		b := *rec.Bot
-		l, _ := zap.NewDevelopment(zap.Fields(zap.String("ns", rec.Name)))
-		s := l.Sugar()
-		defer l.Sync()
+	if err != nil {
+		syncLogs()
+		fmt.Fprintln(os.Stderr, "botfarm:", err)
+		os.Exit(1)
+	}
+}
 
-		c, ok := botConfigs[rec.Name]
-		if !ok {
-			s.Errorf("Couldn't find configuration for bot %q", rec.Name)
-			if stopOnFailure {
-				return
-			} else {
-				continue
-			}
-		}
+// runFarm runs the bots until the process is interrupted
+func runFarm(logger *zap.SugaredLogger, args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	only := flags.String("only", "", "comma-separated names of bots to run")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
 
-		cfg, ok := c.(bot.Config)
-		if !ok {
-			s.Errorf("Couldn't find configuration for bot %q", rec.Name)
-			if stopOnFailure {
-				return
-			} else {
-				continue
-			}
+	cfgFile, botConfigs, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	f := &farm{cfgFile: cfgFile, configs: botConfigs, bots: make(map[string]bot.Bot)}
+	farmCfg, err := farmConfig(botConfigs)
+	if err != nil {
+		return err
+	}
+	if unknown := unknownFields(botConfigs, bot.FarmSection, &bot.FarmConfig{}); len(unknown) > 0 {
+		logger.Warnw("unknown farm configuration fields are ignored", "fields", unknown)
+	}
+
+	bots, err := instances(botConfigs)
+	if err != nil {
+		return err
+	}
+	for _, b := range bots {
+		f.bots[b.Name()] = b
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(*only, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := f.bots[name]; !ok {
+			return fmt.Errorf("bot instance %q isn't declared", name)
 		}
+		selected[name] = true
+	}
+
+	bot.Use(
+		bot.Recovery(),
+		bot.Metrics(),
+		bot.Logging(),
+		bot.Timing(farmCfg.SlowRequest),
+		bot.RateLimit(farmCfg.UserRateLimit, farmCfg.UserRateBurst),
+	)
 
-		err := validateConfig(rec, cfg)
+	bot.SetAdmins(farmCfg.Admins...)
+
+	supervisor := bot.NewSupervisor(logger)
+
+	server := bot.NewServer(farmCfg.ListenAddr, logger)
+	server.Handle("/metrics", bot.MetricsHandler())
+	bot.HealthHandlers(server, supervisor, farmCfg.AdminToken)
+	if farmCfg.AdminToken != "" {
+		bot.AdminHandlers(server, supervisor, farmCfg.AdminToken, f.initBot)
+	}
+
+	if farmCfg.UpdateMode == bot.ModeWebhook {
+		r, err := bot.NewWebhookReceiver(server, farmCfg.WebhookURL, farmCfg.WebhookSecret, logger)
 		if err != nil {
-			s.Error(err)
-			if stopOnFailure {
-				return
-			} else {
-				continue
-			}
+			return fmt.Errorf("couldn't set up webhooks: %w", err)
 		}
+		r.SetDeleteOnStop(farmCfg.WebhookDeleteOnStop)
+		bot.SetReceiver(r)
+	}
+
+	if err = server.Start(); err != nil {
+		return fmt.Errorf("couldn't start HTTP server: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		err = b.Init(cfg, s)
+	for _, b := range bots {
+		name := b.Name()
+		if len(selected) > 0 && !selected[name] {
+			continue
+		}
+
+		l, _ := zap.NewDevelopment(zap.Fields(zap.String("ns", name)))
+		s := l.Sugar()
+		defer l.Sync()
+
+		err := f.initBot(name, s, false)
 		if err != nil {
+			supervisor.AddFailed(name, b, err, s)
 			if stopOnFailure {
-				return
+				return err
 			} else {
 				continue
 			}
 		}
 
-		go b.Run()
+		supervisor.Add(name, b, s)
 	}
 
-	stickHere := make(<-chan int)
-	<-stickHere
+	supervisor.Run(ctx)
+	logger.Info("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	supervisor.Stop(shutdownCtx)
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Errorw("Couldn't shut down HTTP server", "err", err)
+	}
+
+	return nil
 }